@@ -10,6 +10,8 @@ import (
 	"github.com/nambroa/lodging-bookings/internal/helpers"
 	"github.com/nambroa/lodging-bookings/internal/models"
 	"github.com/nambroa/lodging-bookings/internal/render"
+	"github.com/nambroa/lodging-bookings/internal/sessionstore"
+	"github.com/nambroa/lodging-bookings/internal/storage"
 	"log"
 	"net/http"
 	"os"
@@ -34,6 +36,7 @@ func main() {
 	defer db.SQL.Close()
 	defer close(app.Mailchan)
 	listenForMail()
+	sweepExpiredHolds()
 	fmt.Println("Starting application on port", portNumber)
 	// Start a webserver and listen to a specific port.
 	serve := &http.Server{
@@ -48,6 +51,8 @@ func main() {
 func run() (*driver.DB, error) {
 	// Types that will be stored in the session object (encoded in the session object).
 	gob.Register(models.Reservation{})
+	gob.Register(models.Cart{})
+	gob.Register(models.ReservationGroup{})
 	gob.Register(models.User{})
 	gob.Register(models.Room{})
 	gob.Register(models.RoomRestriction{})
@@ -60,18 +65,19 @@ func run() (*driver.DB, error) {
 	// Change this to true when in production.
 	app.InProduction = false
 
+	// Guests can book up to a year ahead, and same-day arrivals until 6pm.
+	app.BookingWindow = models.BookingWindow{SameDayCutoff: 18, MaxAdvanceDays: 365}
+
+	// Photos and other files uploaded from the admin are kept on the local disk and served under /uploads.
+	app.Storage = storage.NewLocalStorage("./uploads", "/uploads")
+
+	// Emails sent by background jobs, like the waitlist ones, link back to the site here.
+	app.BaseURL = "http://localhost" + portNumber
+
 	// Adding logs to the app config.
 	app.InfoLog = log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
 	app.ErrorLog = log.New(os.Stdout, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
 
-	// Creating session info and adding it to the app config.
-	session = scs.New()
-	session.Lifetime = 24 * time.Hour
-	session.Cookie.Persist = true
-	session.Cookie.SameSite = http.SameSiteLaxMode
-	session.Cookie.Secure = app.InProduction
-	app.Session = session
-
 	// Connect to DB.
 	log.Println("Connecting to database..")
 	db, err := driver.ConnectSQL("host=localhost port=5432 dbname=lodging-bookings user=postgres password=123")
@@ -80,6 +86,17 @@ func run() (*driver.DB, error) {
 	}
 	log.Println("Connection to the database was successful.")
 
+	// Creating session info and adding it to the app config.
+	// Sessions are stored in the DB so they survive restarts and can be shared between several instances of the app.
+	// The store also starts a goroutine that periodically deletes the expired sessions.
+	session = scs.New()
+	session.Store = sessionstore.NewPostgresStore(db.SQL)
+	session.Lifetime = 24 * time.Hour
+	session.Cookie.Persist = true
+	session.Cookie.SameSite = http.SameSiteLaxMode
+	session.Cookie.Secure = app.InProduction
+	app.Session = session
+
 	// Create template cache.
 	templateCache, err := render.CreateTemplateCache()
 	if err != nil {
@@ -2,10 +2,20 @@ package main
 
 import (
 	"github.com/justinas/nosurf"
+	"github.com/nambroa/lodging-bookings/internal/handlers"
 	"github.com/nambroa/lodging-bookings/internal/helpers"
 	"net/http"
 )
 
+// LimitRequestSize caps the size of request bodies, so uploads can't fill the disk or the memory. It runs before
+// NoSurf, which reads the body to find the CSRF token.
+func LimitRequestSize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, handlers.MaxUploadSize)
+		next.ServeHTTP(w, r)
+	})
+}
+
 // NoSurf adds CSRF protection to all POST requests.
 func NoSurf(next http.Handler) http.Handler {
 	csrfHandler := nosurf.New(next)
@@ -25,6 +35,25 @@ func SessionLoad(next http.Handler) http.Handler {
 	return session.LoadAndSave(next)
 }
 
+// TrackSession records which user and device own the current session, so users can list and revoke their sessions.
+// It runs after the handler, since logging in renews the session token.
+func TrackSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r)
+
+		userID := session.GetInt(r.Context(), "user_id")
+		token := session.Token(r.Context())
+		if userID == 0 || token == "" {
+			return
+		}
+		err := handlers.Repo.DB.TouchUserSession(token, userID, r.UserAgent(), helpers.ClientIP(r),
+			session.Deadline(r.Context()))
+		if err != nil {
+			app.ErrorLog.Println("Cannot track session:", err)
+		}
+	})
+}
+
 // Auth Middleware applied to routes in order to protect them (requiring authentication).
 func Auth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
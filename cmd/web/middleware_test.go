@@ -29,3 +29,15 @@ func TestSessionLoad(t *testing.T) {
 		t.Error(fmt.Sprintf("type is not http.Handler but is %T", v))
 	}
 }
+
+func TestLimitRequestSize(t *testing.T) {
+	var myH myHandler
+	h := LimitRequestSize(&myH)
+
+	switch v := h.(type) {
+	case http.Handler:
+		// do nothing
+	default:
+		t.Error(fmt.Sprintf("type is not http.Handler but is %T", v))
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/nambroa/lodging-bookings/internal/config"
 	"github.com/nambroa/lodging-bookings/internal/handlers"
+	"github.com/nambroa/lodging-bookings/internal/storage"
 	"net/http"
 )
 
@@ -13,14 +14,19 @@ func routes(app *config.AppConfig) http.Handler {
 
 	// Middleware Setup
 	mux.Use(middleware.Recoverer)
+	mux.Use(LimitRequestSize)
 	mux.Use(NoSurf)
 	mux.Use(SessionLoad)
+	mux.Use(TrackSession)
 
 	// Routing
 	mux.Get("/", handlers.Repo.Home)
 	mux.Get("/about", handlers.Repo.About)
-	mux.Get("/generals-quarters", handlers.Repo.Generals)
-	mux.Get("/majors-suite", handlers.Repo.Majors)
+	mux.Get("/rooms", handlers.Repo.Rooms)
+	mux.Get("/rooms/{slug}", handlers.Repo.ShowRoom)
+	// The rooms used to have their own pages, links to them are sent to the pages built from the database.
+	mux.Handle("/generals-quarters", http.RedirectHandler("/rooms/generals-quarters", http.StatusMovedPermanently))
+	mux.Handle("/majors-suite", http.RedirectHandler("/rooms/majors-suite", http.StatusMovedPermanently))
 	mux.Get("/contact", handlers.Repo.Contact)
 
 	mux.Get("/search-availability", handlers.Repo.Availability)
@@ -31,30 +37,119 @@ func routes(app *config.AppConfig) http.Handler {
 
 	mux.Get("/make-reservation", handlers.Repo.Reservation)
 	mux.Post("/make-reservation", handlers.Repo.PostReservation)
+	mux.Post("/make-reservation/promo", handlers.Repo.PostPromoCode)
+	mux.Post("/make-reservation/promo/remove", handlers.Repo.PostRemovePromoCode)
 	mux.Get("/reservation-summary", handlers.Repo.ReservationSummary)
 
+	// Booking cart, to book several rooms together under one group confirmation code.
+	mux.Get("/cart", handlers.Repo.Cart)
+	mux.Post("/cart", handlers.Repo.PostCart)
+	mux.Get("/cart/add", handlers.Repo.AddToCart)
+	mux.Post("/cart/{index}/remove", handlers.Repo.PostCartRemove)
+	mux.Get("/cart-summary", handlers.Repo.CartSummary)
+
+	// Waitlist of fully booked rooms, guests claim a freed room with the link they are emailed.
+	mux.Get("/waitlist", handlers.Repo.Waitlist)
+	mux.Post("/waitlist", handlers.Repo.PostWaitlist)
+	mux.Get("/waitlist/claim/{token}", handlers.Repo.ClaimWaitlist)
+
 	mux.Get("/user/login", handlers.Repo.ShowLogin)
 	mux.Post("/user/login", handlers.Repo.PostShowLogin)
 	mux.Get("/user/logout", handlers.Repo.Logout)
 
+	// Guest self-service portal, the guest logs in with a one-time link sent to the email of the reservation.
+	mux.Get("/my/login", handlers.Repo.GuestLogin)
+	mux.Post("/my/login", handlers.Repo.PostGuestLogin)
+	mux.Get("/my/login/{token}", handlers.Repo.GuestLoginLink)
+	mux.Get("/my/logout", handlers.Repo.GuestLogout)
+	mux.Get("/my/reservations", handlers.Repo.GuestReservations)
+	mux.Get("/my/reservations/{code}", handlers.Repo.GuestShowReservation)
+	mux.Post("/my/reservations/{code}", handlers.Repo.GuestPostShowReservation)
+	mux.Post("/my/reservations/{code}/cancel", handlers.Repo.GuestCancelReservation)
+	mux.Get("/my/reservations/{code}/invoice", handlers.Repo.GuestReservationInvoice)
+
 	// Fileserver to go get static files
 	fileServer := http.FileServer(http.Dir("./static/"))
 	mux.Handle("/static/*", http.StripPrefix("/static", fileServer))
 
+	// Uploaded files are served by the app when they are kept on the local disk.
+	if local, ok := app.Storage.(*storage.LocalStorage); ok {
+		uploadServer := http.FileServer(http.Dir(local.Dir))
+		mux.Handle(local.BaseURL+"/*", http.StripPrefix(local.BaseURL, uploadServer))
+	}
+
 	// Protected routes.
 	mux.Route("/admin", func(mux chi.Router) {
 		//mux.Use(Auth)
 		mux.Get("/dashboard", handlers.Repo.AdminDashboard)
 		mux.Get("/reservations-new", handlers.Repo.AdminNewReservations)
 		mux.Get("/reservations-all", handlers.Repo.AdminAllReservations)
+		mux.Get("/reservations-find", handlers.Repo.AdminFindReservation)
 		mux.Get("/reservations-calendar", handlers.Repo.AdminReservationsCalendar)
 		mux.Post("/reservations-calendar", handlers.Repo.AdminPostReservationsCalendar)
 		// src highlights whether or not the users comes from the all or new reservations part of the layout.
 		mux.Get("/reservations/{src}/{id}", handlers.Repo.AdminShowReservation)
 		mux.Post("/reservations/{src}/{id}", handlers.Repo.AdminPostShowReservation)
 
-		mux.Get("/process-reservation/{src}/{id}", handlers.Repo.AdminProcessReservation)
-		mux.Get("/delete-reservation/{src}/{id}", handlers.Repo.AdminDeleteReservation)
+		mux.Post("/reservations/{src}/{id}/status", handlers.Repo.AdminPostReservationStatus)
+		mux.Post("/reservations/{src}/{id}/modify", handlers.Repo.AdminPostModifyReservation)
+		mux.Post("/reservations/{src}/{id}/unit", handlers.Repo.AdminPostReservationUnit)
+		mux.Post("/reservations/{src}/{id}/extras", handlers.Repo.AdminPostReservationExtras)
+		mux.Get("/reservations/{src}/{id}/invoice", handlers.Repo.AdminReservationInvoice)
+
+		mux.Get("/rooms", handlers.Repo.AdminRooms)
+		mux.Get("/rooms/new", handlers.Repo.AdminNewRoom)
+		mux.Post("/rooms/new", handlers.Repo.AdminPostNewRoom)
+		mux.Get("/rooms/{id}", handlers.Repo.AdminEditRoom)
+		mux.Post("/rooms/{id}", handlers.Repo.AdminPostEditRoom)
+		mux.Post("/rooms/{id}/archived", handlers.Repo.AdminPostRoomArchived)
+		mux.Post("/rooms/{id}/move", handlers.Repo.AdminPostMoveRoom)
+		mux.Get("/rooms/{id}/photos", handlers.Repo.AdminRoomPhotos)
+		mux.Post("/rooms/{id}/photos", handlers.Repo.AdminPostRoomPhotos)
+		mux.Post("/rooms/{id}/photos/{photoID}", handlers.Repo.AdminPostRoomPhoto)
+		mux.Post("/rooms/{id}/photos/{photoID}/move", handlers.Repo.AdminPostMoveRoomPhoto)
+		mux.Post("/rooms/{id}/photos/{photoID}/delete", handlers.Repo.AdminDeleteRoomPhoto)
+		mux.Get("/rooms/{id}/units", handlers.Repo.AdminRoomUnits)
+		mux.Post("/rooms/{id}/units", handlers.Repo.AdminPostRoomUnits)
+		mux.Post("/rooms/{id}/units/{unitID}", handlers.Repo.AdminPostRoomUnit)
+		mux.Post("/rooms/{id}/units/{unitID}/archived", handlers.Repo.AdminPostRoomUnitArchived)
+		mux.Get("/amenities", handlers.Repo.AdminAmenities)
+		mux.Post("/amenities", handlers.Repo.AdminPostAmenity)
+		mux.Post("/amenities/{id}", handlers.Repo.AdminPostEditAmenity)
+		mux.Post("/amenities/{id}/delete", handlers.Repo.AdminDeleteAmenity)
+		mux.Get("/extras", handlers.Repo.AdminExtras)
+		mux.Post("/extras", handlers.Repo.AdminPostExtra)
+		mux.Post("/extras/{id}", handlers.Repo.AdminPostEditExtra)
+		mux.Get("/waitlist", handlers.Repo.AdminWaitlist)
+
+		mux.Get("/cancellation-policies", handlers.Repo.AdminCancellationPolicies)
+		mux.Post("/cancellation-policies", handlers.Repo.AdminPostCancellationPolicy)
+		mux.Post("/cancellation-policies/rooms", handlers.Repo.AdminPostRoomCancellationPolicies)
+		mux.Get("/rates", handlers.Repo.AdminRates)
+		mux.Post("/rates", handlers.Repo.AdminPostRatesCalendar)
+		mux.Post("/rates/rooms", handlers.Repo.AdminPostRoomRates)
+		mux.Post("/rates/rules", handlers.Repo.AdminPostRateRule)
+		mux.Post("/rates/rules/{id}/delete", handlers.Repo.AdminDeleteRateRule)
+		mux.Get("/pricing", handlers.Repo.AdminPricing)
+		mux.Post("/pricing", handlers.Repo.AdminPostPricingRule)
+		mux.Get("/pricing/simulation", handlers.Repo.AdminPricingSimulation)
+		mux.Post("/pricing/{id}/active", handlers.Repo.AdminPostPricingRuleActive)
+		mux.Post("/pricing/{id}/delete", handlers.Repo.AdminDeletePricingRule)
+		mux.Get("/promo-codes", handlers.Repo.AdminPromoCodes)
+		mux.Post("/promo-codes", handlers.Repo.AdminPostPromoCode)
+		mux.Get("/promo-codes/{id}", handlers.Repo.AdminPromoCodeUsage)
+		mux.Post("/promo-codes/{id}/active", handlers.Repo.AdminPostPromoCodeActive)
+		mux.Get("/charges", handlers.Repo.AdminCharges)
+		mux.Post("/charges", handlers.Repo.AdminPostCharge)
+		mux.Post("/charges/{id}/delete", handlers.Repo.AdminDeleteCharge)
+		mux.Get("/stay-rules", handlers.Repo.AdminStayRules)
+		mux.Post("/stay-rules", handlers.Repo.AdminPostStayRule)
+		mux.Post("/stay-rules/{id}/delete", handlers.Repo.AdminDeleteStayRule)
+
+		mux.Get("/audit", handlers.Repo.AdminAudit)
+
+		mux.Get("/sessions", handlers.Repo.AdminSessions)
+		mux.Post("/sessions/revoke", handlers.Repo.AdminRevokeSession)
 
 	})
 
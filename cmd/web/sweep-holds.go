@@ -0,0 +1,39 @@
+package main
+
+import (
+	"github.com/nambroa/lodging-bookings/internal/handlers"
+	"time"
+)
+
+// holdSweepInterval is how often the holds of abandoned reservation forms are removed.
+const holdSweepInterval = time.Minute
+
+func sweepExpiredHolds() {
+	// Function that executes on the background indefinitely. Expired holds already count as available, this only
+	// keeps them from piling up in the room_restrictions table.
+	go func() {
+		ticker := time.NewTicker(holdSweepInterval)
+		for range ticker.C {
+			released, err := handlers.Repo.DB.DeleteExpiredHolds()
+			if err != nil {
+				app.ErrorLog.Println("Cannot delete expired room holds:", err)
+				continue
+			}
+			if released > 0 {
+				app.InfoLog.Printf("Released %d expired room holds", released)
+			}
+
+			// Waitlist claims expire with their holds, so the next guests waiting are offered the rooms.
+			err = handlers.Repo.ExpireWaitlistClaims()
+			if err != nil {
+				app.ErrorLog.Println("Cannot expire waitlist claims:", err)
+			}
+
+			// Expired idempotency keys can't be replayed anymore, so they're only taking up space.
+			_, err = handlers.Repo.DB.DeleteExpiredIdempotencyKeys()
+			if err != nil {
+				app.ErrorLog.Println("Cannot delete expired idempotency keys:", err)
+			}
+		}
+	}()
+}
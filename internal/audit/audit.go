@@ -0,0 +1,56 @@
+package audit
+
+import (
+	"encoding/json"
+	"reflect"
+)
+
+// Change holds the value of a single field before and after a change.
+type Change struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// Diff returns a JSON object with the fields that differ between before and after, in the form
+// {"field": {"before": ..., "after": ...}}. Pass nil as before for creations and nil as after for deletions, so every
+// field of the other value is recorded.
+func Diff(before, after interface{}) ([]byte, error) {
+	beforeFields, err := toFields(before)
+	if err != nil {
+		return nil, err
+	}
+	afterFields, err := toFields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := map[string]Change{}
+	for field, value := range beforeFields {
+		if !reflect.DeepEqual(value, afterFields[field]) {
+			changes[field] = Change{Before: value, After: afterFields[field]}
+		}
+	}
+	for field, value := range afterFields {
+		if _, seen := beforeFields[field]; !seen {
+			changes[field] = Change{Before: nil, After: value}
+		}
+	}
+	return json.Marshal(changes)
+}
+
+// toFields converts a value to its JSON fields, so that structs and maps can be compared field by field.
+func toFields(v interface{}) (map[string]interface{}, error) {
+	fields := map[string]interface{}{}
+	if v == nil {
+		return fields, nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(b, &fields)
+	if err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
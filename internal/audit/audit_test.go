@@ -0,0 +1,64 @@
+package audit
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+type testReservation struct {
+	FirstName string
+	Email     string
+	Processed int
+}
+
+func TestDiff_OnlyChangedFields(t *testing.T) {
+	before := testReservation{FirstName: "John", Email: "john@smith.com", Processed: 0}
+	after := testReservation{FirstName: "John", Email: "john@smith.com", Processed: 1}
+
+	b, err := Diff(before, after)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var changes map[string]Change
+	_ = json.Unmarshal(b, &changes)
+	if len(changes) != 1 {
+		t.Fatalf("Expected 1 changed field but got %d: %s", len(changes), b)
+	}
+	if changes["Processed"].Before != float64(0) || changes["Processed"].After != float64(1) {
+		t.Errorf("Processed change recorded as %v", changes["Processed"])
+	}
+}
+
+func TestDiff_Deletion(t *testing.T) {
+	before := testReservation{FirstName: "John", Email: "john@smith.com"}
+
+	b, err := Diff(before, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var changes map[string]Change
+	_ = json.Unmarshal(b, &changes)
+	if len(changes) != 3 {
+		t.Fatalf("Expected every field to be recorded on deletion but got %s", b)
+	}
+	if changes["FirstName"].Before != "John" || changes["FirstName"].After != nil {
+		t.Errorf("FirstName change recorded as %v", changes["FirstName"])
+	}
+}
+
+func TestDiff_Creation(t *testing.T) {
+	after := map[string]interface{}{"room_id": 1, "date": "2050-01-01"}
+
+	b, err := Diff(nil, after)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var changes map[string]Change
+	_ = json.Unmarshal(b, &changes)
+	if len(changes) != 2 || changes["date"].After != "2050-01-01" {
+		t.Errorf("Creation recorded as %s", b)
+	}
+}
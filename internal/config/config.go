@@ -3,6 +3,7 @@ package config
 import (
 	"github.com/alexedwards/scs/v2"
 	"github.com/nambroa/lodging-bookings/internal/models"
+	"github.com/nambroa/lodging-bookings/internal/storage"
 	"html/template"
 	"log"
 )
@@ -16,4 +17,7 @@ type AppConfig struct {
 	InProduction  bool
 	Session       *scs.SessionManager
 	Mailchan      chan models.MailData
+	BookingWindow models.BookingWindow // how soon and how far ahead guests can book, for searches and datepickers.
+	Storage       storage.Storage      // where the files uploaded from the admin, like room photos, are kept.
+	BaseURL       string               // where the site is served, for the links of emails sent outside of a request.
 }
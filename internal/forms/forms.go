@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/asaskevich/govalidator"
 	"net/url"
+	"strconv"
 	"strings"
 )
 
@@ -58,3 +59,74 @@ func (f *Form) IsEmail(fieldName string) {
 		f.Errors.Add(fieldName, "Invalid email address")
 	}
 }
+
+// IntBetween checks that the field is a whole number between min and max, both included.
+func (f *Form) IntBetween(fieldName string, min, max int) bool {
+	value, err := strconv.Atoi(strings.TrimSpace(f.Get(fieldName)))
+	if err != nil || value < min || value > max {
+		f.Errors.Add(fieldName, fmt.Sprintf("This field must be a whole number between %d and %d", min, max))
+		return false
+	}
+	return true
+}
+
+// Ints returns the values of a field sent several times, like a group of checkboxes, as whole numbers without
+// duplicates. It adds an error to the form when one of them isn't a whole number.
+func (f *Form) Ints(fieldName string) []int {
+	var ints []int
+	seen := make(map[int]bool)
+	for _, v := range f.Values[fieldName] {
+		value, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil {
+			f.Errors.Add(fieldName, "This field must only have whole numbers")
+			return nil
+		}
+		if !seen[value] {
+			seen[value] = true
+			ints = append(ints, value)
+		}
+	}
+	return ints
+}
+
+// Checked returns true if value is one of the values of the field, to keep the checkboxes of a group checked when
+// the form is shown again.
+func (f *Form) Checked(fieldName string, value interface{}) bool {
+	for _, v := range f.Values[fieldName] {
+		if v == fmt.Sprint(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsMoney checks that the field is a positive amount of dollars, with at most two decimals.
+func (f *Form) IsMoney(fieldName string) bool {
+	_, err := ParseMoney(f.Get(fieldName))
+	if err != nil {
+		f.Errors.Add(fieldName, "This field must be an amount like 120 or 120.50")
+		return false
+	}
+	return true
+}
+
+// ParseMoney converts an amount of dollars such as "120.50" to cents.
+func ParseMoney(value string) (int, error) {
+	value = strings.TrimPrefix(strings.TrimSpace(value), "$")
+	dollars, cents, hasCents := strings.Cut(value, ".")
+	if dollars == "" || (hasCents && (cents == "" || len(cents) > 2)) {
+		return 0, fmt.Errorf("invalid amount %q", value)
+	}
+	if len(cents) == 1 {
+		cents += "0"
+	}
+
+	amount, err := strconv.Atoi(dollars + cents)
+	if err != nil || amount < 0 || strings.ContainsAny(value, "+-") {
+		return 0, fmt.Errorf("invalid amount %q", value)
+	}
+	if !hasCents {
+		amount *= 100
+	}
+	return amount, nil
+}
@@ -105,3 +105,98 @@ func TestForm_Required(t *testing.T) {
 		t.Error("Form is invalid when required fields are present")
 	}
 }
+
+func TestForm_IntBetween(t *testing.T) {
+	var tests = []struct {
+		value    string
+		expected bool
+	}{
+		{"50", true},
+		{"0", true},
+		{"100", true},
+		{"101", false},
+		{"-1", false},
+		{"12.5", false},
+		{"fifty", false},
+		{"", false},
+	}
+
+	for _, test := range tests {
+		postData := url.Values{}
+		postData.Add("percent", test.value)
+		form := New(postData)
+
+		if form.IntBetween("percent", 0, 100) != test.expected {
+			t.Errorf("For value %q, expected IntBetween to return %t", test.value, test.expected)
+		}
+		if form.Valid() != test.expected {
+			t.Errorf("For value %q, expected the form to be valid: %t", test.value, test.expected)
+		}
+	}
+}
+
+func TestForm_Ints(t *testing.T) {
+	form := New(url.Values{"amenities": {"2", "1", "2"}})
+	ints := form.Ints("amenities")
+	if len(ints) != 2 || ints[0] != 2 || ints[1] != 1 {
+		t.Errorf("Expected [2 1], got %v", ints)
+	}
+	if !form.Valid() {
+		t.Error("Form is invalid with whole numbers")
+	}
+
+	form = New(url.Values{"amenities": {"1", "balcony"}})
+	if form.Ints("amenities") != nil || form.Valid() {
+		t.Error("Expected an error for a value that isn't a whole number")
+	}
+
+	form = New(nil)
+	if form.Ints("amenities") != nil || !form.Valid() {
+		t.Error("Expected no values and no error for a missing field")
+	}
+}
+
+func TestForm_Checked(t *testing.T) {
+	form := New(url.Values{"amenities": {"1", "3"}})
+	if !form.Checked("amenities", 3) || !form.Checked("amenities", "1") {
+		t.Error("Expected values 1 and 3 to be checked")
+	}
+	if form.Checked("amenities", 2) || New(nil).Checked("amenities", 1) {
+		t.Error("Expected value 2 not to be checked")
+	}
+}
+
+func TestParseMoney(t *testing.T) {
+	var tests = []struct {
+		value    string
+		expected int
+		valid    bool
+	}{
+		{"120", 12000, true},
+		{"120.5", 12050, true},
+		{"120.50", 12050, true},
+		{"$99.99", 9999, true},
+		{"0", 0, true},
+		{"120.505", 0, false},
+		{"120.", 0, false},
+		{".50", 0, false},
+		{"-10", 0, false},
+		{"ten", 0, false},
+		{"", 0, false},
+	}
+
+	for _, test := range tests {
+		amount, err := ParseMoney(test.value)
+		if (err == nil) != test.valid {
+			t.Errorf("For value %q, expected valid to be %t but got error %v", test.value, test.valid, err)
+		}
+		if test.valid && amount != test.expected {
+			t.Errorf("For value %q, expected %d cents but got %d", test.value, test.expected, amount)
+		}
+
+		form := New(url.Values{"rate": []string{test.value}})
+		if form.IsMoney("rate") != test.valid {
+			t.Errorf("For value %q, expected IsMoney to return %t", test.value, test.valid)
+		}
+	}
+}
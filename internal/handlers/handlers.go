@@ -1,19 +1,27 @@
 package handlers
 
 import (
+	"crypto/md5"
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/go-chi/chi/v5"
+	"github.com/nambroa/lodging-bookings/internal/audit"
 	"github.com/nambroa/lodging-bookings/internal/config"
 	"github.com/nambroa/lodging-bookings/internal/driver"
 	"github.com/nambroa/lodging-bookings/internal/forms"
 	"github.com/nambroa/lodging-bookings/internal/helpers"
 	"github.com/nambroa/lodging-bookings/internal/models"
+	"github.com/nambroa/lodging-bookings/internal/photos"
+	"github.com/nambroa/lodging-bookings/internal/pricing"
 	"github.com/nambroa/lodging-bookings/internal/render"
 	"github.com/nambroa/lodging-bookings/internal/repository"
 	"github.com/nambroa/lodging-bookings/internal/repository/dbrepo"
+	"html"
+	"io"
 	"log"
+	"mime/multipart"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -23,8 +31,9 @@ import (
 
 // Repository pattern used to share the appConfig and the DB with the handlers.
 type Repository struct {
-	App *config.AppConfig
-	DB  repository.DatabaseRepo
+	App     *config.AppConfig
+	DB      repository.DatabaseRepo
+	Pricing *pricing.Service
 }
 
 // Repo is used by the handlers.
@@ -32,17 +41,21 @@ var Repo *Repository
 
 // NewRepo creates a new repository with the content being the app config instance.
 func NewRepo(appConfig *config.AppConfig, db *driver.DB) *Repository {
+	dbRepo := dbrepo.NewPostgresRepo(db.SQL, appConfig)
 	return &Repository{
-		App: appConfig,
-		DB:  dbrepo.NewPostgresRepo(db.SQL, appConfig),
+		App:     appConfig,
+		DB:      dbRepo,
+		Pricing: pricing.NewService(dbRepo),
 	}
 }
 
 // NewTestRepo creates a new repository without a DB connection for the unit tests.
 func NewTestRepo(appConfig *config.AppConfig) *Repository {
+	dbRepo := dbrepo.NewTestingRepo(appConfig)
 	return &Repository{
-		App: appConfig,
-		DB:  dbrepo.NewTestingRepo(appConfig),
+		App:     appConfig,
+		DB:      dbRepo,
+		Pricing: pricing.NewService(dbRepo),
 	}
 }
 
@@ -62,14 +75,43 @@ func (m *Repository) About(w http.ResponseWriter, r *http.Request) {
 	render.Template(w, r, "about.page.gohtml", &models.TemplateData{})
 }
 
-// Majors is the Majors Suite page handler. Renders the room page.
-func (m *Repository) Majors(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "majors.page.gohtml", &models.TemplateData{})
+// Rooms is the rooms page handler. Lists the rooms guests can book.
+func (m *Repository) Rooms(w http.ResponseWriter, r *http.Request) {
+	rooms, err := m.DB.GetAllRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	err = m.withCoverPhotos(rooms)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	render.Template(w, r, "rooms.page.gohtml", &models.TemplateData{Data: map[string]interface{}{"rooms": rooms}})
 }
 
-// Generals is the Generals Quarters page handler. Renders the room page.
-func (m *Repository) Generals(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "generals.page.gohtml", &models.TemplateData{})
+// ShowRoom is the room page handler. Renders the room with the slug in the URL.
+func (m *Repository) ShowRoom(w http.ResponseWriter, r *http.Request) {
+	room, err := m.DB.GetRoomBySlug(chi.URLParam(r, "slug"))
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	room.Photos, err = m.DB.GetPhotosForRoom(room.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	room.Amenities, err = m.DB.GetAmenitiesForRoom(room.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	render.Template(w, r, "room.page.gohtml", &models.TemplateData{Data: map[string]interface{}{"room": room}})
 }
 
 // Contact is the contact page handler.
@@ -77,6 +119,12 @@ func (m *Repository) Contact(w http.ResponseWriter, r *http.Request) {
 	render.Template(w, r, "contact.page.gohtml", &models.TemplateData{})
 }
 
+// quoteGuests is the number of guests stays are quoted for when nobody is booking them, like on the rate calendar.
+const quoteGuests = 1
+
+// maxGuests is the most adults, or children, a stay can be searched or booked for.
+const maxGuests = 20
+
 // Reservation is the Make Reservation page handler.
 func (m *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
 	reservation, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
@@ -92,31 +140,203 @@ func (m *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 		return
 	}
-	reservation.Room.RoomName = room.RoomName
+	reservation.Room = room
+	// The guest is booked at the price quoted here, even if the rates change before the form is submitted.
+	reservation.Quote, err = m.Pricing.QuoteRoom(room, reservation.StartDate, reservation.EndDate,
+		reservation.Guests())
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't quote room")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+	// The promo codes the guest applied are checked again, since the stay or the codes may have changed since.
+	var dropped []string
+	reservation.Quote, dropped, err = m.applyPromoCodes(r, reservation.Quote, reservation.Email)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't apply promo codes")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+	if len(dropped) > 0 {
+		m.App.Session.Put(r.Context(), "error", strings.Join(dropped, ". "))
+	}
 	m.App.Session.Put(r.Context(), "reservation", reservation) // Adding reservation with room info to the session.
+
+	// Showing the form counts as activity, so the room stays held while the guest fills it in.
+	err = m.keepHold(r, reservation)
+	if errors.Is(err, repository.ErrRoomNotAvailable) {
+		m.roomTaken(w, r)
+		return
+	}
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't hold room")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+	extras, err := m.DB.GetExtrasForStay(reservation.StartDate, reservation.EndDate, 0)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't get extras")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+
+	// Identifies this submission of the form, so submitting it twice doesn't make two reservations.
+	key, err := helpers.GenerateToken()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	renderMakeReservation(w, r, reservation, extras, forms.New(nil), key)
+}
+
+// renderMakeReservation renders the make reservation form of a reservation, offering the extras of its stay.
+func renderMakeReservation(w http.ResponseWriter, r *http.Request, reservation models.Reservation,
+	extras []models.Extra, form *forms.Form, key string) {
 	// Parsing go date format to user readable in order to show it in the reservation html.
 	startDateFormatted := reservation.StartDate.Format("2006-01-02") // Formats time.Time in that specific layout.
 	endDateFormatted := reservation.EndDate.Format("2006-01-02")     // Formats time.Time in that specific layout.
 
-	data := map[string]interface{}{"reservation": reservation} // must be same key as reservation is called in PostReservation.
-	stringMap := map[string]string{"start_date": startDateFormatted, "end_date": endDateFormatted}
+	data := map[string]interface{}{"reservation": reservation, "extras": extras}
+	stringMap := map[string]string{"start_date": startDateFormatted, "end_date": endDateFormatted,
+		"idempotency_key": key}
 	render.Template(w, r, "make-reservation.page.gohtml", &models.TemplateData{
-		Form:      forms.New(nil),
+		Form:      form,
 		Data:      data,
 		StringMap: stringMap,
 	})
 }
 
-// PostReservation handles the posting of a reservation form.
-func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
-	// Get reservation from session context
+// maxExtraQuantity is the most units of an extra a stay can book.
+const maxExtraQuantity = 10
+
+// extraQuantities returns the quantities of the extras chosen in an extras form, by extra id, adding an error to the
+// form for the ones that don't have that many units left. Per guest extras are chosen with a checkbox and booked for
+// every guest.
+func extraQuantities(form *forms.Form, extras []models.Extra, guests int) map[int]int {
+	quantities := make(map[int]int)
+	for _, extra := range extras {
+		field := fmt.Sprintf("extra_%d", extra.ID)
+		if !form.Has(field) {
+			continue
+		}
+		if extra.Per == models.ExtraPerGuestNight {
+			quantities[extra.ID] = guests
+		} else if form.IntBetween(field, 0, maxExtraQuantity) {
+			quantities[extra.ID], _ = strconv.Atoi(strings.TrimSpace(form.Get(field)))
+		}
+
+		if extra.Limited() && quantities[extra.ID] > extra.Remaining {
+			if extra.Remaining == 0 {
+				form.Errors.Add(field, "Sold out for these dates")
+			} else {
+				form.Errors.Add(field, fmt.Sprintf("Only %d left for these dates", extra.Remaining))
+			}
+		}
+	}
+	return quantities
+}
+
+// applyPromoCodes applies the promo codes the guest entered on the make reservation page to the quote of their
+// stay, booked with email. The codes that can't be redeemed are dropped, and the reasons why are returned so the
+// guest can be told.
+func (m *Repository) applyPromoCodes(r *http.Request, quote models.Quote, email string) (models.Quote, []string,
+	error) {
+	codes, _ := m.App.Session.Get(r.Context(), "promo_codes").([]string)
+	var dropped []string
+	for {
+		discounted, err := m.Pricing.ApplyPromoCodes(quote, codes, email)
+		var promoErr *pricing.PromoCodeError
+		if !errors.As(err, &promoErr) {
+			m.App.Session.Put(r.Context(), "promo_codes", codes)
+			return discounted, dropped, err
+		}
+
+		dropped = append(dropped, fmt.Sprintf("The promo code %s %s", promoErr.Code, promoErr.Reason))
+		remaining := removePromoCode(codes, promoErr.Code)
+		if len(remaining) == len(codes) {
+			return quote, dropped, err
+		}
+		codes = remaining
+	}
+}
+
+// removePromoCode returns the codes without code.
+func removePromoCode(codes []string, code string) []string {
+	var remaining []string
+	for _, c := range codes {
+		if c != code {
+			remaining = append(remaining, c)
+		}
+	}
+	return remaining
+}
+
+// PostPromoCode applies a promo code to the stay on the make reservation page, if it can be redeemed for it together
+// with the codes already applied.
+func (m *Repository) PostPromoCode(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
 	reservation, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
 	if !ok {
-		m.App.Session.Put(r.Context(), "error", "can't get reservation from session for PostReservation")
+		m.App.Session.Put(r.Context(), "error", "can't get reservation from session")
 		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 		return
 	}
 
+	code := models.NormalizePromoCode(r.Form.Get("promo_code"))
+	if code == "" {
+		m.App.Session.Put(r.Context(), "error", "Enter a promo code")
+		http.Redirect(w, r, "/make-reservation", http.StatusSeeOther)
+		return
+	}
+	codes, _ := m.App.Session.Get(r.Context(), "promo_codes").([]string)
+	for _, applied := range codes {
+		if applied == code {
+			m.App.Session.Put(r.Context(), "error", fmt.Sprintf("The promo code %s was already applied", code))
+			http.Redirect(w, r, "/make-reservation", http.StatusSeeOther)
+			return
+		}
+	}
+
+	codes = append(codes, code)
+	_, err = m.Pricing.ApplyPromoCodes(reservation.Quote, codes, reservation.Email)
+	var promoErr *pricing.PromoCodeError
+	if errors.As(err, &promoErr) {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("The promo code %s %s", promoErr.Code, promoErr.Reason))
+		http.Redirect(w, r, "/make-reservation", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "promo_codes", codes)
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Promo code %s applied", code))
+	http.Redirect(w, r, "/make-reservation", http.StatusSeeOther)
+}
+
+// PostRemovePromoCode takes a promo code off the stay on the make reservation page.
+func (m *Repository) PostRemovePromoCode(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	codes, _ := m.App.Session.Get(r.Context(), "promo_codes").([]string)
+	m.App.Session.Put(r.Context(), "promo_codes",
+		removePromoCode(codes, models.NormalizePromoCode(r.Form.Get("promo_code"))))
+	m.App.Session.Put(r.Context(), "flash", "Promo code removed")
+	http.Redirect(w, r, "/make-reservation", http.StatusSeeOther)
+}
+
+// PostReservation handles the posting of a reservation form.
+func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 	if err != nil {
 		m.App.Session.Put(r.Context(), "error", "can't parse form for PostReservation")
@@ -124,56 +344,151 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// A repeated submission (double click, refresh, client retry) returns the reservation made by the first one.
+	// This is checked before the session, since the first submission may already have taken the reservation out.
+	key := idempotencyKey(r)
+	scopedKey := m.scopedIdempotencyKey(r, key)
+	if key != "" {
+		record, claimed, err := m.claimIdempotencyKey(scopedKey, requestFingerprint(r.PostForm))
+		if err != nil {
+			m.idempotencyKeyError(w, r, err, "PostReservation")
+			return
+		}
+		if !claimed {
+			m.replayReservation(w, r, record.ReservationID)
+			return
+		}
+		// If no reservation gets made, free the key so the corrected form can be submitted again with it.
+		defer m.releaseIdempotencyKey(scopedKey)
+	}
+
+	// Get reservation from session context
+	reservation, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
+	if !ok {
+		m.App.Session.Put(r.Context(), "error", "can't get reservation from session for PostReservation")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+
 	// Update reservation stored in the session.
 	reservation.FirstName = r.Form.Get("first_name")
 	reservation.LastName = r.Form.Get("last_name")
 	reservation.Email = r.Form.Get("email")
 	reservation.Phone = r.Form.Get("phone")
-	roomId, err := strconv.Atoi(r.Form.Get("room_id"))
-	room, err := m.DB.GetRoomByID(roomId)
+	// The room comes from the reservation the guest held, a room_id posted with the form could be any room.
+	room, err := m.DB.GetRoomByID(reservation.RoomID)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't get room from DB for PostReservation")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
 	reservation.Room = room
 
+	// Make sure the room is still held for the guest, so nobody can book it while this reservation is saved.
+	err = m.keepHold(r, reservation)
+	if errors.Is(err, repository.ErrRoomNotAvailable) {
+		m.roomTaken(w, r)
+		return
+	}
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't hold room for PostReservation")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+
 	form := forms.New(r.PostForm)
 	form.Required("first_name", "last_name", "email")
 	form.MinLength("first_name", 3)
 	form.IsEmail("email")
+	reservation.Adults, reservation.Children = stayGuests(form)
+	if form.Valid() && !room.Sleeps(reservation.Guests()) {
+		form.Errors.Add("adults", fmt.Sprintf("The %s sleeps at most %d guests", room.RoomName, room.Capacity))
+	}
 
-	// If form is invalid, repopulate the fields of the reservation so the user only needs to type the errored fields.
-	if !form.Valid() {
-		data := map[string]interface{}{"reservation": reservation}
+	// Taxes and fees can be charged per guest, so the stay is quoted again if the guest changed how many they are.
+	if form.Valid() && reservation.Guests() != reservation.Quote.Guests {
+		reservation.Quote, err = m.Pricing.QuoteRoom(room, reservation.StartDate, reservation.EndDate,
+			reservation.Guests())
+		if err != nil {
+			m.App.Session.Put(r.Context(), "error", "can't quote room for PostReservation")
+			http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+			return
+		}
+	}
 
-		render.Template(w, r, "make-reservation.page.gohtml", &models.TemplateData{
-			Form: form,
-			Data: data,
-		})
+	// The extras are checked against what's left of them now, and again while the reservation is saved.
+	extras, err := m.DB.GetExtrasForStay(reservation.StartDate, reservation.EndDate, 0)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't get extras for PostReservation")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 		return
 	}
-
-	newReservationID, err := m.DB.InsertReservation(reservation)
+	reservation.Quote = pricing.AddExtras(reservation.Quote, extras,
+		extraQuantities(form, extras, reservation.Guests()))
+	// Some promo codes can only be redeemed a number of times with the same email, which is only known now.
+	var dropped []string
+	reservation.Quote, dropped, err = m.applyPromoCodes(r, reservation.Quote, reservation.Email)
 	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "can't insert reservation into DB for PostReservation")
+		m.App.Session.Put(r.Context(), "error", "can't apply promo codes for PostReservation")
 		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 		return
 	}
-	restriction := models.RoomRestriction{
-		StartDate:     reservation.StartDate,
-		EndDate:       reservation.EndDate,
-		RoomID:        reservation.RoomID,
-		ReservationID: newReservationID,
-		RestrictionID: 1, // Temporary, RestrictionID 1 = Restriction of type reservation
+	for _, message := range dropped {
+		form.Errors.Add("promo_code", message)
+	}
+
+	// If form is invalid, repopulate the fields of the reservation so the user only needs to type the errored fields.
+	if !form.Valid() {
+		renderMakeReservation(w, r, reservation, extras, form, key)
+		return
+	}
+
+	// The hold becomes the room restriction of the reservation and the key records it, in the same transaction.
+	newReservationID, confirmationCode, err := m.DB.InsertReservation(reservation,
+		m.App.Session.GetInt(r.Context(), "hold_id"), scopedKey)
+	if errors.Is(err, repository.ErrRoomNotAvailable) {
+		// The hold expired right before the reservation was saved, and someone else took the last unit meanwhile.
+		m.App.Session.Remove(r.Context(), "hold_id")
+		m.roomTaken(w, r)
+		return
+	}
+	if errors.Is(err, repository.ErrExtraSoldOut) {
+		// Someone else booked the last units of an extra while the guest filled in the form.
+		extras, err = m.DB.GetExtrasForStay(reservation.StartDate, reservation.EndDate, 0)
+		if err != nil {
+			m.App.Session.Put(r.Context(), "error", "can't get extras for PostReservation")
+			http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+			return
+		}
+		form.Errors.Add("extras", "Some of the extras you chose just sold out, please choose them again")
+		renderMakeReservation(w, r, reservation, extras, form, key)
+		return
+	}
+	if errors.Is(err, repository.ErrPromoCodeUsedUp) {
+		// Someone else redeemed the last use of a promo code while the guest filled in the form.
+		form.Errors.Add("promo_code", "One of your promo codes was just used up, please remove it")
+		renderMakeReservation(w, r, reservation, extras, form, key)
+		return
 	}
-	err = m.DB.InsertRoomRestriction(restriction)
 	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "can't insert room restriction into DB for PostReservation")
+		m.App.Session.Put(r.Context(), "error", "can't insert reservation into DB for PostReservation")
 		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 		return
 	}
+	reservation.ID = newReservationID
+	reservation.ConfirmationCode = confirmationCode
+	m.App.Session.Remove(r.Context(), "promo_codes")
+	m.App.Session.Remove(r.Context(), "hold_id")
 	// Send email notification to the guest.
 	htmlMessage := fmt.Sprintf(`
 		<strong> Reservation Confirmation </strong><br>
 		Dear %s: <br>
-		Your reservation for the %s from the %s to the %s is now confirmed.
-`, reservation.FirstName, reservation.Room.RoomName, reservation.StartDate.Format("2006-01-02"), reservation.EndDate.Format("2006-01-02"))
+		Your reservation for the %s from the %s to the %s, for %s, is now confirmed.<br>
+		Your confirmation code is <strong>%s</strong>.<br>
+		%s
+`, reservation.FirstName, reservation.Room.RoomName, reservation.StartDate.Format("2006-01-02"),
+		reservation.EndDate.Format("2006-01-02"), reservation.GuestsDescription(), reservation.ConfirmationCode,
+		quoteLines(reservation.Quote))
 
 	msg := models.MailData{
 		To:      reservation.Email,
@@ -187,8 +502,10 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 	htmlMessage = fmt.Sprintf(`
 		<strong> Reservation Confirmation </strong><br>
 		Dear %s: <br>
-		A reservation has been made for your property %s from the %s to the %s.
-`, reservation.FirstName, reservation.Room.RoomName, reservation.StartDate.Format("2006-01-02"), reservation.EndDate.Format("2006-01-02"))
+		A reservation (%s) has been made for your property %s from the %s to the %s, for %s, for a total of %s.
+`, reservation.FirstName, reservation.ConfirmationCode, reservation.Room.RoomName,
+		reservation.StartDate.Format("2006-01-02"), reservation.EndDate.Format("2006-01-02"),
+		reservation.GuestsDescription(), render.FormatMoney(reservation.Quote.Total))
 
 	msg = models.MailData{
 		To:      "owner-email@here.com",
@@ -204,6 +521,140 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/reservation-summary", http.StatusSeeOther)
 }
 
+// quoteLines itemizes the price of a stay for the emails sent to guests.
+func quoteLines(quote models.Quote) string {
+	var lines strings.Builder
+	fmt.Fprintf(&lines, "%d night(s): %s<br>\n", len(quote.Nights), render.FormatMoney(quote.Subtotal))
+	for _, extra := range quote.Extras {
+		fmt.Fprintf(&lines, "%s x %d: %s<br>\n", html.EscapeString(extra.Name), extra.Quantity,
+			render.FormatMoney(extra.Amount))
+	}
+	for _, discount := range quote.Discounts {
+		fmt.Fprintf(&lines, "Promo code %s: %s<br>\n", html.EscapeString(discount.Code),
+			render.FormatMoney(-discount.Amount))
+	}
+	for _, item := range quote.LineItems {
+		fmt.Fprintf(&lines, "%s: %s<br>\n", html.EscapeString(item.Name), render.FormatMoney(item.Amount))
+	}
+	fmt.Fprintf(&lines, "<strong>Total: %s</strong>", render.FormatMoney(quote.Total))
+	return lines.String()
+}
+
+// idempotencyWait is how long a repeated submission waits for the first one to finish, before giving up.
+const idempotencyWait = 5 * time.Second
+
+// idempotencyPollInterval is how often a repeated submission checks whether the first one finished.
+const idempotencyPollInterval = 100 * time.Millisecond
+
+// idempotencyKeyLifetime is how long a submission can be repeated and still get the reservation it made.
+const idempotencyKeyLifetime = 24 * time.Hour
+
+var errIdempotencyKeyReused = errors.New("idempotency key was already used for a different reservation")
+var errIdempotencyKeyInProgress = errors.New("a reservation with this idempotency key is still being processed")
+
+// idempotencyKey returns the key identifying a reservation submission. API callers send it in the Idempotency-Key
+// header, the reservation form sends it as a hidden field. An empty key disables the duplicate check.
+func idempotencyKey(r *http.Request) string {
+	if key := strings.TrimSpace(r.Header.Get("Idempotency-Key")); key != "" {
+		return key
+	}
+	return strings.TrimSpace(r.Form.Get("idempotency_key"))
+}
+
+// scopedIdempotencyKey ties a key to the session that sent it, so a key sent from another session never replays the
+// reservation of this one. An empty key stays empty.
+func (m *Repository) scopedIdempotencyKey(r *http.Request, key string) string {
+	if key == "" {
+		return ""
+	}
+	return helpers.HashToken(m.App.Session.Token(r.Context()) + ":" + key)
+}
+
+// requestFingerprint hashes the submitted fields, leaving out the ones that change between identical submissions.
+func requestFingerprint(form url.Values) string {
+	fields := url.Values{}
+	for field, values := range form {
+		if field == "csrf_token" || field == "idempotency_key" {
+			continue
+		}
+		fields[field] = values
+	}
+	return helpers.HashToken(fields.Encode())
+}
+
+// claimIdempotencyKey claims a key for this submission. If another submission already claimed it, this waits until
+// that one made its reservation and returns it, with claimed as false.
+func (m *Repository) claimIdempotencyKey(key, requestHash string) (models.IdempotencyKey, bool, error) {
+	deadline := time.Now().Add(idempotencyWait)
+	for {
+		record, claimed, err := m.DB.ClaimIdempotencyKey(key, requestHash, time.Now().Add(idempotencyKeyLifetime))
+		// The key disappears if the first submission fails between the insert and the select. Claiming again is fine.
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return record, false, err
+		}
+		if err == nil {
+			if claimed {
+				return record, true, nil
+			}
+			if record.RequestHash != requestHash {
+				return record, false, errIdempotencyKeyReused
+			}
+			if record.ReservationID != 0 || record.GroupID != 0 {
+				return record, false, nil
+			}
+		}
+		if time.Now().After(deadline) {
+			return record, false, errIdempotencyKeyInProgress
+		}
+		time.Sleep(idempotencyPollInterval)
+	}
+}
+
+// idempotencyKeyError answers a submission whose idempotency key couldn't be claimed.
+func (m *Repository) idempotencyKeyError(w http.ResponseWriter, r *http.Request, err error, handler string) {
+	switch {
+	case errors.Is(err, errIdempotencyKeyReused):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	case errors.Is(err, errIdempotencyKeyInProgress):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		m.App.Session.Put(r.Context(), "error", "can't check idempotency key for "+handler)
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+	}
+}
+
+// releaseIdempotencyKey frees a key whose submission didn't make a reservation. Completed keys are kept.
+func (m *Repository) releaseIdempotencyKey(key string) {
+	err := m.DB.DeleteIdempotencyKey(key)
+	if err != nil {
+		m.App.ErrorLog.Println("Cannot release idempotency key:", err)
+	}
+}
+
+// replayReservation answers a repeated submission with the summary of the reservation made by the first one.
+func (m *Repository) replayReservation(w http.ResponseWriter, r *http.Request, reservationID int) {
+	reservation, err := m.DB.GetReservationByID(reservationID)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't get reservation from DB for PostReservation")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+	m.App.Session.Put(r.Context(), "reservation", reservation)
+	http.Redirect(w, r, "/reservation-summary", http.StatusSeeOther)
+}
+
+// replayGroup answers a repeated booking cart submission with the summary of the group booked by the first one.
+func (m *Repository) replayGroup(w http.ResponseWriter, r *http.Request, groupID int) {
+	group, err := m.DB.GetReservationGroupByID(groupID)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't get reservation group from DB for PostCart")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+	m.App.Session.Put(r.Context(), "group", group)
+	http.Redirect(w, r, "/cart-summary", http.StatusSeeOther)
+}
+
 // ReservationSummary displays information about the reservation after confirming it.
 func (m *Repository) ReservationSummary(w http.ResponseWriter, r *http.Request) {
 	// Take reservation info from the session.
@@ -232,52 +683,224 @@ func (m *Repository) ReservationSummary(w http.ResponseWriter, r *http.Request)
 
 // Availability is the search availability page handler.
 func (m *Repository) Availability(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "search-availability.page.gohtml", &models.TemplateData{})
+	m.renderSearchAvailability(w, r, forms.New(nil))
+}
+
+// renderSearchAvailability renders the search availability page with the given form, and the amenities guests can
+// filter the rooms by.
+func (m *Repository) renderSearchAvailability(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	amenities, err := m.DB.GetAmenities()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	render.Template(w, r, "search-availability.page.gohtml", &models.TemplateData{
+		Data: map[string]interface{}{"amenities": amenities},
+		Form: form,
+	})
 }
 
 // PostAvailability is the search availability form handler.
 // PostAvailability renders the search availability page after the form has been processed.
 func (m *Repository) PostAvailability(w http.ResponseWriter, r *http.Request) {
-	// matches input from form with name attribute start. Default type is string
-	startDate, err := parseDateFromForm(r.Form, "start")
-	endDate, err := parseDateFromForm(r.Form, "end")
+	err := r.ParseForm()
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
 	}
 
-	rooms, err := m.DB.SearchAvailabilityForAllRooms(startDate, endDate)
-	if err != nil {
-		helpers.ServerError(w, err)
+	form := forms.New(r.PostForm)
+	// Guests looking for any stay in a month don't pick their dates.
+	anyStayInMonth := form.Get("flexibility") == models.FlexibleMonth
+	var startDate, endDate time.Time
+	if !anyStayInMonth {
+		startDate, endDate = m.stayDates(form)
+	}
+	adults, children := stayGuests(form)
+	amenityIDs := form.Ints("amenities")
+	windows := m.flexibleWindows(form, startDate, endDate)
+	if !form.Valid() {
+		m.renderSearchAvailability(w, r, form)
 		return
 	}
 
+	// Rooms too small for the guests, or missing some of the amenities they are looking for, are left out.
+	var rooms []models.Room
+	if !anyStayInMonth {
+		rooms, err = m.DB.SearchAvailabilityForAllRooms(startDate, endDate, adults+children, amenityIDs)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+
+	// When nothing is free for the dates of a flexible guest, other dates are suggested instead.
+	if len(rooms) == 0 && len(windows) > 0 {
+		alternatives, err := m.alternativeRooms(windows, adults+children, amenityIDs)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		if len(alternatives) > 0 {
+			render.Template(w, r, "alternative-dates.page.gohtml", &models.TemplateData{
+				Data: map[string]interface{}{"alternatives": alternatives},
+				StringMap: map[string]string{"adults": strconv.Itoa(adults), "children": strconv.Itoa(children),
+					"flexibility": form.Get("flexibility")},
+			})
+			return
+		}
+	}
+
 	if len(rooms) == 0 {
 		m.App.Session.Put(r.Context(), "error", "No availability for selected dates. Please select another date.")
 		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
 		return
 	}
+	err = m.withCoverPhotos(rooms)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// Show what staying in each room would cost, so the guest can compare them.
+	quotes := make(map[int]models.Quote)
+	for _, room := range rooms {
+		quotes[room.ID], err = m.Pricing.QuoteRoom(room, startDate, endDate, adults+children)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
 
 	data := make(map[string]interface{})
 	data["rooms"] = rooms
+	data["quotes"] = quotes
 
 	// Storing info in the session to pass to the make reservation page later.
 	reservation := models.Reservation{
 		StartDate: startDate,
 		EndDate:   endDate,
+		Adults:    adults,
+		Children:  children,
 	}
 	m.App.Session.Put(r.Context(), "reservation", reservation)
 
-	render.Template(w, r, "choose-room.page.gohtml", &models.TemplateData{Data: data})
+	// The stay is also in the links adding a room to the booking cart.
+	stringMap := map[string]string{"start": r.Form.Get("start"), "end": r.Form.Get("end"),
+		"adults": strconv.Itoa(adults), "children": strconv.Itoa(children)}
+	render.Template(w, r, "choose-room.page.gohtml", &models.TemplateData{Data: data, StringMap: stringMap})
 }
 
-type jsonResponse struct {
-	OK        bool   `json:"ok"` // `json` part means the name of the attribute in json.
-	Message   string `json:"message"`
-	RoomID    string `json:"room_id"`
-	StartDate string `json:"start_date"`
-	EndDate   string `json:"end_date"`
-}
+// maxFlexibleNights is the longest stay guests can look for in a month.
+const maxFlexibleNights = 14
+
+// maxAlternativesPerRoom is how many other stays are suggested for every room, when the one searched for isn't free.
+const maxAlternativesPerRoom = 3
+
+// flexibleWindows returns the other stays a search should look at, given how flexible the guest is about their dates
+// in the flexibility field of a form: up to 1, 3 or 7 days around start and end, or any stay of the nights field in
+// the month field. Stays outside the booking window are left out. It adds an error to the form when the fields are
+// invalid.
+func (m *Repository) flexibleWindows(form *forms.Form, start, end time.Time) []models.DateWindow {
+	var windows []models.DateWindow
+	switch form.Get("flexibility") {
+	case "":
+		return nil
+	case "1", "3", "7":
+		days, _ := strconv.Atoi(form.Get("flexibility"))
+		if form.Valid() {
+			windows = models.NearbyWindows(start, end, days)
+		}
+	case models.FlexibleMonth:
+		form.Required("month")
+		month, err := time.Parse("2006-01", form.Get("month"))
+		if form.Has("month") && err != nil {
+			form.Errors.Add("month", "Invalid month")
+		}
+		if !form.Has("nights") {
+			form.Set("nights", "1")
+		}
+		form.IntBetween("nights", 1, maxFlexibleNights)
+		nights, _ := strconv.Atoi(strings.TrimSpace(form.Get("nights")))
+		if form.Valid() {
+			windows = models.MonthWindows(month, nights)
+		}
+	default:
+		form.Errors.Add("flexibility", "Invalid flexibility")
+	}
+
+	var bookable []models.DateWindow
+	for _, window := range windows {
+		if _, broken := m.App.BookingWindow.Violation(window.Start, time.Now()); !broken {
+			bookable = append(bookable, window)
+		}
+	}
+	if form.Valid() && form.Get("flexibility") == models.FlexibleMonth && len(bookable) == 0 {
+		form.Errors.Add("month", "None of the stays in this month can be booked anymore")
+	}
+	return bookable
+}
+
+// alternativeRooms searches the rooms free for each window, for the guests and with the amenities they are looking
+// for, and returns the best of them, quoted.
+func (m *Repository) alternativeRooms(windows []models.DateWindow, guests int,
+	amenityIDs []int) ([]models.Alternative, error) {
+	var alternatives []models.Alternative
+	for _, window := range windows {
+		rooms, err := m.DB.SearchAvailabilityForAllRooms(window.Start, window.End, guests, amenityIDs)
+		if err != nil {
+			return nil, err
+		}
+		for _, room := range rooms {
+			quote, err := m.Pricing.QuoteRoom(room, window.Start, window.End, guests)
+			if err != nil {
+				return nil, err
+			}
+			alternatives = append(alternatives, models.Alternative{Room: room, Window: window, Quote: quote})
+		}
+	}
+	return models.RankAlternatives(alternatives, maxAlternativesPerRoom), nil
+}
+
+// alternativeDates returns the best windows the room is free for, quoted for the guests.
+func (m *Repository) alternativeDates(room models.Room, windows []models.DateWindow,
+	guests int) ([]models.Alternative, error) {
+	var alternatives []models.Alternative
+	for _, window := range windows {
+		free, err := m.DB.SearchAvailabilityByDatesByRoomID(window.Start, window.End, room.ID)
+		if err != nil {
+			return nil, err
+		}
+		if !free {
+			continue
+		}
+		quote, err := m.Pricing.QuoteRoom(room, window.Start, window.End, guests)
+		if err != nil {
+			return nil, err
+		}
+		alternatives = append(alternatives, models.Alternative{Room: room, Window: window, Quote: quote})
+	}
+	return models.RankAlternatives(alternatives, maxAlternativesPerRoom), nil
+}
+
+type jsonResponse struct {
+	OK           bool              `json:"ok"` // `json` part means the name of the attribute in json.
+	Message      string            `json:"message"`
+	RoomID       string            `json:"room_id"`
+	StartDate    string            `json:"start_date"`
+	EndDate      string            `json:"end_date"`
+	Adults       int               `json:"adults"`
+	Children     int               `json:"children"`
+	Alternatives []jsonAlternative `json:"alternatives,omitempty"` // other dates to try, when the room isn't free.
+	Waitlist     bool              `json:"waitlist,omitempty"`     // the room is taken, the guest can wait for it.
+}
+
+// jsonAlternative is another stay the room is free for.
+type jsonAlternative struct {
+	StartDate string `json:"start_date"`
+	EndDate   string `json:"end_date"`
+	Total     string `json:"total"`
+}
 
 // AvailabilityJSON is the search availability form handler. It sends back a JSON response.
 // Used for the popup modal to search availability for a specific room.
@@ -292,17 +915,71 @@ func (m *Repository) AvailabilityJSON(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	startDate, _ := parseDateFromForm(r.Form, "start")
-	endDate, _ := parseDateFromForm(r.Form, "end")
+	form := forms.New(r.PostForm)
+	anyStayInMonth := form.Get("flexibility") == models.FlexibleMonth
+	var startDate, endDate time.Time
+	if !anyStayInMonth {
+		startDate, endDate = m.stayDates(form)
+	}
+	adults, children := stayGuests(form)
+	amenityIDs := form.Ints("amenities")
+	windows := m.flexibleWindows(form, startDate, endDate)
 	roomID, _ := strconv.Atoi(r.Form.Get("room_id"))
 
-	available, _ := m.DB.SearchAvailabilityByDatesByRoomID(startDate, endDate, roomID)
+	available := false
+	var message string
+	for _, field := range []string{"start", "end", "adults", "children", "amenities", "flexibility", "month",
+		"nights"} {
+		if message == "" {
+			message = form.Errors.Get(field)
+		}
+	}
+	room, err := m.DB.GetRoomByID(roomID)
+	if form.Valid() {
+		if err == nil && !room.Sleeps(adults+children) {
+			form.Errors.Add("adults", fmt.Sprintf("The %s sleeps at most %d guests", room.RoomName, room.Capacity))
+			message = form.Errors.Get("adults")
+		}
+		if err == nil && form.Valid() && len(amenityIDs) > 0 {
+			room.Amenities, err = m.DB.GetAmenitiesForRoom(roomID)
+			if err == nil && !room.HasAmenities(amenityIDs) {
+				form.Errors.Add("amenities",
+					fmt.Sprintf("The %s doesn't have all the amenities you are looking for", room.RoomName))
+				message = form.Errors.Get("amenities")
+			}
+		}
+	}
+	if form.Valid() && !anyStayInMonth {
+		available, _ = m.DB.SearchAvailabilityByDatesByRoomID(startDate, endDate, roomID)
+	}
+	if form.Valid() && !available && !anyStayInMonth {
+		// Tell the guest which stay rule their dates break, if any, so they can pick dates that follow it.
+		rules, err := m.DB.GetStayRulesForRoom(roomID, startDate, endDate)
+		if err == nil {
+			message, _ = models.StayViolation(rules, startDate, endDate)
+		}
+	}
 	response := jsonResponse{
 		OK:        available,
-		Message:   "",
+		Message:   message,
 		StartDate: r.Form.Get("start"),
 		EndDate:   r.Form.Get("end"),
 		RoomID:    strconv.Itoa(roomID),
+		Adults:    adults,
+		Children:  children,
+		// Stays breaking a stay rule won't become bookable when the room is freed, so only taken rooms can be waited for.
+		Waitlist: form.Valid() && !available && !anyStayInMonth && room.ID != 0 && message == "",
+	}
+	// A flexible guest gets other dates to try instead.
+	if form.Valid() && !available && room.ID != 0 {
+		alternatives, _ := m.alternativeDates(room, windows, adults+children)
+		for _, alternative := range alternatives {
+			response.Alternatives = append(response.Alternatives, jsonAlternative{
+				StartDate: alternative.Window.Start.Format("2006-01-02"),
+				EndDate:   alternative.Window.End.Format("2006-01-02"),
+				Total:     render.FormatMoney(alternative.Quote.Total),
+			})
+		}
 	}
 
 	out, err := json.MarshalIndent(response, "", "     ")
@@ -318,6 +995,52 @@ func (m *Repository) AvailabilityJSON(w http.ResponseWriter, r *http.Request) {
 	w.Write(out)
 }
 
+// stayDates reads the arrival and departure of a stay from the start and end fields of a form. It adds an error to
+// the form when they are missing, invalid or outside the booking window of the property.
+func (m *Repository) stayDates(form *forms.Form) (time.Time, time.Time) {
+	form.Required("start", "end")
+	if !form.Valid() {
+		return time.Time{}, time.Time{}
+	}
+
+	startDate, err := parseDateFromForm(form.Values, "start")
+	if err != nil {
+		form.Errors.Add("start", "Invalid date")
+	}
+	endDate, err := parseDateFromForm(form.Values, "end")
+	if err != nil {
+		form.Errors.Add("end", "Invalid date")
+	}
+	if !form.Valid() {
+		return startDate, endDate
+	}
+
+	if !endDate.After(startDate) {
+		form.Errors.Add("end", "The departure must be after the arrival")
+	}
+	if reason, broken := m.App.BookingWindow.Violation(startDate, time.Now()); broken {
+		form.Errors.Add("start", reason)
+	}
+	return startDate, endDate
+}
+
+// stayGuests reads how many adults and children are staying from the adults and children fields of a form, one
+// adult and no children when they are left out. It adds an error to the form when they are out of range.
+func stayGuests(form *forms.Form) (int, int) {
+	if !form.Has("adults") {
+		form.Set("adults", "1")
+	}
+	if !form.Has("children") {
+		form.Set("children", "0")
+	}
+	form.IntBetween("adults", 1, maxGuests)
+	form.IntBetween("children", 0, maxGuests)
+
+	adults, _ := strconv.Atoi(strings.TrimSpace(form.Get("adults")))
+	children, _ := strconv.Atoi(strings.TrimSpace(form.Get("children")))
+	return adults, children
+}
+
 // ChooseRoom displays a list of rooms that the user can make a reservation of.
 func (m *Repository) ChooseRoom(w http.ResponseWriter, r *http.Request) {
 	roomID, err := strconv.Atoi(chi.URLParam(r, "id")) // key is the same as the key in routes.go
@@ -330,342 +1053,3718 @@ func (m *Repository) ChooseRoom(w http.ResponseWriter, r *http.Request) {
 		helpers.ServerError(w, errors.New("error obtaining reservation from session while choosing a room"))
 		return
 	}
-
 	reservation.RoomID = roomID
+
+	// The dates were checked when the guest searched, but the booking window may have moved since.
+	form := forms.New(url.Values{"start": {reservation.StartDate.Format("2006-01-02")},
+		"end": {reservation.EndDate.Format("2006-01-02")}})
+	m.stayDates(form)
+	if form.Valid() {
+		reason, broken, err := m.brokenStayRule(reservation)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		if broken {
+			form.Errors.Add("start", reason)
+		}
+	}
+	if !form.Valid() {
+		m.App.Session.Put(r.Context(), "error", firstFormError(form, "start", "end"))
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+
 	m.App.Session.Put(r.Context(), "reservation", reservation)
 
+	err = m.holdRoom(r, reservation)
+	if errors.Is(err, repository.ErrRoomNotAvailable) {
+		m.roomTaken(w, r)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
 	// Redirect user to make a reservation for the room they chose.
 	http.Redirect(w, r, "/make-reservation", http.StatusSeeOther)
 }
 
 // BookRoom takes URL params, builds a reservation in the session and takes the user to the make reservation page.
 func (m *Repository) BookRoom(w http.ResponseWriter, r *http.Request) {
-	// Get room ID, Reservation start and end date from url params.
-	roomId, _ := strconv.Atoi(r.URL.Query().Get("id"))
-	startDate := r.URL.Query().Get("s")
-	endDate := r.URL.Query().Get("e")
-
-	var reservation models.Reservation
-	reservation.RoomID = roomId
-	parsedStartDate, _ := time.Parse("2006-01-02", startDate)
-	parsedEndDate, _ := time.Parse("2006-01-02", endDate)
-	reservation.StartDate = parsedStartDate
-	reservation.EndDate = parsedEndDate
-	room, err := m.DB.GetRoomByID(roomId)
+	roomID, _ := strconv.Atoi(r.URL.Query().Get("id"))
+	room, err := m.DB.GetRoomByID(roomID)
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
 	}
-	reservation.Room.RoomName = room.RoomName
-
-	m.App.Session.Put(r.Context(), "reservation", reservation)
-	http.Redirect(w, r, "/make-reservation", http.StatusSeeOther)
-
-}
-
-func (m *Repository) ShowLogin(writer http.ResponseWriter, request *http.Request) {
-	render.Template(writer, request, "login.page.gohtml", &models.TemplateData{Form: forms.New(nil)})
-}
 
-// PostShowLogin handles the user logging in.
-func (m *Repository) PostShowLogin(writer http.ResponseWriter, request *http.Request) {
-	// Prevents session fixation attack.
-	_ = m.App.Session.RenewToken(request.Context())
-
-	err := request.ParseForm()
+	reservation, form, err := m.requestedStay(r, room)
 	if err != nil {
-		log.Println("Cannot parse postshowlogin form:", err)
-		m.App.Session.Put(request.Context(), "error", "Cannot parse user information")
-		http.Redirect(writer, request, "/user/login", http.StatusSeeOther)
+		helpers.ServerError(w, err)
 		return
 	}
-
-	form := forms.New(request.PostForm)
-	form.Required("email", "password")
-	form.IsEmail("email")
 	if !form.Valid() {
-		log.Println("Postshowlogin form is not valid:", form.Errors)
-		render.Template(writer, request, "login.page.gohtml", &models.TemplateData{Form: form})
+		m.App.Session.Put(r.Context(), "error", firstFormError(form, "start", "end", "adults", "children"))
+		http.Redirect(w, r, "/rooms/"+room.Slug, http.StatusSeeOther)
 		return
 	}
 
-	id, _, err := m.DB.Authenticate(request.Form.Get("email"), request.Form.Get("password"))
+	m.App.Session.Put(r.Context(), "reservation", reservation)
+
+	err = m.holdRoom(r, reservation)
+	if errors.Is(err, repository.ErrRoomNotAvailable) {
+		m.roomTaken(w, r)
+		return
+	}
 	if err != nil {
-		log.Println("Cannot authenticate user in postshowlogin:", err)
-		m.App.Session.Put(request.Context(), "error", "Invalid login credentials")
-		http.Redirect(writer, request, "/user/login", http.StatusSeeOther)
+		helpers.ServerError(w, err)
 		return
 	}
 
-	m.App.Session.Put(request.Context(), "user_id", id)
-	m.App.Session.Put(request.Context(), "flash", "Logged in successfully")
-	http.Redirect(writer, request, "/", http.StatusSeeOther)
+	http.Redirect(w, r, "/make-reservation", http.StatusSeeOther)
 
 }
 
-// Logout logs the user out.
-func (m *Repository) Logout(writer http.ResponseWriter, request *http.Request) {
-	// Destroy the user session.
-	_ = m.App.Session.Destroy(request.Context())
-	// Prevents session fixation attack.
-	_ = m.App.Session.RenewToken(request.Context())
-	m.App.Session.Put(request.Context(), "flash", "Logged out successfully")
-	http.Redirect(writer, request, "/", http.StatusSeeOther)
+// requestedStay reads the stay in a room a guest asked to book from the s, e, a and c URL params. It adds an error to
+// the form when the dates or the guests are invalid, when the room doesn't sleep that many guests, or when the stay
+// breaks a stay rule of the room.
+func (m *Repository) requestedStay(r *http.Request, room models.Room) (models.Reservation, *forms.Form, error) {
+	query := r.URL.Query()
+	form := forms.New(url.Values{"start": {query.Get("s")}, "end": {query.Get("e")}, "adults": {query.Get("a")},
+		"children": {query.Get("c")}})
+	reservation := models.Reservation{RoomID: room.ID, Room: room}
+	reservation.StartDate, reservation.EndDate = m.stayDates(form)
+	reservation.Adults, reservation.Children = stayGuests(form)
+	if form.Valid() && !room.Sleeps(reservation.Guests()) {
+		form.Errors.Add("adults", fmt.Sprintf("The %s sleeps at most %d guests", room.RoomName, room.Capacity))
+	}
+	if form.Valid() {
+		reason, broken, err := m.brokenStayRule(reservation)
+		if err != nil {
+			return reservation, form, err
+		}
+		if broken {
+			form.Errors.Add("start", reason)
+		}
+	}
+	return reservation, form, nil
 }
 
-func (m *Repository) AdminDashboard(writer http.ResponseWriter, request *http.Request) {
-	render.Template(writer, request, "admin-dashboard.page.gohtml", &models.TemplateData{})
+// firstFormError returns the first error of the given fields of a form, to show it as the error of a redirect.
+func firstFormError(form *forms.Form, fields ...string) string {
+	for _, field := range fields {
+		if message := form.Errors.Get(field); message != "" {
+			return message
+		}
+	}
+	return ""
 }
 
-// AdminNewReservations shows all the new reservations in the admin layout.
-func (m *Repository) AdminNewReservations(writer http.ResponseWriter, request *http.Request) {
-	reservations, err := m.DB.GetNewReservations()
+// brokenStayRule returns the description of the stay rule of its room the reservation breaks, if any. Holds are
+// only taken for stays that follow the rules, since the reservation couldn't be made anyway.
+func (m *Repository) brokenStayRule(reservation models.Reservation) (string, bool, error) {
+	rules, err := m.DB.GetStayRulesForRoom(reservation.RoomID, reservation.StartDate, reservation.EndDate)
 	if err != nil {
-		helpers.ServerError(writer, err)
-		return
+		return "", false, err
 	}
-	data := map[string]interface{}{"reservations": reservations}
-
-	render.Template(writer, request, "admin-new-reservations.page.gohtml", &models.TemplateData{Data: data})
+	reason, broken := models.StayViolation(rules, reservation.StartDate, reservation.EndDate)
+	return reason, broken, nil
 }
 
-// AdminAllReservations shows all the reservations in the admin layout.
-func (m *Repository) AdminAllReservations(writer http.ResponseWriter, request *http.Request) {
-	reservations, err := m.DB.GetAllReservations()
+// roomHoldDuration is how long a room stays held for a guest filling in the reservation form, counted from their
+// last activity on it.
+const roomHoldDuration = 15 * time.Minute
+
+// holdRoom holds the room of the reservation for the guest, releasing the room they held before, if any.
+func (m *Repository) holdRoom(r *http.Request, reservation models.Reservation) error {
+	m.releaseHold(r)
+
+	holdID, err := m.DB.InsertHold(reservation.RoomID, reservation.StartDate, reservation.EndDate,
+		time.Now().Add(roomHoldDuration))
 	if err != nil {
-		helpers.ServerError(writer, err)
-		return
+		return err
 	}
-	data := map[string]interface{}{"reservations": reservations}
-	render.Template(writer, request, "admin-all-reservations.page.gohtml", &models.TemplateData{Data: data})
+	m.App.Session.Put(r.Context(), "hold_id", holdID)
+	return nil
 }
 
-// AdminReservationsCalendar displays the reservation calendar in the admin layout.
-func (m *Repository) AdminReservationsCalendar(writer http.ResponseWriter, request *http.Request) {
-	// Assume that there is no month or year specified.
-	now := time.Now()
-
-	// If the user specified a year and a month, convert the calendar to use those instead of the current year/month.
-	if request.URL.Query().Get("y") != "" {
-		year, _ := strconv.Atoi(request.URL.Query().Get("y"))
-		month, _ := strconv.Atoi(request.URL.Query().Get("m"))
-		now = time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
+// keepHold extends the hold of the guest on their room. If the hold already expired, the room is held again as long
+// as nobody else took it in the meantime.
+func (m *Repository) keepHold(r *http.Request, reservation models.Reservation) error {
+	holdID := m.App.Session.GetInt(r.Context(), "hold_id")
+	if holdID != 0 {
+		err := m.DB.ExtendHold(holdID, time.Now().Add(roomHoldDuration))
+		if !errors.Is(err, repository.ErrHoldNotFound) {
+			return err
+		}
 	}
-	data := map[string]interface{}{"now": now}
-
-	// Calculate next year/month and previous year/month. These are used to navigate the calendar.
-	next := now.AddDate(0, 1, 0)
-	last := now.AddDate(0, -1, 0)
-	nextMonth := next.Format("01")
-	nextMonthYear := next.Format("2006")
-	lastMonth := last.Format("01")
-	lastMonthYear := last.Format("2006")
-	stringMap := map[string]string{"next_month": nextMonth, "next_month_year": nextMonthYear, "last_month": lastMonth,
-		"last_month_year": lastMonthYear, "this_month": now.Format("01"), "this_month_year": now.Format("2006")}
-
-	// Get the first and last days of the month.
-	currentYear, currentMonth, _ := now.Date()
-	currentLocation := now.Location()
-	firstOfMonth := time.Date(currentYear, currentMonth, 1, 0, 0, 0, 0, currentLocation)
-	lastOfMonth := firstOfMonth.AddDate(0, 1, -1)
-
-	// Store number of days in a month.
-	intMap := map[string]int{"days_in_month": lastOfMonth.Day()}
+	return m.holdRoom(r, reservation)
+}
 
-	rooms, err := m.DB.GetAllRooms()
-	if err != nil {
-		helpers.ServerError(writer, err)
+// releaseHold frees the room held by the guest, if any.
+func (m *Repository) releaseHold(r *http.Request) {
+	holdID := m.App.Session.PopInt(r.Context(), "hold_id")
+	if holdID == 0 {
 		return
 	}
-	data["rooms"] = rooms
-
-	// Create reservation map and block map to be used in the calendar. For each day in the map marked as 0, it means
-	// the day is available for reservation (and will not appear checked on the calendar).
-	for _, room := range rooms {
-		reservationMap := map[string]int{}
-		blockMap := map[string]int{}
-		// Iterate the whole month, one day at a time. Initialize the map as fully available.
-		for d := firstOfMonth; d.After(lastOfMonth) == false; d = d.AddDate(0, 0, 1) {
-			reservationMap[d.Format("2006-01-2")] = 0 // Room available
-			blockMap[d.Format("2006-01-2")] = 0
-		}
-		// Get the restrictions for the rooms for the current month and add them to the map.
-		restrictions, err := m.DB.GetRestrictionsForRoomByDate(room.ID, firstOfMonth, lastOfMonth)
-		if err != nil {
-			helpers.ServerError(writer, err)
-			return
-		}
-		// If the restriction is from a reservation, add it to the reservation map. Otherwise add it to the block map.
-		for _, restriction := range restrictions {
-			if restriction.ReservationID > 0 {
-				// It's a reservation
-				for d := restriction.StartDate; d.After(restriction.EndDate) == false; d = d.AddDate(0, 0, 1) {
-					reservationMap[d.Format("2006-01-2")] = restriction.ReservationID
-				}
-			} else {
-				// It's a block from the owner.
-				blockMap[restriction.StartDate.Format("2006-01-2")] = restriction.ID
-			}
-		}
-		// Add it to the data map in order to pass it on to the template.
-		data[fmt.Sprintf("reservation_map_%d", room.ID)] = reservationMap
-		data[fmt.Sprintf("block_map_%d", room.ID)] = blockMap
-		// Store the blockMap in the session, so that when the user makes changes in the calendar and posts
-		// that form, I can take this block map out of the session and compare it to the changes made by the user.
-		m.App.Session.Put(request.Context(), fmt.Sprintf("block_map_%d", room.ID), blockMap)
+	err := m.DB.ReleaseHold(holdID)
+	if err != nil {
+		m.App.ErrorLog.Println("Cannot release room hold:", err)
 	}
+}
 
-	render.Template(writer, request, "admin-reservations-calendar.page.gohtml", &models.TemplateData{StringMap: stringMap,
-		Data: data, IntMap: intMap})
+// roomTaken tells the guest that the room they chose was booked by someone else, and sends them back to the search.
+func (m *Repository) roomTaken(w http.ResponseWriter, r *http.Request) {
+	m.App.Session.Put(r.Context(), "error", "Sorry, this room was just booked for these dates. Please search again.")
+	http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
 }
 
-// AdminShowReservation shows the content of a single reservation in the admin layout.
-func (m *Repository) AdminShowReservation(writer http.ResponseWriter, request *http.Request) {
-	// User can come from the all reservations or new reservations list.
-	exploded := strings.Split(request.RequestURI, "/")
-	id, err := strconv.Atoi(exploded[4]) // actual URL is for ex. localhost:8080/admin/reservations/all/{ID}
+// AddToCart holds the room and dates in the URL params for the guest and adds the stay to their booking cart, so
+// they can book several rooms together.
+func (m *Repository) AddToCart(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	roomID, _ := strconv.Atoi(query.Get("id"))
+	room, err := m.DB.GetRoomByID(roomID)
 	if err != nil {
-		helpers.ServerError(writer, err)
+		helpers.ServerError(w, err)
 		return
 	}
-	src := exploded[3] // could be "all" or "new".
-	stringMap := map[string]string{"src": src}
 
-	// Get reservation from the DB.
-	res, err := m.DB.GetReservationByID(id)
+	reservation, form, err := m.requestedStay(r, room)
 	if err != nil {
-		helpers.ServerError(writer, err)
+		helpers.ServerError(w, err)
+		return
+	}
+	cart, _ := m.App.Session.Get(r.Context(), "cart").(models.Cart)
+	for _, item := range cart.Items {
+		booked := item.Reservation
+		if form.Valid() && booked.RoomID == room.ID && booked.StartDate.Before(reservation.EndDate) &&
+			reservation.StartDate.Before(booked.EndDate) {
+			form.Errors.Add("start", fmt.Sprintf("The %s is already in your cart for these dates", room.RoomName))
+		}
+	}
+	if !form.Valid() {
+		m.App.Session.Put(r.Context(), "error", firstFormError(form, "start", "end", "adults", "children"))
+		http.Redirect(w, r, "/rooms/"+room.Slug, http.StatusSeeOther)
 		return
 	}
-	render.Template(writer, request, "admin-reservations-show.page.gohtml", &models.TemplateData{
-		Data: map[string]interface{}{"reservation": res}, StringMap: stringMap, Form: forms.New(nil),
-	})
-}
 
-func (m *Repository) AdminPostShowReservation(writer http.ResponseWriter, request *http.Request) {
-	err := request.ParseForm()
+	reservation.Quote, err = m.Pricing.QuoteRoom(room, reservation.StartDate, reservation.EndDate,
+		reservation.Guests())
 	if err != nil {
-		helpers.ServerError(writer, err)
+		helpers.ServerError(w, err)
+		return
+	}
+	holdID, err := m.DB.InsertHold(room.ID, reservation.StartDate, reservation.EndDate,
+		time.Now().Add(roomHoldDuration))
+	if errors.Is(err, repository.ErrRoomNotAvailable) {
+		m.roomTaken(w, r)
 		return
 	}
-	// User can come from the all reservations or new reservations list.
-	exploded := strings.Split(request.RequestURI, "/")
-	id, err := strconv.Atoi(exploded[4]) // actual URL is for ex. localhost:8080/admin/reservations/all/{ID}
 	if err != nil {
-		helpers.ServerError(writer, err)
+		helpers.ServerError(w, err)
 		return
 	}
-	src := exploded[3] // could be "all" or "new".
+
+	cart.Items = append(cart.Items, models.CartItem{Reservation: reservation, HoldID: holdID})
+	m.App.Session.Put(r.Context(), "cart", cart)
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("The %s was added to your cart", room.RoomName))
+	http.Redirect(w, r, "/cart", http.StatusSeeOther)
+}
+
+// Cart displays the booking cart of the guest, with the form to book all its stays at once.
+func (m *Repository) Cart(w http.ResponseWriter, r *http.Request) {
+	cart, taken, err := m.keepCartHolds(r)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	if len(taken) > 0 {
+		m.roomsTakenFromCart(r, taken)
+	}
+
+	// Identifies this submission of the form, so submitting it twice doesn't book the rooms twice.
+	key, err := helpers.GenerateToken()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	renderCart(w, r, cart, forms.New(nil), key)
+}
+
+// renderCart renders the booking cart page with the guest form.
+func renderCart(w http.ResponseWriter, r *http.Request, cart models.Cart, form *forms.Form, key string) {
+	render.Template(w, r, "cart.page.gohtml", &models.TemplateData{
+		Data:      map[string]interface{}{"cart": cart},
+		StringMap: map[string]string{"idempotency_key": key},
+		Form:      form,
+	})
+}
+
+// keepCartHolds extends the holds of the stays in the booking cart of the guest, holding again the rooms whose hold
+// expired. Stays whose room was booked by someone else in the meantime are taken out of the cart, and the names of
+// their rooms are returned.
+func (m *Repository) keepCartHolds(r *http.Request) (models.Cart, []string, error) {
+	cart, _ := m.App.Session.Get(r.Context(), "cart").(models.Cart)
+
+	var kept []models.CartItem
+	var taken []string
+	for _, item := range cart.Items {
+		err := m.DB.ExtendHold(item.HoldID, time.Now().Add(roomHoldDuration))
+		if errors.Is(err, repository.ErrHoldNotFound) {
+			res := item.Reservation
+			item.HoldID, err = m.DB.InsertHold(res.RoomID, res.StartDate, res.EndDate, time.Now().Add(roomHoldDuration))
+		}
+		if errors.Is(err, repository.ErrRoomNotAvailable) {
+			taken = append(taken, item.Reservation.Room.RoomName)
+			continue
+		}
+		if err != nil {
+			return cart, taken, err
+		}
+		kept = append(kept, item)
+	}
+	cart.Items = kept
+	m.App.Session.Put(r.Context(), "cart", cart)
+
+	return cart, taken, nil
+}
+
+// roomsTakenFromCart tells the guest which rooms were taken out of their cart because someone else booked them.
+func (m *Repository) roomsTakenFromCart(r *http.Request, rooms []string) {
+	m.App.Session.Put(r.Context(), "error", fmt.Sprintf(
+		"Sorry, the %s was just booked for your dates and was taken out of your cart.",
+		strings.Join(rooms, " and the ")))
+}
+
+// PostCartRemove takes a stay out of the booking cart of the guest, releasing its room.
+func (m *Repository) PostCartRemove(w http.ResponseWriter, r *http.Request) {
+	cart, _ := m.App.Session.Get(r.Context(), "cart").(models.Cart)
+	index, err := strconv.Atoi(chi.URLParam(r, "index"))
+	if err != nil || index < 0 || index >= len(cart.Items) {
+		m.App.Session.Put(r.Context(), "error", "This stay is not in your cart anymore")
+		http.Redirect(w, r, "/cart", http.StatusSeeOther)
+		return
+	}
+
+	item := cart.Items[index]
+	err = m.DB.ReleaseHold(item.HoldID)
+	if err != nil {
+		m.App.ErrorLog.Println("Cannot release room hold:", err)
+	}
+	cart.Items = append(cart.Items[:index], cart.Items[index+1:]...)
+	m.App.Session.Put(r.Context(), "cart", cart)
+
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("The %s was taken out of your cart",
+		item.Reservation.Room.RoomName))
+	http.Redirect(w, r, "/cart", http.StatusSeeOther)
+}
+
+// PostCart books all the stays in the booking cart of the guest at once, under one group confirmation code.
+func (m *Repository) PostCart(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't parse form for PostCart")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+
+	// A repeated submission returns the group booked by the first one. This is checked before the cart, since the
+	// first submission may already have emptied it.
+	key := idempotencyKey(r)
+	scopedKey := m.scopedIdempotencyKey(r, key)
+	if key != "" {
+		record, claimed, err := m.claimIdempotencyKey(scopedKey, requestFingerprint(r.PostForm))
+		if err != nil {
+			m.idempotencyKeyError(w, r, err, "PostCart")
+			return
+		}
+		if !claimed {
+			m.replayGroup(w, r, record.GroupID)
+			return
+		}
+		// If no group gets booked, free the key so the corrected form can be submitted again with it.
+		defer m.releaseIdempotencyKey(scopedKey)
+	}
+
+	// Make sure every room is still held, so nobody can book one of them while the group is saved. If one was
+	// taken, the guest gets to check the cart, and its new total, again.
+	cart, taken, err := m.keepCartHolds(r)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't hold rooms for PostCart")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+	if len(taken) > 0 {
+		m.roomsTakenFromCart(r, taken)
+		http.Redirect(w, r, "/cart", http.StatusSeeOther)
+		return
+	}
+	if len(cart.Items) == 0 {
+		m.App.Session.Put(r.Context(), "error", "Your cart is empty. Please add a room to it first.")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("first_name", "last_name", "email")
+	form.MinLength("first_name", 3)
+	form.IsEmail("email")
+	if !form.Valid() {
+		renderCart(w, r, cart, form, key)
+		return
+	}
+
+	group := models.ReservationGroup{
+		FirstName: r.Form.Get("first_name"),
+		LastName:  r.Form.Get("last_name"),
+		Email:     r.Form.Get("email"),
+		Phone:     r.Form.Get("phone"),
+	}
+	var holdIDs []int
+	for _, item := range cart.Items {
+		reservation := item.Reservation
+		reservation.FirstName = group.FirstName
+		reservation.LastName = group.LastName
+		reservation.Email = group.Email
+		reservation.Phone = group.Phone
+		group.Reservations = append(group.Reservations, reservation)
+		holdIDs = append(holdIDs, item.HoldID)
+	}
+
+	// The holds become the room restrictions of the reservations and the key records the group, in one transaction.
+	inserted, err := m.DB.InsertReservationGroup(group, holdIDs, scopedKey)
+	if errors.Is(err, repository.ErrHoldNotFound) {
+		// A hold expired between keeping it and booking the group, nothing was booked.
+		m.App.Session.Put(r.Context(), "error", "Your rooms were not held anymore, please confirm them again")
+		http.Redirect(w, r, "/cart", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't insert reservation group into DB for PostCart")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+	m.App.Session.Remove(r.Context(), "cart")
+
+	// The group is loaded again for the confirmation codes of its reservations, which keep the itemized quotes
+	// they were booked with.
+	saved, err := m.DB.GetReservationGroupByID(inserted.ID)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't get reservation group from DB for PostCart")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+	booked := make(map[int]models.Reservation)
+	for _, res := range inserted.Reservations {
+		booked[res.ID] = res
+	}
+	for i, res := range saved.Reservations {
+		if b, ok := booked[res.ID]; ok {
+			saved.Reservations[i].Adults = b.Adults
+			saved.Reservations[i].Children = b.Children
+			saved.Reservations[i].Quote = b.Quote
+		}
+	}
+
+	// Send one email notification to the guest, for all the rooms.
+	var rooms strings.Builder
+	for _, res := range saved.Reservations {
+		fmt.Fprintf(&rooms, `<br>
+		<strong>%s</strong> from the %s to the %s, for %s. Confirmation code <strong>%s</strong>.<br>
+		%s<br>
+`, res.Room.RoomName, res.StartDate.Format("2006-01-02"), res.EndDate.Format("2006-01-02"),
+			res.GuestsDescription(), res.ConfirmationCode, quoteLines(res.Quote))
+	}
+	htmlMessage := fmt.Sprintf(`
+		<strong> Reservation Confirmation </strong><br>
+		Dear %s: <br>
+		Your reservation of %d rooms is now confirmed.<br>
+		Your group confirmation code is <strong>%s</strong>.<br>
+		%s
+		<strong>Total for all the rooms: %s</strong>
+`, saved.FirstName, len(saved.Reservations), saved.ConfirmationCode, rooms.String(),
+		render.FormatMoney(saved.Total()))
+
+	msg := models.MailData{
+		To:      saved.Email,
+		From:    "me@here.com",
+		Subject: "Reservation Confirmation",
+		Content: htmlMessage,
+	}
+	m.App.Mailchan <- msg
+
+	// Send email notification to the owner.
+	var roomNames []string
+	for _, res := range saved.Reservations {
+		roomNames = append(roomNames, fmt.Sprintf("%s (%s) from the %s to the %s", res.Room.RoomName,
+			res.ConfirmationCode, res.StartDate.Format("2006-01-02"), res.EndDate.Format("2006-01-02")))
+	}
+	htmlMessage = fmt.Sprintf(`
+		<strong> Reservation Confirmation </strong><br>
+		A group reservation (%s) has been made by %s %s for your properties %s, for a total of %s.
+`, saved.ConfirmationCode, saved.FirstName, saved.LastName, strings.Join(roomNames, ", "),
+		render.FormatMoney(saved.Total()))
+
+	msg = models.MailData{
+		To:      "owner-email@here.com",
+		From:    "me@here.com",
+		Subject: "Reservation Confirmation",
+		Content: htmlMessage,
+	}
+	m.App.Mailchan <- msg
+
+	// Put the group into the session to show it in the summary.
+	m.App.Session.Put(r.Context(), "group", saved)
+
+	http.Redirect(w, r, "/cart-summary", http.StatusSeeOther)
+}
+
+// CartSummary displays the reservations booked together from the booking cart, after confirming them.
+func (m *Repository) CartSummary(w http.ResponseWriter, r *http.Request) {
+	group, ok := m.App.Session.Get(r.Context(), "group").(models.ReservationGroup)
+	if !ok {
+		m.App.ErrorLog.Println("Can't get reservation group from session")
+		m.App.Session.Put(r.Context(), "error", "Can't get reservation from session.")
+		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
+		return
+	}
+
+	// Delete the group from the session to improve privacy.
+	m.App.Session.Remove(r.Context(), "group")
+
+	render.Template(w, r, "cart-summary.page.gohtml", &models.TemplateData{
+		Data: map[string]interface{}{"group": group},
+	})
+}
+
+// waitlistClaimLifetime is how long a guest told their room is free has to claim it. The room is held for them until
+// then.
+const waitlistClaimLifetime = 2 * time.Hour
+
+// Waitlist displays the form to join the waitlist of the room and stay in the URL params.
+func (m *Repository) Waitlist(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	form := forms.New(url.Values{"room_id": {query.Get("id")}, "start": {query.Get("s")}, "end": {query.Get("e")},
+		"adults": {query.Get("a")}, "children": {query.Get("c")}})
+	m.renderWaitlist(w, r, form)
+}
+
+// renderWaitlist renders the form to join the waitlist of the room in the room_id field of form.
+func (m *Repository) renderWaitlist(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	roomID, _ := strconv.Atoi(form.Get("room_id"))
+	room, err := m.DB.GetRoomByID(roomID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	render.Template(w, r, "waitlist.page.gohtml", &models.TemplateData{
+		Data: map[string]interface{}{"room": room},
+		Form: form,
+	})
+}
+
+// PostWaitlist puts the guest on the waitlist of a room for their stay.
+func (m *Repository) PostWaitlist(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	startDate, endDate := m.stayDates(form)
+	adults, children := stayGuests(form)
+	form.Required("first_name", "email")
+	form.IsEmail("email")
+	roomID, _ := strconv.Atoi(r.Form.Get("room_id"))
+	room, err := m.DB.GetRoomByID(roomID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	if form.Valid() && !room.Sleeps(adults+children) {
+		form.Errors.Add("adults", fmt.Sprintf("The %s sleeps at most %d guests", room.RoomName, room.Capacity))
+	}
+	if !form.Valid() {
+		m.renderWaitlist(w, r, form)
+		return
+	}
+
+	// Nobody needs to wait for a room that is free.
+	available, err := m.DB.SearchAvailabilityByDatesByRoomID(startDate, endDate, room.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	if available {
+		m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("The %s is free for these dates", room.RoomName))
+		http.Redirect(w, r, fmt.Sprintf("/book-room?id=%d&s=%s&e=%s&a=%d&c=%d", room.ID,
+			startDate.Format("2006-01-02"), endDate.Format("2006-01-02"), adults, children), http.StatusSeeOther)
+		return
+	}
+
+	entry := models.WaitlistEntry{
+		RoomID:    room.ID,
+		StartDate: startDate,
+		EndDate:   endDate,
+		FirstName: r.Form.Get("first_name"),
+		LastName:  r.Form.Get("last_name"),
+		Email:     strings.TrimSpace(r.Form.Get("email")),
+		Adults:    adults,
+		Children:  children,
+	}
+	_, err = m.DB.InsertWaitlistEntry(entry)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	htmlMessage := fmt.Sprintf(`
+		<strong> Waitlist </strong><br>
+		Dear %s: <br>
+		You are on the waitlist of the %s from the %s to the %s. We will email you as soon as it is free for your
+		dates, and hold it for you for %d hours.
+`, entry.FirstName, room.RoomName, startDate.Format("2006-01-02"), endDate.Format("2006-01-02"),
+		int(waitlistClaimLifetime.Hours()))
+
+	m.App.Mailchan <- models.MailData{
+		To:      entry.Email,
+		From:    "me@here.com",
+		Subject: "You are on the waitlist",
+		Content: htmlMessage,
+	}
+
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf(
+		"You are on the waitlist of the %s. We will email you as soon as it is free for your dates.", room.RoomName))
+	http.Redirect(w, r, "/rooms/"+room.Slug, http.StatusSeeOther)
+}
+
+// NotifyWaitlist offers a room freed from start to end to the guests waiting for it, in the order they joined the
+// waitlist. Every guest whose whole stay is now free gets the room held for them, and an email with a link to claim
+// it. Guests whose stay is still partly taken, including by the guests before them, keep waiting.
+func (m *Repository) NotifyWaitlist(roomID int, start, end time.Time) error {
+	entries, err := m.DB.GetWaitingEntries(roomID, start, end)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		expiresAt := time.Now().Add(waitlistClaimLifetime)
+		holdID, err := m.DB.InsertHold(entry.RoomID, entry.StartDate, entry.EndDate, expiresAt)
+		if errors.Is(err, repository.ErrRoomNotAvailable) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		token, err := helpers.GenerateToken()
+		if err != nil {
+			return err
+		}
+		err = m.DB.NotifyWaitlistEntry(entry.ID, holdID, helpers.HashToken(token), expiresAt)
+		if err != nil {
+			return err
+		}
+
+		link := fmt.Sprintf("%s/waitlist/claim/%s", m.App.BaseURL, token)
+		htmlMessage := fmt.Sprintf(`
+		<strong> Your room is free </strong><br>
+		Dear %s: <br>
+		The %s is now free from the %s to the %s, and we are holding it for you for %d hours.<br>
+		Use the following link to book it before someone else does:<br>
+		<a href="%s">%s</a>
+`, entry.FirstName, entry.Room.RoomName, entry.StartDate.Format("2006-01-02"), entry.EndDate.Format("2006-01-02"),
+			int(waitlistClaimLifetime.Hours()), link, link)
+
+		m.App.Mailchan <- models.MailData{
+			To:      entry.Email,
+			From:    "me@here.com",
+			Subject: "Your room is free",
+			Content: htmlMessage,
+		}
+	}
+	return nil
+}
+
+// ExpireWaitlistClaims ends the claims guests didn't use in time, and offers their rooms to the next guests waiting.
+func (m *Repository) ExpireWaitlistClaims() error {
+	expired, err := m.DB.ExpireWaitlistClaims()
+	if err != nil {
+		return err
+	}
+	// The holds of the claims expired with them, so their stays count as free again.
+	for _, entry := range expired {
+		err = m.NotifyWaitlist(entry.RoomID, entry.StartDate, entry.EndDate)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ClaimWaitlist takes a guest from the claim link of their waitlist entry to the reservation form of their stay, with
+// the room held for them.
+func (m *Repository) ClaimWaitlist(w http.ResponseWriter, r *http.Request) {
+	entry, err := m.DB.ClaimWaitlistEntry(helpers.HashToken(chi.URLParam(r, "token")))
+	if errors.Is(err, repository.ErrWaitlistClaimNotFound) {
+		m.App.Session.Put(r.Context(), "error", "This waitlist link is invalid or has expired. Please search again.")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	reservation := models.Reservation{
+		RoomID:    entry.RoomID,
+		StartDate: entry.StartDate,
+		EndDate:   entry.EndDate,
+		FirstName: entry.FirstName,
+		LastName:  entry.LastName,
+		Email:     entry.Email,
+		Adults:    entry.Adults,
+		Children:  entry.Children,
+	}
+	// The hold of the claim becomes the hold of the reservation form, which keeps it while the guest fills it in.
+	m.releaseHold(r)
+	m.App.Session.Put(r.Context(), "reservation", reservation)
+	m.App.Session.Put(r.Context(), "hold_id", entry.HoldID)
+
+	http.Redirect(w, r, "/make-reservation", http.StatusSeeOther)
+}
+
+func (m *Repository) ShowLogin(writer http.ResponseWriter, request *http.Request) {
+	render.Template(writer, request, "login.page.gohtml", &models.TemplateData{Form: forms.New(nil)})
+}
+
+// PostShowLogin handles the user logging in.
+func (m *Repository) PostShowLogin(writer http.ResponseWriter, request *http.Request) {
+	// Prevents session fixation attack.
+	_ = m.App.Session.RenewToken(request.Context())
+
+	err := request.ParseForm()
+	if err != nil {
+		log.Println("Cannot parse postshowlogin form:", err)
+		m.App.Session.Put(request.Context(), "error", "Cannot parse user information")
+		http.Redirect(writer, request, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	form := forms.New(request.PostForm)
+	form.Required("email", "password")
+	form.IsEmail("email")
+	if !form.Valid() {
+		log.Println("Postshowlogin form is not valid:", form.Errors)
+		render.Template(writer, request, "login.page.gohtml", &models.TemplateData{Form: form})
+		return
+	}
+
+	id, _, err := m.DB.Authenticate(request.Form.Get("email"), request.Form.Get("password"))
+	if err != nil {
+		log.Println("Cannot authenticate user in postshowlogin:", err)
+		m.App.Session.Put(request.Context(), "error", "Invalid login credentials")
+		http.Redirect(writer, request, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(request.Context(), "user_id", id)
+	m.App.Session.Put(request.Context(), "flash", "Logged in successfully")
+	http.Redirect(writer, request, "/", http.StatusSeeOther)
+
+}
+
+// Logout logs the user out.
+func (m *Repository) Logout(writer http.ResponseWriter, request *http.Request) {
+	// Destroy the user session.
+	_ = m.App.Session.Destroy(request.Context())
+	// Prevents session fixation attack.
+	_ = m.App.Session.RenewToken(request.Context())
+	m.App.Session.Put(request.Context(), "flash", "Logged out successfully")
+	http.Redirect(writer, request, "/", http.StatusSeeOther)
+}
+
+func (m *Repository) AdminDashboard(writer http.ResponseWriter, request *http.Request) {
+	render.Template(writer, request, "admin-dashboard.page.gohtml", &models.TemplateData{})
+}
+
+// AdminFindReservation looks up a reservation by its confirmation code and shows it.
+func (m *Repository) AdminFindReservation(writer http.ResponseWriter, request *http.Request) {
+	code := helpers.NormalizeConfirmationCode(request.URL.Query().Get("code"))
+
+	res, err := m.DB.GetReservationByCode(code)
+	if err != nil {
+		m.App.Session.Put(request.Context(), "error", fmt.Sprintf("No reservation found with code %s", code))
+		http.Redirect(writer, request, "/admin/dashboard", http.StatusSeeOther)
+		return
+	}
+	http.Redirect(writer, request, fmt.Sprintf("/admin/reservations/all/%d", res.ID), http.StatusSeeOther)
+}
+
+// AdminNewReservations shows all the new (pending) reservations in the admin layout.
+func (m *Repository) AdminNewReservations(writer http.ResponseWriter, request *http.Request) {
+	reservations, err := m.DB.GetReservationsByStatus(models.StatusPending)
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	data := map[string]interface{}{"reservations": reservations}
+
+	render.Template(writer, request, "admin-new-reservations.page.gohtml", &models.TemplateData{Data: data})
+}
+
+// AdminAllReservations shows all the reservations in the admin layout, optionally filtered by status.
+func (m *Repository) AdminAllReservations(writer http.ResponseWriter, request *http.Request) {
+	status := models.ReservationStatus(request.URL.Query().Get("status"))
+
+	var reservations []models.Reservation
+	var err error
+	if status.Valid() {
+		reservations, err = m.DB.GetReservationsByStatus(status)
+	} else {
+		status = ""
+		reservations, err = m.DB.GetAllReservations()
+	}
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	data := map[string]interface{}{"reservations": reservations, "statuses": models.ReservationStatuses,
+		"status": status}
+	render.Template(writer, request, "admin-all-reservations.page.gohtml", &models.TemplateData{Data: data})
+}
+
+// AdminReservationsCalendar displays the reservation calendar in the admin layout.
+func (m *Repository) AdminReservationsCalendar(writer http.ResponseWriter, request *http.Request) {
+	// Assume that there is no month or year specified.
+	now := time.Now()
+
+	// If the user specified a year and a month, convert the calendar to use those instead of the current year/month.
+	if request.URL.Query().Get("y") != "" {
+		year, _ := strconv.Atoi(request.URL.Query().Get("y"))
+		month, _ := strconv.Atoi(request.URL.Query().Get("m"))
+		now = time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
+	}
+	data := map[string]interface{}{"now": now}
+
+	// Calculate next year/month and previous year/month. These are used to navigate the calendar.
+	stringMap := monthNavigation(now)
+
+	// Get the first and last days of the month.
+	currentYear, currentMonth, _ := now.Date()
+	currentLocation := now.Location()
+	firstOfMonth := time.Date(currentYear, currentMonth, 1, 0, 0, 0, 0, currentLocation)
+	lastOfMonth := firstOfMonth.AddDate(0, 1, -1)
+
+	// Store number of days in a month.
+	intMap := map[string]int{"days_in_month": lastOfMonth.Day()}
+
+	rooms, err := m.DB.GetAllRooms()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	data["rooms"] = rooms
+
+	// Create reservation map and block map to be used in the calendar. For each day in the map marked as 0, it means
+	// the day is available for reservation (and will not appear checked on the calendar).
+	for _, room := range rooms {
+		reservationMap := map[string]int{}
+		blockMap := map[string]int{}
+		// Iterate the whole month, one day at a time. Initialize the map as fully available.
+		for d := firstOfMonth; d.After(lastOfMonth) == false; d = d.AddDate(0, 0, 1) {
+			reservationMap[d.Format("2006-01-2")] = 0 // Room available
+			blockMap[d.Format("2006-01-2")] = 0
+		}
+		// Get the restrictions for the rooms for the current month and add them to the map.
+		restrictions, err := m.DB.GetRestrictionsForRoomByDate(room.ID, firstOfMonth, lastOfMonth)
+		if err != nil {
+			helpers.ServerError(writer, err)
+			return
+		}
+		// If the restriction is from a reservation, add it to the reservation map. Otherwise add it to the block map.
+		for _, restriction := range restrictions {
+			if restriction.ReservationID > 0 {
+				// It's a reservation
+				for d := restriction.StartDate; d.After(restriction.EndDate) == false; d = d.AddDate(0, 0, 1) {
+					reservationMap[d.Format("2006-01-2")] = restriction.ReservationID
+				}
+			} else {
+				// It's a block from the owner.
+				blockMap[restriction.StartDate.Format("2006-01-2")] = restriction.ID
+			}
+		}
+		// Add it to the data map in order to pass it on to the template.
+		data[fmt.Sprintf("reservation_map_%d", room.ID)] = reservationMap
+		data[fmt.Sprintf("block_map_%d", room.ID)] = blockMap
+		// Store the blockMap in the session, so that when the user makes changes in the calendar and posts
+		// that form, I can take this block map out of the session and compare it to the changes made by the user.
+		m.App.Session.Put(request.Context(), fmt.Sprintf("block_map_%d", room.ID), blockMap)
+	}
+
+	render.Template(writer, request, "admin-reservations-calendar.page.gohtml", &models.TemplateData{StringMap: stringMap,
+		Data: data, IntMap: intMap})
+}
+
+// AdminShowReservation shows the content of a single reservation in the admin layout.
+func (m *Repository) AdminShowReservation(writer http.ResponseWriter, request *http.Request) {
+	// User can come from the all reservations or new reservations list.
+	exploded := strings.Split(request.RequestURI, "/")
+	id, err := strconv.Atoi(exploded[4]) // actual URL is for ex. localhost:8080/admin/reservations/all/{ID}
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	src := exploded[3] // could be "all" or "new".
+
+	// Get reservation from the DB.
+	res, err := m.DB.GetReservationByID(id)
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	m.renderAdminReservation(writer, request, res, src, forms.New(nil))
+}
+
+// renderAdminReservation renders the admin page of a reservation, with its status history, the rooms it can be
+// moved to, the units of its room it can be assigned to and the extras it can book.
+func (m *Repository) renderAdminReservation(writer http.ResponseWriter, request *http.Request,
+	res models.Reservation, src string, form *forms.Form) {
+	history, err := m.DB.GetStatusHistoryForReservation(res.ID)
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	rooms, err := m.DB.GetAllRooms()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	res.Room.Units, err = m.DB.GetUnitsForRoom(res.RoomID)
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	extras, err := m.DB.GetExtrasForStay(res.StartDate, res.EndDate, res.ID)
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	// Reservations booked together link to each other.
+	var group models.ReservationGroup
+	if res.GroupID != 0 {
+		group, err = m.DB.GetReservationGroupByID(res.GroupID)
+		if err != nil {
+			helpers.ServerError(writer, err)
+			return
+		}
+	}
+	render.Template(writer, request, "admin-reservations-show.page.gohtml", &models.TemplateData{
+		Data: map[string]interface{}{"reservation": res, "history": history, "rooms": rooms, "extras": extras,
+			"quantities": res.Quote.ExtraQuantities(), "group": group},
+		StringMap: map[string]string{"src": src},
+		Form:      form,
+	})
+}
+
+func (m *Repository) AdminPostShowReservation(writer http.ResponseWriter, request *http.Request) {
+	err := request.ParseForm()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	// User can come from the all reservations or new reservations list.
+	exploded := strings.Split(request.RequestURI, "/")
+	id, err := strconv.Atoi(exploded[4]) // actual URL is for ex. localhost:8080/admin/reservations/all/{ID}
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	src := exploded[3] // could be "all" or "new".
+
+	res, err := m.DB.GetReservationByID(id)
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	before := res
+
+	res.FirstName = request.Form.Get("first_name")
+	res.LastName = request.Form.Get("last_name")
+	res.Email = request.Form.Get("email")
+	res.Phone = request.Form.Get("phone")
+
+	err = m.DB.UpdateReservation(res)
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	m.recordAudit(request, "update", "reservation", res.ID, before, res)
+	m.App.Session.Put(request.Context(), "flash", "Reservation Saved")
+	http.Redirect(writer, request, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
+
+}
+
+// AdminPostModifyReservation changes the dates and/or the room of a reservation, if the room is free for the new
+// dates, and sends the guest an updated confirmation.
+func (m *Repository) AdminPostModifyReservation(writer http.ResponseWriter, request *http.Request) {
+	err := request.ParseForm()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	id, _ := strconv.Atoi(chi.URLParam(request, "id"))
+	src := chi.URLParam(request, "src")
+
+	before, err := m.DB.GetReservationByID(id)
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	if !before.Status.Modifiable() {
+		m.App.Session.Put(request.Context(), "error",
+			fmt.Sprintf("The dates of a %s reservation can't be changed", before.Status))
+		http.Redirect(writer, request, fmt.Sprintf("/admin/reservations/%s/%d", src, id), http.StatusSeeOther)
+		return
+	}
+
+	form := forms.New(request.PostForm)
+	form.Required("start_date", "end_date", "room_id")
+
+	startDate, err := parseDateFromForm(request.Form, "start_date")
+	if err != nil {
+		form.Errors.Add("start_date", "Invalid date")
+	}
+	endDate, err := parseDateFromForm(request.Form, "end_date")
+	if err != nil {
+		form.Errors.Add("end_date", "Invalid date")
+	}
+	if form.Valid() && !endDate.After(startDate) {
+		form.Errors.Add("end_date", "The departure must be after the arrival")
+	}
+	roomID, _ := strconv.Atoi(request.Form.Get("room_id"))
+	room, err := m.DB.GetRoomByID(roomID)
+	if err != nil {
+		form.Errors.Add("room_id", "Unknown room")
+	} else if !room.Sleeps(before.Guests()) {
+		form.Errors.Add("room_id", fmt.Sprintf("%s sleeps at most %d guests", room.RoomName, room.Capacity))
+	}
+
+	after := before
+	after.RoomID = roomID
+	after.Room = room
+	after.StartDate = startDate
+	after.EndDate = endDate
+
+	// The new dates or room, and the extras of the reservation, are priced at today's rates.
+	if form.Valid() {
+		after.Quote, err = m.Pricing.Quote(roomID, startDate, endDate, before.Guests())
+		if err != nil {
+			helpers.ServerError(writer, err)
+			return
+		}
+		extras, err := m.DB.GetExtras()
+		if err != nil {
+			helpers.ServerError(writer, err)
+			return
+		}
+		after.Quote = pricing.AddExtras(after.Quote, extras, before.Quote.ExtraQuantities())
+		after.Quote, err = m.Pricing.KeepDiscounts(after.Quote, before.Quote.Discounts)
+		if err != nil {
+			helpers.ServerError(writer, err)
+			return
+		}
+		err = m.DB.ModifyReservation(after)
+		if errors.Is(err, repository.ErrRoomNotAvailable) {
+			form.Errors.Add("start_date", fmt.Sprintf("%s is not available for these dates", room.RoomName))
+		} else if errors.Is(err, repository.ErrExtraSoldOut) {
+			form.Errors.Add("start_date", "Some of the extras of the reservation are sold out for these dates")
+		} else if err != nil {
+			helpers.ServerError(writer, err)
+			return
+		}
+	}
+	if !form.Valid() {
+		m.renderAdminReservation(writer, request, before, src, form)
+		return
+	}
+
+	m.recordAudit(request, "modify", "reservation", id, before, after)
+
+	// Send the guest an updated confirmation.
+	htmlMessage := fmt.Sprintf(`
+		<strong> Reservation Updated </strong><br>
+		Dear %s: <br>
+		Your reservation %s has been changed. You are now booked in the %s from the %s to the %s.<br>
+		%s
+`, after.FirstName, after.ConfirmationCode, after.Room.RoomName, after.StartDate.Format("2006-01-02"),
+		after.EndDate.Format("2006-01-02"), quoteLines(after.Quote))
+
+	m.App.Mailchan <- models.MailData{
+		To:      after.Email,
+		From:    "me@here.com",
+		Subject: "Reservation Updated",
+		Content: htmlMessage,
+	}
+
+	m.App.Session.Put(request.Context(), "flash", "Reservation dates and room updated")
+	http.Redirect(writer, request, fmt.Sprintf("/admin/reservations/%s/%d", src, id), http.StatusSeeOther)
+}
+
+// AdminPostReservationUnit assigns a reservation to another unit of its room, overriding the unit it was given
+// automatically. The unit must be free for the dates of the reservation.
+func (m *Repository) AdminPostReservationUnit(writer http.ResponseWriter, request *http.Request) {
+	err := request.ParseForm()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	id, _ := strconv.Atoi(chi.URLParam(request, "id"))
+	src := chi.URLParam(request, "src")
 
 	res, err := m.DB.GetReservationByID(id)
 	if err != nil {
 		helpers.ServerError(writer, err)
 		return
 	}
+	if !res.Status.Modifiable() {
+		m.App.Session.Put(request.Context(), "error",
+			fmt.Sprintf("The unit of a %s reservation can't be changed", res.Status))
+		http.Redirect(writer, request, fmt.Sprintf("/admin/reservations/%s/%d", src, id), http.StatusSeeOther)
+		return
+	}
+
+	units, err := m.DB.GetUnitsForRoom(res.RoomID)
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	form := forms.New(request.PostForm)
+	form.Required("unit_id")
+	unitID, _ := strconv.Atoi(request.Form.Get("unit_id"))
+	var unit models.RoomUnit
+	for _, u := range units {
+		if u.ID == unitID && !u.Archived() {
+			unit = u
+		}
+	}
+	if form.Valid() && unit.ID == 0 {
+		form.Errors.Add("unit_id", fmt.Sprintf("Choose a unit of the %s", res.Room.RoomName))
+	}
+
+	if form.Valid() {
+		err = m.DB.AssignReservationUnit(id, unit.ID)
+		if errors.Is(err, repository.ErrRoomNotAvailable) {
+			form.Errors.Add("unit_id", fmt.Sprintf("%s is taken for the dates of the reservation", unit.Name))
+		} else if err != nil {
+			helpers.ServerError(writer, err)
+			return
+		}
+	}
+	if !form.Valid() {
+		m.renderAdminReservation(writer, request, res, src, form)
+		return
+	}
+
+	m.recordAudit(request, "update", "reservation", id, map[string]interface{}{"unit_id": res.UnitID},
+		map[string]interface{}{"unit_id": unit.ID})
+	m.App.Session.Put(request.Context(), "flash", fmt.Sprintf("Reservation assigned to %s", unit.Name))
+	http.Redirect(writer, request, fmt.Sprintf("/admin/reservations/%s/%d", src, id), http.StatusSeeOther)
+}
+
+// AdminPostReservationExtras replaces the extras booked with a reservation, priced at today's rates, if they have
+// enough units left for its dates. Extras that aren't offered anymore are dropped.
+func (m *Repository) AdminPostReservationExtras(writer http.ResponseWriter, request *http.Request) {
+	err := request.ParseForm()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	id, _ := strconv.Atoi(chi.URLParam(request, "id"))
+	src := chi.URLParam(request, "src")
+
+	before, err := m.DB.GetReservationByID(id)
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	if !before.Status.Modifiable() {
+		m.App.Session.Put(request.Context(), "error",
+			fmt.Sprintf("The extras of a %s reservation can't be changed", before.Status))
+		http.Redirect(writer, request, fmt.Sprintf("/admin/reservations/%s/%d", src, id), http.StatusSeeOther)
+		return
+	}
+
+	extras, err := m.DB.GetExtrasForStay(before.StartDate, before.EndDate, id)
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	form := forms.New(request.PostForm)
+	after := before
+	after.Quote = pricing.AddExtras(before.Quote, extras, extraQuantities(form, extras, before.Guests()))
+
+	if form.Valid() {
+		err = m.DB.ModifyReservation(after)
+		if errors.Is(err, repository.ErrExtraSoldOut) {
+			form.Errors.Add("extras", "Some of the extras just sold out for the dates of the reservation")
+		} else if errors.Is(err, repository.ErrRoomNotAvailable) {
+			form.Errors.Add("extras", fmt.Sprintf("%s is not available for the dates of the reservation anymore",
+				before.Room.RoomName))
+		} else if err != nil {
+			helpers.ServerError(writer, err)
+			return
+		}
+	}
+	if !form.Valid() {
+		m.renderAdminReservation(writer, request, before, src, form)
+		return
+	}
+
+	m.recordAudit(request, "update", "reservation", id, map[string]interface{}{"extras": before.Quote.Extras},
+		map[string]interface{}{"extras": after.Quote.Extras})
+
+	// Send the guest an updated confirmation, since the price changed.
+	htmlMessage := fmt.Sprintf(`
+		<strong> Reservation Updated </strong><br>
+		Dear %s: <br>
+		The extras of your reservation %s have been changed.<br>
+		%s
+`, after.FirstName, after.ConfirmationCode, quoteLines(after.Quote))
+
+	m.App.Mailchan <- models.MailData{
+		To:      after.Email,
+		From:    "me@here.com",
+		Subject: "Reservation Updated",
+		Content: htmlMessage,
+	}
+
+	m.App.Session.Put(request.Context(), "flash", "Reservation extras updated")
+	http.Redirect(writer, request, fmt.Sprintf("/admin/reservations/%s/%d", src, id), http.StatusSeeOther)
+}
+
+// AdminPostReservationStatus moves a reservation to another status of its lifecycle, for example from pending to
+// confirmed.
+func (m *Repository) AdminPostReservationStatus(writer http.ResponseWriter, request *http.Request) {
+	err := request.ParseForm()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	id, _ := strconv.Atoi(chi.URLParam(request, "id"))
+	src := chi.URLParam(request, "src")
+	to := models.ReservationStatus(request.Form.Get("status"))
+
+	before, err := m.DB.GetReservationByID(id)
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	// Cancelling also frees the dates and settles the refund, so it doesn't go through a plain status change.
+	userID := m.App.Session.GetInt(request.Context(), "user_id")
+	after := before
+	if to == models.StatusCancelled {
+		after.RefundPercent, after.RefundAmount, err = m.cancelReservation(before, userID)
+	} else {
+		err = m.DB.TransitionReservationStatus(id, to, userID)
+	}
+	if errors.Is(err, models.ErrInvalidTransition) {
+		m.App.Session.Put(request.Context(), "error",
+			fmt.Sprintf("A %s reservation can't be marked as %s", before.Status, to))
+		http.Redirect(writer, request, fmt.Sprintf("/admin/reservations/%s/%d", src, id), http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	after.Status = to
+	m.recordAudit(request, "status", "reservation", id, before, after)
+	if to == models.StatusCancelled {
+		m.App.Session.Put(request.Context(), "flash",
+			fmt.Sprintf("Reservation cancelled, %s (%d%%) is refundable", render.FormatMoney(after.RefundAmount),
+				after.RefundPercent))
+	} else {
+		m.App.Session.Put(request.Context(), "flash", fmt.Sprintf("Reservation marked as %s", to))
+	}
+
+	http.Redirect(writer, request, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
+}
+
+// cancelReservation cancels a reservation under the cancellation policy of its room and emails the guest and the
+// owner. userID is 0 when the guest cancels. Returns the percentage of the reservation refunded to the guest, and
+// the refunded amount in cents.
+func (m *Repository) cancelReservation(res models.Reservation, userID int) (int, int, error) {
+	policy, err := m.DB.GetCancellationPolicyForRoom(res.RoomID)
+	if err != nil {
+		return 0, 0, err
+	}
+	refund := policy.RefundPercent(res.StartDate, time.Now())
+
+	amount, err := m.DB.CancelReservation(res.ID, refund, userID)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// The guests waiting for the room get the freed dates.
+	err = m.NotifyWaitlist(res.RoomID, res.StartDate, res.EndDate)
+	if err != nil {
+		m.App.ErrorLog.Println("Cannot notify the waitlist:", err)
+	}
+
+	htmlMessage := fmt.Sprintf(`
+		<strong> Reservation Cancelled </strong><br>
+		Dear %s: <br>
+		Your reservation %s for %s from the %s to the %s has been cancelled.<br>
+		Under the %s cancellation policy, %d%% of your reservation, %s, will be refunded.
+`, res.FirstName, res.ConfirmationCode, res.Room.RoomName, res.StartDate.Format("2006-01-02"),
+		res.EndDate.Format("2006-01-02"), policy.Name, refund, render.FormatMoney(amount))
+
+	m.App.Mailchan <- models.MailData{
+		To:      res.Email,
+		From:    "me@here.com",
+		Subject: "Reservation Cancelled",
+		Content: htmlMessage,
+	}
+
+	// Let the owner know the dates are free again.
+	htmlMessage = fmt.Sprintf(`
+		<strong> Reservation Cancelled </strong><br>
+		The reservation %s of %s %s for %s from the %s to the %s has been cancelled.<br>
+		%d%% of the reservation, %s, is owed back to the guest.
+`, res.ConfirmationCode, res.FirstName, res.LastName, res.Room.RoomName, res.StartDate.Format("2006-01-02"),
+		res.EndDate.Format("2006-01-02"), refund, render.FormatMoney(amount))
+
+	m.App.Mailchan <- models.MailData{
+		To:      "owner-email@here.com",
+		From:    "me@here.com",
+		Subject: "Reservation Cancelled",
+		Content: htmlMessage,
+	}
+
+	return refund, amount, nil
+}
+
+// AdminPostReservationsCalendar handles post requests coming from the reservation calendar in the admin layout.
+func (m *Repository) AdminPostReservationsCalendar(writer http.ResponseWriter, request *http.Request) {
+	err := request.ParseForm()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	year, _ := strconv.Atoi(request.Form.Get("y"))
+	month, _ := strconv.Atoi(request.Form.Get("m"))
+
+	// Process Blocks.
+	rooms, err := m.DB.GetAllRooms()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	form := forms.New(request.PostForm)
+
+	// Handle Block Deletion.
+	for _, room := range rooms {
+		// Get the block map from the session. Contains the data as it stood before the user made any changes in the
+		// calendar itself.
+		// If an entry exists in this map but not in the posted data, whose restriction id is greater than 0, we need
+		// to remove that block since this means the user unchecked it in the calendar. Restriction id greater than 0
+		// means its an owner-imposed restriction instead of a user reservation.
+		curMap := m.App.Session.Get(request.Context(), fmt.Sprintf("block_map_%d", room.ID)).(map[string]int)
+		for day, rID := range curMap {
+			// If curMap[day] does not exist, ok will be false.
+			if val, ok := curMap[day]; ok {
+				// Ok is true, so check if the value is greater than 0 and is missing in the form post.
+				// The rest are placeholders for days without blocks.
+				if val > 0 {
+					if !form.Has(fmt.Sprintf("remove_block_%d_%s", room.ID, day)) {
+						// Delete the restriction by ID.
+						err := m.DB.DeleteBlockByID(rID)
+						if err != nil {
+							log.Println(err)
+							continue
+						}
+						m.recordAudit(request, "delete", "room_restriction", rID,
+							map[string]interface{}{"room_id": room.ID, "date": day}, nil)
+						// Blocks are one night long, the guests waiting for the room get it.
+						blocked, _ := time.Parse("2006-01-2", day)
+						err = m.NotifyWaitlist(room.ID, blocked, blocked.AddDate(0, 0, 1))
+						if err != nil {
+							log.Println(err)
+						}
+					}
+				}
+			}
+		}
+	}
+
+	// Handle Block Creation.
+	for name, _ := range request.PostForm {
+		if strings.HasPrefix(name, "add_block") {
+			// example string: add_block_1_2020-12-14 with _1_ being the room ID.
+			exploded := strings.Split(name, "_")
+			roomID, _ := strconv.Atoi(exploded[2])
+			startDate, _ := time.Parse("2006-01-2", exploded[3])
+			// Insert a new block.
+			blockID, err := m.DB.InsertBlockForRoom(roomID, startDate)
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+			m.recordAudit(request, "create", "room_restriction", blockID, nil,
+				map[string]interface{}{"room_id": roomID, "date": exploded[3]})
+		}
+	}
+
+	m.App.Session.Put(request.Context(), "flash", "Changes saved")
+	http.Redirect(writer, request, fmt.Sprintf("/admin/reservations-calendar?y=%d&m=%d", year, month), http.StatusSeeOther)
+
+}
+
+// AdminSessions lists the active sessions of the logged-in user, so they can see where they are logged in.
+func (m *Repository) AdminSessions(writer http.ResponseWriter, request *http.Request) {
+	userID := m.App.Session.GetInt(request.Context(), "user_id")
+	if userID == 0 {
+		m.App.Session.Put(request.Context(), "error", "Please log in first.")
+		http.Redirect(writer, request, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	sessions, err := m.DB.GetSessionsForUser(userID)
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	// Flag the session used by this request, so the user does not revoke it by accident.
+	currentHash := fmt.Sprintf("%x", md5.Sum([]byte(m.App.Session.Token(request.Context()))))
+	for i := range sessions {
+		sessions[i].Device = helpers.DeviceFromUserAgent(sessions[i].UserAgent)
+		sessions[i].Current = sessions[i].TokenHash == currentHash
+	}
+
+	data := map[string]interface{}{"sessions": sessions}
+	render.Template(writer, request, "admin-sessions.page.gohtml", &models.TemplateData{Data: data})
+}
+
+// AdminRevokeSession logs the user out of one of their other sessions.
+func (m *Repository) AdminRevokeSession(writer http.ResponseWriter, request *http.Request) {
+	err := request.ParseForm()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	userID := m.App.Session.GetInt(request.Context(), "user_id")
+	if userID == 0 {
+		m.App.Session.Put(request.Context(), "error", "Please log in first.")
+		http.Redirect(writer, request, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	// Only sessions belonging to the logged-in user can be revoked, the user id is part of the delete query.
+	err = m.DB.DeleteUserSession(request.Form.Get("session"), userID)
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	m.recordAudit(request, "revoke", "session", 0, map[string]interface{}{"session": request.Form.Get("session")}, nil)
+
+	m.App.Session.Put(request.Context(), "flash", "Session revoked")
+	http.Redirect(writer, request, "/admin/sessions", http.StatusSeeOther)
+}
+
+// customPolicyTiers is how many tiers can be entered when creating a custom cancellation policy.
+const customPolicyTiers = 3
+
+// AdminCancellationPolicies lists the cancellation policies and the policy of each room, and shows the form to create
+// a custom policy.
+func (m *Repository) AdminCancellationPolicies(writer http.ResponseWriter, request *http.Request) {
+	m.renderCancellationPolicies(writer, request, forms.New(nil))
+}
+
+// AdminPostCancellationPolicy creates a custom cancellation policy. Tier rows left blank are ignored.
+func (m *Repository) AdminPostCancellationPolicy(writer http.ResponseWriter, request *http.Request) {
+	err := request.ParseForm()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	form := forms.New(request.PostForm)
+	form.Required("name")
+
+	policy := models.CancellationPolicy{Name: strings.TrimSpace(request.Form.Get("name"))}
+	for i := 1; i <= customPolicyTiers; i++ {
+		daysField, percentField := fmt.Sprintf("days_%d", i), fmt.Sprintf("percent_%d", i)
+		if !form.Has(daysField) && !form.Has(percentField) {
+			continue
+		}
+		if form.IntBetween(daysField, 0, 365) && form.IntBetween(percentField, 0, 100) {
+			days, _ := strconv.Atoi(strings.TrimSpace(request.Form.Get(daysField)))
+			percent, _ := strconv.Atoi(strings.TrimSpace(request.Form.Get(percentField)))
+			policy.Tiers = append(policy.Tiers, models.CancellationTier{DaysBeforeArrival: days,
+				RefundPercent: percent})
+		}
+	}
+
+	if !form.Valid() {
+		m.renderCancellationPolicies(writer, request, form)
+		return
+	}
+
+	policy.ID, err = m.DB.InsertCancellationPolicy(policy)
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	m.recordAudit(request, "create", "cancellation_policy", policy.ID, nil, policy)
+	m.App.Session.Put(request.Context(), "flash", "Cancellation policy created")
+	http.Redirect(writer, request, "/admin/cancellation-policies", http.StatusSeeOther)
+}
+
+// AdminPostRoomCancellationPolicies saves the cancellation policy chosen for each room.
+func (m *Repository) AdminPostRoomCancellationPolicies(writer http.ResponseWriter, request *http.Request) {
+	err := request.ParseForm()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	rooms, err := m.DB.GetAllRooms()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	for _, room := range rooms {
+		policyID, err := strconv.Atoi(request.Form.Get(fmt.Sprintf("policy_%d", room.ID)))
+		if err != nil || policyID == room.CancellationPolicyID {
+			continue
+		}
+		err = m.DB.UpdateCancellationPolicyForRoom(room.ID, policyID)
+		if err != nil {
+			helpers.ServerError(writer, err)
+			return
+		}
+		after := room
+		after.CancellationPolicyID = policyID
+		m.recordAudit(request, "update", "room", room.ID, room, after)
+	}
+
+	m.App.Session.Put(request.Context(), "flash", "Room policies saved")
+	http.Redirect(writer, request, "/admin/cancellation-policies", http.StatusSeeOther)
+}
+
+// renderCancellationPolicies renders the cancellation policies page with the given form.
+func (m *Repository) renderCancellationPolicies(writer http.ResponseWriter, request *http.Request, form *forms.Form) {
+	policies, err := m.DB.GetCancellationPolicies()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	rooms, err := m.DB.GetAllRooms()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	data := map[string]interface{}{"policies": policies, "rooms": rooms}
+	intMap := map[string]int{"tiers": customPolicyTiers}
+	render.Template(writer, request, "admin-cancellation-policies.page.gohtml", &models.TemplateData{Data: data,
+		IntMap: intMap, Form: form})
+}
+
+// AdminRates shows the rate calendar: the price of every night of the month in each room, with the rooms' base rates
+// and the rate rules.
+func (m *Repository) AdminRates(writer http.ResponseWriter, request *http.Request) {
+	m.renderRates(writer, request, forms.New(nil))
+}
+
+// renderRates renders the rate calendar for the month in the y and m parameters, with the given form.
+func (m *Repository) renderRates(writer http.ResponseWriter, request *http.Request, form *forms.Form) {
+	now := calendarMonth(request)
+	firstOfMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	nextMonth := firstOfMonth.AddDate(0, 1, 0)
+
+	rooms, err := m.DB.GetAllRooms()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	rules, err := m.DB.GetRateRules()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	data := map[string]interface{}{"now": now, "rooms": rooms, "rules": rules}
+
+	// The price of each night of the month, keyed like the reservation calendar's maps.
+	for _, room := range rooms {
+		quote, err := m.Pricing.QuoteRoom(room, firstOfMonth, nextMonth, quoteGuests)
+		if err != nil {
+			helpers.ServerError(writer, err)
+			return
+		}
+		rateMap := map[string]int{}
+		for _, night := range quote.Nights {
+			rateMap[night.Date.Format("2006-01-2")] = night.Rate
+		}
+		data[fmt.Sprintf("rate_map_%d", room.ID)] = rateMap
+		// Kept in the session to find out which prices were changed when the calendar is posted.
+		m.App.Session.Put(request.Context(), fmt.Sprintf("rate_map_%d", room.ID), rateMap)
+	}
+
+	intMap := map[string]int{"days_in_month": nextMonth.AddDate(0, 0, -1).Day(), "max_priority": maxRulePriority}
+	render.Template(writer, request, "admin-rates.page.gohtml", &models.TemplateData{Data: data,
+		StringMap: monthNavigation(now), IntMap: intMap, Form: form})
+}
+
+// ratesURL returns the rate calendar for the month the request was made from.
+func ratesURL(request *http.Request) string {
+	now := calendarMonth(request)
+	return fmt.Sprintf("/admin/rates?y=%d&m=%d", now.Year(), now.Month())
+}
+
+// calendarMonth returns the first day of the month given by the y and m parameters of a calendar, or the current
+// month if there are none or they're out of range.
+func calendarMonth(request *http.Request) time.Time {
+	year, yearErr := strconv.Atoi(request.FormValue("y"))
+	month, monthErr := strconv.Atoi(request.FormValue("m"))
+	// time.Date would normalize a month like 13 into the next year, and years outside 1-9999 can't be formatted back.
+	if yearErr != nil || monthErr != nil || year < 1 || year > 9999 || month < 1 || month > 12 {
+		now := time.Now()
+		return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+	return time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// monthNavigation returns the year and month of a calendar and of the months before and after it, used to navigate
+// the calendar.
+func monthNavigation(now time.Time) map[string]string {
+	next := now.AddDate(0, 1, 0)
+	last := now.AddDate(0, -1, 0)
+	return map[string]string{"next_month": next.Format("01"), "next_month_year": next.Format("2006"),
+		"last_month": last.Format("01"), "last_month_year": last.Format("2006"), "this_month": now.Format("01"),
+		"this_month_year": now.Format("2006")}
+}
+
+// AdminPostRatesCalendar saves the prices changed in the rate calendar, as overrides for those nights.
+func (m *Repository) AdminPostRatesCalendar(writer http.ResponseWriter, request *http.Request) {
+	err := request.ParseForm()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	rooms, err := m.DB.GetAllRooms()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	invalid := 0
+	for _, room := range rooms {
+		// The prices as they were shown, to find out which ones the admin changed.
+		curMap, ok := m.App.Session.Get(request.Context(), fmt.Sprintf("rate_map_%d", room.ID)).(map[string]int)
+		if !ok {
+			continue
+		}
+		for day, rate := range curMap {
+			value := request.Form.Get(fmt.Sprintf("price_%d_%s", room.ID, day))
+			if value == "" {
+				continue
+			}
+			newRate, err := forms.ParseMoney(value)
+			if err != nil || newRate == 0 {
+				invalid++
+				continue
+			}
+			if newRate == rate {
+				continue
+			}
+			night, _ := time.Parse("2006-01-2", day)
+			ruleID, err := m.DB.SetRateOverride(room.ID, night, newRate)
+			if err != nil {
+				helpers.ServerError(writer, err)
+				return
+			}
+			m.recordAudit(request, "create", "rate_rule", ruleID,
+				map[string]interface{}{"room_id": room.ID, "date": day, "rate": rate},
+				map[string]interface{}{"room_id": room.ID, "date": day, "rate": newRate})
+		}
+	}
+
+	if invalid > 0 {
+		m.App.Session.Put(request.Context(), "error",
+			fmt.Sprintf("%d price(s) were not amounts like 120 or 120.50 and were not saved", invalid))
+	} else {
+		m.App.Session.Put(request.Context(), "flash", "Changes saved")
+	}
+	http.Redirect(writer, request, ratesURL(request), http.StatusSeeOther)
+}
+
+// AdminPostRoomRates saves the base rates of every room. Quotes already given and reservations already made keep
+// their price.
+func (m *Repository) AdminPostRoomRates(writer http.ResponseWriter, request *http.Request) {
+	err := request.ParseForm()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	rooms, err := m.DB.GetAllRooms()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	form := forms.New(request.PostForm)
+	updated := make([]models.Room, 0, len(rooms))
+	for _, room := range rooms {
+		rateField := fmt.Sprintf("rate_%d", room.ID)
+		weekdayField, weekendField := fmt.Sprintf("weekday_%d", room.ID), fmt.Sprintf("weekend_%d", room.ID)
+		minField, maxField := fmt.Sprintf("min_%d", room.ID), fmt.Sprintf("max_%d", room.ID)
+		valid := form.IsMoney(rateField)
+		valid = form.IntBetween(weekdayField, -100, 300) && valid
+		valid = form.IntBetween(weekendField, -100, 300) && valid
+		// The floor and the ceiling are optional.
+		for _, field := range []string{minField, maxField} {
+			if form.Has(field) {
+				valid = form.IsMoney(field) && valid
+			}
+		}
+		if !valid {
+			continue
+		}
+
+		after := room
+		after.BaseRate, _ = forms.ParseMoney(form.Get(rateField))
+		after.WeekdayAdjustment, _ = strconv.Atoi(strings.TrimSpace(form.Get(weekdayField)))
+		after.WeekendAdjustment, _ = strconv.Atoi(strings.TrimSpace(form.Get(weekendField)))
+		after.MinRate, _ = forms.ParseMoney(form.Get(minField))
+		after.MaxRate, _ = forms.ParseMoney(form.Get(maxField))
+		if after.MaxRate > 0 && after.MaxRate < after.MinRate {
+			form.Errors.Add(maxField, "The ceiling can't be below the floor")
+			continue
+		}
+		updated = append(updated, after)
+	}
+
+	if !form.Valid() {
+		m.renderRates(writer, request, form)
+		return
+	}
+
+	for i, after := range updated {
+		before := rooms[i]
+		if before.BaseRate == after.BaseRate && before.WeekdayAdjustment == after.WeekdayAdjustment &&
+			before.WeekendAdjustment == after.WeekendAdjustment && before.MinRate == after.MinRate &&
+			before.MaxRate == after.MaxRate {
+			continue
+		}
+		err = m.DB.UpdateRoomRates(after)
+		if err != nil {
+			helpers.ServerError(writer, err)
+			return
+		}
+		m.recordAudit(request, "update", "room", after.ID, before, after)
+	}
+
+	m.App.Session.Put(request.Context(), "flash", "Rates saved")
+	http.Redirect(writer, request, ratesURL(request), http.StatusSeeOther)
+}
+
+// maxRulePriority is the highest priority of the rules created by hand, below the overrides from the rate calendar.
+const maxRulePriority = models.OverridePriority - 1
+
+// AdminPostRateRule creates a rate rule. It either fixes the nightly price or adjusts it by a percentage.
+func (m *Repository) AdminPostRateRule(writer http.ResponseWriter, request *http.Request) {
+	err := request.ParseForm()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	form := forms.New(request.PostForm)
+	form.Required("name", "room_id", "rule_start_date", "rule_end_date")
+
+	rule := models.RateRule{Name: strings.TrimSpace(form.Get("name"))}
+	rule.StartDate, err = parseDateFromForm(request.Form, "rule_start_date")
+	if err != nil {
+		form.Errors.Add("rule_start_date", "Invalid date")
+	}
+	rule.EndDate, err = parseDateFromForm(request.Form, "rule_end_date")
+	if err != nil {
+		form.Errors.Add("rule_end_date", "Invalid date")
+	}
+	if form.Valid() && rule.EndDate.Before(rule.StartDate) {
+		form.Errors.Add("rule_end_date", "The last night can't be before the first one")
+	}
+	rule.RoomID, _ = strconv.Atoi(form.Get("room_id"))
+	if _, err := m.DB.GetRoomByID(rule.RoomID); err != nil {
+		form.Errors.Add("room_id", "Unknown room")
+	}
+	if form.IntBetween("priority", 0, maxRulePriority) {
+		rule.Priority, _ = strconv.Atoi(strings.TrimSpace(form.Get("priority")))
+	}
+	switch {
+	case form.Has("rate"):
+		if form.IsMoney("rate") {
+			rule.Rate, _ = forms.ParseMoney(form.Get("rate"))
+		}
+	case form.Has("adjustment"):
+		if form.IntBetween("adjustment", -100, 300) {
+			rule.Adjustment, _ = strconv.Atoi(strings.TrimSpace(form.Get("adjustment")))
+		}
+	default:
+		form.Errors.Add("rate", "Enter either a nightly price or an adjustment")
+	}
+
+	if !form.Valid() {
+		m.renderRates(writer, request, form)
+		return
+	}
+
+	rule.ID, err = m.DB.InsertRateRule(rule)
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	m.recordAudit(request, "create", "rate_rule", rule.ID, nil, rule)
+	m.App.Session.Put(request.Context(), "flash", "Rate rule created")
+	http.Redirect(writer, request, ratesURL(request), http.StatusSeeOther)
+}
+
+// AdminDeleteRateRule deletes a rate rule, the nights it covered go back to the other rules or the base rate.
+func (m *Repository) AdminDeleteRateRule(writer http.ResponseWriter, request *http.Request) {
+	err := request.ParseForm()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	id, _ := strconv.Atoi(chi.URLParam(request, "id"))
+	rule, err := m.DB.GetRateRuleByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(writer, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	err = m.DB.DeleteRateRule(rule.ID)
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	m.recordAudit(request, "delete", "rate_rule", rule.ID, rule, nil)
+	m.App.Session.Put(request.Context(), "flash", "Rate rule deleted")
+	http.Redirect(writer, request, ratesURL(request), http.StatusSeeOther)
+}
+
+// AdminPricing lists the rules of the dynamic pricing engine and shows the form to create one.
+func (m *Repository) AdminPricing(writer http.ResponseWriter, request *http.Request) {
+	m.renderPricing(writer, request, forms.New(nil))
+}
+
+// AdminPostPricingRule creates a dynamic pricing rule, active from now on.
+func (m *Repository) AdminPostPricingRule(writer http.ResponseWriter, request *http.Request) {
+	err := request.ParseForm()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	form := forms.New(request.PostForm)
+	form.Required("name", "kind")
+
+	rule := models.PricingRule{Name: strings.TrimSpace(form.Get("name")), Kind: form.Get("kind"), Active: true}
+	switch rule.Kind {
+	case models.PricingOccupancy:
+		form.IntBetween("threshold", 0, 100)
+	case models.PricingLeadTime:
+		form.IntBetween("threshold", 0, 365)
+	default:
+		form.Errors.Add("kind", "Unknown kind of rule")
+	}
+	form.IntBetween("adjustment", -100, 300)
+
+	if !form.Valid() {
+		m.renderPricing(writer, request, form)
+		return
+	}
+
+	rule.Threshold, _ = strconv.Atoi(strings.TrimSpace(form.Get("threshold")))
+	rule.Adjustment, _ = strconv.Atoi(strings.TrimSpace(form.Get("adjustment")))
+	rule.ID, err = m.DB.InsertPricingRule(rule)
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	m.recordAudit(request, "create", "pricing_rule", rule.ID, nil, rule)
+	m.App.Session.Put(request.Context(), "flash", "Pricing rule created")
+	http.Redirect(writer, request, "/admin/pricing", http.StatusSeeOther)
+}
+
+// AdminPostPricingRuleActive turns a dynamic pricing rule on or off.
+func (m *Repository) AdminPostPricingRuleActive(writer http.ResponseWriter, request *http.Request) {
+	err := request.ParseForm()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	id, _ := strconv.Atoi(chi.URLParam(request, "id"))
+	active := request.Form.Get("active") == "true"
+
+	err = m.DB.UpdatePricingRuleActive(id, active)
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	m.recordAudit(request, "update", "pricing_rule", id, map[string]interface{}{"active": !active},
+		map[string]interface{}{"active": active})
+	m.App.Session.Put(request.Context(), "flash", "Pricing rule saved")
+	http.Redirect(writer, request, "/admin/pricing", http.StatusSeeOther)
+}
+
+// AdminDeletePricingRule deletes a dynamic pricing rule.
+func (m *Repository) AdminDeletePricingRule(writer http.ResponseWriter, request *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(request, "id"))
+	rule, err := m.DB.GetPricingRuleByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(writer, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	err = m.DB.DeletePricingRule(rule.ID)
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	m.recordAudit(request, "delete", "pricing_rule", rule.ID, rule, nil)
+	m.App.Session.Put(request.Context(), "flash", "Pricing rule deleted")
+	http.Redirect(writer, request, "/admin/pricing", http.StatusSeeOther)
+}
+
+// renderPricing renders the dynamic pricing page with the given form.
+func (m *Repository) renderPricing(writer http.ResponseWriter, request *http.Request, form *forms.Form) {
+	rules, err := m.DB.GetPricingRules()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	data := map[string]interface{}{"rules": rules, "kinds": models.PricingKinds}
+	intMap := map[string]int{"simulation_days": defaultSimulationDays}
+	render.Template(writer, request, "admin-pricing.page.gohtml", &models.TemplateData{Data: data, IntMap: intMap,
+		Form: form})
+}
+
+// defaultSimulationDays and maxSimulationDays are how many days the pricing simulation covers by default and at
+// most.
+const (
+	defaultSimulationDays = 90
+	maxSimulationDays     = 365
+)
+
+type simulatedNight struct {
+	Date     string   `json:"date"`
+	BaseRate int      `json:"base_rate"`
+	Rate     int      `json:"rate"`
+	Rules    []string `json:"rules"`
+}
+
+type simulatedRoom struct {
+	RoomID   int              `json:"room_id"`
+	RoomName string           `json:"room_name"`
+	Nights   []simulatedNight `json:"nights"`
+}
+
+type simulationResponse struct {
+	OK        bool            `json:"ok"`
+	Message   string          `json:"message"`
+	StartDate string          `json:"start_date"`
+	Days      int             `json:"days"`
+	Rooms     []simulatedRoom `json:"rooms"`
+}
+
+// AdminPricingSimulation sends back, as JSON, the price of every night of every room from today on, as the current
+// rules, rates and occupancy would make it. The number of days is given by the days parameter, 90 by default.
+func (m *Repository) AdminPricingSimulation(writer http.ResponseWriter, request *http.Request) {
+	days := defaultSimulationDays
+	if request.URL.Query().Get("days") != "" {
+		var err error
+		days, err = strconv.Atoi(request.URL.Query().Get("days"))
+		if err != nil || days < 1 || days > maxSimulationDays {
+			writeSimulation(writer, http.StatusBadRequest, simulationResponse{
+				Message: fmt.Sprintf("days must be a whole number between 1 and %d", maxSimulationDays)})
+			return
+		}
+	}
+
+	now := time.Now()
+	start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	response := simulationResponse{OK: true, StartDate: start.Format("2006-01-02"), Days: days}
+
+	rooms, err := m.DB.GetAllRooms()
+	if err != nil {
+		writeSimulation(writer, http.StatusInternalServerError, simulationResponse{Message: "Internal server error"})
+		return
+	}
+	for _, room := range rooms {
+		prices, err := m.Pricing.Simulate(room, start, days)
+		if err != nil {
+			writeSimulation(writer, http.StatusInternalServerError, simulationResponse{Message: "Internal server error"})
+			return
+		}
+
+		simulated := simulatedRoom{RoomID: room.ID, RoomName: room.RoomName}
+		for _, price := range prices {
+			night := simulatedNight{Date: price.Date.Format("2006-01-02"), BaseRate: price.BaseRate, Rate: price.Rate,
+				Rules: []string{}}
+			for _, rule := range price.Applied {
+				night.Rules = append(night.Rules, rule.Name)
+			}
+			simulated.Nights = append(simulated.Nights, night)
+		}
+		response.Rooms = append(response.Rooms, simulated)
+	}
+
+	writeSimulation(writer, http.StatusOK, response)
+}
+
+// writeSimulation writes the response of the pricing simulation.
+func writeSimulation(writer http.ResponseWriter, status int, response simulationResponse) {
+	out, _ := json.MarshalIndent(response, "", "    ")
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	writer.Write(out)
+}
+
+// AdminCharges lists the taxes and fees and shows the form to add one.
+func (m *Repository) AdminCharges(writer http.ResponseWriter, request *http.Request) {
+	m.renderCharges(writer, request, forms.New(nil))
+}
+
+// AdminPostCharge adds a tax or fee to the quotes of the stays while it's in effect.
+func (m *Repository) AdminPostCharge(writer http.ResponseWriter, request *http.Request) {
+	err := request.ParseForm()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	form := forms.New(request.PostForm)
+	form.Required("name", "kind", "calculation", "amount")
+
+	charge := models.Charge{Name: strings.TrimSpace(form.Get("name")), Kind: form.Get("kind"),
+		Calculation: form.Get("calculation"), Per: form.Get("per")}
+	if charge.Kind != models.ChargeTax && charge.Kind != models.ChargeFee {
+		form.Errors.Add("kind", "Unknown kind of charge")
+	}
+	switch charge.Calculation {
+	case models.ChargePercent:
+		// Percentages are of the price of the whole stay.
+		charge.Per = models.PerStay
+	case models.ChargeFixed:
+		if charge.Per != models.PerStay && charge.Per != models.PerNight && charge.Per != models.PerGuest {
+			form.Errors.Add("per", "Choose what the amount is charged per")
+		}
+	default:
+		form.Errors.Add("calculation", "Unknown calculation")
+	}
+	// Fixed amounts are in cents and percentages in hundredths of a percent, both have two decimals.
+	if form.Has("amount") && form.IsMoney("amount") {
+		charge.Amount, _ = forms.ParseMoney(form.Get("amount"))
+	}
+	if form.Has("start_date") {
+		charge.StartDate, err = parseDateFromForm(request.Form, "start_date")
+		if err != nil {
+			form.Errors.Add("start_date", "Invalid date")
+		}
+	}
+	if form.Has("end_date") {
+		charge.EndDate, err = parseDateFromForm(request.Form, "end_date")
+		if err != nil {
+			form.Errors.Add("end_date", "Invalid date")
+		}
+	}
+	if !charge.StartDate.IsZero() && !charge.EndDate.IsZero() && charge.EndDate.Before(charge.StartDate) {
+		form.Errors.Add("end_date", "The last day can't be before the first one")
+	}
+
+	if !form.Valid() {
+		m.renderCharges(writer, request, form)
+		return
+	}
+
+	charge.ID, err = m.DB.InsertCharge(charge)
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	m.recordAudit(request, "create", "charge", charge.ID, nil, charge)
+	m.App.Session.Put(request.Context(), "flash", "Charge added")
+	http.Redirect(writer, request, "/admin/charges", http.StatusSeeOther)
+}
+
+// AdminDeleteCharge deletes a tax or fee. Reservations already made keep charging it.
+func (m *Repository) AdminDeleteCharge(writer http.ResponseWriter, request *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(request, "id"))
+	charge, err := m.DB.GetChargeByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(writer, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	err = m.DB.DeleteCharge(charge.ID)
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	m.recordAudit(request, "delete", "charge", charge.ID, charge, nil)
+	m.App.Session.Put(request.Context(), "flash", "Charge deleted")
+	http.Redirect(writer, request, "/admin/charges", http.StatusSeeOther)
+}
+
+// renderCharges renders the taxes and fees page with the given form.
+func (m *Repository) renderCharges(writer http.ResponseWriter, request *http.Request, form *forms.Form) {
+	charges, err := m.DB.GetCharges()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	render.Template(writer, request, "admin-charges.page.gohtml", &models.TemplateData{
+		Data: map[string]interface{}{"charges": charges},
+		Form: form,
+	})
+}
+
+// AdminStayRules lists the stay rules of the rooms and shows the form to add one.
+func (m *Repository) AdminStayRules(writer http.ResponseWriter, request *http.Request) {
+	m.renderStayRules(writer, request, forms.New(nil))
+}
+
+// AdminPostStayRule adds a stay rule to a room: a minimum or maximum number of nights, or closing it to arrivals or
+// departures, from a day to another and optionally only on some days of the week.
+func (m *Repository) AdminPostStayRule(writer http.ResponseWriter, request *http.Request) {
+	err := request.ParseForm()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	form := forms.New(request.PostForm)
+	form.Required("room_id", "restriction_id", "start_date", "end_date")
+
+	rule := models.RoomRestriction{}
+	rule.RoomID, _ = strconv.Atoi(form.Get("room_id"))
+	rule.RestrictionID, _ = strconv.Atoi(form.Get("restriction_id"))
+	switch rule.RestrictionID {
+	case models.RestrictionMinStay, models.RestrictionMaxStay:
+		if form.IntBetween("nights", 1, 365) {
+			rule.Nights, _ = strconv.Atoi(strings.TrimSpace(form.Get("nights")))
+		}
+	case models.RestrictionClosedToArrival, models.RestrictionClosedToDeparture:
+	default:
+		form.Errors.Add("restriction_id", "Unknown kind of rule")
+	}
+	for _, value := range request.PostForm["days"] {
+		day, err := strconv.Atoi(value)
+		if err != nil || day < int(time.Sunday) || day > int(time.Saturday) {
+			form.Errors.Add("days", "Unknown day of the week")
+			break
+		}
+		rule.Days |= 1 << day
+	}
+
+	// The form takes the last day the rule applies, restrictions store the day after it.
+	var lastDay time.Time
+	if form.Has("start_date") {
+		rule.StartDate, err = parseDateFromForm(request.Form, "start_date")
+		if err != nil {
+			form.Errors.Add("start_date", "Invalid date")
+		}
+	}
+	if form.Has("end_date") {
+		lastDay, err = parseDateFromForm(request.Form, "end_date")
+		if err != nil {
+			form.Errors.Add("end_date", "Invalid date")
+		}
+		rule.EndDate = lastDay.AddDate(0, 0, 1)
+	}
+	if !rule.StartDate.IsZero() && !lastDay.IsZero() && lastDay.Before(rule.StartDate) {
+		form.Errors.Add("end_date", "The last day can't be before the first one")
+	}
+
+	if !form.Valid() {
+		m.renderStayRules(writer, request, form)
+		return
+	}
+
+	rule.ID, err = m.DB.InsertStayRule(rule)
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	m.recordAudit(request, "create", "stay_rule", rule.ID, nil, rule)
+	m.App.Session.Put(request.Context(), "flash", "Stay rule added")
+	http.Redirect(writer, request, "/admin/stay-rules", http.StatusSeeOther)
+}
+
+// AdminDeleteStayRule deletes a stay rule.
+func (m *Repository) AdminDeleteStayRule(writer http.ResponseWriter, request *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(request, "id"))
+	rule, err := m.DB.GetStayRuleByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(writer, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	err = m.DB.DeleteStayRule(rule.ID)
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	m.recordAudit(request, "delete", "stay_rule", rule.ID, rule, nil)
+	m.App.Session.Put(request.Context(), "flash", "Stay rule deleted")
+	http.Redirect(writer, request, "/admin/stay-rules", http.StatusSeeOther)
+}
+
+// renderStayRules renders the stay rules page with the given form.
+func (m *Repository) renderStayRules(writer http.ResponseWriter, request *http.Request, form *forms.Form) {
+	rules, err := m.DB.GetStayRules()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	rooms, err := m.DB.GetAllRooms()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	weekdays := make([]string, 0, 7)
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		weekdays = append(weekdays, day.String())
+	}
+	selectedDays := make(map[int]bool)
+	for _, value := range form.Values["days"] {
+		day, _ := strconv.Atoi(value)
+		selectedDays[day] = true
+	}
+
+	render.Template(writer, request, "admin-stay-rules.page.gohtml", &models.TemplateData{
+		Data: map[string]interface{}{"rules": rules, "rooms": rooms, "weekdays": weekdays,
+			"selected_days": selectedDays},
+		Form: form,
+	})
+}
+
+// AdminRooms lists the rooms in the order guests see them, and the archived rooms.
+func (m *Repository) AdminRooms(writer http.ResponseWriter, request *http.Request) {
+	rooms, err := m.DB.GetAllRooms()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	archived, err := m.DB.GetArchivedRooms()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	render.Template(writer, request, "admin-rooms.page.gohtml", &models.TemplateData{
+		Data: map[string]interface{}{"rooms": rooms, "archived": archived},
+	})
+}
+
+// AdminNewRoom shows the form to add a room.
+func (m *Repository) AdminNewRoom(writer http.ResponseWriter, request *http.Request) {
+	form := forms.New(url.Values{"capacity": {"2"}})
+	m.renderRoom(writer, request, map[string]interface{}{}, form)
+}
+
+// renderRoom renders the form of a room with the given data, and the amenities it can have. The data has the room
+// being edited, if any.
+func (m *Repository) renderRoom(writer http.ResponseWriter, request *http.Request, data map[string]interface{},
+	form *forms.Form) {
+	amenities, err := m.DB.GetAmenities()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	data["amenities"] = amenities
+	render.Template(writer, request, "admin-room.page.gohtml", &models.TemplateData{Data: data, Form: form})
+}
+
+// roomAmenities returns the amenities checked in the room form. Ids of amenities that don't exist are ignored.
+func (m *Repository) roomAmenities(form *forms.Form) ([]models.Amenity, error) {
+	amenities, err := m.DB.GetAmenities()
+	if err != nil {
+		return nil, err
+	}
+	var checked []models.Amenity
+	for _, amenity := range amenities {
+		if form.Checked("amenities", amenity.ID) {
+			checked = append(checked, amenity)
+		}
+	}
+	return checked, nil
+}
+
+// amenityIDs returns the ids of the given amenities.
+func amenityIDs(amenities []models.Amenity) []int {
+	ids := make([]int, len(amenities))
+	for i, amenity := range amenities {
+		ids[i] = amenity.ID
+	}
+	return ids
+}
+
+// AdminPostNewRoom adds a room. Guests can find and book it right away.
+func (m *Repository) AdminPostNewRoom(writer http.ResponseWriter, request *http.Request) {
+	err := request.ParseForm()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	form := forms.New(request.PostForm)
+	room := roomFromForm(form, models.Room{})
+	if form.IsMoney("base_rate") {
+		room.BaseRate, _ = forms.ParseMoney(form.Get("base_rate"))
+	}
+	room.Amenities, err = m.roomAmenities(form)
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	if form.Valid() {
+		room.ID, err = m.DB.InsertRoom(room)
+		if errors.Is(err, repository.ErrSlugTaken) {
+			form.Errors.Add("slug", "Another room already has this address")
+		} else if err != nil {
+			helpers.ServerError(writer, err)
+			return
+		}
+	}
+
+	if !form.Valid() {
+		m.renderRoom(writer, request, map[string]interface{}{}, form)
+		return
+	}
+
+	err = m.DB.SetRoomAmenities(room.ID, amenityIDs(room.Amenities))
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	m.recordAudit(request, "create", "room", room.ID, nil, room)
+	m.App.Session.Put(request.Context(), "flash", "Room added")
+	http.Redirect(writer, request, "/admin/rooms", http.StatusSeeOther)
+}
+
+// AdminEditRoom shows the form to edit the details of a room.
+func (m *Repository) AdminEditRoom(writer http.ResponseWriter, request *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(request, "id"))
+	room, err := m.DB.GetRoomByID(id)
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	room.Amenities, err = m.DB.GetAmenitiesForRoom(id)
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	form := forms.New(url.Values{
+		"room_name":   {room.RoomName},
+		"slug":        {room.Slug},
+		"description": {room.Description},
+		"capacity":    {strconv.Itoa(room.Capacity)},
+		"beds":        {room.Beds},
+		"size":        {strconv.Itoa(room.Size)},
+	})
+	for _, amenity := range room.Amenities {
+		form.Add("amenities", strconv.Itoa(amenity.ID))
+	}
+	m.renderRoom(writer, request, map[string]interface{}{"room": room}, form)
+}
+
+// AdminPostEditRoom saves the details of a room.
+func (m *Repository) AdminPostEditRoom(writer http.ResponseWriter, request *http.Request) {
+	err := request.ParseForm()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	id, _ := strconv.Atoi(chi.URLParam(request, "id"))
+	before, err := m.DB.GetRoomByID(id)
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	before.Amenities, err = m.DB.GetAmenitiesForRoom(id)
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	form := forms.New(request.PostForm)
+	after := roomFromForm(form, before)
+	after.Amenities, err = m.roomAmenities(form)
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	if form.Valid() {
+		err = m.DB.UpdateRoom(after)
+		if errors.Is(err, repository.ErrSlugTaken) {
+			form.Errors.Add("slug", "Another room already has this address")
+		} else if err != nil {
+			helpers.ServerError(writer, err)
+			return
+		}
+	}
+
+	if !form.Valid() {
+		m.renderRoom(writer, request, map[string]interface{}{"room": before}, form)
+		return
+	}
+
+	err = m.DB.SetRoomAmenities(id, amenityIDs(after.Amenities))
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	m.recordAudit(request, "update", "room", id, before, after)
+	m.App.Session.Put(request.Context(), "flash", "Room saved")
+	http.Redirect(writer, request, "/admin/rooms", http.StatusSeeOther)
+}
+
+// roomFromForm validates the details of a room posted from the room form, and returns the room with them. The slug
+// defaults to one made from the name.
+func roomFromForm(form *forms.Form, room models.Room) models.Room {
+	form.Required("room_name")
+	if !form.Has("slug") {
+		form.Set("slug", models.Slugify(form.Get("room_name")))
+	}
+	if form.Has("room_name") && !models.IsSlug(form.Get("slug")) {
+		form.Errors.Add("slug", "Use lowercase letters and digits separated by dashes, like garden-suite")
+	}
+	form.IntBetween("capacity", 1, 50)
+	if form.Has("size") {
+		form.IntBetween("size", 0, 10000)
+	}
+
+	room.RoomName = strings.TrimSpace(form.Get("room_name"))
+	room.Slug = form.Get("slug")
+	room.Description = strings.TrimSpace(form.Get("description"))
+	room.Beds = strings.TrimSpace(form.Get("beds"))
+	room.Capacity, _ = strconv.Atoi(strings.TrimSpace(form.Get("capacity")))
+	room.Size, _ = strconv.Atoi(strings.TrimSpace(form.Get("size")))
+	return room
+}
+
+// AdminPostRoomArchived takes a room out of use, or puts an archived room back in use. Archived rooms keep their
+// reservations, but guests can't find or book them anymore.
+func (m *Repository) AdminPostRoomArchived(writer http.ResponseWriter, request *http.Request) {
+	err := request.ParseForm()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	id, _ := strconv.Atoi(chi.URLParam(request, "id"))
+	archived := request.Form.Get("archived") == "true"
+
+	err = m.DB.SetRoomArchived(id, archived)
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	m.recordAudit(request, "update", "room", id, map[string]interface{}{"archived": !archived},
+		map[string]interface{}{"archived": archived})
+	if archived {
+		m.App.Session.Put(request.Context(), "flash", "Room archived")
+	} else {
+		m.App.Session.Put(request.Context(), "flash", "Room restored")
+	}
+	http.Redirect(writer, request, "/admin/rooms", http.StatusSeeOther)
+}
+
+// AdminPostMoveRoom moves a room one place up or down in the order guests see the rooms in.
+func (m *Repository) AdminPostMoveRoom(writer http.ResponseWriter, request *http.Request) {
+	err := request.ParseForm()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	id, _ := strconv.Atoi(chi.URLParam(request, "id"))
+
+	rooms, err := m.DB.GetAllRooms()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	ids := make([]int, len(rooms))
+	for i, room := range rooms {
+		ids[i] = room.ID
+	}
+	from, to := swapWithNeighbour(ids, id, request.Form.Get("direction"))
+	if from == to {
+		// Unknown rooms and the first or last room moved past the end stay where they are.
+		http.Redirect(writer, request, "/admin/rooms", http.StatusSeeOther)
+		return
+	}
+
+	err = m.DB.UpdateRoomOrder(ids)
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	m.recordAudit(request, "update", "room", id, map[string]interface{}{"sort_order": from},
+		map[string]interface{}{"sort_order": to})
+	http.Redirect(writer, request, "/admin/rooms", http.StatusSeeOther)
+}
+
+// swapWithNeighbour moves id one place "up" or "down" in ids, swapping it with its neighbour, and returns its sort
+// order before and after the move, counted from 1. Both are the same if id can't move that way, and 0 if it isn't in
+// ids.
+func swapWithNeighbour(ids []int, id int, direction string) (int, int) {
+	for i := range ids {
+		if ids[i] != id {
+			continue
+		}
+		switch {
+		case direction == "up" && i > 0:
+			ids[i-1], ids[i] = ids[i], ids[i-1]
+			return i + 1, i
+		case direction == "down" && i < len(ids)-1:
+			ids[i], ids[i+1] = ids[i+1], ids[i]
+			return i + 1, i + 2
+		}
+		return i + 1, i + 1
+	}
+	return 0, 0
+}
+
+// maxPhotosPerUpload is how many photos can be uploaded at once.
+const maxPhotosPerUpload = 10
+
+// MaxUploadSize is the largest request body the app accepts, in bytes, enough for maxPhotosPerUpload photos.
+const MaxUploadSize = maxPhotosPerUpload*photos.MaxFileSize + 1<<20
+
+// roomForPhotos returns the room in the id URL parameter with its photos, for the photo pages.
+func (m *Repository) roomForPhotos(request *http.Request) (models.Room, error) {
+	id, _ := strconv.Atoi(chi.URLParam(request, "id"))
+	room, err := m.DB.GetRoomByID(id)
+	if err != nil {
+		return room, err
+	}
+	room.Photos, err = m.DB.GetPhotosForRoom(id)
+	return room, err
+}
+
+// roomPhoto returns the photo in the photoID URL parameter, if it's a photo of the room in the id one.
+func (m *Repository) roomPhoto(request *http.Request) (models.RoomPhoto, error) {
+	roomID, _ := strconv.Atoi(chi.URLParam(request, "id"))
+	photoID, _ := strconv.Atoi(chi.URLParam(request, "photoID"))
+	photo, err := m.DB.GetRoomPhotoByID(photoID)
+	if err == nil && photo.RoomID != roomID {
+		return photo, sql.ErrNoRows
+	}
+	return photo, err
+}
+
+// withCoverPhotos sets the first photo of each room as its only photo, for the lists of rooms.
+func (m *Repository) withCoverPhotos(rooms []models.Room) error {
+	covers, err := m.DB.GetCoverPhotos()
+	if err != nil {
+		return err
+	}
+	for i, room := range rooms {
+		if cover, ok := covers[room.ID]; ok {
+			rooms[i].Photos = []models.RoomPhoto{cover}
+		}
+	}
+	return nil
+}
+
+// AdminRoomPhotos shows the photos of a room, with the form to upload more.
+func (m *Repository) AdminRoomPhotos(writer http.ResponseWriter, request *http.Request) {
+	room, err := m.roomForPhotos(request)
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	render.Template(writer, request, "admin-room-photos.page.gohtml", &models.TemplateData{
+		Data: map[string]interface{}{"room": room},
+	})
+}
+
+// AdminPostRoomPhotos uploads photos of a room, with an optional caption for all of them. Each photo is checked to
+// be a JPEG, PNG or GIF image from its content, and its thumbnails are made before it's stored. Photos that can't be
+// used are reported, the others are still added.
+func (m *Repository) AdminPostRoomPhotos(writer http.ResponseWriter, request *http.Request) {
+	room, err := m.roomForPhotos(request)
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	redirect := fmt.Sprintf("/admin/rooms/%d/photos", room.ID)
+
+	request.Body = http.MaxBytesReader(writer, request.Body, MaxUploadSize)
+	err = request.ParseMultipartForm(32 << 20)
+	if err != nil {
+		m.App.Session.Put(request.Context(), "error",
+			fmt.Sprintf("Cannot read the upload, send at most %d photos of 10MB at once", maxPhotosPerUpload))
+		http.Redirect(writer, request, redirect, http.StatusSeeOther)
+		return
+	}
+
+	files := request.MultipartForm.File["photos"]
+	if len(files) == 0 || len(files) > maxPhotosPerUpload {
+		m.App.Session.Put(request.Context(), "error",
+			fmt.Sprintf("Choose from 1 to %d photos to upload", maxPhotosPerUpload))
+		http.Redirect(writer, request, redirect, http.StatusSeeOther)
+		return
+	}
+
+	caption := strings.TrimSpace(request.FormValue("caption"))
+	var rejected []string
+	uploaded := 0
+	for _, file := range files {
+		photo, err := m.saveRoomPhoto(room.ID, file, caption)
+		if errors.Is(err, photos.ErrTooLarge) || errors.Is(err, photos.ErrUnsupportedType) {
+			rejected = append(rejected, fmt.Sprintf("%s: %s", file.Filename, err))
+			continue
+		}
+		if err != nil {
+			helpers.ServerError(writer, err)
+			return
+		}
+		m.recordAudit(request, "create", "room_photo", photo.ID, nil, photo)
+		uploaded++
+	}
+
+	if uploaded > 0 {
+		m.App.Session.Put(request.Context(), "flash", fmt.Sprintf("%d photo(s) uploaded", uploaded))
+	}
+	if len(rejected) > 0 {
+		m.App.Session.Put(request.Context(), "error", "Some photos were not uploaded. "+strings.Join(rejected, ", "))
+	}
+	http.Redirect(writer, request, redirect, http.StatusSeeOther)
+}
+
+// saveRoomPhoto checks an uploaded photo, stores it with its thumbnails under a random name and inserts it.
+func (m *Repository) saveRoomPhoto(roomID int, file *multipart.FileHeader, caption string) (models.RoomPhoto, error) {
+	var photo models.RoomPhoto
+	if file.Size > photos.MaxFileSize {
+		return photo, photos.ErrTooLarge
+	}
+
+	f, err := file.Open()
+	if err != nil {
+		return photo, err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return photo, err
+	}
+
+	processed, err := photos.Process(data, models.PhotoWidths)
+	if err != nil {
+		return photo, err
+	}
+
+	// Names are random, so they can't be guessed and a replaced photo is never served from a cache.
+	name, err := helpers.GenerateToken()
+	if err != nil {
+		return photo, err
+	}
+	photo = models.RoomPhoto{
+		RoomID:  roomID,
+		Path:    fmt.Sprintf("rooms/%d/%s%s", roomID, name, processed.Extension),
+		Caption: caption,
+		Width:   processed.Width,
+		Height:  processed.Height,
+	}
+
+	err = m.App.Storage.Save(photo.Path, data)
+	if err != nil {
+		return photo, err
+	}
+	for width, thumbnail := range processed.Thumbnails {
+		err = m.App.Storage.Save(photo.Thumbnail(width), thumbnail)
+		if err != nil {
+			return photo, err
+		}
+	}
+
+	photo.ID, err = m.DB.InsertRoomPhoto(photo)
+	return photo, err
+}
+
+// AdminPostRoomPhoto saves the caption of a room photo.
+func (m *Repository) AdminPostRoomPhoto(writer http.ResponseWriter, request *http.Request) {
+	err := request.ParseForm()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	photo, err := m.roomPhoto(request)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(writer, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	caption := strings.TrimSpace(request.Form.Get("caption"))
+	err = m.DB.UpdateRoomPhotoCaption(photo.ID, caption)
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	m.recordAudit(request, "update", "room_photo", photo.ID, map[string]interface{}{"caption": photo.Caption},
+		map[string]interface{}{"caption": caption})
+	m.App.Session.Put(request.Context(), "flash", "Caption saved")
+	http.Redirect(writer, request, fmt.Sprintf("/admin/rooms/%d/photos", photo.RoomID), http.StatusSeeOther)
+}
+
+// AdminPostMoveRoomPhoto moves a photo one place up or down in the order of the photos of its room.
+func (m *Repository) AdminPostMoveRoomPhoto(writer http.ResponseWriter, request *http.Request) {
+	err := request.ParseForm()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	room, err := m.roomForPhotos(request)
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	id, _ := strconv.Atoi(chi.URLParam(request, "photoID"))
+
+	ids := make([]int, len(room.Photos))
+	for i, photo := range room.Photos {
+		ids[i] = photo.ID
+	}
+	from, to := swapWithNeighbour(ids, id, request.Form.Get("direction"))
+	if from == to {
+		// Photos of other rooms and the first or last photo moved past the end stay where they are.
+		http.Redirect(writer, request, fmt.Sprintf("/admin/rooms/%d/photos", room.ID), http.StatusSeeOther)
+		return
+	}
+
+	err = m.DB.UpdateRoomPhotoOrder(room.ID, ids)
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	m.recordAudit(request, "update", "room_photo", id, map[string]interface{}{"sort_order": from},
+		map[string]interface{}{"sort_order": to})
+	http.Redirect(writer, request, fmt.Sprintf("/admin/rooms/%d/photos", room.ID), http.StatusSeeOther)
+}
+
+// AdminDeleteRoomPhoto deletes a room photo and its files.
+func (m *Repository) AdminDeleteRoomPhoto(writer http.ResponseWriter, request *http.Request) {
+	photo, err := m.roomPhoto(request)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(writer, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	err = m.DB.DeleteRoomPhoto(photo.ID)
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	// The photo is gone from the pages already, so files that can't be deleted are only logged.
+	for _, file := range photo.Files() {
+		err = m.App.Storage.Delete(file)
+		if err != nil {
+			m.App.ErrorLog.Println("Cannot delete room photo file:", err)
+		}
+	}
+
+	m.recordAudit(request, "delete", "room_photo", photo.ID, photo, nil)
+	m.App.Session.Put(request.Context(), "flash", "Photo deleted")
+	http.Redirect(writer, request, fmt.Sprintf("/admin/rooms/%d/photos", photo.RoomID), http.StatusSeeOther)
+}
+
+// AdminAmenities lists the amenities rooms can have and shows the form to add one.
+func (m *Repository) AdminAmenities(writer http.ResponseWriter, request *http.Request) {
+	m.renderAmenities(writer, request, forms.New(url.Values{"icon": {models.AmenityIcons[0]}}))
+}
+
+// AdminPostAmenity adds an amenity. It can be given to rooms from their edit page.
+func (m *Repository) AdminPostAmenity(writer http.ResponseWriter, request *http.Request) {
+	err := request.ParseForm()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	form := forms.New(request.PostForm)
+	amenity := amenityFromForm(form, models.Amenity{})
+	if form.Valid() {
+		amenity.ID, err = m.DB.InsertAmenity(amenity)
+		if errors.Is(err, repository.ErrAmenityTaken) {
+			form.Errors.Add("name", "Another amenity already has this name")
+		} else if err != nil {
+			helpers.ServerError(writer, err)
+			return
+		}
+	}
+
+	if !form.Valid() {
+		m.renderAmenities(writer, request, form)
+		return
+	}
+
+	m.recordAudit(request, "create", "amenity", amenity.ID, nil, amenity)
+	m.App.Session.Put(request.Context(), "flash", "Amenity added")
+	http.Redirect(writer, request, "/admin/amenities", http.StatusSeeOther)
+}
+
+// AdminPostEditAmenity renames an amenity or changes its icon.
+func (m *Repository) AdminPostEditAmenity(writer http.ResponseWriter, request *http.Request) {
+	err := request.ParseForm()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	before, err := m.amenity(request)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(writer, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	// Every amenity has its own form in the list, so errors are flashed rather than shown next to the fields.
+	form := forms.New(request.PostForm)
+	after := amenityFromForm(form, before)
+	if form.Valid() {
+		err = m.DB.UpdateAmenity(after)
+		if errors.Is(err, repository.ErrAmenityTaken) {
+			form.Errors.Add("name", "Another amenity already has this name")
+		} else if err != nil {
+			helpers.ServerError(writer, err)
+			return
+		}
+	}
+	if !form.Valid() {
+		message := form.Errors.Get("name")
+		if message == "" {
+			message = form.Errors.Get("icon")
+		}
+		m.App.Session.Put(request.Context(), "error", fmt.Sprintf("%s was not saved: %s", before.Name, message))
+		http.Redirect(writer, request, "/admin/amenities", http.StatusSeeOther)
+		return
+	}
+
+	m.recordAudit(request, "update", "amenity", before.ID, before, after)
+	m.App.Session.Put(request.Context(), "flash", "Amenity saved")
+	http.Redirect(writer, request, "/admin/amenities", http.StatusSeeOther)
+}
+
+// AdminDeleteAmenity deletes an amenity, removing it from the rooms that had it.
+func (m *Repository) AdminDeleteAmenity(writer http.ResponseWriter, request *http.Request) {
+	amenity, err := m.amenity(request)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(writer, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	err = m.DB.DeleteAmenity(amenity.ID)
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	m.recordAudit(request, "delete", "amenity", amenity.ID, amenity, nil)
+	m.App.Session.Put(request.Context(), "flash", "Amenity deleted")
+	http.Redirect(writer, request, "/admin/amenities", http.StatusSeeOther)
+}
+
+// amenity returns the amenity in the id URL parameter, or sql.ErrNoRows if there is none.
+func (m *Repository) amenity(request *http.Request) (models.Amenity, error) {
+	id, _ := strconv.Atoi(chi.URLParam(request, "id"))
+	amenities, err := m.DB.GetAmenities()
+	if err != nil {
+		return models.Amenity{}, err
+	}
+	for _, amenity := range amenities {
+		if amenity.ID == id {
+			return amenity, nil
+		}
+	}
+	return models.Amenity{}, sql.ErrNoRows
+}
+
+// amenityFromForm validates the name and icon of an amenity posted from the amenity forms, and returns the amenity
+// with them.
+func amenityFromForm(form *forms.Form, amenity models.Amenity) models.Amenity {
+	form.Required("name", "icon")
+	if form.Has("icon") && !models.IsAmenityIcon(form.Get("icon")) {
+		form.Errors.Add("icon", "Choose one of the icons")
+	}
+
+	amenity.Name = strings.TrimSpace(form.Get("name"))
+	amenity.Icon = form.Get("icon")
+	return amenity
+}
+
+// renderAmenities renders the amenities page with the given form.
+func (m *Repository) renderAmenities(writer http.ResponseWriter, request *http.Request, form *forms.Form) {
+	amenities, err := m.DB.GetAmenities()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	render.Template(writer, request, "admin-amenities.page.gohtml", &models.TemplateData{
+		Data: map[string]interface{}{"amenities": amenities, "icons": models.AmenityIcons},
+		Form: form,
+	})
+}
+
+// AdminExtras lists the extras guests can add to their stays and shows the form to add one.
+func (m *Repository) AdminExtras(writer http.ResponseWriter, request *http.Request) {
+	m.renderExtras(writer, request, forms.New(url.Values{"per": {models.ExtraPerStay}, "active": {"true"}}))
+}
+
+// AdminPostExtra adds an extra, offered to the guests when they book if it's active.
+func (m *Repository) AdminPostExtra(writer http.ResponseWriter, request *http.Request) {
+	err := request.ParseForm()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	form := forms.New(request.PostForm)
+	extra := extraFromForm(form, models.Extra{})
+	if form.Valid() {
+		extra.ID, err = m.DB.InsertExtra(extra)
+		if errors.Is(err, repository.ErrExtraTaken) {
+			form.Errors.Add("name", "Another extra already has this name")
+		} else if err != nil {
+			helpers.ServerError(writer, err)
+			return
+		}
+	}
+
+	if !form.Valid() {
+		m.renderExtras(writer, request, form)
+		return
+	}
+
+	m.recordAudit(request, "create", "extra", extra.ID, nil, extra)
+	m.App.Session.Put(request.Context(), "flash", "Extra added")
+	http.Redirect(writer, request, "/admin/extras", http.StatusSeeOther)
+}
+
+// AdminPostEditExtra changes an extra. Reservations keep the extras they booked, at the price they booked them.
+func (m *Repository) AdminPostEditExtra(writer http.ResponseWriter, request *http.Request) {
+	err := request.ParseForm()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	id, _ := strconv.Atoi(chi.URLParam(request, "id"))
+
+	extras, err := m.DB.GetExtras()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	var before models.Extra
+	for _, extra := range extras {
+		if extra.ID == id {
+			before = extra
+		}
+	}
+	if before.ID == 0 {
+		helpers.ClientError(writer, http.StatusNotFound)
+		return
+	}
+
+	// Every extra has its own form in the list, so errors are flashed rather than shown next to the fields.
+	form := forms.New(request.PostForm)
+	after := extraFromForm(form, before)
+	if form.Valid() {
+		err = m.DB.UpdateExtra(after)
+		if errors.Is(err, repository.ErrExtraTaken) {
+			form.Errors.Add("name", "Another extra already has this name")
+		} else if err != nil {
+			helpers.ServerError(writer, err)
+			return
+		}
+	}
+	if !form.Valid() {
+		var message string
+		for _, field := range []string{"name", "per", "price", "inventory"} {
+			if message == "" {
+				message = form.Errors.Get(field)
+			}
+		}
+		m.App.Session.Put(request.Context(), "error", fmt.Sprintf("%s was not saved: %s", before.Name, message))
+		http.Redirect(writer, request, "/admin/extras", http.StatusSeeOther)
+		return
+	}
+
+	m.recordAudit(request, "update", "extra", id, before, after)
+	m.App.Session.Put(request.Context(), "flash", "Extra saved")
+	http.Redirect(writer, request, "/admin/extras", http.StatusSeeOther)
+}
+
+// extraFromForm validates an extra posted from the extra forms, and returns the extra with its values.
+func extraFromForm(form *forms.Form, extra models.Extra) models.Extra {
+	form.Required("name", "per", "price")
+
+	extra.Name = strings.TrimSpace(form.Get("name"))
+	extra.Per = form.Get("per")
+	if extra.Per != models.ExtraPerStay && extra.Per != models.ExtraPerNight && extra.Per != models.ExtraPerGuestNight {
+		form.Errors.Add("per", "Choose what the price is charged per")
+	}
+	if form.Has("price") && form.IsMoney("price") {
+		extra.Price, _ = forms.ParseMoney(form.Get("price"))
+	}
+	// An empty inventory means the extra is unlimited.
+	extra.Inventory = 0
+	if form.Has("inventory") && form.IntBetween("inventory", 0, 1000) {
+		extra.Inventory, _ = strconv.Atoi(strings.TrimSpace(form.Get("inventory")))
+	}
+	extra.Active = form.Has("active")
+	return extra
+}
+
+// renderExtras renders the extras page with the given form.
+func (m *Repository) renderExtras(writer http.ResponseWriter, request *http.Request, form *forms.Form) {
+	extras, err := m.DB.GetExtras()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	render.Template(writer, request, "admin-extras.page.gohtml", &models.TemplateData{
+		Data: map[string]interface{}{"extras": extras},
+		Form: form,
+	})
+}
+
+// maxPromoLimit is the highest minimum of nights, or limit of uses, a promo code can have.
+const maxPromoLimit = 100000
+
+// AdminPromoCodes lists the promo codes, with how many times they were redeemed and the discount they gave, and
+// shows the form to create one.
+func (m *Repository) AdminPromoCodes(writer http.ResponseWriter, request *http.Request) {
+	m.renderPromoCodes(writer, request, forms.New(url.Values{"kind": {models.PromoPercent}, "active": {"true"}}))
+}
+
+// AdminPostPromoCode creates a promo code. Promo codes can't be changed once created, only turned off, so the guests
+// who redeemed one keep the terms they redeemed it on.
+func (m *Repository) AdminPostPromoCode(writer http.ResponseWriter, request *http.Request) {
+	err := request.ParseForm()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	rooms, err := m.DB.GetAllRooms()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	form := forms.New(request.PostForm)
+	promo := promoCodeFromForm(form, rooms)
+	if form.Valid() {
+		promo.ID, err = m.DB.InsertPromoCode(promo)
+		if errors.Is(err, repository.ErrPromoCodeTaken) {
+			form.Errors.Add("code", "Another promo code already has this code")
+		} else if err != nil {
+			helpers.ServerError(writer, err)
+			return
+		}
+	}
+
+	if !form.Valid() {
+		m.renderPromoCodes(writer, request, form)
+		return
+	}
+
+	m.recordAudit(request, "create", "promo_code", promo.ID, nil, promo)
+	m.App.Session.Put(request.Context(), "flash", fmt.Sprintf("Promo code %s created", promo.Code))
+	http.Redirect(writer, request, "/admin/promo-codes", http.StatusSeeOther)
+}
+
+// promoCodeFromForm validates a promo code posted from the promo code form, and returns the promo code with its
+// values. Only the given rooms can be chosen.
+func promoCodeFromForm(form *forms.Form, rooms []models.Room) models.PromoCode {
+	form.Required("code", "kind", "amount", "valid_from", "valid_until")
+
+	promo := models.PromoCode{
+		Code:        models.NormalizePromoCode(form.Get("code")),
+		Description: strings.TrimSpace(form.Get("description")),
+		Kind:        form.Get("kind"),
+		Stackable:   form.Has("stackable"),
+		Active:      form.Has("active"),
+	}
+	if strings.ContainsAny(promo.Code, " \t") {
+		form.Errors.Add("code", "Codes can't contain spaces")
+	}
+	switch promo.Kind {
+	case models.PromoPercent:
+		if form.Has("amount") && form.IntBetween("amount", 1, 100) {
+			promo.Amount, _ = strconv.Atoi(strings.TrimSpace(form.Get("amount")))
+		}
+	case models.PromoFixed:
+		if form.Has("amount") && form.IsMoney("amount") {
+			promo.Amount, _ = forms.ParseMoney(form.Get("amount"))
+		}
+	default:
+		form.Errors.Add("kind", "Choose whether the discount is a percentage or an amount")
+	}
+
+	var err error
+	promo.ValidFrom, err = parseDateFromForm(form.Values, "valid_from")
+	if err != nil && form.Has("valid_from") {
+		form.Errors.Add("valid_from", "Invalid date")
+	}
+	promo.ValidUntil, err = parseDateFromForm(form.Values, "valid_until")
+	if err != nil && form.Has("valid_until") {
+		form.Errors.Add("valid_until", "Invalid date")
+	}
+	if form.Valid() && promo.ValidUntil.Before(promo.ValidFrom) {
+		form.Errors.Add("valid_until", "The last day can't be before the first one")
+	}
+
+	// Empty limits aren't enforced.
+	for field, limit := range map[string]*int{"min_nights": &promo.MinNights, "max_uses": &promo.MaxUses,
+		"max_uses_per_email": &promo.MaxUsesPerEmail} {
+		if form.Has(field) && form.IntBetween(field, 0, maxPromoLimit) {
+			*limit, _ = strconv.Atoi(strings.TrimSpace(form.Get(field)))
+		}
+	}
+
+	for _, id := range form.Ints("room_ids") {
+		known := false
+		for _, room := range rooms {
+			known = known || room.ID == id
+		}
+		if !known {
+			form.Errors.Add("room_ids", "Unknown room")
+			break
+		}
+		promo.RoomIDs = append(promo.RoomIDs, id)
+	}
+	return promo
+}
+
+// AdminPostPromoCodeActive turns a promo code on or off. Reservations keep the discounts they got with it.
+func (m *Repository) AdminPostPromoCodeActive(writer http.ResponseWriter, request *http.Request) {
+	err := request.ParseForm()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	id, _ := strconv.Atoi(chi.URLParam(request, "id"))
+	before, err := m.DB.GetPromoCodeByID(id)
+	if errors.Is(err, repository.ErrPromoCodeNotFound) {
+		helpers.ClientError(writer, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	after := before
+	after.Active = request.Form.Get("active") == "true"
+
+	// Submitting the form twice doesn't change the code again, so nothing is recorded the second time.
+	if after.Active != before.Active {
+		err = m.DB.UpdatePromoCodeActive(id, after.Active)
+		if err != nil {
+			helpers.ServerError(writer, err)
+			return
+		}
+		m.recordAudit(request, "update", "promo_code", id, before, after)
+	}
+	m.App.Session.Put(request.Context(), "flash", "Promo code saved")
+	http.Redirect(writer, request, "/admin/promo-codes", http.StatusSeeOther)
+}
+
+// AdminPromoCodeUsage shows the reservations that redeemed a promo code and the discount each of them got.
+func (m *Repository) AdminPromoCodeUsage(writer http.ResponseWriter, request *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(request, "id"))
+	promo, err := m.DB.GetPromoCodeByID(id)
+	if errors.Is(err, repository.ErrPromoCodeNotFound) {
+		helpers.ClientError(writer, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	redemptions, err := m.DB.GetPromoRedemptions(id)
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	for _, redemption := range redemptions {
+		promo.Uses++
+		promo.Discounted += redemption.Amount
+	}
+
+	render.Template(writer, request, "admin-promo-code.page.gohtml", &models.TemplateData{
+		Data: map[string]interface{}{"promo": promo, "redemptions": redemptions},
+	})
+}
+
+// renderPromoCodes renders the promo codes page with the given form.
+func (m *Repository) renderPromoCodes(writer http.ResponseWriter, request *http.Request, form *forms.Form) {
+	promos, err := m.DB.GetPromoCodes()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	rooms, err := m.DB.GetAllRooms()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	roomNames := make(map[int]string)
+	for _, room := range rooms {
+		roomNames[room.ID] = room.RoomName
+	}
+
+	render.Template(writer, request, "admin-promo-codes.page.gohtml", &models.TemplateData{
+		Data: map[string]interface{}{"promos": promos, "rooms": rooms, "roomNames": roomNames},
+		Form: form,
+	})
+}
+
+// roomForUnits returns the room in the id URL parameter with its units, for the unit pages.
+func (m *Repository) roomForUnits(request *http.Request) (models.Room, error) {
+	id, _ := strconv.Atoi(chi.URLParam(request, "id"))
+	room, err := m.DB.GetRoomByID(id)
+	if err != nil {
+		return room, err
+	}
+	room.Units, err = m.DB.GetUnitsForRoom(id)
+	return room, err
+}
+
+// roomUnit returns the unit in the unitID URL parameter, if it's a unit of the room in the id one.
+func (m *Repository) roomUnit(request *http.Request) (models.RoomUnit, error) {
+	room, err := m.roomForUnits(request)
+	if err != nil {
+		return models.RoomUnit{}, err
+	}
+	unitID, _ := strconv.Atoi(chi.URLParam(request, "unitID"))
+	for _, unit := range room.Units {
+		if unit.ID == unitID {
+			return unit, nil
+		}
+	}
+	return models.RoomUnit{}, sql.ErrNoRows
+}
+
+// AdminRoomUnits shows the units of a room, with the form to add more.
+func (m *Repository) AdminRoomUnits(writer http.ResponseWriter, request *http.Request) {
+	room, err := m.roomForUnits(request)
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	render.Template(writer, request, "admin-room-units.page.gohtml", &models.TemplateData{
+		Data: map[string]interface{}{"room": room},
+		Form: forms.New(nil),
+	})
+}
 
-	res.FirstName = request.Form.Get("first_name")
-	res.LastName = request.Form.Get("last_name")
-	res.Email = request.Form.Get("email")
-	res.Phone = request.Form.Get("phone")
-
-	err = m.DB.UpdateReservation(res)
+// AdminPostRoomUnits adds a unit to a room. It can be assigned reservations right away.
+func (m *Repository) AdminPostRoomUnits(writer http.ResponseWriter, request *http.Request) {
+	err := request.ParseForm()
 	if err != nil {
 		helpers.ServerError(writer, err)
 		return
 	}
-	m.App.Session.Put(request.Context(), "flash", "Reservation Saved")
-	http.Redirect(writer, request, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
+	room, err := m.roomForUnits(request)
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+
+	form := forms.New(request.PostForm)
+	form.Required("name")
+	if !form.Valid() {
+		render.Template(writer, request, "admin-room-units.page.gohtml", &models.TemplateData{
+			Data: map[string]interface{}{"room": room},
+			Form: form,
+		})
+		return
+	}
 
+	unit := models.RoomUnit{RoomID: room.ID, Name: strings.TrimSpace(form.Get("name"))}
+	unit.ID, err = m.DB.InsertRoomUnit(unit)
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	m.recordAudit(request, "create", "room_unit", unit.ID, nil, unit)
+	m.App.Session.Put(request.Context(), "flash", "Unit added")
+	http.Redirect(writer, request, fmt.Sprintf("/admin/rooms/%d/units", room.ID), http.StatusSeeOther)
 }
 
-// AdminProcessReservation marks a reservation as processed
-func (m *Repository) AdminProcessReservation(writer http.ResponseWriter, request *http.Request) {
-	id, _ := strconv.Atoi(chi.URLParam(request, "id"))
-	src := chi.URLParam(request, "src")
+// AdminPostRoomUnit renames a unit of a room.
+func (m *Repository) AdminPostRoomUnit(writer http.ResponseWriter, request *http.Request) {
+	err := request.ParseForm()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	before, err := m.roomUnit(request)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(writer, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	redirect := fmt.Sprintf("/admin/rooms/%d/units", before.RoomID)
 
-	_ = m.DB.UpdateProcessedForReservation(id, 1)
-	m.App.Session.Put(request.Context(), "flash", "Reservation marked as processed")
+	after := before
+	after.Name = strings.TrimSpace(request.Form.Get("name"))
+	if after.Name == "" {
+		m.App.Session.Put(request.Context(), "error", "The name of a unit can't be empty")
+		http.Redirect(writer, request, redirect, http.StatusSeeOther)
+		return
+	}
 
-	http.Redirect(writer, request, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
+	err = m.DB.UpdateRoomUnit(after)
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	m.recordAudit(request, "update", "room_unit", after.ID, map[string]interface{}{"name": before.Name},
+		map[string]interface{}{"name": after.Name})
+	m.App.Session.Put(request.Context(), "flash", "Unit saved")
+	http.Redirect(writer, request, redirect, http.StatusSeeOther)
+}
+
+// AdminPostRoomUnitArchived takes a unit out of use, or puts an archived unit back in use. Archived units keep their
+// reservations, but new ones aren't assigned to them.
+func (m *Repository) AdminPostRoomUnitArchived(writer http.ResponseWriter, request *http.Request) {
+	err := request.ParseForm()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	unit, err := m.roomUnit(request)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(writer, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	archived := request.Form.Get("archived") == "true"
 
+	err = m.DB.SetRoomUnitArchived(unit.ID, archived)
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	m.recordAudit(request, "update", "room_unit", unit.ID, map[string]interface{}{"archived": !archived},
+		map[string]interface{}{"archived": archived})
+	if archived {
+		m.App.Session.Put(request.Context(), "flash", "Unit archived")
+	} else {
+		m.App.Session.Put(request.Context(), "flash", "Unit restored")
+	}
+	http.Redirect(writer, request, fmt.Sprintf("/admin/rooms/%d/units", unit.RoomID), http.StatusSeeOther)
 }
 
-// AdminDeleteReservation deletes a reservation.
-func (m *Repository) AdminDeleteReservation(writer http.ResponseWriter, request *http.Request) {
-	id, _ := strconv.Atoi(chi.URLParam(request, "id"))
-	src := chi.URLParam(request, "src")
+// auditEntityTypes are the kinds of entities that can be found in the audit log, used to filter it.
+var auditEntityTypes = []string{"reservation", "room_restriction", "session", "room", "cancellation_policy",
+	"rate_rule", "pricing_rule", "charge", "stay_rule", "room_photo", "room_unit", "amenity", "extra", "promo_code"}
 
-	_ = m.DB.DeleteReservation(id)
-	m.App.Session.Put(request.Context(), "flash", "Reservation deleted")
+// AdminAudit shows the audit log of the changes made from the admin, filtered by user, entity and date range.
+func (m *Repository) AdminAudit(writer http.ResponseWriter, request *http.Request) {
+	query := request.URL.Query()
+	var filter models.AuditFilter
+	filter.UserID, _ = strconv.Atoi(query.Get("user_id"))
+	filter.EntityType = query.Get("entity_type")
+	filter.EntityID, _ = strconv.Atoi(query.Get("entity_id"))
+	// Dates are optional, an empty or invalid date simply doesn't filter.
+	filter.Start, _ = parseDateFromForm(query, "start")
+	filter.End, _ = parseDateFromForm(query, "end")
 
-	http.Redirect(writer, request, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
+	events, err := m.DB.GetAuditEvents(filter)
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	users, err := m.DB.GetAllUsers()
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
 
+	data := map[string]interface{}{"events": events, "users": users, "entity_types": auditEntityTypes}
+	stringMap := map[string]string{"entity_type": filter.EntityType, "entity_id": query.Get("entity_id"),
+		"start": query.Get("start"), "end": query.Get("end")}
+	intMap := map[string]int{"user_id": filter.UserID}
+	render.Template(writer, request, "admin-audit.page.gohtml", &models.TemplateData{Data: data,
+		StringMap: stringMap, IntMap: intMap})
 }
 
-// AdminPostReservationsCalendar handles post requests coming from the reservation calendar in the admin layout.
-func (m *Repository) AdminPostReservationsCalendar(writer http.ResponseWriter, request *http.Request) {
-	err := request.ParseForm()
+// AdminWaitlist shows the waitlist entries whose stay includes the night of the date in the URL params, today when
+// there's none, with what happened to them.
+func (m *Repository) AdminWaitlist(writer http.ResponseWriter, request *http.Request) {
+	date, err := parseDateFromForm(request.URL.Query(), "date")
+	if err != nil {
+		now := time.Now()
+		date = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	}
+
+	entries, err := m.DB.GetWaitlistEntriesForDate(date)
 	if err != nil {
 		helpers.ServerError(writer, err)
 		return
 	}
 
-	year, _ := strconv.Atoi(request.Form.Get("y"))
-	month, _ := strconv.Atoi(request.Form.Get("m"))
+	stringMap := map[string]string{
+		"date":     date.Format("2006-01-02"),
+		"previous": date.AddDate(0, 0, -1).Format("2006-01-02"),
+		"next":     date.AddDate(0, 0, 1).Format("2006-01-02"),
+	}
+	render.Template(writer, request, "admin-waitlist.page.gohtml", &models.TemplateData{
+		Data:      map[string]interface{}{"entries": entries},
+		StringMap: stringMap,
+	})
+}
 
-	// Process Blocks.
-	rooms, err := m.DB.GetAllRooms()
+// recordAudit stores who changed what in the audit log. Pass nil as before for creations and as after for deletions.
+// Failing to record the change is logged, but doesn't fail the request since the change itself was already made.
+func (m *Repository) recordAudit(request *http.Request, action, entityType string, entityID int, before,
+	after interface{}) {
+	changes, err := audit.Diff(before, after)
 	if err != nil {
-		helpers.ServerError(writer, err)
+		m.App.ErrorLog.Println("Cannot compute audit changes:", err)
 		return
 	}
 
-	form := forms.New(request.PostForm)
+	err = m.DB.InsertAuditEvent(models.AuditEvent{
+		UserID:     m.App.Session.GetInt(request.Context(), "user_id"),
+		Action:     action,
+		EntityType: entityType,
+		EntityID:   entityID,
+		Changes:    string(changes),
+	})
+	if err != nil {
+		m.App.ErrorLog.Println("Cannot record audit event:", err)
+	}
+}
 
-	// Handle Block Deletion.
-	for _, room := range rooms {
-		// Get the block map from the session. Contains the data as it stood before the user made any changes in the
-		// calendar itself.
-		// If an entry exists in this map but not in the posted data, whose restriction id is greater than 0, we need
-		// to remove that block since this means the user unchecked it in the calendar. Restriction id greater than 0
-		// means its an owner-imposed restriction instead of a user reservation.
-		curMap := m.App.Session.Get(request.Context(), fmt.Sprintf("block_map_%d", room.ID)).(map[string]int)
-		for day, rID := range curMap {
-			// If curMap[day] does not exist, ok will be false.
-			if val, ok := curMap[day]; ok {
-				// Ok is true, so check if the value is greater than 0 and is missing in the form post.
-				// The rest are placeholders for days without blocks.
-				if val > 0 {
-					if !form.Has(fmt.Sprintf("remove_block_%d_%s", room.ID, day)) {
-						// Delete the restriction by ID.
-						err := m.DB.DeleteBlockByID(rID)
-						if err != nil {
-							log.Println(err)
-						}
-					}
-				}
-			}
-		}
+// guestLoginLinkLifetime is how long a guest login link sent by email can be used for.
+const guestLoginLinkLifetime = 30 * time.Minute
+
+// GuestLogin displays the form where guests enter their email to receive a login link.
+func (m *Repository) GuestLogin(w http.ResponseWriter, r *http.Request) {
+	render.Template(w, r, "my-login.page.gohtml", &models.TemplateData{Form: forms.New(nil)})
+}
+
+// PostGuestLogin emails a one-time login link to a guest, if they have reservations under the given email.
+func (m *Repository) PostGuestLogin(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
 	}
 
-	// Handle Block Creation.
-	for name, _ := range request.PostForm {
-		if strings.HasPrefix(name, "add_block") {
-			// example string: add_block_1_2020-12-14 with _1_ being the room ID.
-			exploded := strings.Split(name, "_")
-			roomID, _ := strconv.Atoi(exploded[2])
-			startDate, _ := time.Parse("2006-01-2", exploded[3])
-			// Insert a new block.
-			err := m.DB.InsertBlockForRoom(roomID, startDate)
-			if err != nil {
-				log.Println(err)
-			}
+	form := forms.New(r.PostForm)
+	form.Required("email")
+	form.IsEmail("email")
+	if !form.Valid() {
+		render.Template(w, r, "my-login.page.gohtml", &models.TemplateData{Form: form})
+		return
+	}
+
+	email := strings.TrimSpace(r.Form.Get("email"))
+	reservations, err := m.DB.GetReservationsByEmail(email)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// The same message is shown whether or not the email has reservations, so the form can't be used to find out
+	// who is staying at the property.
+	if len(reservations) > 0 {
+		token, err := helpers.GenerateToken()
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		err = m.DB.InsertGuestLoginToken(email, helpers.HashToken(token), time.Now().Add(guestLoginLinkLifetime))
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		scheme := "http"
+		if m.App.InProduction {
+			scheme = "https"
+		}
+		link := fmt.Sprintf("%s://%s/my/login/%s", scheme, r.Host, token)
+		htmlMessage := fmt.Sprintf(`
+		<strong> Manage your booking </strong><br>
+		Dear %s: <br>
+		Use the following link to see and manage your reservations. It can only be used once and expires in %d minutes.<br>
+		<a href="%s">%s</a>
+`, reservations[0].FirstName, int(guestLoginLinkLifetime.Minutes()), link, link)
+
+		m.App.Mailchan <- models.MailData{
+			To:      email,
+			From:    "me@here.com",
+			Subject: "Your login link",
+			Content: htmlMessage,
 		}
 	}
 
-	m.App.Session.Put(request.Context(), "flash", "Changes saved")
-	http.Redirect(writer, request, fmt.Sprintf("/admin/reservations-calendar?y=%d&m=%d", year, month), http.StatusSeeOther)
+	m.App.Session.Put(r.Context(), "flash", "If we have bookings for that email, a login link is on its way.")
+	http.Redirect(w, r, "/my/login", http.StatusSeeOther)
+}
+
+// GuestLoginLink logs a guest in with the one-time link they received by email.
+func (m *Repository) GuestLoginLink(w http.ResponseWriter, r *http.Request) {
+	email, err := m.DB.ConsumeGuestLoginToken(helpers.HashToken(chi.URLParam(r, "token")))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "This login link is invalid or has expired. Please request a new one.")
+		http.Redirect(w, r, "/my/login", http.StatusSeeOther)
+		return
+	}
+
+	// Prevents session fixation attack.
+	_ = m.App.Session.RenewToken(r.Context())
+	m.App.Session.Put(r.Context(), "guest_email", email)
+	http.Redirect(w, r, "/my/reservations", http.StatusSeeOther)
+}
+
+// GuestLogout logs the guest out of the self-service portal.
+func (m *Repository) GuestLogout(w http.ResponseWriter, r *http.Request) {
+	m.App.Session.Remove(r.Context(), "guest_email")
+	_ = m.App.Session.RenewToken(r.Context())
+	m.App.Session.Put(r.Context(), "flash", "Logged out successfully")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// GuestReservations lists the reservations of the logged-in guest.
+func (m *Repository) GuestReservations(w http.ResponseWriter, r *http.Request) {
+	email := m.App.Session.GetString(r.Context(), "guest_email")
+	if email == "" {
+		m.App.Session.Put(r.Context(), "error", "Please enter your email to manage your booking.")
+		http.Redirect(w, r, "/my/login", http.StatusSeeOther)
+		return
+	}
+
+	reservations, err := m.DB.GetReservationsByEmail(email)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	data := map[string]interface{}{"reservations": reservations}
+	render.Template(w, r, "my-reservations.page.gohtml", &models.TemplateData{Data: data})
+}
+
+// GuestShowReservation shows a reservation of the logged-in guest, where they can update or cancel it.
+func (m *Repository) GuestShowReservation(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.guestReservation(w, r)
+	if !ok {
+		return
+	}
+	m.renderGuestReservation(w, r, res, forms.New(nil))
+}
+
+// GuestPostShowReservation updates the contact details of a reservation of the logged-in guest.
+func (m *Repository) GuestPostShowReservation(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.guestReservation(w, r)
+	if !ok {
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// The email is not editable, since it is what gives the guest access to the reservation.
+	res.FirstName = r.Form.Get("first_name")
+	res.LastName = r.Form.Get("last_name")
+	res.Phone = r.Form.Get("phone")
+
+	form := forms.New(r.PostForm)
+	form.Required("first_name", "last_name")
+	form.MinLength("first_name", 3)
+	if !form.Valid() {
+		m.renderGuestReservation(w, r, res, form)
+		return
+	}
+
+	err = m.DB.UpdateReservation(res)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.App.Session.Put(r.Context(), "flash", "Reservation Saved")
+	http.Redirect(w, r, fmt.Sprintf("/my/reservations/%s", res.ConfirmationCode), http.StatusSeeOther)
+}
+
+// GuestCancelReservation cancels a reservation of the logged-in guest, refunding it according to the cancellation
+// policy of the room.
+func (m *Repository) GuestCancelReservation(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.guestReservation(w, r)
+	if !ok {
+		return
+	}
+
+	if !canGuestCancel(res) {
+		m.App.Session.Put(r.Context(), "error",
+			"This reservation can no longer be cancelled online. Please contact us.")
+		http.Redirect(w, r, fmt.Sprintf("/my/reservations/%s", res.ConfirmationCode), http.StatusSeeOther)
+		return
+	}
+
+	_, amount, err := m.cancelReservation(res, 0)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Reservation cancelled, %s will be refunded",
+		render.FormatMoney(amount)))
+	http.Redirect(w, r, "/my/reservations", http.StatusSeeOther)
+}
+
+// renderGuestReservation renders the reservation page of the guest portal, with the cancellation policy of the room
+// and the refund the guest would get by cancelling now.
+func (m *Repository) renderGuestReservation(w http.ResponseWriter, r *http.Request, res models.Reservation,
+	form *forms.Form) {
+	policy, err := m.DB.GetCancellationPolicyForRoom(res.RoomID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := map[string]interface{}{
+		"reservation": res,
+		"policy":      policy,
+		"can_cancel":  canGuestCancel(res),
+	}
+	intMap := map[string]int{"refund_percent": policy.RefundPercent(res.StartDate, time.Now())}
+	render.Template(w, r, "my-reservation.page.gohtml", &models.TemplateData{Data: data, IntMap: intMap, Form: form})
+}
+
+// guestReservation gets the reservation whose confirmation code is in the URL, making sure it belongs to the logged-in guest. If it doesn't, the
+// guest is redirected and ok is false.
+func (m *Repository) guestReservation(w http.ResponseWriter, r *http.Request) (models.Reservation, bool) {
+	email := m.App.Session.GetString(r.Context(), "guest_email")
+	if email == "" {
+		m.App.Session.Put(r.Context(), "error", "Please enter your email to manage your booking.")
+		http.Redirect(w, r, "/my/login", http.StatusSeeOther)
+		return models.Reservation{}, false
+	}
+
+	// Reservations of other guests are reported as not found, so they can't be discovered by trying codes.
+	res, err := m.DB.GetReservationByCode(helpers.NormalizeConfirmationCode(chi.URLParam(r, "code")))
+	if err != nil || !strings.EqualFold(res.Email, email) {
+		m.App.Session.Put(r.Context(), "error", "Reservation not found")
+		http.Redirect(w, r, "/my/reservations", http.StatusSeeOther)
+		return models.Reservation{}, false
+	}
+	return res, true
+}
+
+// GuestReservationInvoice shows the invoice of a reservation of the guest.
+func (m *Repository) GuestReservationInvoice(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.guestReservation(w, r)
+	if !ok {
+		return
+	}
+	renderInvoice(w, r, res)
+}
+
+// AdminReservationInvoice shows the invoice of a reservation.
+func (m *Repository) AdminReservationInvoice(writer http.ResponseWriter, request *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(request, "id"))
+
+	res, err := m.DB.GetReservationByID(id)
+	if err != nil {
+		helpers.ServerError(writer, err)
+		return
+	}
+	renderInvoice(writer, request, res)
+}
+
+// renderInvoice renders the invoice of a reservation, itemized as it was priced when it was booked.
+func renderInvoice(w http.ResponseWriter, r *http.Request, res models.Reservation) {
+	render.Template(w, r, "invoice.page.gohtml", &models.TemplateData{
+		Data: map[string]interface{}{"reservation": res},
+	})
+}
 
+// canGuestCancel returns true if the reservation can still be cancelled by the guest without contacting the owner,
+// which is until the day before the arrival.
+func canGuestCancel(res models.Reservation) bool {
+	return res.Status.CanTransitionTo(models.StatusCancelled) && models.DaysBetween(time.Now(), res.StartDate) > 0
 }
 
 // parseDateFromForm converts a date extracted from an html form to a Go friendly format (usually used to query).
@@ -1,15 +1,23 @@
 package handlers
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"github.com/go-chi/chi/v5"
 	"github.com/nambroa/lodging-bookings/internal/models"
+	"github.com/nambroa/lodging-bookings/internal/storage"
+	"image"
+	"image/png"
 	"log"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -27,10 +35,13 @@ var theTests = []struct {
 }{
 	{"home", "/", "GET", http.StatusOK},
 	{"about", "/about", "GET", http.StatusOK},
-	{"gq", "/generals-quarters", "GET", http.StatusOK},
-	{"ms", "/majors-suite", "GET", http.StatusOK},
+	{"rooms", "/rooms", "GET", http.StatusOK},
+	{"gq", "/rooms/generals-quarters", "GET", http.StatusOK},
+	{"ms", "/rooms/majors-suite", "GET", http.StatusOK},
+	{"unknown-room", "/rooms/presidential-suite", "GET", http.StatusNotFound},
 	{"sa", "/search-availability", "GET", http.StatusOK},
 	{"contact", "/contact", "GET", http.StatusOK},
+	{"my-login", "/my/login", "GET", http.StatusOK},
 }
 
 func TestHandlers(t *testing.T) {
@@ -57,9 +68,12 @@ func TestHandlers(t *testing.T) {
 }
 
 func TestRepository_Reservation_WithReservationInSession(t *testing.T) {
+	// A stay from a Friday to a Sunday: two weekend nights at $120, plus 10% of VAT and a $50 cleaning fee.
 	reservation := models.Reservation{
-		RoomID: 1,
-		Room:   models.Room{ID: 1, RoomName: "General's Quarters"},
+		RoomID:    1,
+		Room:      models.Room{ID: 1, RoomName: "General's Quarters"},
+		StartDate: time.Date(2050, time.January, 7, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2050, time.January, 9, 0, 0, 0, 0, time.UTC),
 	}
 
 	req, _ := http.NewRequest("GET", "/make-reservation", nil)
@@ -76,6 +90,15 @@ func TestRepository_Reservation_WithReservationInSession(t *testing.T) {
 	if rr.Code != http.StatusOK {
 		t.Errorf("Reservation handler returned wrong response code. Got %d, wanted %d", rr.Code, http.StatusOK)
 	}
+	for _, amount := range []string{"$240.00", "$24.00", "$50.00", "$314.00"} {
+		if !strings.Contains(rr.Body.String(), amount) {
+			t.Errorf("Reservation handler didn't show %s in the price of the stay", amount)
+		}
+	}
+	quote := session.Get(ctx, "reservation").(models.Reservation).Quote
+	if quote.Total != 31400 || len(quote.Nights) != 2 || len(quote.LineItems) != 2 {
+		t.Errorf("Reservation handler stored the wrong quote in the session: %+v", quote)
+	}
 }
 
 func TestRepository_Reservation_WithoutReservationInSession(t *testing.T) {
@@ -116,6 +139,8 @@ func TestRepository_PostReservation_ValidReservation(t *testing.T) {
 		Room:      models.Room{ID: 1, RoomName: "General's Quarters"},
 		StartDate: time.Now(),
 		EndDate:   time.Now(),
+		Adults:    1,
+		Quote:     models.Quote{Guests: 1},
 	}
 
 	postedData := url.Values{}
@@ -140,6 +165,182 @@ func TestRepository_PostReservation_ValidReservation(t *testing.T) {
 	}
 }
 
+func TestRepository_PostReservation_HoldGone(t *testing.T) {
+	counter, ok := Repo.DB.(interface{ ReservationsInserted() int })
+	if !ok {
+		t.Fatal("test repo does not count inserted reservations")
+	}
+
+	// Hold 3 is extended when the form is posted, but swept before the reservation is saved.
+	var tests = []struct {
+		name             string
+		roomID           int
+		roomName         string
+		expectedLocation string
+		expectedInserted int
+	}{
+		{"unit-still-free", 1, "General's Quarters", "/reservation-summary", 1},
+		{"room-booked-meanwhile", 2, "Major's Suite", "/search-availability", 0},
+	}
+
+	for _, test := range tests {
+		reservation := models.Reservation{
+			RoomID:    test.roomID,
+			Room:      models.Room{ID: test.roomID, RoomName: test.roomName},
+			StartDate: time.Now(),
+			EndDate:   time.Now(),
+			Adults:    1,
+			Quote:     models.Quote{RoomID: test.roomID, Guests: 1},
+		}
+		postedData := url.Values{"first_name": {"John"}, "last_name": {"Smith"}, "email": {"john@smith.com"},
+			"phone": {"123456789"}}
+
+		insertedBefore := counter.ReservationsInserted()
+		req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		session.Put(ctx, "reservation", reservation)
+		session.Put(ctx, "hold_id", 3)
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.PostReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != test.expectedLocation {
+			t.Errorf("For %s, expected a redirect to %s, got code %d and location %q", test.name,
+				test.expectedLocation, rr.Code, rr.Header().Get("Location"))
+		}
+		if inserted := counter.ReservationsInserted() - insertedBefore; inserted != test.expectedInserted {
+			t.Errorf("For %s, expected %d reservations to be inserted, got %d", test.name, test.expectedInserted,
+				inserted)
+		}
+		if session.Exists(ctx, "hold_id") {
+			t.Errorf("For %s, expected the hold to be taken out of the session", test.name)
+		}
+	}
+}
+
+func TestRepository_PostReservation_ConcurrentDuplicates(t *testing.T) {
+	counter, ok := Repo.DB.(interface{ ReservationsInserted() int })
+	if !ok {
+		t.Fatal("test repo does not count inserted reservations")
+	}
+	insertedBefore := counter.ReservationsInserted()
+
+	reservation := models.Reservation{
+		RoomID:    1,
+		Room:      models.Room{ID: 1, RoomName: "General's Quarters"},
+		StartDate: time.Now(),
+		EndDate:   time.Now(),
+		Adults:    1,
+		Quote:     models.Quote{Guests: 1},
+	}
+	postedData := url.Values{}
+	postedData.Add("first_name", "John")
+	postedData.Add("last_name", "Smith")
+	postedData.Add("email", "john@smith.com")
+	postedData.Add("phone", "123456789")
+
+	// Fire the same submission several times at once, as a double click or an impatient API client would.
+	const duplicates = 10
+	var wg sync.WaitGroup
+	recorders := make([]*httptest.ResponseRecorder, duplicates)
+	for i := 0; i < duplicates; i++ {
+		req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		session.Put(ctx, "reservation", reservation)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Idempotency-Key", "concurrent-duplicates-key")
+		recorders[i] = httptest.NewRecorder()
+
+		wg.Add(1)
+		go func(rr *httptest.ResponseRecorder, req *http.Request) {
+			defer wg.Done()
+			http.HandlerFunc(Repo.PostReservation).ServeHTTP(rr, req)
+		}(recorders[i], req)
+	}
+	wg.Wait()
+
+	for i, rr := range recorders {
+		if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/reservation-summary" {
+			t.Errorf("Submission %d got code %d and location %q, wanted the reservation summary", i, rr.Code,
+				rr.Header().Get("Location"))
+		}
+	}
+	if inserted := counter.ReservationsInserted() - insertedBefore; inserted != 1 {
+		t.Errorf("Expected duplicate submissions to insert 1 reservation, but %d were inserted", inserted)
+	}
+}
+
+func TestRepository_PostReservation_IdempotencyKeyReusedForAnotherReservation(t *testing.T) {
+	reservation := models.Reservation{RoomID: 1, StartDate: time.Now(), EndDate: time.Now(), Adults: 1,
+		Quote: models.Quote{Guests: 1}}
+
+	for i, email := range []string{"john@smith.com", "jane@smith.com"} {
+		postedData := url.Values{}
+		postedData.Add("first_name", "John")
+		postedData.Add("last_name", "Smith")
+		postedData.Add("email", email)
+		postedData.Add("idempotency_key", "reused-key")
+
+		req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		session.Put(ctx, "reservation", reservation)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.PostReservation).ServeHTTP(rr, req)
+
+		expected := http.StatusSeeOther
+		if i == 1 {
+			expected = http.StatusUnprocessableEntity
+		}
+		if rr.Code != expected {
+			t.Errorf("Submission with email %s got code %d, wanted %d", email, rr.Code, expected)
+		}
+	}
+}
+
+func TestRepository_PostReservation_IdempotencyKeyScopedToSession(t *testing.T) {
+	counter, ok := Repo.DB.(interface{ ReservationsInserted() int })
+	if !ok {
+		t.Fatal("test repo does not count inserted reservations")
+	}
+	insertedBefore := counter.ReservationsInserted()
+
+	reservation := models.Reservation{RoomID: 1, StartDate: time.Now(), EndDate: time.Now(), Adults: 1,
+		Quote: models.Quote{Guests: 1}}
+	postedData := url.Values{}
+	postedData.Add("first_name", "John")
+	postedData.Add("last_name", "Smith")
+	postedData.Add("email", "john@smith.com")
+	postedData.Add("idempotency_key", "scoped-key")
+
+	// Two guests happen to send the same key, each from their own session.
+	for i := 0; i < 2; i++ {
+		token, _, err := session.Commit(getCtx(httptest.NewRequest("GET", "/", nil)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(postedData.Encode()))
+		req.Header.Set("X-Session", token)
+		ctx := getCtx(req)
+		session.Put(ctx, "reservation", reservation)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.PostReservation).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("Submission %d got code %d, wanted %d", i, rr.Code, http.StatusSeeOther)
+		}
+	}
+	if inserted := counter.ReservationsInserted() - insertedBefore; inserted != 2 {
+		t.Errorf("Expected a reservation for each session, but %d were inserted", inserted)
+	}
+}
+
 func TestRepository_PostReservation_WithNoReservationInSession(t *testing.T) {
 	postedData := url.Values{}
 	postedData.Add("first_name", "John")
@@ -181,6 +382,30 @@ func TestRepository_PostReservation_WithInvalidFormFormat(t *testing.T) {
 	}
 }
 
+func TestRepository_PostReservation_IgnoresPostedRoom(t *testing.T) {
+	reservation := models.Reservation{RoomID: 1, StartDate: time.Now(), EndDate: time.Now(), Adults: 1,
+		Quote: models.Quote{Guests: 1}}
+	postedData := url.Values{"first_name": {"John"}, "last_name": {"Smith"}, "email": {"john@smith.com"},
+		"room_id": {"1321"}}
+
+	req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(postedData.Encode()))
+	ctx := getCtx(req)
+	session.Put(ctx, "reservation", reservation)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.PostReservation).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/reservation-summary" {
+		t.Errorf("Expected the room of the reservation to be booked, got code %d and location %q", rr.Code,
+			rr.Header().Get("Location"))
+	}
+	booked, _ := session.Get(ctx, "reservation").(models.Reservation)
+	if booked.Room.ID != 1 {
+		t.Errorf("Expected the General's Quarters to be booked, got room %d", booked.Room.ID)
+	}
+}
+
 func TestRepository_PostReservation_WithInvalidRoomID(t *testing.T) {
 	reservation := models.Reservation{
 		RoomID:    1321,
@@ -239,11 +464,2657 @@ func TestRepository_AvailabilityJSON_InvalidRoom(t *testing.T) {
 
 }
 
-// getCtx gets the context from the session in the request.
-func getCtx(req *http.Request) context.Context {
-	ctx, err := session.Load(req.Context(), req.Header.Get("X-Session"))
+func TestRepository_AvailabilityJSON_StayRule(t *testing.T) {
+	// Room 1 has a 3-night minimum on weekends, January 7, 2050 is a Friday.
+	reqBody := "start=2050-01-07&end=2050-01-09&room_id=1"
+
+	req, _ := http.NewRequest("POST", "/search-availability-json", strings.NewReader(reqBody))
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	handler := http.HandlerFunc(Repo.AvailabilityJSON)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	var j jsonResponse
+	err := json.Unmarshal([]byte(rr.Body.String()), &j)
 	if err != nil {
-		log.Println(err)
+		t.Error("failed to parse json")
+	}
+
+	if j.OK || j.Message != "3-night minimum on weekends" {
+		t.Error("AvailabilityJSON didn't explain the stay rule broken by the dates. Response: ", j)
+	}
+}
+
+func TestRepository_PostAvailability_BookingWindow(t *testing.T) {
+	app.BookingWindow = models.BookingWindow{MaxAdvanceDays: 365}
+	defer func() { app.BookingWindow = models.BookingWindow{} }()
+
+	day := func(days int) string { return time.Now().AddDate(0, 0, days).Format("2006-01-02") }
+
+	var tests = []struct {
+		name          string
+		start         string
+		end           string
+		expectedCode  int
+		expectedError string
+	}{
+		{"within-the-window", day(10), day(12), http.StatusSeeOther, ""},
+		{"in-the-past", day(-2), day(1), http.StatusOK, "The arrival can&#39;t be in the past"},
+		{"too-far-ahead", day(400), day(402), http.StatusOK, "at most 365 days before the arrival"},
+		{"departure-before-arrival", day(12), day(10), http.StatusOK, "The departure must be after the arrival"},
+		{"invalid-date", "tomorrow", day(2), http.StatusOK, "Invalid date"},
+		{"missing-departure", day(10), "", http.StatusOK, "This field cannot be blank"},
+	}
+
+	for _, test := range tests {
+		postedData := url.Values{"start": {test.start}, "end": {test.end}}
+		req, _ := http.NewRequest("POST", "/search-availability", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.PostAvailability)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != test.expectedCode {
+			t.Errorf("For %s, expected code %d but got %d", test.name, test.expectedCode, rr.Code)
+		}
+		if test.expectedError != "" && !strings.Contains(rr.Body.String(), test.expectedError) {
+			t.Errorf("For %s, expected the form to show %q", test.name, test.expectedError)
+		}
+	}
+}
+
+func TestRepository_AvailabilityJSON_BookingWindow(t *testing.T) {
+	yesterday := time.Now().AddDate(0, 0, -1).Format("2006-01-02")
+	tomorrow := time.Now().AddDate(0, 0, 1).Format("2006-01-02")
+	reqBody := "start=" + yesterday + "&end=" + tomorrow + "&room_id=2"
+
+	req, _ := http.NewRequest("POST", "/search-availability-json", strings.NewReader(reqBody))
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	handler := http.HandlerFunc(Repo.AvailabilityJSON)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	var j jsonResponse
+	err := json.Unmarshal([]byte(rr.Body.String()), &j)
+	if err != nil {
+		t.Error("failed to parse json")
+	}
+
+	if j.OK || j.Message != "The arrival can't be in the past" {
+		t.Error("AvailabilityJSON didn't reject an arrival in the past. Response: ", j)
+	}
+}
+
+func TestRepository_BookRoom_HoldsRoom(t *testing.T) {
+	var tests = []struct {
+		name             string
+		roomID           string
+		expectedLocation string
+	}{
+		{"free-room", "1", "/make-reservation"},
+		{"room-just-taken", "2", "/search-availability"},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", "/book-room?s=2050-01-01&e=2050-01-02&id="+test.roomID, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.BookRoom)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Header().Get("Location") != test.expectedLocation {
+			t.Errorf("For %s, expected redirect to %s but got %s", test.name, test.expectedLocation,
+				rr.Header().Get("Location"))
+		}
+		if test.expectedLocation == "/make-reservation" && session.GetInt(ctx, "hold_id") == 0 {
+			t.Errorf("For %s, expected the room hold to be stored in the session", test.name)
+		}
+	}
+}
+
+func TestRepository_BookRoom_LastUnit(t *testing.T) {
+	// Room 1 has two units in use, so it can be held by two overlapping stays at most.
+	var tests = []struct {
+		name             string
+		start            string
+		end              string
+		expectedLocation string
+	}{
+		{"first-unit", "2052-03-01", "2052-03-04", "/make-reservation"},
+		{"last-unit", "2052-03-02", "2052-03-05", "/make-reservation"},
+		{"no-unit-left", "2052-03-03", "2052-03-04", "/search-availability"},
+		{"arriving-as-the-first-leaves", "2052-03-04", "2052-03-06", "/make-reservation"},
+		{"leaving-as-the-first-arrives", "2052-02-27", "2052-03-01", "/make-reservation"},
+		{"no-unit-left-after-that", "2052-03-04", "2052-03-05", "/search-availability"},
+	}
+
+	var holdIDs []int
+	defer func() {
+		for _, id := range holdIDs {
+			_ = Repo.DB.ReleaseHold(id)
+		}
+	}()
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", fmt.Sprintf("/book-room?s=%s&e=%s&id=1", test.start, test.end), nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.BookRoom)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Header().Get("Location") != test.expectedLocation {
+			t.Errorf("For %s, expected redirect to %s but got %s", test.name, test.expectedLocation,
+				rr.Header().Get("Location"))
+		}
+		if holdID := session.GetInt(ctx, "hold_id"); holdID != 0 {
+			holdIDs = append(holdIDs, holdID)
+		}
+	}
+
+	// A guest giving up on their stay frees its unit for the next one.
+	_ = Repo.DB.ReleaseHold(holdIDs[0])
+	req, _ := http.NewRequest("GET", "/book-room?s=2052-03-03&e=2052-03-04&id=1", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.BookRoom).ServeHTTP(rr, req)
+	if rr.Header().Get("Location") != "/make-reservation" {
+		t.Errorf("Expected the released unit to be held again, got redirect to %s", rr.Header().Get("Location"))
+	}
+	holdIDs = append(holdIDs, session.GetInt(ctx, "hold_id"))
+}
+
+func TestRepository_BookRoom_TooManyGuests(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/book-room?s=2050-01-01&e=2050-01-02&id=1&a=2&c=1", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(Repo.BookRoom)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Header().Get("Location") != "/rooms/generals-quarters" {
+		t.Errorf("Expected to be sent back to the room page, got %s", rr.Header().Get("Location"))
+	}
+}
+
+func TestRepository_BookRoom_InvalidDates(t *testing.T) {
+	app.BookingWindow = models.BookingWindow{MaxAdvanceDays: 365}
+	defer func() { app.BookingWindow = models.BookingWindow{} }()
+
+	day := func(days int) string { return time.Now().AddDate(0, 0, days).Format("2006-01-02") }
+
+	var tests = []struct {
+		name          string
+		start         string
+		end           string
+		expectedError string
+	}{
+		{"in-the-past", day(-2), day(1), "The arrival can't be in the past"},
+		{"too-far-ahead", day(400), day(402), "at most 365 days before the arrival"},
+		{"departure-before-arrival", day(12), day(10), "The departure must be after the arrival"},
+		{"invalid-date", "tomorrow", day(2), "Invalid date"},
+		{"missing-departure", day(10), "", "This field cannot be blank"},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", fmt.Sprintf("/book-room?s=%s&e=%s&id=1", test.start, test.end), nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.BookRoom)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Header().Get("Location") != "/rooms/generals-quarters" {
+			t.Errorf("For %s, expected to be sent back to the room page, got %s", test.name,
+				rr.Header().Get("Location"))
+		}
+		if !strings.Contains(session.GetString(ctx, "error"), test.expectedError) {
+			t.Errorf("For %s, expected the error %q, got %q", test.name, test.expectedError,
+				session.GetString(ctx, "error"))
+		}
+		if session.Exists(ctx, "hold_id") {
+			t.Errorf("For %s, expected no room to be held", test.name)
+		}
+	}
+}
+
+func TestRepository_ChooseRoom(t *testing.T) {
+	app.BookingWindow = models.BookingWindow{MaxAdvanceDays: 365}
+	defer func() { app.BookingWindow = models.BookingWindow{} }()
+
+	day := func(days int) time.Time { return time.Now().AddDate(0, 0, days) }
+
+	var tests = []struct {
+		name             string
+		start            time.Time
+		end              time.Time
+		expectedLocation string
+	}{
+		{"within-the-window", day(10), day(12), "/make-reservation"},
+		{"arrival-passed-since-the-search", day(-1), day(1), "/search-availability"},
+		{"departure-before-arrival", day(12), day(10), "/search-availability"},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", "/choose-room/1", nil)
+		ctx := getCtx(req)
+		req = req.WithContext(withURLParams(ctx, map[string]string{"id": "1"}))
+		session.Put(ctx, "reservation", models.Reservation{StartDate: test.start, EndDate: test.end})
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.ChooseRoom)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Header().Get("Location") != test.expectedLocation {
+			t.Errorf("For %s, expected redirect to %s but got %s", test.name, test.expectedLocation,
+				rr.Header().Get("Location"))
+		}
+		expectedHeld := test.expectedLocation == "/make-reservation"
+		if session.Exists(ctx, "hold_id") != expectedHeld {
+			t.Errorf("For %s, expected the room to be held: %t", test.name, expectedHeld)
+		}
+		_ = Repo.DB.ReleaseHold(session.GetInt(ctx, "hold_id"))
+	}
+}
+
+func TestRepository_BookRoom_StayRule(t *testing.T) {
+	// Room 1 has a 3-night minimum on weekends, January 7, 2050 is a Friday.
+	req, _ := http.NewRequest("GET", "/book-room?s=2050-01-07&e=2050-01-09&id=1", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(Repo.BookRoom)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Header().Get("Location") != "/rooms/generals-quarters" {
+		t.Errorf("Expected to be sent back to the room page, got %s", rr.Header().Get("Location"))
+	}
+	if session.GetString(ctx, "error") != "3-night minimum on weekends" {
+		t.Errorf("Expected the broken stay rule to be explained, got %q", session.GetString(ctx, "error"))
+	}
+	if session.Exists(ctx, "hold_id") {
+		t.Error("Expected no room to be held for a stay breaking a stay rule")
+	}
+}
+
+func TestRepository_AvailabilityJSON_TooManyGuests(t *testing.T) {
+	reqBody := "start=2050-01-01&end=2050-01-02&room_id=1&adults=2&children=1"
+
+	req, _ := http.NewRequest("POST", "/search-availability-json", strings.NewReader(reqBody))
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	handler := http.HandlerFunc(Repo.AvailabilityJSON)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	var j jsonResponse
+	err := json.Unmarshal([]byte(rr.Body.String()), &j)
+	if err != nil {
+		t.Error("failed to parse json")
+	}
+
+	if j.OK || j.Message != "The General's Quarters sleeps at most 2 guests" {
+		t.Error("AvailabilityJSON didn't reject a stay for more guests than the room sleeps. Response: ", j)
+	}
+}
+
+func TestRepository_AvailabilityJSON_Amenities(t *testing.T) {
+	var tests = []struct {
+		name            string
+		roomID          string
+		amenities       []string
+		expectedMessage string
+	}{
+		{"has-amenities", "1", []string{"1", "2"}, ""},
+		{"missing-amenity", "1", []string{"2", "3"},
+			"The General's Quarters doesn't have all the amenities you are looking for"},
+		{"no-amenities", "2", []string{"1"}, "The Major's Suite doesn't have all the amenities you are looking for"},
+		{"invalid-amenity", "1", []string{"balcony"}, "This field must only have whole numbers"},
+	}
+
+	for _, test := range tests {
+		postedData := url.Values{"start": {"2050-01-03"}, "end": {"2050-01-04"}, "room_id": {test.roomID},
+			"amenities": test.amenities}
+		req, _ := http.NewRequest("POST", "/search-availability-json", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		handler := http.HandlerFunc(Repo.AvailabilityJSON)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		var j jsonResponse
+		err := json.Unmarshal([]byte(rr.Body.String()), &j)
+		if err != nil {
+			t.Errorf("For %s, failed to parse json", test.name)
+		}
+		if j.Message != test.expectedMessage {
+			t.Errorf("For %s, expected message %q but got %q", test.name, test.expectedMessage, j.Message)
+		}
+	}
+}
+
+func TestRepository_PostAvailability_InvalidAmenities(t *testing.T) {
+	postedData := url.Values{"start": {"2050-01-01"}, "end": {"2050-01-02"}, "amenities": {"balcony"}}
+	req, _ := http.NewRequest("POST", "/search-availability", strings.NewReader(postedData.Encode()))
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	handler := http.HandlerFunc(Repo.PostAvailability)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "This field must only have whole numbers") {
+		t.Errorf("PostAvailability didn't show the form again for invalid amenities, got code %d", rr.Code)
+	}
+}
+
+func TestRepository_PostReservation_Guests(t *testing.T) {
+	var tests = []struct {
+		name               string
+		adults             string
+		children           string
+		expectedStatusCode int
+		expectedGuests     int
+	}{
+		{"same-guests", "1", "0", http.StatusSeeOther, 1},
+		{"more-guests", "1", "1", http.StatusSeeOther, 2},
+		{"too-many-guests", "2", "1", http.StatusOK, 0},
+		{"no-adults", "0", "2", http.StatusOK, 0},
+	}
+
+	for _, test := range tests {
+		reservation := models.Reservation{
+			RoomID:    1,
+			StartDate: time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
+			EndDate:   time.Date(2050, 1, 4, 0, 0, 0, 0, time.UTC),
+			Adults:    1,
+			Quote:     models.Quote{Guests: 1},
+		}
+
+		postedData := url.Values{}
+		postedData.Add("first_name", "John")
+		postedData.Add("last_name", "Smith")
+		postedData.Add("email", "john@smith.com")
+		postedData.Add("room_id", "1")
+		postedData.Add("adults", test.adults)
+		postedData.Add("children", test.children)
+
+		req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		session.Put(ctx, "reservation", reservation)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.PostReservation).ServeHTTP(rr, req)
+
+		if rr.Code != test.expectedStatusCode {
+			t.Errorf("For %s, expected code %d but got %d", test.name, test.expectedStatusCode, rr.Code)
+		}
+		if test.expectedGuests == 0 {
+			continue
+		}
+		// Per guest taxes and fees depend on the guest count, so the stay is quoted for the guests booking it.
+		booked, _ := session.Get(ctx, "reservation").(models.Reservation)
+		if booked.Guests() != test.expectedGuests || booked.Quote.Guests != test.expectedGuests {
+			t.Errorf("For %s, expected the stay to be booked and quoted for %d guests, got %d and %d", test.name,
+				test.expectedGuests, booked.Guests(), booked.Quote.Guests)
+		}
+	}
+}
+
+func TestRepository_PostReservation_Extras(t *testing.T) {
+	var tests = []struct {
+		name           string
+		extras         url.Values
+		expectedCode   int
+		expectedError  string
+		expectedExtras int
+	}{
+		// Breakfast for the 2 guests and a parking space, for 1 night.
+		{"breakfast-and-parking", url.Values{"extra_1": {"1"}, "extra_2": {"1"}}, http.StatusSeeOther, "", 4000},
+		{"no-extras", url.Values{"extra_2": {"0"}}, http.StatusSeeOther, "", 0},
+		{"sold-out", url.Values{"extra_3": {"1"}}, http.StatusOK, "Sold out for these dates", 0},
+		{"not-enough-left", url.Values{"extra_2": {"3"}}, http.StatusOK, "Only 2 left for these dates", 0},
+		{"invalid-quantity", url.Values{"extra_2": {"many"}}, http.StatusOK, "This field must be a whole number", 0},
+		{"sold-out-while-booking", url.Values{"extra_2": {"2"}}, http.StatusOK, "just sold out", 0},
+	}
+
+	for _, test := range tests {
+		reservation := models.Reservation{
+			RoomID:    1,
+			StartDate: time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
+			EndDate:   time.Date(2050, 1, 4, 0, 0, 0, 0, time.UTC),
+			Adults:    2,
+			Quote:     models.Quote{Guests: 2, Nights: []models.NightlyRate{{Rate: 10000}}, Subtotal: 10000},
+		}
+
+		postedData := url.Values{"first_name": {"John"}, "last_name": {"Smith"}, "email": {"john@smith.com"},
+			"room_id": {"1"}, "adults": {"2"}}
+		for key, values := range test.extras {
+			postedData[key] = values
+		}
+
+		req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		session.Put(ctx, "reservation", reservation)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.PostReservation).ServeHTTP(rr, req)
+
+		if rr.Code != test.expectedCode {
+			t.Errorf("For %s, expected code %d but got %d", test.name, test.expectedCode, rr.Code)
+		}
+		if test.expectedError != "" && !strings.Contains(rr.Body.String(), test.expectedError) {
+			t.Errorf("For %s, expected the form to show %q", test.name, test.expectedError)
+		}
+		if rr.Code != http.StatusSeeOther {
+			continue
+		}
+		booked, _ := session.Get(ctx, "reservation").(models.Reservation)
+		if booked.Quote.ExtrasTotal != test.expectedExtras ||
+			booked.Quote.Total != booked.Quote.Subtotal+test.expectedExtras {
+			t.Errorf("For %s, expected extras of %d in the total, got %d and a total of %d", test.name,
+				test.expectedExtras, booked.Quote.ExtrasTotal, booked.Quote.Total)
+		}
+	}
+}
+
+func TestRepository_AdminSessions(t *testing.T) {
+	// Not logged in, should be sent to the login page.
+	req, _ := http.NewRequest("GET", "/admin/sessions", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(Repo.AdminSessions)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("AdminSessions handler returned wrong response code. Got %d, wanted %d", rr.Code, http.StatusSeeOther)
+	}
+
+	// Logged in, should list the sessions.
+	req, _ = http.NewRequest("GET", "/admin/sessions", nil)
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	session.Put(ctx, "user_id", 1)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("AdminSessions handler returned wrong response code. Got %d, wanted %d", rr.Code, http.StatusOK)
+	}
+}
+
+func TestRepository_AdminRevokeSession(t *testing.T) {
+	postedData := url.Values{}
+	postedData.Add("session", "d41d8cd98f00b204e9800998ecf8427e")
+
+	req, _ := http.NewRequest("POST", "/admin/sessions/revoke", strings.NewReader(postedData.Encode()))
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	session.Put(ctx, "user_id", 1)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(Repo.AdminRevokeSession)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("AdminRevokeSession handler returned wrong response code. Got %d, wanted %d", rr.Code, http.StatusSeeOther)
+	}
+	if rr.Header().Get("Location") != "/admin/sessions" {
+		t.Errorf("AdminRevokeSession redirected to %s, wanted /admin/sessions", rr.Header().Get("Location"))
+	}
+}
+
+func TestRepository_GuestLoginLink(t *testing.T) {
+	var tests = []struct {
+		name             string
+		token            string
+		expectedLocation string
+	}{
+		{"valid-token", "valid", "/my/reservations"},
+		{"invalid-token", "invalid", "/my/login"},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", "/my/login/"+test.token, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(withURLParams(ctx, map[string]string{"token": test.token}))
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.GuestLoginLink)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("For %s, expected %d but got %d", test.name, http.StatusSeeOther, rr.Code)
+		}
+		if rr.Header().Get("Location") != test.expectedLocation {
+			t.Errorf("For %s, expected redirect to %s but got %s", test.name, test.expectedLocation,
+				rr.Header().Get("Location"))
+		}
+	}
+}
+
+func TestRepository_GuestShowReservation(t *testing.T) {
+	var tests = []struct {
+		name               string
+		guestEmail         string
+		code               string
+		expectedStatusCode int
+		expectedLocation   string
+	}{
+		{"not-logged-in", "", "LB-7K3Q9X", http.StatusSeeOther, "/my/login"},
+		{"own-reservation", "john@smith.com", "LB-7K3Q9X", http.StatusOK, ""},
+		{"own-reservation-typed-code", "john@smith.com", "7k3q9x", http.StatusOK, ""},
+		{"other-guest-reservation", "jane@smith.com", "LB-7K3Q9X", http.StatusSeeOther, "/my/reservations"},
+		{"non-existent-reservation", "john@smith.com", "LB-AAAAAA", http.StatusSeeOther, "/my/reservations"},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", "/my/reservations/"+test.code, nil)
+		ctx := getCtx(req)
+		if test.guestEmail != "" {
+			session.Put(ctx, "guest_email", test.guestEmail)
+		}
+		req = req.WithContext(withURLParams(ctx, map[string]string{"code": test.code}))
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.GuestShowReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != test.expectedStatusCode {
+			t.Errorf("For %s, expected %d but got %d", test.name, test.expectedStatusCode, rr.Code)
+		}
+		if rr.Header().Get("Location") != test.expectedLocation {
+			t.Errorf("For %s, expected redirect to %s but got %s", test.name, test.expectedLocation,
+				rr.Header().Get("Location"))
+		}
+	}
+}
+
+func TestRepository_GuestCancelReservation(t *testing.T) {
+	req, _ := http.NewRequest("POST", "/my/reservations/LB-7K3Q9X/cancel", nil)
+	ctx := getCtx(req)
+	session.Put(ctx, "guest_email", "john@smith.com")
+	req = req.WithContext(withURLParams(ctx, map[string]string{"code": "LB-7K3Q9X"}))
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(Repo.GuestCancelReservation)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Header().Get("Location") != "/my/reservations" {
+		t.Errorf("GuestCancelReservation redirected to %s, wanted /my/reservations", rr.Header().Get("Location"))
+	}
+	// The reservation arrives in more than a week, so all of its $250.00 is refunded.
+	if flash := session.PopString(ctx, "flash"); flash != "Reservation cancelled, $250.00 will be refunded" {
+		t.Errorf("Expected the flash to show the refunded amount, got %q", flash)
+	}
+}
+
+func TestRepository_AdminFindReservation(t *testing.T) {
+	var tests = []struct {
+		name             string
+		code             string
+		expectedLocation string
+	}{
+		{"existing-code", "LB-7K3Q9X", "/admin/reservations/all/1"},
+		{"existing-code-without-prefix", "7k3q9x", "/admin/reservations/all/1"},
+		{"non-existent-code", "LB-AAAAAA", "/admin/dashboard"},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", "/admin/reservations-find?code="+test.code, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminFindReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Header().Get("Location") != test.expectedLocation {
+			t.Errorf("For %s, expected redirect to %s but got %s", test.name, test.expectedLocation,
+				rr.Header().Get("Location"))
+		}
+	}
+}
+
+func TestRepository_AdminAudit(t *testing.T) {
+	var tests = []struct {
+		name  string
+		query string
+	}{
+		{"no-filters", ""},
+		{"all-filters", "?user_id=1&entity_type=reservation&entity_id=1&start=2050-01-01&end=2050-01-31"},
+		{"invalid-filters", "?user_id=abc&start=not-a-date"},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", "/admin/audit"+test.query, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminAudit)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Errorf("For %s, expected %d but got %d", test.name, http.StatusOK, rr.Code)
+		}
+	}
+}
+
+func TestRepository_AdminPostReservationStatus(t *testing.T) {
+	var tests = []struct {
+		name             string
+		status           string
+		expectedLocation string
+	}{
+		{"allowed-transition", "confirmed", "/admin/reservations-new"},
+		{"cancellation", "cancelled", "/admin/reservations-new"},
+		{"forbidden-transition", "checked-out", "/admin/reservations/new/1"},
+		{"unknown-status", "processed", "/admin/reservations/new/1"},
+	}
+
+	for _, test := range tests {
+		postedData := url.Values{}
+		postedData.Add("status", test.status)
+
+		req, _ := http.NewRequest("POST", "/admin/reservations/new/1/status", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(withURLParams(ctx, map[string]string{"src": "new", "id": "1"}))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminPostReservationStatus)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Header().Get("Location") != test.expectedLocation {
+			t.Errorf("For %s, expected redirect to %s but got %s", test.name, test.expectedLocation,
+				rr.Header().Get("Location"))
+		}
+	}
+}
+
+func TestRepository_AdminAllReservations_FilterByStatus(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/admin/reservations-all?status=confirmed", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(Repo.AdminAllReservations)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("AdminAllReservations handler returned wrong response code. Got %d, wanted %d", rr.Code, http.StatusOK)
+	}
+}
+
+func TestRepository_AdminPostCancellationPolicy(t *testing.T) {
+	var tests = []struct {
+		name             string
+		postedData       url.Values
+		expectedCode     int
+		expectedLocation string
+	}{
+		{"valid-policy", url.Values{"name": {"Semi-flexible"}, "days_1": {"3"}, "percent_1": {"100"}},
+			http.StatusSeeOther, "/admin/cancellation-policies"},
+		{"non-refundable-policy", url.Values{"name": {"Non-refundable"}},
+			http.StatusSeeOther, "/admin/cancellation-policies"},
+		{"missing-name", url.Values{"days_1": {"3"}, "percent_1": {"100"}}, http.StatusOK, ""},
+		{"percent-out-of-range", url.Values{"name": {"Generous"}, "days_1": {"3"}, "percent_1": {"150"}},
+			http.StatusOK, ""},
+		{"half-filled-tier", url.Values{"name": {"Partial"}, "days_2": {"3"}}, http.StatusOK, ""},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest("POST", "/admin/cancellation-policies", strings.NewReader(test.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminPostCancellationPolicy)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != test.expectedCode {
+			t.Errorf("For %s, expected code %d but got %d", test.name, test.expectedCode, rr.Code)
+		}
+		if rr.Header().Get("Location") != test.expectedLocation {
+			t.Errorf("For %s, expected redirect to %q but got %q", test.name, test.expectedLocation,
+				rr.Header().Get("Location"))
+		}
+	}
+}
+
+func TestRepository_AdminPostRoomRates(t *testing.T) {
+	valid := url.Values{"y": {"2050"}, "m": {"1"}, "rate_1": {"100"}, "weekday_1": {"0"}, "weekend_1": {"20"},
+		"rate_2": {"$150.50"}, "weekday_2": {"-10"}, "weekend_2": {"25"}}
+	invalidRate := url.Values{"rate_1": {"a lot"}, "weekday_1": {"0"}, "weekend_1": {"20"},
+		"rate_2": {"150"}, "weekday_2": {"0"}, "weekend_2": {"20"}}
+	missingRoom := url.Values{"rate_1": {"100"}, "weekday_1": {"0"}, "weekend_1": {"20"}}
+	discountTooBig := url.Values{"rate_1": {"100"}, "weekday_1": {"-150"}, "weekend_1": {"20"},
+		"rate_2": {"150"}, "weekday_2": {"0"}, "weekend_2": {"20"}}
+	withLimits := url.Values{"rate_1": {"100"}, "weekday_1": {"0"}, "weekend_1": {"20"}, "min_1": {"80"},
+		"max_1": {"200"}, "rate_2": {"150"}, "weekday_2": {"0"}, "weekend_2": {"20"}}
+	ceilingBelowFloor := url.Values{"rate_1": {"100"}, "weekday_1": {"0"}, "weekend_1": {"20"}, "min_1": {"80"},
+		"max_1": {"60"}, "rate_2": {"150"}, "weekday_2": {"0"}, "weekend_2": {"20"}}
+
+	var tests = []struct {
+		name             string
+		postedData       url.Values
+		expectedCode     int
+		expectedLocation string
+	}{
+		{"valid-rates", valid, http.StatusSeeOther, "/admin/rates?y=2050&m=1"},
+		{"invalid-rate", invalidRate, http.StatusOK, ""},
+		{"missing-room", missingRoom, http.StatusOK, ""},
+		{"discount-too-big", discountTooBig, http.StatusOK, ""},
+		{"floor-and-ceiling", withLimits, http.StatusSeeOther, ratesURLThisMonth()},
+		{"ceiling-below-floor", ceilingBelowFloor, http.StatusOK, ""},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest("POST", "/admin/rates/rooms", strings.NewReader(test.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminPostRoomRates)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != test.expectedCode {
+			t.Errorf("For %s, expected code %d but got %d", test.name, test.expectedCode, rr.Code)
+		}
+		if rr.Header().Get("Location") != test.expectedLocation {
+			t.Errorf("For %s, expected redirect to %q but got %q", test.name, test.expectedLocation,
+				rr.Header().Get("Location"))
+		}
+	}
+}
+
+func TestRepository_AdminRates(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/admin/rates?y=2050&m=1", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(Repo.AdminRates)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("AdminRates returned wrong response code. Got %d, wanted %d", rr.Code, http.StatusOK)
+	}
+	// Friday 2050-01-07 is a weekend night in the test rooms, at $120.
+	rates, ok := session.Get(ctx, "rate_map_1").(map[string]int)
+	if !ok || rates["2050-01-7"] != 12000 || rates["2050-01-6"] != 10000 {
+		t.Errorf("AdminRates didn't put the nightly prices of the month in the session: %v", rates)
+	}
+}
+
+func TestRepository_AdminPostRatesCalendar(t *testing.T) {
+	var tests = []struct {
+		name          string
+		price         string
+		expectedError bool
+	}{
+		{"changed-price", "150", false},
+		{"unchanged-price", "$120.00", false},
+		{"invalid-price", "a lot", true},
+	}
+
+	for _, test := range tests {
+		postedData := url.Values{"y": {"2050"}, "m": {"1"}, "price_1_2050-01-7": {test.price}}
+		req, _ := http.NewRequest("POST", "/admin/rates", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		session.Put(ctx, "rate_map_1", map[string]int{"2050-01-7": 12000})
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminPostRatesCalendar)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/admin/rates?y=2050&m=1" {
+			t.Errorf("For %s, expected redirect back to the calendar but got %d %q", test.name, rr.Code,
+				rr.Header().Get("Location"))
+		}
+		if session.Exists(ctx, "error") != test.expectedError {
+			t.Errorf("For %s, expected an error to be reported: %t", test.name, test.expectedError)
+		}
+	}
+}
+
+func TestRepository_AdminPostRateRule(t *testing.T) {
+	rule := func(changes url.Values) url.Values {
+		values := url.Values{"name": {"High season"}, "room_id": {"1"}, "priority": {"10"},
+			"rule_start_date": {"2050-06-01"}, "rule_end_date": {"2050-08-31"}, "adjustment": {"20"}}
+		for key, value := range changes {
+			values[key] = value
+		}
+		return values
+	}
+
+	var tests = []struct {
+		name         string
+		postedData   url.Values
+		expectedCode int
+	}{
+		{"adjustment", rule(nil), http.StatusSeeOther},
+		{"fixed-price", rule(url.Values{"adjustment": {""}, "rate": {"250"}}), http.StatusSeeOther},
+		{"no-price", rule(url.Values{"adjustment": {""}}), http.StatusOK},
+		{"last-night-before-first", rule(url.Values{"rule_end_date": {"2050-05-31"}}), http.StatusOK},
+		{"override-priority", rule(url.Values{"priority": {"1000"}}), http.StatusOK},
+		{"non-existent-room", rule(url.Values{"room_id": {"3"}}), http.StatusOK},
+		{"missing-name", rule(url.Values{"name": {""}}), http.StatusOK},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest("POST", "/admin/rates/rules", strings.NewReader(test.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminPostRateRule)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != test.expectedCode {
+			t.Errorf("For %s, expected code %d but got %d", test.name, test.expectedCode, rr.Code)
+		}
+	}
+}
+
+func TestRepository_AdminDeleteRateRule(t *testing.T) {
+	auditor, ok := Repo.DB.(interface{ AuditEvents() []models.AuditEvent })
+	if !ok {
+		t.Fatal("test repo does not keep audit events")
+	}
+
+	var tests = []struct {
+		name             string
+		url              string
+		id               string
+		expectedCode     int
+		expectedLocation string
+	}{
+		{"deleted", "/admin/rates/rules/1/delete?y=2050&m=6", "1", http.StatusSeeOther, "/admin/rates?y=2050&m=6"},
+		{"month-out-of-range", "/admin/rates/rules/1/delete?y=2050&m=13", "1", http.StatusSeeOther,
+			ratesURLThisMonth()},
+		{"year-out-of-range", "/admin/rates/rules/1/delete?y=-1&m=6", "1", http.StatusSeeOther, ratesURLThisMonth()},
+		{"non-existent", "/admin/rates/rules/99/delete", "99", http.StatusNotFound, ""},
+	}
+
+	for _, test := range tests {
+		eventsBefore := len(auditor.AuditEvents())
+		req, _ := http.NewRequest("POST", test.url, strings.NewReader(""))
+		ctx := getCtx(req)
+		req = req.WithContext(withURLParams(ctx, map[string]string{"id": test.id}))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminDeleteRateRule)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != test.expectedCode || rr.Header().Get("Location") != test.expectedLocation {
+			t.Errorf("For %s, expected code %d and location %q, got %d and %q", test.name, test.expectedCode,
+				test.expectedLocation, rr.Code, rr.Header().Get("Location"))
+		}
+		events := auditor.AuditEvents()[eventsBefore:]
+		deleted := test.expectedCode == http.StatusSeeOther
+		if deleted && (len(events) != 1 || !strings.Contains(events[0].Changes, `"Name":{"before":"High season"`)) {
+			t.Errorf("For %s, expected the deleted rule to be audited, got %v", test.name, events)
+		}
+		if !deleted && len(events) != 0 {
+			t.Errorf("For %s, expected nothing to be audited, got %v", test.name, events)
+		}
+	}
+}
+
+// ratesURLThisMonth returns the rate calendar of the current month, where forms posted without a month go back to.
+func ratesURLThisMonth() string {
+	now := time.Now()
+	return fmt.Sprintf("/admin/rates?y=%d&m=%d", now.Year(), now.Month())
+}
+
+func TestRepository_AdminPostPricingRule(t *testing.T) {
+	var tests = []struct {
+		name         string
+		postedData   url.Values
+		expectedCode int
+	}{
+		{"occupancy", url.Values{"name": {"Busy month"}, "kind": {"occupancy"}, "threshold": {"80"},
+			"adjustment": {"15"}}, http.StatusSeeOther},
+		{"lead-time", url.Values{"name": {"Last minute"}, "kind": {"lead_time"}, "threshold": {"3"},
+			"adjustment": {"-10"}}, http.StatusSeeOther},
+		{"occupancy-over-100", url.Values{"name": {"Overbooked"}, "kind": {"occupancy"}, "threshold": {"120"},
+			"adjustment": {"15"}}, http.StatusOK},
+		{"unknown-kind", url.Values{"name": {"Weather"}, "kind": {"rain"}, "threshold": {"1"},
+			"adjustment": {"15"}}, http.StatusOK},
+		{"missing-adjustment", url.Values{"name": {"Busy month"}, "kind": {"occupancy"}, "threshold": {"80"}},
+			http.StatusOK},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest("POST", "/admin/pricing", strings.NewReader(test.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminPostPricingRule)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != test.expectedCode {
+			t.Errorf("For %s, expected code %d but got %d", test.name, test.expectedCode, rr.Code)
+		}
+	}
+}
+
+func TestRepository_AdminDeletePricingRule(t *testing.T) {
+	auditor, ok := Repo.DB.(interface{ AuditEvents() []models.AuditEvent })
+	if !ok {
+		t.Fatal("test repo does not keep audit events")
+	}
+
+	var tests = []struct {
+		name         string
+		id           string
+		expectedCode int
+	}{
+		{"deleted", "1", http.StatusSeeOther},
+		{"non-existent", "99", http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		eventsBefore := len(auditor.AuditEvents())
+		req, _ := http.NewRequest("POST", "/admin/pricing/"+test.id+"/delete", nil)
+		ctx := getCtx(req)
+		req = req.WithContext(withURLParams(ctx, map[string]string{"id": test.id}))
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminDeletePricingRule)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != test.expectedCode {
+			t.Errorf("For %s, expected code %d but got %d", test.name, test.expectedCode, rr.Code)
+		}
+		events := auditor.AuditEvents()[eventsBefore:]
+		deleted := test.expectedCode == http.StatusSeeOther
+		if deleted && (len(events) != 1 || !strings.Contains(events[0].Changes, `"Name":{"before":"Last minute"`)) {
+			t.Errorf("For %s, expected the deleted rule to be audited, got %v", test.name, events)
+		}
+		if !deleted && len(events) != 0 {
+			t.Errorf("For %s, expected nothing to be audited, got %v", test.name, events)
+		}
+	}
+}
+
+func TestRepository_AdminPricingSimulation(t *testing.T) {
+	var tests = []struct {
+		name           string
+		query          string
+		expectedCode   int
+		expectedNights int
+	}{
+		{"default", "", http.StatusOK, 90},
+		{"one-week", "?days=7", http.StatusOK, 7},
+		{"no-days", "?days=0", http.StatusBadRequest, 0},
+		{"too-many-days", "?days=1000", http.StatusBadRequest, 0},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", "/admin/pricing/simulation"+test.query, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminPricingSimulation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != test.expectedCode {
+			t.Errorf("For %s, expected code %d but got %d", test.name, test.expectedCode, rr.Code)
+		}
+		var response simulationResponse
+		err := json.Unmarshal(rr.Body.Bytes(), &response)
+		if err != nil {
+			t.Errorf("For %s, failed to parse json: %v", test.name, err)
+			continue
+		}
+		if response.OK != (test.expectedCode == http.StatusOK) {
+			t.Errorf("For %s, expected ok to be %t", test.name, test.expectedCode == http.StatusOK)
+		}
+		for _, room := range response.Rooms {
+			if len(room.Nights) != test.expectedNights {
+				t.Errorf("For %s, expected %d nights for room %d, got %d", test.name, test.expectedNights,
+					room.RoomID, len(room.Nights))
+			}
+		}
+	}
+}
+
+func TestRepository_AdminPostStayRule(t *testing.T) {
+	stayRule := func(changes url.Values) url.Values {
+		values := url.Values{"room_id": {"1"}, "restriction_id": {"4"}, "nights": {"3"}, "days": {"5", "6"},
+			"start_date": {"2050-01-01"}, "end_date": {"2050-12-31"}}
+		for key, value := range changes {
+			values[key] = value
+		}
+		return values
+	}
+
+	var tests = []struct {
+		name         string
+		postedData   url.Values
+		expectedCode int
+	}{
+		{"weekend-minimum-stay", stayRule(nil), http.StatusSeeOther},
+		{"closed-to-arrival", stayRule(url.Values{"restriction_id": {"6"}, "nights": {""}}), http.StatusSeeOther},
+		{"every-day", stayRule(url.Values{"days": nil}), http.StatusSeeOther},
+		{"minimum-stay-without-nights", stayRule(url.Values{"nights": {""}}), http.StatusOK},
+		{"unknown-rule", stayRule(url.Values{"restriction_id": {"2"}}), http.StatusOK},
+		{"unknown-day", stayRule(url.Values{"days": {"7"}}), http.StatusOK},
+		{"ends-before-start", stayRule(url.Values{"start_date": {"2050-12-31"}, "end_date": {"2050-01-01"}}),
+			http.StatusOK},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest("POST", "/admin/stay-rules", strings.NewReader(test.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminPostStayRule)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != test.expectedCode {
+			t.Errorf("For %s, expected code %d but got %d", test.name, test.expectedCode, rr.Code)
+		}
+	}
+}
+
+func TestRepository_AdminDeleteStayRule(t *testing.T) {
+	auditor, ok := Repo.DB.(interface{ AuditEvents() []models.AuditEvent })
+	if !ok {
+		t.Fatal("test repo does not keep audit events")
+	}
+
+	var tests = []struct {
+		name         string
+		id           string
+		expectedCode int
+	}{
+		{"deleted", "1", http.StatusSeeOther},
+		{"non-existent", "99", http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		eventsBefore := len(auditor.AuditEvents())
+		req, _ := http.NewRequest("POST", "/admin/stay-rules/"+test.id+"/delete", nil)
+		ctx := getCtx(req)
+		req = req.WithContext(withURLParams(ctx, map[string]string{"id": test.id}))
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminDeleteStayRule)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != test.expectedCode {
+			t.Errorf("For %s, expected code %d but got %d", test.name, test.expectedCode, rr.Code)
+		}
+		events := auditor.AuditEvents()[eventsBefore:]
+		deleted := test.expectedCode == http.StatusSeeOther
+		if deleted && (len(events) != 1 || events[0].EntityType != "stay_rule" ||
+			!strings.Contains(events[0].Changes, `"Nights":{"before":3`)) {
+			t.Errorf("For %s, expected the deleted stay rule to be audited, got %v", test.name, events)
+		}
+		if !deleted && len(events) != 0 {
+			t.Errorf("For %s, expected nothing to be audited, got %v", test.name, events)
+		}
+	}
+}
+
+func TestRepository_AdminPostNewRoom(t *testing.T) {
+	newRoom := func(changes url.Values) url.Values {
+		values := url.Values{"room_name": {"Garden Suite"}, "description": {"Opens on the garden."},
+			"capacity": {"3"}, "beds": {"1 queen bed, 1 sofa bed"}, "size": {"35"}, "base_rate": {"140.00"}}
+		for key, value := range changes {
+			values[key] = value
+		}
+		return values
+	}
+
+	var tests = []struct {
+		name          string
+		postedData    url.Values
+		expectedCode  int
+		expectedError string
+	}{
+		{"slug-from-name", newRoom(nil), http.StatusSeeOther, ""},
+		{"own-slug", newRoom(url.Values{"slug": {"garden"}}), http.StatusSeeOther, ""},
+		{"slug-taken", newRoom(url.Values{"slug": {"majors-suite"}}), http.StatusOK, "Another room already has"},
+		{"invalid-slug", newRoom(url.Values{"slug": {"Garden Suite"}}), http.StatusOK, "Use lowercase letters"},
+		{"missing-name", newRoom(url.Values{"room_name": {""}}), http.StatusOK, "This field cannot be blank"},
+		{"no-capacity", newRoom(url.Values{"capacity": {"0"}}), http.StatusOK, ""},
+		{"invalid-rate", newRoom(url.Values{"base_rate": {"free"}}), http.StatusOK, ""},
+		{"with-amenities", newRoom(url.Values{"amenities": {"1", "3"}}), http.StatusSeeOther, ""},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest("POST", "/admin/rooms/new", strings.NewReader(test.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminPostNewRoom)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != test.expectedCode {
+			t.Errorf("For %s, expected code %d but got %d", test.name, test.expectedCode, rr.Code)
+		}
+		if test.expectedError != "" && !strings.Contains(rr.Body.String(), test.expectedError) {
+			t.Errorf("For %s, expected the form to show %q", test.name, test.expectedError)
+		}
+	}
+}
+
+func TestRepository_AdminPostEditRoom(t *testing.T) {
+	var tests = []struct {
+		name         string
+		slug         string
+		expectedCode int
+	}{
+		{"same-slug", "generals-quarters", http.StatusSeeOther},
+		{"new-slug", "generals", http.StatusSeeOther},
+		{"slug-of-another-room", "majors-suite", http.StatusOK},
+	}
+
+	for _, test := range tests {
+		postedData := url.Values{"room_name": {"General's Quarters"}, "slug": {test.slug}, "capacity": {"2"}}
+		req, _ := http.NewRequest("POST", "/admin/rooms/1", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(withURLParams(ctx, map[string]string{"id": "1"}))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminPostEditRoom)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != test.expectedCode {
+			t.Errorf("For %s, expected code %d but got %d", test.name, test.expectedCode, rr.Code)
+		}
+	}
+}
+
+func TestRepository_AdminPostMoveRoom(t *testing.T) {
+	auditor, ok := Repo.DB.(interface{ AuditEvents() []models.AuditEvent })
+	if !ok {
+		t.Fatal("test repo does not keep audit events")
+	}
+
+	// The test rooms are 1 and 2, in that order.
+	var tests = []struct {
+		name            string
+		id              string
+		direction       string
+		expectedChanges string
+	}{
+		{"moved-up", "2", "up", `{"sort_order":{"before":2,"after":1}}`},
+		{"moved-down", "1", "down", `{"sort_order":{"before":1,"after":2}}`},
+		{"already-first", "1", "up", ""},
+		{"already-last", "2", "down", ""},
+		{"non-existent", "99", "up", ""},
+	}
+
+	for _, test := range tests {
+		eventsBefore := len(auditor.AuditEvents())
+		postedData := url.Values{"direction": {test.direction}}
+		req, _ := http.NewRequest("POST", "/admin/rooms/"+test.id+"/move", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(withURLParams(ctx, map[string]string{"id": test.id}))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminPostMoveRoom)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("For %s, expected code %d but got %d", test.name, http.StatusSeeOther, rr.Code)
+		}
+		events := auditor.AuditEvents()[eventsBefore:]
+		switch {
+		case test.expectedChanges == "" && len(events) != 0:
+			t.Errorf("For %s, expected nothing to be audited, got %v", test.name, events)
+		case test.expectedChanges != "" && (len(events) != 1 || events[0].Changes != test.expectedChanges):
+			t.Errorf("For %s, expected the change %s to be audited, got %v", test.name, test.expectedChanges, events)
+		}
+	}
+}
+
+func TestRepository_AdminRoomPages(t *testing.T) {
+	var tests = []struct {
+		name    string
+		handler http.HandlerFunc
+		id      string
+	}{
+		{"rooms", Repo.AdminRooms, ""},
+		{"new-room", Repo.AdminNewRoom, ""},
+		{"edit-room", Repo.AdminEditRoom, "1"},
+		{"room-photos", Repo.AdminRoomPhotos, "1"},
+		{"room-units", Repo.AdminRoomUnits, "1"},
+		{"amenities", Repo.AdminAmenities, ""},
+		{"extras", Repo.AdminExtras, ""},
+		{"waitlist", Repo.AdminWaitlist, ""},
+		{"promo-codes", Repo.AdminPromoCodes, ""},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", "/admin/rooms", nil)
+		ctx := getCtx(req)
+		req = req.WithContext(withURLParams(ctx, map[string]string{"id": test.id}))
+		rr := httptest.NewRecorder()
+		test.handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Errorf("For %s, expected code %d but got %d", test.name, http.StatusOK, rr.Code)
+		}
+	}
+}
+
+func TestRepository_AdminPostRoomPhotos(t *testing.T) {
+	var photo bytes.Buffer
+	err := png.Encode(&photo, image.NewRGBA(image.Rect(0, 0, 800, 600)))
+	if err != nil {
+		t.Fatal("Cannot encode photo:", err)
+	}
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	writer.WriteField("caption", "The bedroom")
+	part, _ := writer.CreateFormFile("photos", "bedroom.png")
+	part.Write(photo.Bytes())
+	part, _ = writer.CreateFormFile("photos", "notes.png")
+	part.Write([]byte("not a photo, whatever its name says"))
+	writer.Close()
+
+	req, _ := http.NewRequest("POST", "/admin/rooms/1/photos", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	ctx := getCtx(req)
+	req = req.WithContext(withURLParams(ctx, map[string]string{"id": "1"}))
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(Repo.AdminPostRoomPhotos)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("Expected code %d but got %d", http.StatusSeeOther, rr.Code)
+	}
+	if flash := session.GetString(ctx, "flash"); flash != "1 photo(s) uploaded" {
+		t.Errorf("Expected the photo to be uploaded, got flash %q", flash)
+	}
+	if e := session.GetString(ctx, "error"); !strings.Contains(e, "notes.png") {
+		t.Errorf("Expected the text file to be rejected, got error %q", e)
+	}
+
+	// The photo is stored with a thumbnail of every width.
+	dir := app.Storage.(*storage.LocalStorage).Dir
+	for _, pattern := range []string{"*.png", "*-320.jpg", "*-640.jpg", "*-1280.jpg"} {
+		files, _ := filepath.Glob(filepath.Join(dir, "rooms", "1", pattern))
+		if len(files) != 1 {
+			t.Errorf("Expected one stored file matching %s, got %d", pattern, len(files))
+		}
+	}
+}
+
+func TestRepository_AdminPostMoveRoomPhoto(t *testing.T) {
+	auditor, ok := Repo.DB.(interface{ AuditEvents() []models.AuditEvent })
+	if !ok {
+		t.Fatal("test repo does not keep audit events")
+	}
+
+	// Room 2 has photos 3 and 4, in that order.
+	var tests = []struct {
+		name            string
+		photoID         string
+		direction       string
+		expectedChanges string
+	}{
+		{"moved-up", "4", "up", `{"sort_order":{"before":2,"after":1}}`},
+		{"moved-down", "3", "down", `{"sort_order":{"before":1,"after":2}}`},
+		{"already-first", "3", "up", ""},
+		{"photo-of-another-room", "1", "down", ""},
+	}
+
+	for _, test := range tests {
+		eventsBefore := len(auditor.AuditEvents())
+		postedData := url.Values{"direction": {test.direction}}
+		req, _ := http.NewRequest("POST", "/admin/rooms/2/photos/"+test.photoID+"/move",
+			strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(withURLParams(ctx, map[string]string{"id": "2", "photoID": test.photoID}))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminPostMoveRoomPhoto)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/admin/rooms/2/photos" {
+			t.Errorf("For %s, expected a redirect to the photos of the room, got code %d and location %q",
+				test.name, rr.Code, rr.Header().Get("Location"))
+		}
+		events := auditor.AuditEvents()[eventsBefore:]
+		switch {
+		case test.expectedChanges == "" && len(events) != 0:
+			t.Errorf("For %s, expected nothing to be audited, got %v", test.name, events)
+		case test.expectedChanges != "" && (len(events) != 1 || events[0].Changes != test.expectedChanges):
+			t.Errorf("For %s, expected the change %s to be audited, got %v", test.name, test.expectedChanges, events)
+		}
+	}
+}
+
+func TestRepository_AdminDeleteRoomPhoto(t *testing.T) {
+	var tests = []struct {
+		name               string
+		roomID             string
+		photoID            string
+		expectedStatusCode int
+	}{
+		{"valid", "1", "1", http.StatusSeeOther},
+		{"other-room", "2", "1", http.StatusNotFound},
+		{"non-existent", "1", "5", http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest("POST", "/admin/rooms/1/photos/1/delete", nil)
+		ctx := getCtx(req)
+		req = req.WithContext(withURLParams(ctx, map[string]string{"id": test.roomID, "photoID": test.photoID}))
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminDeleteRoomPhoto)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != test.expectedStatusCode {
+			t.Errorf("For %s, expected code %d but got %d", test.name, test.expectedStatusCode, rr.Code)
+		}
+	}
+}
+
+func TestRepository_GuestReservationInvoice(t *testing.T) {
+	var tests = []struct {
+		name               string
+		guestEmail         string
+		expectedStatusCode int
+	}{
+		{"own-reservation", "john@smith.com", http.StatusOK},
+		{"other-guest-reservation", "jane@smith.com", http.StatusSeeOther},
+		{"not-logged-in", "", http.StatusSeeOther},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", "/my/reservations/LB-7K3Q9X/invoice", nil)
+		ctx := getCtx(req)
+		if test.guestEmail != "" {
+			session.Put(ctx, "guest_email", test.guestEmail)
+		}
+		req = req.WithContext(withURLParams(ctx, map[string]string{"code": "LB-7K3Q9X"}))
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.GuestReservationInvoice)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != test.expectedStatusCode {
+			t.Errorf("For %s, expected %d but got %d", test.name, test.expectedStatusCode, rr.Code)
+		}
+		if rr.Code == http.StatusOK && !strings.Contains(rr.Body.String(), "Cleaning") {
+			t.Errorf("For %s, the invoice doesn't itemize the fees", test.name)
+		}
+	}
+}
+
+func TestRepository_AdminReservationInvoice(t *testing.T) {
+	var tests = []struct {
+		name               string
+		id                 string
+		expectedStatusCode int
+	}{
+		{"existing-reservation", "1", http.StatusOK},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", "/admin/reservations/all/"+test.id+"/invoice", nil)
+		ctx := getCtx(req)
+		req = req.WithContext(withURLParams(ctx, map[string]string{"src": "all", "id": test.id}))
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminReservationInvoice)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != test.expectedStatusCode {
+			t.Errorf("For %s, expected %d but got %d", test.name, test.expectedStatusCode, rr.Code)
+		}
+		if rr.Code == http.StatusOK && !strings.Contains(rr.Body.String(), "$250.00") {
+			t.Errorf("For %s, the invoice doesn't show the total", test.name)
+		}
+	}
+}
+
+func TestRepository_AdminPostCharge(t *testing.T) {
+	charge := func(changes url.Values) url.Values {
+		values := url.Values{"name": {"Occupancy tax"}, "kind": {"tax"}, "calculation": {"percent"},
+			"amount": {"5.5"}}
+		for key, value := range changes {
+			values[key] = value
+		}
+		return values
+	}
+
+	var tests = []struct {
+		name         string
+		postedData   url.Values
+		expectedCode int
+	}{
+		{"percent-tax", charge(nil), http.StatusSeeOther},
+		{"fee-per-night", charge(url.Values{"kind": {"fee"}, "calculation": {"fixed"}, "per": {"night"},
+			"amount": {"2.50"}}), http.StatusSeeOther},
+		{"in-effect-for-a-year", charge(url.Values{"start_date": {"2050-01-01"}, "end_date": {"2050-12-31"}}),
+			http.StatusSeeOther},
+		{"fixed-without-per", charge(url.Values{"calculation": {"fixed"}}), http.StatusOK},
+		{"unknown-kind", charge(url.Values{"kind": {"donation"}}), http.StatusOK},
+		{"invalid-amount", charge(url.Values{"amount": {"five"}}), http.StatusOK},
+		{"ends-before-start", charge(url.Values{"start_date": {"2050-12-31"}, "end_date": {"2050-01-01"}}),
+			http.StatusOK},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest("POST", "/admin/charges", strings.NewReader(test.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminPostCharge)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != test.expectedCode {
+			t.Errorf("For %s, expected code %d but got %d", test.name, test.expectedCode, rr.Code)
+		}
+	}
+}
+
+func TestRepository_AdminDeleteCharge(t *testing.T) {
+	auditor, ok := Repo.DB.(interface{ AuditEvents() []models.AuditEvent })
+	if !ok {
+		t.Fatal("test repo does not keep audit events")
+	}
+
+	var tests = []struct {
+		name         string
+		id           string
+		expectedCode int
+	}{
+		{"deleted", "2", http.StatusSeeOther},
+		{"non-existent", "99", http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		eventsBefore := len(auditor.AuditEvents())
+		req, _ := http.NewRequest("POST", "/admin/charges/"+test.id+"/delete", nil)
+		ctx := getCtx(req)
+		req = req.WithContext(withURLParams(ctx, map[string]string{"id": test.id}))
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminDeleteCharge)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != test.expectedCode {
+			t.Errorf("For %s, expected code %d but got %d", test.name, test.expectedCode, rr.Code)
+		}
+		events := auditor.AuditEvents()[eventsBefore:]
+		deleted := test.expectedCode == http.StatusSeeOther
+		if deleted && (len(events) != 1 || !strings.Contains(events[0].Changes, `"Name":{"before":"Cleaning"`)) {
+			t.Errorf("For %s, expected the deleted charge to be audited, got %v", test.name, events)
+		}
+		if !deleted && len(events) != 0 {
+			t.Errorf("For %s, expected nothing to be audited, got %v", test.name, events)
+		}
+	}
+}
+
+func TestRepository_AdminPostModifyReservation(t *testing.T) {
+	start := time.Now().AddDate(0, 2, 0).Format("2006-01-02")
+	end := time.Now().AddDate(0, 2, 3).Format("2006-01-02")
+
+	var tests = []struct {
+		name             string
+		postedData       url.Values
+		expectedCode     int
+		expectedLocation string
+	}{
+		{"valid-change", url.Values{"start_date": {start}, "end_date": {end}, "room_id": {"1"}},
+			http.StatusSeeOther, "/admin/reservations/all/1"},
+		{"room-not-available", url.Values{"start_date": {start}, "end_date": {end}, "room_id": {"2"}},
+			http.StatusOK, ""},
+		{"departure-before-arrival", url.Values{"start_date": {end}, "end_date": {start}, "room_id": {"1"}},
+			http.StatusOK, ""},
+		{"invalid-date", url.Values{"start_date": {"invalid"}, "end_date": {end}, "room_id": {"1"}},
+			http.StatusOK, ""},
+		{"non-existent-room", url.Values{"start_date": {start}, "end_date": {end}, "room_id": {"3"}},
+			http.StatusOK, ""},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest("POST", "/admin/reservations/all/1/modify",
+			strings.NewReader(test.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(withURLParams(ctx, map[string]string{"src": "all", "id": "1"}))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminPostModifyReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != test.expectedCode {
+			t.Errorf("For %s, expected code %d but got %d", test.name, test.expectedCode, rr.Code)
+		}
+		if rr.Header().Get("Location") != test.expectedLocation {
+			t.Errorf("For %s, expected redirect to %q but got %q", test.name, test.expectedLocation,
+				rr.Header().Get("Location"))
+		}
+	}
+}
+
+func TestRepository_AdminPostReservationUnit(t *testing.T) {
+	var tests = []struct {
+		name             string
+		unitID           string
+		expectedCode     int
+		expectedLocation string
+	}{
+		{"free-unit", "2", http.StatusSeeOther, "/admin/reservations/all/1"},
+		{"archived-unit", "3", http.StatusOK, ""},
+		{"unit-of-another-room", "4", http.StatusOK, ""},
+		{"missing-unit", "", http.StatusOK, ""},
+	}
+
+	for _, test := range tests {
+		postedData := url.Values{"unit_id": {test.unitID}}
+		req, _ := http.NewRequest("POST", "/admin/reservations/all/1/unit", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(withURLParams(ctx, map[string]string{"src": "all", "id": "1"}))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminPostReservationUnit)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != test.expectedCode {
+			t.Errorf("For %s, expected code %d but got %d", test.name, test.expectedCode, rr.Code)
+		}
+		if rr.Header().Get("Location") != test.expectedLocation {
+			t.Errorf("For %s, expected redirect to %q but got %q", test.name, test.expectedLocation,
+				rr.Header().Get("Location"))
+		}
+	}
+}
+
+func TestRepository_AdminPostReservationExtras(t *testing.T) {
+	var tests = []struct {
+		name             string
+		postedData       url.Values
+		expectedCode     int
+		expectedLocation string
+	}{
+		{"valid", url.Values{"extra_1": {"1"}, "extra_2": {"1"}}, http.StatusSeeOther, "/admin/reservations/all/1"},
+		{"no-extras", url.Values{}, http.StatusSeeOther, "/admin/reservations/all/1"},
+		{"sold-out", url.Values{"extra_3": {"1"}}, http.StatusOK, ""},
+		{"sold-out-while-saving", url.Values{"extra_2": {"2"}}, http.StatusOK, ""},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest("POST", "/admin/reservations/all/1/extras",
+			strings.NewReader(test.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(withURLParams(ctx, map[string]string{"src": "all", "id": "1"}))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminPostReservationExtras)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != test.expectedCode {
+			t.Errorf("For %s, expected code %d but got %d", test.name, test.expectedCode, rr.Code)
+		}
+		if rr.Header().Get("Location") != test.expectedLocation {
+			t.Errorf("For %s, expected redirect to %q but got %q", test.name, test.expectedLocation,
+				rr.Header().Get("Location"))
+		}
+	}
+}
+
+func TestRepository_AdminPostRoomUnits(t *testing.T) {
+	var tests = []struct {
+		name         string
+		postedData   url.Values
+		expectedCode int
+	}{
+		{"valid", url.Values{"name": {"104"}}, http.StatusSeeOther},
+		{"missing-name", url.Values{"name": {""}}, http.StatusOK},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest("POST", "/admin/rooms/1/units", strings.NewReader(test.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(withURLParams(ctx, map[string]string{"id": "1"}))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminPostRoomUnits)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != test.expectedCode {
+			t.Errorf("For %s, expected code %d but got %d", test.name, test.expectedCode, rr.Code)
+		}
+	}
+}
+
+func TestRepository_AdminPostRoomUnitArchived(t *testing.T) {
+	var tests = []struct {
+		name         string
+		roomID       string
+		unitID       string
+		expectedCode int
+	}{
+		{"valid", "1", "2", http.StatusSeeOther},
+		{"other-room", "2", "2", http.StatusNotFound},
+		{"non-existent", "1", "9", http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		postedData := url.Values{"archived": {"true"}}
+		req, _ := http.NewRequest("POST", "/admin/rooms/1/units/2/archived",
+			strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(withURLParams(ctx, map[string]string{"id": test.roomID, "unitID": test.unitID}))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminPostRoomUnitArchived)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != test.expectedCode {
+			t.Errorf("For %s, expected code %d but got %d", test.name, test.expectedCode, rr.Code)
+		}
+	}
+}
+
+func TestRepository_AdminPostAmenity(t *testing.T) {
+	var tests = []struct {
+		name          string
+		postedData    url.Values
+		expectedCode  int
+		expectedError string
+	}{
+		{"valid", url.Values{"name": {"Sea view"}, "icon": {"ti-anchor"}}, http.StatusSeeOther, ""},
+		{"name-taken", url.Values{"name": {"Ensuite"}, "icon": {"ti-home"}}, http.StatusOK,
+			"Another amenity already has this name"},
+		{"unknown-icon", url.Values{"name": {"Sea view"}, "icon": {"ti-ocean"}}, http.StatusOK,
+			"Choose one of the icons"},
+		{"missing-name", url.Values{"name": {""}, "icon": {"ti-anchor"}}, http.StatusOK,
+			"This field cannot be blank"},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest("POST", "/admin/amenities", strings.NewReader(test.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminPostAmenity)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != test.expectedCode {
+			t.Errorf("For %s, expected code %d but got %d", test.name, test.expectedCode, rr.Code)
+		}
+		if test.expectedError != "" && !strings.Contains(rr.Body.String(), test.expectedError) {
+			t.Errorf("For %s, expected the form to show %q", test.name, test.expectedError)
+		}
+	}
+}
+
+func TestRepository_AdminPostEditAmenity(t *testing.T) {
+	var tests = []struct {
+		name          string
+		id            string
+		postedData    url.Values
+		expectedCode  int
+		expectedError string
+	}{
+		{"valid", "2", url.Values{"name": {"Terrace"}, "icon": {"ti-shine"}}, http.StatusSeeOther, ""},
+		{"name-taken", "2", url.Values{"name": {"Ensuite"}, "icon": {"ti-shine"}}, http.StatusSeeOther,
+			"Balcony was not saved: Another amenity already has this name"},
+		{"non-existent", "9", url.Values{"name": {"Terrace"}, "icon": {"ti-shine"}}, http.StatusNotFound, ""},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest("POST", "/admin/amenities/"+test.id, strings.NewReader(test.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(withURLParams(ctx, map[string]string{"id": test.id}))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminPostEditAmenity)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != test.expectedCode {
+			t.Errorf("For %s, expected code %d but got %d", test.name, test.expectedCode, rr.Code)
+		}
+		if flashed := session.PopString(req.Context(), "error"); flashed != test.expectedError {
+			t.Errorf("For %s, expected error %q but got %q", test.name, test.expectedError, flashed)
+		}
+	}
+}
+
+func TestRepository_AdminDeleteAmenity(t *testing.T) {
+	auditor, ok := Repo.DB.(interface{ AuditEvents() []models.AuditEvent })
+	if !ok {
+		t.Fatal("test repo does not keep audit events")
+	}
+
+	var tests = []struct {
+		name         string
+		id           string
+		expectedCode int
+	}{
+		{"deleted", "2", http.StatusSeeOther},
+		{"non-existent", "99", http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		eventsBefore := len(auditor.AuditEvents())
+		req, _ := http.NewRequest("POST", "/admin/amenities/"+test.id+"/delete", nil)
+		ctx := getCtx(req)
+		req = req.WithContext(withURLParams(ctx, map[string]string{"id": test.id}))
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminDeleteAmenity)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != test.expectedCode {
+			t.Errorf("For %s, expected code %d but got %d", test.name, test.expectedCode, rr.Code)
+		}
+		events := auditor.AuditEvents()[eventsBefore:]
+		deleted := test.expectedCode == http.StatusSeeOther
+		if deleted && (len(events) != 1 || !strings.Contains(events[0].Changes, `"Name":{"before":"Balcony"`)) {
+			t.Errorf("For %s, expected the deleted amenity to be audited, got %v", test.name, events)
+		}
+		if !deleted && len(events) != 0 {
+			t.Errorf("For %s, expected nothing to be audited, got %v", test.name, events)
+		}
+	}
+}
+
+func TestRepository_AdminPostExtra(t *testing.T) {
+	var tests = []struct {
+		name          string
+		postedData    url.Values
+		expectedCode  int
+		expectedError string
+	}{
+		{"valid", url.Values{"name": {"Parking"}, "per": {"night"}, "price": {"10"}, "inventory": {"5"}},
+			http.StatusSeeOther, ""},
+		{"unlimited", url.Values{"name": {"Parking"}, "per": {"night"}, "price": {"10"}}, http.StatusSeeOther, ""},
+		{"name-taken", url.Values{"name": {"Breakfast"}, "per": {"guest-night"}, "price": {"15"}}, http.StatusOK,
+			"Another extra already has this name"},
+		{"unknown-per", url.Values{"name": {"Parking"}, "per": {"week"}, "price": {"10"}}, http.StatusOK,
+			"Choose what the price is charged per"},
+		{"invalid-price", url.Values{"name": {"Parking"}, "per": {"night"}, "price": {"ten"}}, http.StatusOK,
+			"This field must be an amount"},
+		{"negative-inventory", url.Values{"name": {"Parking"}, "per": {"night"}, "price": {"10"},
+			"inventory": {"-1"}}, http.StatusOK, "This field must be a whole number"},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest("POST", "/admin/extras", strings.NewReader(test.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminPostExtra)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != test.expectedCode {
+			t.Errorf("For %s, expected code %d but got %d", test.name, test.expectedCode, rr.Code)
+		}
+		if test.expectedError != "" && !strings.Contains(rr.Body.String(), test.expectedError) {
+			t.Errorf("For %s, expected the form to show %q", test.name, test.expectedError)
+		}
+	}
+}
+
+func TestRepository_AdminPostEditExtra(t *testing.T) {
+	var tests = []struct {
+		name          string
+		id            string
+		postedData    url.Values
+		expectedCode  int
+		expectedError string
+	}{
+		{"valid", "2", url.Values{"name": {"Parking"}, "per": {"night"}, "price": {"$12.00"}, "inventory": {"4"},
+			"active": {"true"}}, http.StatusSeeOther, ""},
+		{"name-taken", "2", url.Values{"name": {"Breakfast"}, "per": {"night"}, "price": {"12"}},
+			http.StatusSeeOther, "Parking was not saved: Another extra already has this name"},
+		{"invalid-price", "2", url.Values{"name": {"Parking"}, "per": {"night"}, "price": {"twelve"}},
+			http.StatusSeeOther, "Parking was not saved: This field must be an amount like 120 or 120.50"},
+		{"non-existent", "9", url.Values{"name": {"Parking"}, "per": {"night"}, "price": {"12"}},
+			http.StatusNotFound, ""},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest("POST", "/admin/extras/"+test.id, strings.NewReader(test.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(withURLParams(ctx, map[string]string{"id": test.id}))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminPostEditExtra)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != test.expectedCode {
+			t.Errorf("For %s, expected code %d but got %d", test.name, test.expectedCode, rr.Code)
+		}
+		if flashed := session.PopString(req.Context(), "error"); flashed != test.expectedError {
+			t.Errorf("For %s, expected error %q but got %q", test.name, test.expectedError, flashed)
+		}
+	}
+}
+
+// withURLParams adds chi URL params to a context, for handlers that read them with chi.URLParam.
+func withURLParams(ctx context.Context, params map[string]string) context.Context {
+	routeCtx := chi.NewRouteContext()
+	for key, value := range params {
+		routeCtx.URLParams.Add(key, value)
+	}
+	return context.WithValue(ctx, chi.RouteCtxKey, routeCtx)
+}
+
+// getCtx gets the context from the session in the request.
+func getCtx(req *http.Request) context.Context {
+	ctx, err := session.Load(req.Context(), req.Header.Get("X-Session"))
+	if err != nil {
+		log.Println(err)
+	}
+	return ctx
+}
+
+func TestRepository_AddToCart(t *testing.T) {
+	inCart := models.Cart{Items: []models.CartItem{{HoldID: 1, Reservation: models.Reservation{RoomID: 1,
+		StartDate: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC)}}}}
+
+	var tests = []struct {
+		name             string
+		url              string
+		cart             models.Cart
+		expectedLocation string
+		expectedItems    int
+	}{
+		{"free-room", "/cart/add?id=1&s=2050-01-01&e=2050-01-02&a=2", models.Cart{}, "/cart", 1},
+		{"another-stay", "/cart/add?id=1&s=2050-01-03&e=2050-01-05", inCart, "/cart", 2},
+		{"room-just-taken", "/cart/add?id=2&s=2050-01-01&e=2050-01-02", models.Cart{}, "/search-availability", 0},
+		{"too-many-guests", "/cart/add?id=1&s=2050-01-01&e=2050-01-02&a=2&c=1", models.Cart{},
+			"/rooms/generals-quarters", 0},
+		{"already-in-cart", "/cart/add?id=1&s=2050-01-02&e=2050-01-04", inCart, "/rooms/generals-quarters", 1},
+		{"breaks-stay-rule", "/cart/add?id=1&s=2050-01-07&e=2050-01-09", models.Cart{}, "/rooms/generals-quarters", 0},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", test.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		session.Put(ctx, "cart", test.cart)
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AddToCart)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Header().Get("Location") != test.expectedLocation {
+			t.Errorf("For %s, expected redirect to %s but got %s", test.name, test.expectedLocation,
+				rr.Header().Get("Location"))
+		}
+		cart, _ := session.Get(ctx, "cart").(models.Cart)
+		if len(cart.Items) != test.expectedItems {
+			t.Errorf("For %s, expected %d stays in the cart but got %d", test.name, test.expectedItems,
+				len(cart.Items))
+		}
+	}
+}
+
+// cartWithRooms returns a cart with a stay in each of the given rooms, all held with the given hold.
+func cartWithRooms(holdID int, roomIDs ...int) models.Cart {
+	var cart models.Cart
+	for _, roomID := range roomIDs {
+		cart.Items = append(cart.Items, models.CartItem{HoldID: holdID, Reservation: models.Reservation{
+			RoomID:    roomID,
+			Room:      models.Room{ID: roomID, RoomName: fmt.Sprintf("Room %d", roomID)},
+			StartDate: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+			EndDate:   time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
+			Adults:    1,
+			Quote:     models.Quote{Total: 25000},
+		}})
+	}
+	return cart
+}
+
+func TestRepository_Cart(t *testing.T) {
+	var tests = []struct {
+		name          string
+		cart          models.Cart
+		expectedItems int
+		expectedError string
+	}{
+		{"held", cartWithRooms(1, 1), 1, ""},
+		// Hold 2 expired, so room 1 is held again, but room 2 was booked by someone else in the meantime.
+		{"hold-expired", cartWithRooms(2, 1, 2), 1, "Sorry, the Room 2 was just booked"},
+		{"empty", models.Cart{}, 0, ""},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", "/cart", nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		session.Put(ctx, "cart", test.cart)
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.Cart)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Errorf("For %s, expected code %d but got %d", test.name, http.StatusOK, rr.Code)
+		}
+		cart, _ := session.Get(ctx, "cart").(models.Cart)
+		if len(cart.Items) != test.expectedItems {
+			t.Errorf("For %s, expected %d stays in the cart but got %d", test.name, test.expectedItems,
+				len(cart.Items))
+		}
+		for _, item := range cart.Items {
+			if item.HoldID != 1 {
+				t.Errorf("For %s, expected the stays in the cart to be held again, got hold %d", test.name,
+					item.HoldID)
+			}
+		}
+		if test.expectedError != "" && !strings.Contains(rr.Body.String(), test.expectedError) {
+			t.Errorf("For %s, expected the page to show %q", test.name, test.expectedError)
+		}
+	}
+}
+
+func TestRepository_PostCartRemove(t *testing.T) {
+	var tests = []struct {
+		name          string
+		index         string
+		expectedItems int
+		expectedFlash string
+		expectedError string
+	}{
+		{"in-cart", "0", 1, "The Room 1 was taken out of your cart", ""},
+		{"not-in-cart", "2", 2, "", "This stay is not in your cart anymore"},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest("POST", "/cart/"+test.index+"/remove", nil)
+		ctx := withURLParams(getCtx(req), map[string]string{"index": test.index})
+		req = req.WithContext(ctx)
+		session.Put(ctx, "cart", cartWithRooms(1, 1, 2))
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.PostCartRemove)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Header().Get("Location") != "/cart" {
+			t.Errorf("For %s, expected redirect to /cart but got %s", test.name, rr.Header().Get("Location"))
+		}
+		cart, _ := session.Get(ctx, "cart").(models.Cart)
+		if len(cart.Items) != test.expectedItems {
+			t.Errorf("For %s, expected %d stays in the cart but got %d", test.name, test.expectedItems,
+				len(cart.Items))
+		}
+		if flash := session.PopString(ctx, "flash"); flash != test.expectedFlash {
+			t.Errorf("For %s, expected flash %q but got %q", test.name, test.expectedFlash, flash)
+		}
+		if message := session.PopString(ctx, "error"); message != test.expectedError {
+			t.Errorf("For %s, expected error %q but got %q", test.name, test.expectedError, message)
+		}
+	}
+}
+
+func TestRepository_PostCart(t *testing.T) {
+	valid := url.Values{"first_name": {"John"}, "last_name": {"Smith"}, "email": {"john@smith.com"}}
+
+	var tests = []struct {
+		name             string
+		cart             models.Cart
+		postedData       url.Values
+		expectedCode     int
+		expectedLocation string
+		expectedError    string
+	}{
+		{"valid", cartWithRooms(1, 1, 1), valid, http.StatusSeeOther, "/cart-summary", ""},
+		{"empty-cart", models.Cart{}, valid, http.StatusSeeOther, "/search-availability", ""},
+		{"room-just-taken", cartWithRooms(2, 2), valid, http.StatusSeeOther, "/cart", ""},
+		{"invalid-form", cartWithRooms(1, 1), url.Values{"first_name": {"J"}, "email": {"john"}}, http.StatusOK, "",
+			"Invalid email address"},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest("POST", "/cart", strings.NewReader(test.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		session.Put(ctx, "cart", test.cart)
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.PostCart)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != test.expectedCode {
+			t.Errorf("For %s, expected code %d but got %d", test.name, test.expectedCode, rr.Code)
+		}
+		if rr.Header().Get("Location") != test.expectedLocation {
+			t.Errorf("For %s, expected redirect to %q but got %q", test.name, test.expectedLocation,
+				rr.Header().Get("Location"))
+		}
+		if test.expectedError != "" && !strings.Contains(rr.Body.String(), test.expectedError) {
+			t.Errorf("For %s, expected the form to show %q", test.name, test.expectedError)
+		}
+		if test.expectedLocation != "/cart-summary" {
+			continue
+		}
+
+		group, ok := session.Get(ctx, "group").(models.ReservationGroup)
+		if !ok || group.ConfirmationCode != "LB-GRP234" {
+			t.Errorf("For %s, expected the group to be stored in the session, got %+v", test.name, group)
+		}
+		if session.Exists(ctx, "cart") {
+			t.Errorf("For %s, expected the cart to be emptied", test.name)
+		}
+
+		req, _ = http.NewRequest("GET", "/cart-summary", nil)
+		req = req.WithContext(ctx)
+		rr = httptest.NewRecorder()
+		http.HandlerFunc(Repo.CartSummary).ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "LB-GRP234") {
+			t.Errorf("For %s, expected the summary to show the group code, got code %d", test.name, rr.Code)
+		}
+	}
+}
+
+func TestRepository_PostCart_ConcurrentDuplicates(t *testing.T) {
+	counter, ok := Repo.DB.(interface{ ReservationsInserted() int })
+	if !ok {
+		t.Fatal("test repo does not count inserted reservations")
+	}
+	insertedBefore := counter.ReservationsInserted()
+
+	postedData := url.Values{"first_name": {"John"}, "last_name": {"Smith"}, "email": {"john@smith.com"},
+		"idempotency_key": {"concurrent-cart-key"}}
+
+	// Fire the same booking of two rooms several times at once, as a double click would.
+	const duplicates = 10
+	var wg sync.WaitGroup
+	recorders := make([]*httptest.ResponseRecorder, duplicates)
+	for i := 0; i < duplicates; i++ {
+		req, _ := http.NewRequest("POST", "/cart", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		session.Put(ctx, "cart", cartWithRooms(1, 1, 2))
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		recorders[i] = httptest.NewRecorder()
+
+		wg.Add(1)
+		go func(rr *httptest.ResponseRecorder, req *http.Request) {
+			defer wg.Done()
+			http.HandlerFunc(Repo.PostCart).ServeHTTP(rr, req)
+		}(recorders[i], req)
+	}
+	wg.Wait()
+
+	for i, rr := range recorders {
+		if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/cart-summary" {
+			t.Errorf("Submission %d got code %d and location %q, wanted the cart summary", i, rr.Code,
+				rr.Header().Get("Location"))
+		}
+	}
+	if inserted := counter.ReservationsInserted() - insertedBefore; inserted != 2 {
+		t.Errorf("Expected duplicate submissions to book the 2 rooms once, but %d reservations were inserted",
+			inserted)
+	}
+}
+
+func TestRepository_PostCart_Quotes(t *testing.T) {
+	// The group is loaded back with the room 1 first, the other way around from how it was booked.
+	cart := cartWithRooms(1, 2, 1)
+	cart.Items[0].Reservation.Adults, cart.Items[0].Reservation.Quote.Total = 2, 30000
+	cart.Items[1].Reservation.Adults, cart.Items[1].Reservation.Quote.Total = 1, 20000
+
+	postedData := url.Values{"first_name": {"John"}, "last_name": {"Smith"}, "email": {"john@smith.com"}}
+	req, _ := http.NewRequest("POST", "/cart", strings.NewReader(postedData.Encode()))
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	session.Put(ctx, "cart", cart)
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(Repo.PostCart)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("Expected code %d but got %d", http.StatusSeeOther, rr.Code)
+	}
+	group := session.Get(ctx, "group").(models.ReservationGroup)
+	expected := map[int][2]int{1: {1, 20000}, 2: {2, 30000}}
+	for _, res := range group.Reservations {
+		if got := [2]int{res.Adults, res.Quote.Total}; got != expected[res.RoomID] {
+			t.Errorf("Expected the reservation of room %d to keep %d adults and a total of %d, got %v", res.RoomID,
+				expected[res.RoomID][0], expected[res.RoomID][1], got)
+		}
+	}
+}
+
+func TestRepository_PostAvailability_Flexible(t *testing.T) {
+	// The test repo only has the General's Quarters free, for stays arriving in 2051.
+	var tests = []struct {
+		name             string
+		postedData       url.Values
+		expectedCode     int
+		expectedInBody   string
+		expectedLocation string
+	}{
+		{"exact", url.Values{"start": {"2050-12-30"}, "end": {"2051-01-01"}}, http.StatusSeeOther, "",
+			"/search-availability"},
+		{"flexible-by-3-days", url.Values{"start": {"2050-12-30"}, "end": {"2051-01-01"}, "flexibility": {"3"}},
+			http.StatusOK, "s=2051-01-01&e=2051-01-03", ""},
+		{"flexible-by-1-day", url.Values{"start": {"2050-12-30"}, "end": {"2051-01-01"}, "flexibility": {"1"}},
+			http.StatusSeeOther, "", "/search-availability"},
+		// The cheapest stays in the month come first, the first one without Friday and Saturday nights arrives on
+		// Sunday the 5th.
+		{"any-stay-in-month", url.Values{"flexibility": {"month"}, "month": {"2051-02"}, "nights": {"3"}},
+			http.StatusOK, "s=2051-02-05&e=2051-02-08", ""},
+		{"too-many-guests", url.Values{"flexibility": {"month"}, "month": {"2051-02"}, "adults": {"3"}},
+			http.StatusSeeOther, "", "/search-availability"},
+		{"invalid-month", url.Values{"flexibility": {"month"}, "month": {"February"}}, http.StatusOK,
+			"Invalid month", ""},
+		{"past-month", url.Values{"flexibility": {"month"}, "month": {"2020-02"}}, http.StatusOK,
+			"None of the stays in this month can be booked anymore", ""},
+		{"invalid-flexibility", url.Values{"start": {"2050-12-30"}, "end": {"2051-01-01"}, "flexibility": {"2"}},
+			http.StatusOK, "Invalid flexibility", ""},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest("POST", "/search-availability", strings.NewReader(test.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.PostAvailability)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != test.expectedCode {
+			t.Errorf("For %s, expected code %d but got %d", test.name, test.expectedCode, rr.Code)
+		}
+		if rr.Header().Get("Location") != test.expectedLocation {
+			t.Errorf("For %s, expected redirect to %q but got %q", test.name, test.expectedLocation,
+				rr.Header().Get("Location"))
+		}
+		if test.expectedInBody != "" && !strings.Contains(rr.Body.String(), test.expectedInBody) {
+			t.Errorf("For %s, expected the page to show %q", test.name, test.expectedInBody)
+		}
+	}
+}
+
+func TestRepository_AvailabilityJSON_Alternatives(t *testing.T) {
+	var tests = []struct {
+		name          string
+		roomID        string
+		flexibility   string
+		expectedDates []string
+	}{
+		{"flexible-by-3-days", "1", "3", []string{"2051-01-01", "2051-01-02"}},
+		{"exact", "1", "", nil},
+		{"fully-booked-room", "2", "7", nil},
+	}
+
+	for _, test := range tests {
+		postedData := url.Values{"start": {"2050-12-30"}, "end": {"2051-01-01"}, "room_id": {test.roomID},
+			"flexibility": {test.flexibility}}
+		req, _ := http.NewRequest("POST", "/search-availability-json", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		handler := http.HandlerFunc(Repo.AvailabilityJSON)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		var j jsonResponse
+		err := json.Unmarshal([]byte(rr.Body.String()), &j)
+		if err != nil {
+			t.Errorf("For %s, failed to parse json", test.name)
+		}
+		if j.OK || len(j.Alternatives) != len(test.expectedDates) {
+			t.Errorf("For %s, expected %d alternatives but got %+v", test.name, len(test.expectedDates), j)
+			continue
+		}
+		for i, start := range test.expectedDates {
+			if j.Alternatives[i].StartDate != start || j.Alternatives[i].Total == "" {
+				t.Errorf("For %s, expected alternative %d to arrive on %s, got %+v", test.name, i, start,
+					j.Alternatives[i])
+			}
+		}
+	}
+}
+
+func TestRepository_Waitlist(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/waitlist?id=1&s=2050-01-10&e=2050-01-12&a=2&c=0", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(Repo.Waitlist)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected code %d but got %d", http.StatusOK, rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), `value="2050-01-10"`) {
+		t.Error("Expected the form to be filled in with the stay in the URL")
+	}
+}
+
+func TestRepository_PostWaitlist(t *testing.T) {
+	// The test repo only has the General's Quarters free, for stays arriving in 2051.
+	guest := url.Values{"room_id": {"1"}, "adults": {"2"}, "first_name": {"John"}, "email": {"john@smith.com"}}
+	withStay := func(values url.Values, start, end string) url.Values {
+		stay := url.Values{"start": {start}, "end": {end}}
+		for key, value := range values {
+			stay[key] = value
+		}
+		return stay
+	}
+
+	var tests = []struct {
+		name             string
+		postedData       url.Values
+		expectedCode     int
+		expectedLocation string
+	}{
+		{"room-taken", withStay(guest, "2050-01-10", "2050-01-12"), http.StatusSeeOther, "/rooms/generals-quarters"},
+		{"room-free", withStay(guest, "2051-01-10", "2051-01-12"), http.StatusSeeOther,
+			"/book-room?id=1&s=2051-01-10&e=2051-01-12&a=2&c=0"},
+		{"invalid-email", withStay(url.Values{"room_id": {"1"}, "first_name": {"John"}, "email": {"john"}},
+			"2050-01-10", "2050-01-12"), http.StatusOK, ""},
+		{"too-many-guests", withStay(url.Values{"room_id": {"1"}, "adults": {"3"}, "first_name": {"John"},
+			"email": {"john@smith.com"}}, "2050-01-10", "2050-01-12"), http.StatusOK, ""},
+		{"non-existent-room", withStay(url.Values{"room_id": {"3"}, "first_name": {"John"},
+			"email": {"john@smith.com"}}, "2050-01-10", "2050-01-12"), http.StatusInternalServerError, ""},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest("POST", "/waitlist", strings.NewReader(test.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.PostWaitlist)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != test.expectedCode {
+			t.Errorf("For %s, expected code %d but got %d", test.name, test.expectedCode, rr.Code)
+		}
+		if rr.Header().Get("Location") != test.expectedLocation {
+			t.Errorf("For %s, expected redirect to %q but got %q", test.name, test.expectedLocation,
+				rr.Header().Get("Location"))
+		}
+	}
+}
+
+func TestRepository_NotifyWaitlist(t *testing.T) {
+	notifier, ok := Repo.DB.(interface{ WaitlistNotified() []int })
+	if !ok {
+		t.Fatal("test repo does not record the notified waitlist entries")
+	}
+
+	var tests = []struct {
+		name     string
+		roomID   int
+		expected []int
+	}{
+		// Both guests waiting for the General's Quarters are notified, in the order they joined the waitlist.
+		{"room-freed", 1, []int{1, 2}},
+		// The Major's Suite can't be held, so its guest keeps waiting.
+		{"room-still-taken", 2, nil},
+	}
+
+	for _, test := range tests {
+		notifiedBefore := len(notifier.WaitlistNotified())
+		err := Repo.NotifyWaitlist(test.roomID, time.Date(2050, 1, 10, 0, 0, 0, 0, time.UTC),
+			time.Date(2050, 1, 13, 0, 0, 0, 0, time.UTC))
+		if err != nil {
+			t.Errorf("For %s, expected no error but got %v", test.name, err)
+		}
+
+		notified := notifier.WaitlistNotified()[notifiedBefore:]
+		if fmt.Sprint(notified) != fmt.Sprint(test.expected) {
+			t.Errorf("For %s, expected entries %v to be notified but got %v", test.name, test.expected, notified)
+		}
+	}
+}
+
+func TestRepository_ExpireWaitlistClaims(t *testing.T) {
+	notifier := Repo.DB.(interface{ WaitlistNotified() []int })
+	notifiedBefore := len(notifier.WaitlistNotified())
+
+	err := Repo.ExpireWaitlistClaims()
+	if err != nil {
+		t.Fatal("Expected no error but got", err)
+	}
+	// The stay of the expired claim is offered to the next guests waiting for it.
+	if notified := notifier.WaitlistNotified()[notifiedBefore:]; fmt.Sprint(notified) != "[1 2]" {
+		t.Errorf("Expected entries [1 2] to be notified but got %v", notified)
+	}
+}
+
+func TestRepository_ClaimWaitlist(t *testing.T) {
+	var tests = []struct {
+		name             string
+		token            string
+		expectedLocation string
+	}{
+		{"valid", "valid-claim", "/make-reservation"},
+		{"invalid-or-expired", "expired-claim", "/search-availability"},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", "/waitlist/claim/"+test.token, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(withURLParams(ctx, map[string]string{"token": test.token}))
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.ClaimWaitlist)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("For %s, expected code %d but got %d", test.name, http.StatusSeeOther, rr.Code)
+		}
+		if rr.Header().Get("Location") != test.expectedLocation {
+			t.Errorf("For %s, expected redirect to %q but got %q", test.name, test.expectedLocation,
+				rr.Header().Get("Location"))
+		}
+		if test.expectedLocation != "/make-reservation" {
+			continue
+		}
+
+		reservation, ok := session.Get(ctx, "reservation").(models.Reservation)
+		if !ok || reservation.FirstName != "John" || reservation.Adults != 2 {
+			t.Errorf("For %s, expected the stay of the entry in the session, got %+v", test.name, reservation)
+		}
+		if holdID := session.GetInt(ctx, "hold_id"); holdID != 1 {
+			t.Errorf("For %s, expected the hold of the claim in the session, got %d", test.name, holdID)
+		}
+	}
+}
+
+func TestRepository_Reservation_PromoCodes(t *testing.T) {
+	// Two weekend nights at $120: SUMMER10 takes $24.00 off, LONGSTAY needs a week.
+	reservation := models.Reservation{
+		RoomID:    1,
+		Room:      models.Room{ID: 1, RoomName: "General's Quarters"},
+		StartDate: time.Date(2050, time.January, 7, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2050, time.January, 9, 0, 0, 0, 0, time.UTC),
+	}
+
+	req, _ := http.NewRequest("GET", "/make-reservation", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	session.Put(ctx, "reservation", reservation)
+	session.Put(ctx, "promo_codes", []string{"SUMMER10", "LONGSTAY"})
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(Repo.Reservation)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected code %d but got %d", http.StatusOK, rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), "-$24.00") || !strings.Contains(rr.Body.String(), "$290.00") {
+		t.Error("Expected the price of the stay to show the discount of SUMMER10")
+	}
+	if !strings.Contains(rr.Body.String(), "The promo code LONGSTAY is only valid for stays of at least 7 nights") {
+		t.Error("Expected the page to say why LONGSTAY was dropped")
+	}
+	if codes := session.Get(ctx, "promo_codes").([]string); fmt.Sprint(codes) != "[SUMMER10]" {
+		t.Errorf("Expected only SUMMER10 to stay applied, got %v", codes)
+	}
+	quote := session.Get(ctx, "reservation").(models.Reservation).Quote
+	if quote.Discount != 2400 || quote.Total != 29000 {
+		t.Errorf("Expected a discount of 2400 and a total of 29000, got %d and %d", quote.Discount, quote.Total)
+	}
+}
+
+func TestRepository_PostPromoCode(t *testing.T) {
+	// A week in the General's Quarters.
+	reservation := models.Reservation{RoomID: 1, Quote: models.Quote{RoomID: 1,
+		Nights: make([]models.NightlyRate, 7), Subtotal: 70000}}
+
+	var tests = []struct {
+		name          string
+		applied       []string
+		code          string
+		expectedCodes string
+		expectedFlash string
+		expectedError string
+	}{
+		{"valid", nil, " summer10 ", "[SUMMER10]", "Promo code SUMMER10 applied", ""},
+		{"stackable", []string{"SUMMER10"}, "LONGSTAY", "[SUMMER10 LONGSTAY]", "Promo code LONGSTAY applied", ""},
+		{"not-stackable", []string{"SUMMER10"}, "WELCOME20", "[SUMMER10]", "",
+			"The promo code WELCOME20 can't be combined with other codes"},
+		{"already-applied", []string{"SUMMER10"}, "SUMMER10", "[SUMMER10]", "",
+			"The promo code SUMMER10 was already applied"},
+		{"unknown", nil, "NOPE", "[]", "", "The promo code NOPE doesn't exist"},
+		{"other-room", nil, "MAJOR5", "[]", "", "The promo code MAJOR5 isn't valid for this room"},
+		{"empty", nil, "", "[]", "", "Enter a promo code"},
+	}
+
+	for _, test := range tests {
+		postedData := url.Values{"promo_code": {test.code}}
+		req, _ := http.NewRequest("POST", "/make-reservation/promo", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		session.Put(ctx, "reservation", reservation)
+		if test.applied != nil {
+			session.Put(ctx, "promo_codes", test.applied)
+		}
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.PostPromoCode)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/make-reservation" {
+			t.Errorf("For %s, expected a redirect to the reservation form, got code %d", test.name, rr.Code)
+		}
+		codes, _ := session.Get(ctx, "promo_codes").([]string)
+		if fmt.Sprint(codes) != test.expectedCodes {
+			t.Errorf("For %s, expected the codes %s to be applied, got %v", test.name, test.expectedCodes, codes)
+		}
+		if flash := session.PopString(ctx, "flash"); flash != test.expectedFlash {
+			t.Errorf("For %s, expected flash %q but got %q", test.name, test.expectedFlash, flash)
+		}
+		if e := session.PopString(ctx, "error"); e != test.expectedError {
+			t.Errorf("For %s, expected error %q but got %q", test.name, test.expectedError, e)
+		}
+	}
+}
+
+func TestRepository_PostRemovePromoCode(t *testing.T) {
+	postedData := url.Values{"promo_code": {"longstay"}}
+	req, _ := http.NewRequest("POST", "/make-reservation/promo/remove", strings.NewReader(postedData.Encode()))
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	session.Put(ctx, "promo_codes", []string{"SUMMER10", "LONGSTAY"})
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(Repo.PostRemovePromoCode)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("Expected code %d but got %d", http.StatusSeeOther, rr.Code)
+	}
+	if codes := session.Get(ctx, "promo_codes").([]string); fmt.Sprint(codes) != "[SUMMER10]" {
+		t.Errorf("Expected only SUMMER10 to stay applied, got %v", codes)
+	}
+}
+
+func TestRepository_PostReservation_PromoCodes(t *testing.T) {
+	reservation := models.Reservation{
+		RoomID:    1,
+		Room:      models.Room{ID: 1, RoomName: "General's Quarters"},
+		StartDate: time.Now(),
+		EndDate:   time.Now(),
+		Adults:    1,
+		Quote:     models.Quote{RoomID: 1, Guests: 1},
+	}
+
+	var tests = []struct {
+		name          string
+		code          string
+		email         string
+		expectedCode  int
+		expectedError string
+	}{
+		{"redeemed", "SUMMER10", "john@smith.com", http.StatusSeeOther, ""},
+		// ONCE was already redeemed by john@smith.com.
+		{"used-by-email", "ONCE", "John@Smith.com", http.StatusOK,
+			"The promo code ONCE was already used with this email"},
+		{"other-email", "ONCE", "jane@smith.com", http.StatusSeeOther, ""},
+		{"used-up-meanwhile", "LASTONE", "john@smith.com", http.StatusOK, "One of your promo codes was just used up"},
+	}
+
+	for _, test := range tests {
+		postedData := url.Values{"first_name": {"John"}, "last_name": {"Smith"}, "email": {test.email},
+			"phone": {"123456789"}}
+		req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		session.Put(ctx, "reservation", reservation)
+		session.Put(ctx, "promo_codes", []string{test.code})
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.PostReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != test.expectedCode {
+			t.Errorf("For %s, expected code %d but got %d", test.name, test.expectedCode, rr.Code)
+		}
+		if test.expectedError != "" && !strings.Contains(rr.Body.String(), test.expectedError) {
+			t.Errorf("For %s, expected the form to show %q", test.name, test.expectedError)
+		}
+		if test.expectedCode != http.StatusSeeOther {
+			continue
+		}
+
+		booked := session.Get(ctx, "reservation").(models.Reservation)
+		if codes := booked.Quote.PromoCodes(); fmt.Sprint(codes) != fmt.Sprintf("[%s]", test.code) {
+			t.Errorf("For %s, expected %s to be redeemed, got %v", test.name, test.code, codes)
+		}
+		if session.Exists(ctx, "promo_codes") {
+			t.Errorf("For %s, expected the promo codes to be cleared once redeemed", test.name)
+		}
+	}
+}
+
+func TestRepository_AdminPostPromoCode(t *testing.T) {
+	valid := url.Values{"code": {"winter15"}, "kind": {"percent"}, "amount": {"15"}, "valid_from": {"2050-01-01"},
+		"valid_until": {"2050-03-31"}, "min_nights": {"2"}, "room_ids": {"1", "2"}, "stackable": {"true"},
+		"active": {"true"}}
+	with := func(field, value string) url.Values {
+		values := url.Values{}
+		for key, value := range valid {
+			values[key] = value
+		}
+		values.Set(field, value)
+		return values
+	}
+
+	var tests = []struct {
+		name          string
+		postedData    url.Values
+		expectedCode  int
+		expectedError string
+	}{
+		{"valid", valid, http.StatusSeeOther, ""},
+		{"fixed-amount", with("kind", "fixed"), http.StatusSeeOther, ""},
+		{"code-taken", with("code", "Summer10"), http.StatusOK, "Another promo code already has this code"},
+		{"code-with-spaces", with("code", "WINTER 15"), http.StatusOK, "Codes can&#39;t contain spaces"},
+		{"unknown-kind", with("kind", "free"), http.StatusOK, "Choose whether the discount"},
+		{"percentage-too-high", with("amount", "150"), http.StatusOK, "This field must be a whole number"},
+		{"invalid-amount", with("amount", "ten"), http.StatusOK, "This field must be a whole number"},
+		{"ends-before-it-starts", with("valid_until", "2049-12-31"), http.StatusOK,
+			"The last day can&#39;t be before the first one"},
+		{"unknown-room", with("room_ids", "9"), http.StatusOK, "Unknown room"},
+		{"negative-uses", with("max_uses", "-1"), http.StatusOK, "This field must be a whole number"},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest("POST", "/admin/promo-codes", strings.NewReader(test.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminPostPromoCode)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != test.expectedCode {
+			t.Errorf("For %s, expected code %d but got %d", test.name, test.expectedCode, rr.Code)
+		}
+		if test.expectedError != "" && !strings.Contains(rr.Body.String(), test.expectedError) {
+			t.Errorf("For %s, expected the form to show %q", test.name, test.expectedError)
+		}
+	}
+}
+
+func TestRepository_AdminPromoCodeUsage(t *testing.T) {
+	var tests = []struct {
+		name           string
+		id             string
+		expectedCode   int
+		expectedInBody string
+	}{
+		{"redeemed", "5", http.StatusOK, "LB-7K3Q9X"},
+		{"never-redeemed", "1", http.StatusOK, "Nobody redeemed this code yet"},
+		{"non-existent", "99", http.StatusNotFound, ""},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", "/admin/promo-codes/"+test.id, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(withURLParams(ctx, map[string]string{"id": test.id}))
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminPromoCodeUsage)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != test.expectedCode {
+			t.Errorf("For %s, expected code %d but got %d", test.name, test.expectedCode, rr.Code)
+		}
+		if !strings.Contains(rr.Body.String(), test.expectedInBody) {
+			t.Errorf("For %s, expected the page to show %q", test.name, test.expectedInBody)
+		}
+	}
+}
+
+func TestRepository_AdminPostPromoCodeActive(t *testing.T) {
+	auditor, ok := Repo.DB.(interface{ AuditEvents() []models.AuditEvent })
+	if !ok {
+		t.Fatal("test repo does not keep audit events")
+	}
+
+	// Every test promo code is active.
+	var tests = []struct {
+		name            string
+		id              string
+		active          string
+		expectedCode    int
+		expectedChanges string
+	}{
+		{"deactivated", "1", "false", http.StatusSeeOther, `{"Active":{"before":true,"after":false}}`},
+		{"already-active", "1", "true", http.StatusSeeOther, ""},
+		{"non-existent", "99", "false", http.StatusNotFound, ""},
+	}
+
+	for _, test := range tests {
+		eventsBefore := len(auditor.AuditEvents())
+		postedData := url.Values{"active": {test.active}}
+		req, _ := http.NewRequest("POST", "/admin/promo-codes/"+test.id+"/active",
+			strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(withURLParams(ctx, map[string]string{"id": test.id}))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminPostPromoCodeActive)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != test.expectedCode {
+			t.Errorf("For %s, expected code %d but got %d", test.name, test.expectedCode, rr.Code)
+		}
+		events := auditor.AuditEvents()[eventsBefore:]
+		switch {
+		case test.expectedChanges == "" && len(events) != 0:
+			t.Errorf("For %s, expected nothing to be audited, got %v", test.name, events)
+		case test.expectedChanges != "" && (len(events) != 1 || events[0].Changes != test.expectedChanges):
+			t.Errorf("For %s, expected the change %s to be audited, got %v", test.name, test.expectedChanges, events)
+		}
 	}
-	return ctx
 }
@@ -7,8 +7,10 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/nambroa/lodging-bookings/internal/config"
+	"github.com/nambroa/lodging-bookings/internal/helpers"
 	"github.com/nambroa/lodging-bookings/internal/models"
 	"github.com/nambroa/lodging-bookings/internal/render"
+	"github.com/nambroa/lodging-bookings/internal/storage"
 	"html/template"
 	"log"
 	"net/http"
@@ -20,12 +22,22 @@ import (
 
 var app config.AppConfig
 var session *scs.SessionManager
-var functions = template.FuncMap{}
+var functions = template.FuncMap{
+	"humanDate":   render.HumanDate,
+	"formatDate":  render.FormatDate,
+	"iterate":     render.Iterate,
+	"add":         render.Add,
+	"formatMoney": render.FormatMoney,
+	"uploadURL":   render.UploadURL,
+	"srcset":      render.Srcset,
+}
 var pathToTemplates = "../../templates" // Changed from base definition since tests are executed in a different package.
 
 func TestMain(m *testing.M) {
 	// Types that will be stored in the session object (encoded in the session object).
 	gob.Register(models.Reservation{})
+	gob.Register(models.Cart{})
+	gob.Register(models.ReservationGroup{})
 
 	// Change this to true when in production.
 	app.InProduction = false
@@ -41,6 +53,13 @@ func TestMain(m *testing.M) {
 	session.Cookie.Secure = app.InProduction
 	app.Session = session
 
+	// Uploads are kept in a temporary directory, removed once the tests are done.
+	uploadDir, err := os.MkdirTemp("", "uploads")
+	if err != nil {
+		log.Fatal("cannot create upload directory")
+	}
+	app.Storage = storage.NewLocalStorage(uploadDir, "/uploads")
+
 	mailChan := make(chan models.MailData)
 	app.Mailchan = mailChan
 	defer close(mailChan)
@@ -58,8 +77,11 @@ func TestMain(m *testing.M) {
 	NewHandlers(repo)
 
 	render.NewRenderer(&app)
+	helpers.NewHelpers(&app)
 
-	os.Exit(m.Run())
+	code := m.Run()
+	os.RemoveAll(uploadDir)
+	os.Exit(code)
 }
 
 func listenForMail() {
@@ -82,8 +104,8 @@ func getRoutes() http.Handler {
 	// Routing
 	mux.Get("/", Repo.Home)
 	mux.Get("/about", Repo.About)
-	mux.Get("/generals-quarters", Repo.Generals)
-	mux.Get("/majors-suite", Repo.Majors)
+	mux.Get("/rooms", Repo.Rooms)
+	mux.Get("/rooms/{slug}", Repo.ShowRoom)
 	mux.Get("/contact", Repo.Contact)
 
 	mux.Get("/search-availability", Repo.Availability)
@@ -94,6 +116,8 @@ func getRoutes() http.Handler {
 	mux.Post("/make-reservation", Repo.PostReservation)
 	mux.Get("/reservation-summary", Repo.ReservationSummary)
 
+	mux.Get("/my/login", Repo.GuestLogin)
+
 	// Fileserver to go get static files
 	fileServer := http.FileServer(http.Dir("./static/"))
 	mux.Handle("/static/*", http.StripPrefix("/static", fileServer))
@@ -1,10 +1,17 @@
 package helpers
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"github.com/nambroa/lodging-bookings/internal/config"
+	"math/big"
+	"net"
 	"net/http"
 	"runtime/debug"
+	"strings"
 )
 
 var app *config.AppConfig
@@ -32,3 +39,80 @@ func IsAuthenticated(request *http.Request) bool {
 	exists := app.Session.Exists(request.Context(), "user_id")
 	return exists
 }
+
+// ClientIP returns the IP address of the client that made the request, without the port.
+func ClientIP(request *http.Request) string {
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		return request.RemoteAddr
+	}
+	return host
+}
+
+// DeviceFromUserAgent returns a short, human readable description (browser on OS) of a User-Agent header.
+func DeviceFromUserAgent(userAgent string) string {
+	browser := "Unknown browser"
+	// Order matters, since most user agents also include the names of the browsers they are based on.
+	for _, b := range []struct{ token, name string }{
+		{"Edg/", "Edge"}, {"OPR/", "Opera"}, {"Firefox/", "Firefox"}, {"Chrome/", "Chrome"}, {"Safari/", "Safari"},
+		{"curl/", "curl"},
+	} {
+		if strings.Contains(userAgent, b.token) {
+			browser = b.name
+			break
+		}
+	}
+
+	os := "unknown OS"
+	for _, o := range []struct{ token, name string }{
+		{"Android", "Android"}, {"iPhone", "iOS"}, {"iPad", "iPadOS"}, {"Windows", "Windows"},
+		{"Mac OS X", "macOS"}, {"Linux", "Linux"},
+	} {
+		if strings.Contains(userAgent, o.token) {
+			os = o.name
+			break
+		}
+	}
+	return fmt.Sprintf("%s on %s", browser, os)
+}
+
+// GenerateToken returns a random, url safe token. Used for links that grant access without a password.
+func GenerateToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashToken returns the sha256 of a token. Only the hash is stored in the DB, so a leaked table can't be used to log in.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// confirmationCodeAlphabet leaves out characters that are easy to mix up when read out loud or typed (0/O, 1/I/L).
+const confirmationCodeAlphabet = "23456789ABCDEFGHJKMNPQRSTUVWXYZ"
+
+// GenerateConfirmationCode returns a random, human-friendly reservation confirmation code, for example LB-7K3Q9X.
+func GenerateConfirmationCode() (string, error) {
+	b := make([]byte, 6)
+	for i := range b {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(confirmationCodeAlphabet))))
+		if err != nil {
+			return "", err
+		}
+		b[i] = confirmationCodeAlphabet[n.Int64()]
+	}
+	return "LB-" + string(b), nil
+}
+
+// NormalizeConfirmationCode turns a code typed by a user (lowercase, missing prefix, spaces) into its stored form.
+func NormalizeConfirmationCode(code string) string {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if !strings.HasPrefix(code, "LB-") {
+		code = "LB-" + strings.TrimPrefix(code, "LB")
+	}
+	return code
+}
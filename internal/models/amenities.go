@@ -0,0 +1,45 @@
+package models
+
+import "time"
+
+// AmenityIcons are the icons of the vendored themify font an amenity can be shown with, by class name.
+var AmenityIcons = []string{
+	"ti-home", "ti-wheelchair", "ti-heart", "ti-shine", "ti-cloud", "ti-car", "ti-cup", "ti-key", "ti-lock",
+	"ti-bell", "ti-desktop", "ti-signal", "ti-plug", "ti-bolt", "ti-anchor", "ti-map", "ti-location-pin",
+	"ti-briefcase", "ti-book", "ti-music", "ti-video-camera", "ti-camera", "ti-gift", "ti-star", "ti-shield",
+	"ti-support", "ti-face-smile", "ti-paint-bucket", "ti-alarm-clock", "ti-basketball",
+}
+
+// Amenity is something a room offers, like a balcony or being pet-friendly. Guests can search for rooms having
+// some amenities.
+type Amenity struct {
+	ID        int
+	Name      string
+	Icon      string // class of the amenity's icon, one of AmenityIcons.
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// IsAmenityIcon returns true if icon is one of AmenityIcons.
+func IsAmenityIcon(icon string) bool {
+	for _, i := range AmenityIcons {
+		if i == icon {
+			return true
+		}
+	}
+	return false
+}
+
+// HasAmenities returns true if the room has all the amenities with the given ids.
+func (r Room) HasAmenities(ids []int) bool {
+	for _, id := range ids {
+		found := false
+		for _, amenity := range r.Amenities {
+			found = found || amenity.ID == id
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,46 @@
+package models
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestAmenityIcons(t *testing.T) {
+	css, err := os.ReadFile("../../static/admin/vendors/ti-icons/css/themify-icons.css")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, icon := range AmenityIcons {
+		if !strings.Contains(string(css), "."+icon+":before") {
+			t.Errorf("%s is not an icon of the vendored themify font", icon)
+		}
+		if !IsAmenityIcon(icon) {
+			t.Errorf("Expected %s to be an amenity icon", icon)
+		}
+	}
+	if IsAmenityIcon("ti-unknown") {
+		t.Error("Expected ti-unknown not to be an amenity icon")
+	}
+}
+
+func TestRoom_HasAmenities(t *testing.T) {
+	room := Room{Amenities: []Amenity{{ID: 1, Name: "Ensuite"}, {ID: 2, Name: "Balcony"}}}
+
+	var tests = []struct {
+		name     string
+		ids      []int
+		expected bool
+	}{
+		{"none", nil, true},
+		{"one", []int{2}, true},
+		{"all", []int{1, 2}, true},
+		{"missing", []int{1, 3}, false},
+	}
+
+	for _, test := range tests {
+		if room.HasAmenities(test.ids) != test.expected {
+			t.Errorf("For %s, expected %t", test.name, test.expected)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// BookingWindow holds the rules of the property on how soon and how far ahead of the arrival stays can be booked.
+type BookingWindow struct {
+	MinLeadDays    int // days between the booking and the arrival, 0 to take same-day arrivals.
+	SameDayCutoff  int // hour of the day from which same-day arrivals aren't taken anymore, 0 for no cutoff.
+	MaxAdvanceDays int // how many days ahead of the arrival stays can be booked at most, 0 for no limit.
+}
+
+// FirstArrival returns the earliest arrival of a stay booked at now.
+func (w BookingWindow) FirstArrival(now time.Time) time.Time {
+	first := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, w.MinLeadDays)
+	if w.MinLeadDays == 0 && w.SameDayCutoff > 0 && now.Hour() >= w.SameDayCutoff {
+		first = first.AddDate(0, 0, 1)
+	}
+	return first
+}
+
+// LastArrival returns the latest arrival of a stay booked at now, or the zero time when there's no limit.
+func (w BookingWindow) LastArrival(now time.Time) time.Time {
+	if w.MaxAdvanceDays == 0 {
+		return time.Time{}
+	}
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, w.MaxAdvanceDays)
+}
+
+// Violation checks the arrival of a stay booked at now against the booking window, and explains why it can't be
+// booked when it's outside of it.
+func (w BookingWindow) Violation(arrival, now time.Time) (string, bool) {
+	leadDays := DaysBetween(now, arrival)
+	switch {
+	case leadDays < 0:
+		return "The arrival can't be in the past", true
+	case leadDays < w.MinLeadDays:
+		return fmt.Sprintf("Stays must be booked at least %d days before the arrival", w.MinLeadDays), true
+	case DaysBetween(w.FirstArrival(now), arrival) < 0:
+		return fmt.Sprintf("Same-day arrivals can only be booked until %s", formatHour(w.SameDayCutoff)), true
+	case w.MaxAdvanceDays > 0 && leadDays > w.MaxAdvanceDays:
+		return fmt.Sprintf("Stays can be booked at most %d days before the arrival", w.MaxAdvanceDays), true
+	}
+	return "", false
+}
+
+// formatHour formats an hour of the day for guests, for example 18 as "6pm".
+func formatHour(hour int) string {
+	switch {
+	case hour == 0:
+		return "midnight"
+	case hour < 12:
+		return fmt.Sprintf("%dam", hour)
+	case hour == 12:
+		return "noon"
+	}
+	return fmt.Sprintf("%dpm", hour-12)
+}
@@ -0,0 +1,54 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBookingWindow_Violation(t *testing.T) {
+	window := BookingWindow{SameDayCutoff: 18, MaxAdvanceDays: 365}
+	morning := time.Date(2050, 1, 10, 9, 30, 0, 0, time.UTC)
+	evening := time.Date(2050, 1, 10, 18, 5, 0, 0, time.UTC)
+
+	var tests = []struct {
+		name      string
+		window    BookingWindow
+		arrival   time.Time
+		now       time.Time
+		violation string
+	}{
+		{"same-day-morning", window, time.Date(2050, 1, 10, 0, 0, 0, 0, time.UTC), morning, ""},
+		{"same-day-evening", window, time.Date(2050, 1, 10, 0, 0, 0, 0, time.UTC), evening,
+			"Same-day arrivals can only be booked until 6pm"},
+		{"next-day-evening", window, time.Date(2050, 1, 11, 0, 0, 0, 0, time.UTC), evening, ""},
+		{"past", window, time.Date(2050, 1, 9, 0, 0, 0, 0, time.UTC), morning, "The arrival can't be in the past"},
+		{"last-day", window, time.Date(2051, 1, 10, 0, 0, 0, 0, time.UTC), morning, ""},
+		{"too-far-ahead", window, time.Date(2051, 1, 11, 0, 0, 0, 0, time.UTC), morning,
+			"Stays can be booked at most 365 days before the arrival"},
+		{"short-lead-time", BookingWindow{MinLeadDays: 2}, time.Date(2050, 1, 11, 0, 0, 0, 0, time.UTC), morning,
+			"Stays must be booked at least 2 days before the arrival"},
+		{"no-limits", BookingWindow{}, time.Date(2099, 1, 11, 0, 0, 0, 0, time.UTC), evening, ""},
+	}
+
+	for _, test := range tests {
+		violation, broken := test.window.Violation(test.arrival, test.now)
+		if violation != test.violation || broken != (test.violation != "") {
+			t.Errorf("For %s, expected violation %q but got %q", test.name, test.violation, violation)
+		}
+	}
+}
+
+func TestBookingWindow_Arrivals(t *testing.T) {
+	window := BookingWindow{MinLeadDays: 1, MaxAdvanceDays: 30}
+	now := time.Date(2050, 1, 10, 9, 30, 0, 0, time.UTC)
+
+	if first := window.FirstArrival(now); !first.Equal(time.Date(2050, 1, 11, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected the first arrival to be the next day, got %s", first)
+	}
+	if last := window.LastArrival(now); !last.Equal(time.Date(2050, 2, 9, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected the last arrival to be 30 days later, got %s", last)
+	}
+	if last := (BookingWindow{}).LastArrival(now); !last.IsZero() {
+		t.Errorf("Expected no last arrival without a maximum advance, got %s", last)
+	}
+}
@@ -0,0 +1,53 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// CancellationPolicy is a cancellation_policies model. Its tiers define how much of a reservation is refunded,
+// depending on how long before the arrival it is cancelled.
+type CancellationPolicy struct {
+	ID        int
+	Name      string
+	Tiers     []CancellationTier
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// CancellationTier is a cancellation_policy_tiers model: RefundPercent is refundable until DaysBeforeArrival days
+// before the arrival.
+type CancellationTier struct {
+	ID                   int
+	CancellationPolicyID int
+	DaysBeforeArrival    int
+	RefundPercent        int
+	CreatedAt            time.Time
+	UpdatedAt            time.Time
+}
+
+// String describes the tier to guests, for example "50% refundable until 7 days before arrival".
+func (t CancellationTier) String() string {
+	return fmt.Sprintf("%d%% refundable until %d days before arrival", t.RefundPercent, t.DaysBeforeArrival)
+}
+
+// RefundPercent returns the percentage of the reservation that is refunded when it is cancelled at cancelledAt.
+// The most generous tier that still applies wins. Nothing is refunded once no tier applies.
+func (p CancellationPolicy) RefundPercent(arrival, cancelledAt time.Time) int {
+	daysBefore := DaysBetween(cancelledAt, arrival)
+
+	refund := 0
+	for _, tier := range p.Tiers {
+		if daysBefore >= tier.DaysBeforeArrival && tier.RefundPercent > refund {
+			refund = tier.RefundPercent
+		}
+	}
+	return refund
+}
+
+// DaysBetween returns the number of calendar days from one date to another, ignoring the time of day.
+func DaysBetween(from, to time.Time) int {
+	fromDay := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.UTC)
+	toDay := time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, time.UTC)
+	return int(toDay.Sub(fromDay).Hours() / 24)
+}
@@ -0,0 +1,41 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCancellationPolicy_RefundPercent(t *testing.T) {
+	moderate := CancellationPolicy{Name: "Moderate", Tiers: []CancellationTier{
+		{DaysBeforeArrival: 5, RefundPercent: 100},
+		{DaysBeforeArrival: 1, RefundPercent: 50},
+	}}
+	arrival := time.Date(2050, 1, 10, 0, 0, 0, 0, time.UTC)
+
+	var tests = []struct {
+		name        string
+		cancelledAt time.Time
+		expected    int
+	}{
+		{"well-before-arrival", time.Date(2049, 12, 1, 18, 0, 0, 0, time.UTC), 100},
+		{"exactly-five-days-before", time.Date(2050, 1, 5, 23, 59, 0, 0, time.UTC), 100},
+		{"four-days-before", time.Date(2050, 1, 6, 8, 0, 0, 0, time.UTC), 50},
+		{"day-before", time.Date(2050, 1, 9, 8, 0, 0, 0, time.UTC), 50},
+		{"arrival-day", time.Date(2050, 1, 10, 8, 0, 0, 0, time.UTC), 0},
+		{"after-arrival", time.Date(2050, 1, 12, 8, 0, 0, 0, time.UTC), 0},
+	}
+
+	for _, test := range tests {
+		refund := moderate.RefundPercent(arrival, test.cancelledAt)
+		if refund != test.expected {
+			t.Errorf("For %s, expected %d%% refund but got %d%%", test.name, test.expected, refund)
+		}
+	}
+}
+
+func TestCancellationPolicy_RefundPercent_NoTiers(t *testing.T) {
+	var nonRefundable CancellationPolicy
+	if refund := nonRefundable.RefundPercent(time.Now().AddDate(1, 0, 0), time.Now()); refund != 0 {
+		t.Errorf("Expected a policy without tiers to refund nothing, got %d%%", refund)
+	}
+}
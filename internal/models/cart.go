@@ -0,0 +1,46 @@
+package models
+
+import "time"
+
+// CartItem is a room and dates a guest put in their booking cart, held for them until they check out.
+type CartItem struct {
+	Reservation Reservation // room, dates, guests and quote of the stay.
+	HoldID      int
+}
+
+// Cart is the stays a guest is booking together, kept in their session until they are confirmed at once.
+type Cart struct {
+	Items []CartItem
+}
+
+// Total returns the price of all the stays in the cart, in cents.
+func (c Cart) Total() int {
+	total := 0
+	for _, item := range c.Items {
+		total += item.Reservation.Quote.Total
+	}
+	return total
+}
+
+// ReservationGroup is a reservation_groups model: reservations of several rooms booked together by a guest, confirmed
+// at once under one confirmation code. Every reservation of the group still has its own confirmation code.
+type ReservationGroup struct {
+	ID               int
+	ConfirmationCode string
+	FirstName        string
+	LastName         string
+	Email            string
+	Phone            string
+	Reservations     []Reservation
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+}
+
+// Total returns the price of all the reservations of the group, in cents.
+func (g ReservationGroup) Total() int {
+	total := 0
+	for _, res := range g.Reservations {
+		total += res.Quote.Total
+	}
+	return total
+}
@@ -0,0 +1,23 @@
+package models
+
+import "testing"
+
+func TestCart_Total(t *testing.T) {
+	cart := Cart{Items: []CartItem{
+		{Reservation: Reservation{Quote: Quote{Total: 25000}}, HoldID: 1},
+		{Reservation: Reservation{Quote: Quote{Total: 18000}}, HoldID: 2},
+	}}
+	if total := cart.Total(); total != 43000 {
+		t.Errorf("Expected a cart total of 43000, got %d", total)
+	}
+	if total := (Cart{}).Total(); total != 0 {
+		t.Errorf("Expected an empty cart to cost nothing, got %d", total)
+	}
+}
+
+func TestReservationGroup_Total(t *testing.T) {
+	group := ReservationGroup{Reservations: []Reservation{{Quote: Quote{Total: 25000}}, {Quote: Quote{Total: 18000}}}}
+	if total := group.Total(); total != 43000 {
+		t.Errorf("Expected a group total of 43000, got %d", total)
+	}
+}
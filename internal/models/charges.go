@@ -0,0 +1,81 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// The kinds of charges added to the price of the nights.
+const (
+	ChargeTax = "tax"
+	ChargeFee = "fee"
+)
+
+// How the amount of a charge is calculated.
+const (
+	// ChargePercent charges are a percentage of the price of the nights, Amount is in hundredths of a percent.
+	ChargePercent = "percent"
+	// ChargeFixed charges are a fixed Amount in cents, per stay, night or guest.
+	ChargeFixed = "fixed"
+)
+
+// What a fixed charge is counted per.
+const (
+	PerStay  = "stay"
+	PerNight = "night"
+	PerGuest = "guest"
+)
+
+// Charge is a charges model: a tax or a fee added to the quote of every stay while it's in effect. StartDate and
+// EndDate are the first and last days it's in effect, either can be zero for no limit.
+type Charge struct {
+	ID          int
+	Name        string
+	Kind        string
+	Calculation string
+	Per         string
+	Amount      int
+	StartDate   time.Time
+	EndDate     time.Time
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// EffectiveOn returns true if the charge is in effect on the given day.
+func (c Charge) EffectiveOn(day time.Time) bool {
+	if !c.StartDate.IsZero() && DaysBetween(c.StartDate, day) < 0 {
+		return false
+	}
+	if !c.EndDate.IsZero() && DaysBetween(day, c.EndDate) < 0 {
+		return false
+	}
+	return true
+}
+
+// String describes how the charge is calculated, for example "5.5% of the room price" or "$2.50 per night".
+func (c Charge) String() string {
+	if c.Calculation == ChargePercent {
+		return fmt.Sprintf("%s%% of the room price", trimZeros(fmt.Sprintf("%d.%02d", c.Amount/100, c.Amount%100)))
+	}
+	return fmt.Sprintf("$%d.%02d per %s", c.Amount/100, c.Amount%100, c.Per)
+}
+
+// trimZeros removes the trailing zeros of a decimal number, and its point if nothing is left after it.
+func trimZeros(number string) string {
+	for number[len(number)-1] == '0' {
+		number = number[:len(number)-1]
+	}
+	if number[len(number)-1] == '.' {
+		number = number[:len(number)-1]
+	}
+	return number
+}
+
+// LineItem is a reservation_line_items model: a tax or fee charged on a quote, as it was when the stay was booked.
+type LineItem struct {
+	ID            int
+	ReservationID int
+	Name          string
+	Kind          string
+	Amount        int
+}
@@ -0,0 +1,80 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// What the price of an extra is counted per.
+const (
+	// ExtraPerStay extras are charged once, like a late checkout. They use their inventory on the departure day.
+	ExtraPerStay = "stay"
+	// ExtraPerNight extras are charged every night, like a parking space.
+	ExtraPerNight = "night"
+	// ExtraPerGuestNight extras are charged for every guest every night, like breakfast. They are booked for all the
+	// guests of the stay, so their quantity is the number of guests.
+	ExtraPerGuestNight = "guest-night"
+)
+
+// Extra is an extras model: something guests can add to their stay when they book it, like breakfast or parking.
+// Limited extras only have Inventory units a day, shared by all the stays.
+type Extra struct {
+	ID        int
+	Name      string
+	Per       string
+	Price     int  // in cents, per Per.
+	Inventory int  // units available every day, 0 for unlimited.
+	Active    bool // inactive extras aren't offered anymore, reservations keep the ones they booked.
+	Remaining int  // units left on every day of a stay, only set when the extras are loaded for one.
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Limited returns true if the extra only has a number of units a day.
+func (e Extra) Limited() bool {
+	return e.Inventory > 0
+}
+
+// SoldOut returns true if a limited extra has no units left for the stay it was loaded for.
+func (e Extra) SoldOut() bool {
+	return e.Limited() && e.Remaining < 1
+}
+
+// String describes the price of the extra, for example "$15.00 per guest per night".
+func (e Extra) String() string {
+	per := e.Per
+	if e.Per == ExtraPerGuestNight {
+		per = "guest per night"
+	}
+	return fmt.Sprintf("$%d.%02d per %s", e.Price/100, e.Price%100, per)
+}
+
+// ReservationExtra is a reservation_extras model: an extra booked with a stay, with its price when it was booked.
+type ReservationExtra struct {
+	ID            int
+	ReservationID int
+	ExtraID       int
+	Name          string
+	Per           string
+	Quantity      int
+	Amount        int
+}
+
+// ExtraQuantities returns the quantity of every extra booked with the quote, by extra id.
+func (q Quote) ExtraQuantities() map[int]int {
+	quantities := make(map[int]int)
+	for _, extra := range q.Extras {
+		quantities[extra.ExtraID] += extra.Quantity
+	}
+	return quantities
+}
+
+// ExtraDays returns the first and last days, both included, an extra uses its inventory on during a stay from start
+// to end, given what its price is counted per. Per stay extras use it on the departure day, the others on every night
+// of the stay.
+func ExtraDays(per string, start, end time.Time) (time.Time, time.Time) {
+	if per == ExtraPerStay {
+		return end, end
+	}
+	return start, end.AddDate(0, 0, -1)
+}
@@ -0,0 +1,64 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExtra_String(t *testing.T) {
+	var tests = []struct {
+		extra    Extra
+		expected string
+	}{
+		{Extra{Per: ExtraPerGuestNight, Price: 1500}, "$15.00 per guest per night"},
+		{Extra{Per: ExtraPerNight, Price: 1050}, "$10.50 per night"},
+		{Extra{Per: ExtraPerStay, Price: 2500}, "$25.00 per stay"},
+	}
+
+	for _, test := range tests {
+		if description := test.extra.String(); description != test.expected {
+			t.Errorf("Expected %q but got %q", test.expected, description)
+		}
+	}
+}
+
+func TestExtra_SoldOut(t *testing.T) {
+	var tests = []struct {
+		name     string
+		extra    Extra
+		expected bool
+	}{
+		{"unlimited", Extra{}, false},
+		{"units left", Extra{Inventory: 5, Remaining: 1}, false},
+		{"no units left", Extra{Inventory: 5}, true},
+	}
+
+	for _, test := range tests {
+		if soldOut := test.extra.SoldOut(); soldOut != test.expected {
+			t.Errorf("For an extra with %s, expected sold out to be %t", test.name, test.expected)
+		}
+	}
+}
+
+func TestQuote_ExtraQuantities(t *testing.T) {
+	quote := Quote{Extras: []ReservationExtra{{ExtraID: 1, Quantity: 2}, {ExtraID: 3, Quantity: 1}}}
+
+	quantities := quote.ExtraQuantities()
+	if len(quantities) != 2 || quantities[1] != 2 || quantities[3] != 1 {
+		t.Errorf("Expected 2 of extra 1 and 1 of extra 3, got %v", quantities)
+	}
+}
+
+func TestExtraDays(t *testing.T) {
+	start := time.Date(2050, 1, 6, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 0, 3)
+
+	first, last := ExtraDays(ExtraPerNight, start, end)
+	if !first.Equal(start) || !last.Equal(end.AddDate(0, 0, -1)) {
+		t.Errorf("Expected a per night extra to use the nights of the stay, got %v to %v", first, last)
+	}
+	first, last = ExtraDays(ExtraPerStay, start, end)
+	if !first.Equal(end) || !last.Equal(end) {
+		t.Errorf("Expected a per stay extra to use the departure day, got %v to %v", first, last)
+	}
+}
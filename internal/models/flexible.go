@@ -0,0 +1,87 @@
+package models
+
+import (
+	"sort"
+	"time"
+)
+
+// FlexibleMonth is the flexibility of a search for any stay of a number of nights in a month, rather than for stays
+// around given dates.
+const FlexibleMonth = "month"
+
+// DateWindow is a stay from Start to End that a flexible search looks at, Shift days away from the stay the guest
+// searched for.
+type DateWindow struct {
+	Start time.Time
+	End   time.Time
+	Shift int // days the arrival moved, negative when it's earlier.
+}
+
+// Distance returns how many days the arrival of the window moved from the one the guest searched for.
+func (w DateWindow) Distance() int {
+	if w.Shift < 0 {
+		return -w.Shift
+	}
+	return w.Shift
+}
+
+// NearbyWindows returns the stays as long as the one from start to end, arriving up to days before or after it,
+// nearest first and earlier first when as near. The stay itself is left out.
+func NearbyWindows(start, end time.Time, days int) []DateWindow {
+	var windows []DateWindow
+	for distance := 1; distance <= days; distance++ {
+		for _, shift := range []int{-distance, distance} {
+			windows = append(windows, DateWindow{
+				Start: start.AddDate(0, 0, shift),
+				End:   end.AddDate(0, 0, shift),
+				Shift: shift,
+			})
+		}
+	}
+	return windows
+}
+
+// MonthWindows returns every stay of the given nights arriving in the month of month, in order. None of them is
+// nearer than another to what the guest searched for.
+func MonthWindows(month time.Time, nights int) []DateWindow {
+	var windows []DateWindow
+	first := time.Date(month.Year(), month.Month(), 1, 0, 0, 0, 0, time.UTC)
+	for start := first; start.Month() == first.Month(); start = start.AddDate(0, 0, 1) {
+		windows = append(windows, DateWindow{Start: start, End: start.AddDate(0, 0, nights)})
+	}
+	return windows
+}
+
+// Alternative is a room free for another stay than the one the guest searched for, with what that stay would cost.
+type Alternative struct {
+	Room   Room
+	Window DateWindow
+	Quote  Quote
+}
+
+// RankAlternatives sorts alternatives nearest first, then cheapest first, then earliest first, and keeps at most
+// perRoom of each room.
+func RankAlternatives(alternatives []Alternative, perRoom int) []Alternative {
+	sorted := append([]Alternative(nil), alternatives...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.Window.Distance() != b.Window.Distance() {
+			return a.Window.Distance() < b.Window.Distance()
+		}
+		if a.Quote.Total != b.Quote.Total {
+			return a.Quote.Total < b.Quote.Total
+		}
+		return a.Window.Start.Before(b.Window.Start)
+	})
+
+	var ranked []Alternative
+	kept := make(map[int]int)
+	for _, alternative := range sorted {
+		if kept[alternative.Room.ID] == perRoom {
+			continue
+		}
+		kept[alternative.Room.ID]++
+		ranked = append(ranked, alternative)
+	}
+	return ranked
+}
@@ -0,0 +1,62 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNearbyWindows(t *testing.T) {
+	start := time.Date(2050, 1, 10, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 0, 2)
+
+	windows := NearbyWindows(start, end, 2)
+	expectedShifts := []int{-1, 1, -2, 2}
+	if len(windows) != len(expectedShifts) {
+		t.Fatalf("Expected %d windows but got %d", len(expectedShifts), len(windows))
+	}
+	for i, window := range windows {
+		if window.Shift != expectedShifts[i] {
+			t.Errorf("Expected window %d to move %d days but it moved %d", i, expectedShifts[i], window.Shift)
+		}
+		if !window.Start.Equal(start.AddDate(0, 0, window.Shift)) || DaysBetween(window.Start, window.End) != 2 {
+			t.Errorf("Expected window %d to be a 2 night stay moved %d days, got %v to %v", i, window.Shift,
+				window.Start, window.End)
+		}
+	}
+}
+
+func TestMonthWindows(t *testing.T) {
+	windows := MonthWindows(time.Date(2050, 2, 14, 0, 0, 0, 0, time.UTC), 3)
+	if len(windows) != 28 {
+		t.Fatalf("Expected a window arriving on every day of February but got %d", len(windows))
+	}
+	first, last := windows[0], windows[len(windows)-1]
+	if first.Start.Day() != 1 || last.Start.Day() != 28 || DaysBetween(last.Start, last.End) != 3 {
+		t.Errorf("Expected 3 night stays arriving from the 1st to the 28th, got %v to %v", first.Start, last.End)
+	}
+}
+
+func TestRankAlternatives(t *testing.T) {
+	alternative := func(roomID, shift, total int) Alternative {
+		return Alternative{Room: Room{ID: roomID}, Window: DateWindow{Shift: shift}, Quote: Quote{Total: total}}
+	}
+	alternatives := []Alternative{
+		alternative(1, 3, 10000),
+		alternative(1, -1, 30000),
+		alternative(2, 1, 20000),
+		alternative(1, 1, 25000),
+		alternative(1, 2, 10000),
+	}
+
+	ranked := RankAlternatives(alternatives, 2)
+	expected := []Alternative{alternative(2, 1, 20000), alternative(1, 1, 25000), alternative(1, -1, 30000)}
+	if len(ranked) != len(expected) {
+		t.Fatalf("Expected %d alternatives but got %d", len(expected), len(ranked))
+	}
+	for i := range expected {
+		if ranked[i].Room.ID != expected[i].Room.ID || ranked[i].Window.Shift != expected[i].Window.Shift {
+			t.Errorf("Expected alternative %d to be room %d moved %d days, got room %d moved %d days", i,
+				expected[i].Room.ID, expected[i].Window.Shift, ranked[i].Room.ID, ranked[i].Window.Shift)
+		}
+	}
+}
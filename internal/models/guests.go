@@ -0,0 +1,30 @@
+package models
+
+import "fmt"
+
+// Guests returns how many people are staying, adults and children alike.
+func (r Reservation) Guests() int {
+	return r.Adults + r.Children
+}
+
+// GuestsDescription describes who is staying, for example "2 adults, 1 child".
+func (r Reservation) GuestsDescription() string {
+	description := plural(r.Adults, "adult", "adults")
+	if r.Children > 0 {
+		description += ", " + plural(r.Children, "child", "children")
+	}
+	return description
+}
+
+// Sleeps returns true if the room has room for the given number of guests.
+func (r Room) Sleeps(guests int) bool {
+	return guests <= r.Capacity
+}
+
+// plural formats a count with the singular or plural form of what is counted.
+func plural(count int, singular, plural string) string {
+	if count == 1 {
+		return fmt.Sprintf("%d %s", count, singular)
+	}
+	return fmt.Sprintf("%d %s", count, plural)
+}
@@ -0,0 +1,27 @@
+package models
+
+import "testing"
+
+func TestReservation_GuestsDescription(t *testing.T) {
+	var tests = []struct {
+		adults   int
+		children int
+		expected string
+	}{
+		{1, 0, "1 adult"},
+		{2, 1, "2 adults, 1 child"},
+		{2, 3, "2 adults, 3 children"},
+	}
+
+	for _, test := range tests {
+		res := Reservation{Adults: test.adults, Children: test.children}
+		if description := res.GuestsDescription(); description != test.expected {
+			t.Errorf("For %d adults and %d children, expected %q but got %q", test.adults, test.children,
+				test.expected, description)
+		}
+		if res.Guests() != test.adults+test.children {
+			t.Errorf("For %d adults and %d children, expected %d guests but got %d", test.adults, test.children,
+				test.adults+test.children, res.Guests())
+		}
+	}
+}
@@ -18,12 +18,43 @@ type User struct {
 
 // Room is the rooms model.
 type Room struct {
-	ID        int
-	RoomName  string
-	CreatedAt time.Time
-	UpdatedAt time.Time
+	ID                   int
+	RoomName             string
+	CancellationPolicyID int
+	BaseRate             int    // nightly rate in cents.
+	WeekdayAdjustment    int    // percentage added to the base rate on Sunday to Thursday nights, can be negative.
+	WeekendAdjustment    int    // percentage added to the base rate on Friday and Saturday nights, can be negative.
+	MinRate              int    // floor the dynamic pricing rules can't go below, in cents. 0 for none.
+	MaxRate              int    // ceiling the dynamic pricing rules can't go above, in cents. 0 for none.
+	Slug                 string // identifies the room in the URL of its page, /rooms/{slug}.
+	Description          string
+	Capacity             int       // how many guests the room sleeps.
+	Beds                 string    // bed configuration, for example "1 king bed".
+	Size                 int       // floor area in square meters, 0 when unknown.
+	SortOrder            int       // position of the room in the lists shown to guests.
+	ArchivedAt           time.Time // when the room was taken out of use, zero while it's in use.
+	Photos               []RoomPhoto
+	Units                []RoomUnit
+	Amenities            []Amenity
+	Available            int // units free for the searched stay, only set by the availability search.
+	CreatedAt            time.Time
+	UpdatedAt            time.Time
 }
 
+// Restriction IDs, as seeded in the restrictions table.
+const (
+	RestrictionReservation = 1
+	RestrictionOwnerBlock  = 2
+	RestrictionHold        = 3 // room held for a guest while they fill in the reservation form.
+
+	// Stay rules don't take the room, they limit which stays can be booked in it. They are seeded after the
+	// restrictions that take the room, so "restriction_id < RestrictionMinStay" selects the latter.
+	RestrictionMinStay           = 4
+	RestrictionMaxStay           = 5
+	RestrictionClosedToArrival   = 6
+	RestrictionClosedToDeparture = 7
+)
+
 // Restriction is the restrictions model.
 type Restriction struct {
 	ID              int
@@ -34,18 +65,65 @@ type Restriction struct {
 
 // Reservation is the reservations model.
 type Reservation struct {
-	ID        int
-	FirstName string
-	LastName  string
-	Email     string
-	Phone     string
-	StartDate time.Time
-	EndDate   time.Time
-	RoomID    int
-	CreatedAt time.Time
-	UpdatedAt time.Time
-	Processed int
-	Room      Room
+	ID               int
+	ConfirmationCode string
+	FirstName        string
+	LastName         string
+	Email            string
+	Phone            string
+	StartDate        time.Time
+	EndDate          time.Time
+	RoomID           int
+	UnitID           int    // unit the reservation was assigned to, 0 if none is.
+	GroupID          int    // group the reservation was booked with, 0 if it was booked on its own.
+	GroupCode        string // confirmation code of the group.
+	Adults           int
+	Children         int
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+	Status           ReservationStatus
+	CancelledAt      time.Time
+	RefundPercent    int   // share of the reservation refunded when it was cancelled, per the room's cancellation policy.
+	RefundAmount     int   // refunded when it was cancelled, in cents, out of the total it had then.
+	Quote            Quote // price of the stay, as quoted when it was booked.
+	Room             Room
+	Unit             RoomUnit
+}
+
+// NightlyRate is the price of one night of a stay, in cents.
+type NightlyRate struct {
+	Date time.Time
+	Rate int
+}
+
+// Quote is the price of a stay in a room, night by night. Amounts are in cents.
+type Quote struct {
+	RoomID      int
+	StartDate   time.Time
+	EndDate     time.Time
+	Guests      int
+	Nights      []NightlyRate
+	LineItems   []LineItem         // taxes and fees, itemized.
+	Extras      []ReservationExtra // extras booked with the stay, itemized.
+	Discounts   []PromoRedemption  // promo codes redeemed with the stay, itemized.
+	Subtotal    int                // price of the nights.
+	ExtrasTotal int                // price of the extras.
+	Discount    int                // taken off the price of the nights by the promo codes.
+	Fees        int
+	Taxes       int
+	Total       int
+}
+
+// StatusChange is a transition of a reservation from one status to another, as stored in reservation_status_history.
+type StatusChange struct {
+	ID            int
+	ReservationID int
+	FromStatus    ReservationStatus
+	ToStatus      ReservationStatus
+	UserID        int
+	User          User
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
 }
 
 // RoomRestriction is the room restrictions model.
@@ -57,10 +135,61 @@ type RoomRestriction struct {
 	UpdatedAt     time.Time
 	RoomID        int
 	Room          Room
+	UnitID        int // unit taken by the restriction, 0 for all the units of the room (like owner blocks).
 	ReservationID int
 	Reservation   Reservation
 	RestrictionID int
 	Restriction   Restriction
+	ExpiresAt     time.Time // only set for holds.
+	Nights        int       // minimum or maximum nights of a stay rule.
+	Days          int       // days of the week a stay rule applies on, as a bitmask of 1 << time.Weekday. 0 for all.
+}
+
+// UserSession is an active login session of a user, as stored in the sessions table.
+type UserSession struct {
+	TokenHash  string // md5 of the session token, used to reference the session without exposing the token.
+	UserID     int
+	UserAgent  string
+	IPAddress  string
+	Device     string
+	LastSeenAt time.Time
+	Expiry     time.Time
+	Current    bool
+}
+
+// AuditEvent is a change made by a user from the admin, as stored in the audit_events table.
+type AuditEvent struct {
+	ID         int
+	UserID     int
+	User       User
+	Action     string
+	EntityType string
+	EntityID   int
+	Changes    string // JSON object of the changed fields, with their values before and after the change.
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// AuditFilter holds the optional filters of the audit log. Zero values mean "don't filter by this".
+type AuditFilter struct {
+	UserID     int
+	EntityType string
+	EntityID   int
+	Start      time.Time
+	End        time.Time
+}
+
+// IdempotencyKey records the outcome of a reservation submission, so repeating it returns the same reservation
+// instead of making a new one. ReservationID, or GroupID for a booking cart, is 0 while the first submission is
+// still being processed.
+type IdempotencyKey struct {
+	Key           string
+	RequestHash   string // hash of the submitted fields, to detect a key reused for a different reservation.
+	ReservationID int
+	GroupID       int
+	ExpiresAt     time.Time // after this, the key can be claimed again and is removed by the sweep.
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
 }
 
 // MailData holds an email message.
@@ -0,0 +1,38 @@
+package models
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// PhotoWidths are the widths in pixels of the thumbnails made for every room photo, from the smallest.
+var PhotoWidths = []int{320, 640, 1280}
+
+// RoomPhoto is a photo of a room, uploaded from the admin.
+type RoomPhoto struct {
+	ID        int
+	RoomID    int
+	Path      string // name of the uploaded file in the storage, for example "rooms/1/a1b2c3.jpg".
+	Caption   string
+	SortOrder int // position of the photo on the room page, the first one being shown in the room lists.
+	Width     int // width of the uploaded file in pixels.
+	Height    int // height of the uploaded file in pixels.
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Thumbnail returns the name in the storage of the photo's thumbnail of the given width, one of PhotoWidths.
+func (p RoomPhoto) Thumbnail(width int) string {
+	return fmt.Sprintf("%s-%d.jpg", strings.TrimSuffix(p.Path, path.Ext(p.Path)), width)
+}
+
+// Files returns the names in the storage of the uploaded file and all of its thumbnails.
+func (p RoomPhoto) Files() []string {
+	files := []string{p.Path}
+	for _, width := range PhotoWidths {
+		files = append(files, p.Thumbnail(width))
+	}
+	return files
+}
@@ -0,0 +1,20 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRoomPhoto_Files(t *testing.T) {
+	photo := RoomPhoto{Path: "rooms/1/a1b2c3.png"}
+
+	if thumbnail := photo.Thumbnail(640); thumbnail != "rooms/1/a1b2c3-640.jpg" {
+		t.Errorf("Expected the 640 thumbnail to be rooms/1/a1b2c3-640.jpg, got %s", thumbnail)
+	}
+
+	expected := []string{"rooms/1/a1b2c3.png", "rooms/1/a1b2c3-320.jpg", "rooms/1/a1b2c3-640.jpg",
+		"rooms/1/a1b2c3-1280.jpg"}
+	if files := photo.Files(); !reflect.DeepEqual(files, expected) {
+		t.Errorf("Expected files %v, got %v", expected, files)
+	}
+}
@@ -0,0 +1,89 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// What the amount of a promo code is.
+const (
+	// PromoPercent codes take a percentage off the price of the nights.
+	PromoPercent = "percent"
+	// PromoFixed codes take an amount in cents off the price of the nights.
+	PromoFixed = "fixed"
+)
+
+// PromoCode is a promo_codes model: a code guests enter when they book to get a discount on the nights of their
+// stay. Limits of 0 aren't enforced.
+type PromoCode struct {
+	ID              int
+	Code            string // what guests enter, stored upper case.
+	Description     string
+	Kind            string
+	Amount          int       // percentage, or cents, taken off the price of the nights.
+	ValidFrom       time.Time // first day the code can be redeemed.
+	ValidUntil      time.Time // last day the code can be redeemed, included.
+	MinNights       int
+	RoomIDs         []int // rooms the code can be redeemed for, any room when empty.
+	MaxUses         int   // redemptions by all the guests.
+	MaxUsesPerEmail int   // redemptions by the guests booking with the same email.
+	Stackable       bool  // stackable codes can be redeemed together, the others only on their own.
+	Active          bool
+	Uses            int // redemptions by reservations that aren't cancelled, only set by the usage report.
+	Discounted      int // discount given by those redemptions, in cents, only set by the usage report.
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+}
+
+// NormalizePromoCode returns a code as it's stored, so guests can enter it in any case.
+func NormalizePromoCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// ValidOn returns true if the code can be redeemed on day.
+func (p PromoCode) ValidOn(day time.Time) bool {
+	return DaysBetween(p.ValidFrom, day) >= 0 && DaysBetween(day, p.ValidUntil) >= 0
+}
+
+// AppliesTo returns true if the code can be redeemed for a stay in the room.
+func (p PromoCode) AppliesTo(roomID int) bool {
+	if len(p.RoomIDs) == 0 {
+		return true
+	}
+	for _, id := range p.RoomIDs {
+		if id == roomID {
+			return true
+		}
+	}
+	return false
+}
+
+// String describes the discount of the code, for example "15% off" or "$20.00 off".
+func (p PromoCode) String() string {
+	if p.Kind == PromoPercent {
+		return fmt.Sprintf("%d%% off", p.Amount)
+	}
+	return fmt.Sprintf("$%d.%02d off", p.Amount/100, p.Amount%100)
+}
+
+// PromoRedemption is a promo_redemptions model: a promo code redeemed with a stay, with the discount it gave.
+type PromoRedemption struct {
+	ID               int
+	ReservationID    int
+	PromoCodeID      int
+	Code             string
+	Amount           int    // discount, in cents.
+	ConfirmationCode string // of the reservation, only set by the usage report.
+	Email            string // of the reservation, only set by the usage report.
+	CreatedAt        time.Time
+}
+
+// PromoCodes returns the codes redeemed with the quote, in the order they were applied.
+func (q Quote) PromoCodes() []string {
+	var codes []string
+	for _, discount := range q.Discounts {
+		codes = append(codes, discount.Code)
+	}
+	return codes
+}
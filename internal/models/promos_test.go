@@ -0,0 +1,53 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPromoCode_ValidOn(t *testing.T) {
+	promo := PromoCode{ValidFrom: time.Date(2050, 6, 1, 0, 0, 0, 0, time.UTC),
+		ValidUntil: time.Date(2050, 6, 30, 0, 0, 0, 0, time.UTC)}
+
+	var tests = []struct {
+		day      time.Time
+		expected bool
+	}{
+		{time.Date(2050, 5, 31, 23, 0, 0, 0, time.UTC), false},
+		{time.Date(2050, 6, 1, 8, 0, 0, 0, time.UTC), true},
+		{time.Date(2050, 6, 30, 23, 0, 0, 0, time.UTC), true},
+		{time.Date(2050, 7, 1, 0, 0, 0, 0, time.UTC), false},
+	}
+
+	for _, test := range tests {
+		if valid := promo.ValidOn(test.day); valid != test.expected {
+			t.Errorf("For %v, expected valid to be %t", test.day, test.expected)
+		}
+	}
+}
+
+func TestPromoCode_AppliesTo(t *testing.T) {
+	if !(PromoCode{}).AppliesTo(2) {
+		t.Error("Expected a code without rooms to apply to any room")
+	}
+	promo := PromoCode{RoomIDs: []int{1, 3}}
+	if !promo.AppliesTo(3) || promo.AppliesTo(2) {
+		t.Error("Expected a code with rooms to only apply to them")
+	}
+}
+
+func TestPromoCode_String(t *testing.T) {
+	var tests = []struct {
+		promo    PromoCode
+		expected string
+	}{
+		{PromoCode{Kind: PromoPercent, Amount: 15}, "15% off"},
+		{PromoCode{Kind: PromoFixed, Amount: 2050}, "$20.50 off"},
+	}
+
+	for _, test := range tests {
+		if description := test.promo.String(); description != test.expected {
+			t.Errorf("Expected %q but got %q", test.expected, description)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// OverridePriority is the priority of the one-night rules set from the rate calendar, so they win over seasons.
+const OverridePriority = 1000
+
+// RateRule is a rate_rules model. It changes the price of a room for the nights from StartDate to EndDate, both
+// included. Rate, when set, is the exact price of each night in cents. Otherwise the night keeps its usual price
+// with Adjustment percent added to it. When several rules cover a night, the one with the highest Priority applies.
+type RateRule struct {
+	ID         int
+	RoomID     int
+	Name       string
+	StartDate  time.Time
+	EndDate    time.Time
+	Priority   int
+	Rate       int
+	Adjustment int
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	Room       Room
+}
+
+// Covers returns true if the rule applies to the night starting on the given day.
+func (r RateRule) Covers(night time.Time) bool {
+	return DaysBetween(r.StartDate, night) >= 0 && DaysBetween(night, r.EndDate) >= 0
+}
+
+// String describes the price change of the rule, for example "$150.00 a night" or "+20%".
+func (r RateRule) String() string {
+	if r.Rate > 0 {
+		return fmt.Sprintf("$%d.%02d a night", r.Rate/100, r.Rate%100)
+	}
+	return fmt.Sprintf("%+d%%", r.Adjustment)
+}
+
+// The conditions a pricing rule can react to.
+const (
+	// PricingOccupancy rules apply when at least Threshold percent of the room's nights in the month are booked.
+	PricingOccupancy = "occupancy"
+	// PricingLeadTime rules apply when the night is at most Threshold days away.
+	PricingLeadTime = "lead_time"
+)
+
+// PricingKinds are the kinds of pricing rules, in the order they are applied.
+var PricingKinds = []string{PricingOccupancy, PricingLeadTime}
+
+// PricingRule is a pricing_rules model. The dynamic pricing engine adds Adjustment percent to the price of a night
+// when the condition of the rule is met, within the floor and ceiling of the room.
+type PricingRule struct {
+	ID         int
+	Name       string
+	Kind       string
+	Threshold  int
+	Adjustment int
+	Active     bool
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// String describes the rule, for example "+15% when at least 80% booked".
+func (r PricingRule) String() string {
+	if r.Kind == PricingLeadTime {
+		return fmt.Sprintf("%+d%% within %d days of arrival", r.Adjustment, r.Threshold)
+	}
+	return fmt.Sprintf("%+d%% when at least %d%% booked", r.Adjustment, r.Threshold)
+}
@@ -0,0 +1,41 @@
+package models
+
+import (
+	"regexp"
+	"strings"
+)
+
+// slugPattern matches the slugs of rooms: lowercase words of letters and digits separated by single dashes.
+var slugPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// Archived returns true if the room was taken out of use. Archived rooms keep their reservations, but can't be
+// found or booked by guests anymore.
+func (r Room) Archived() bool {
+	return !r.ArchivedAt.IsZero()
+}
+
+// Slugify turns a room name into a slug, for example "General's Quarters" into "generals-quarters".
+func Slugify(name string) string {
+	var slug strings.Builder
+	dash := false
+	for _, c := range strings.ToLower(name) {
+		switch {
+		case c >= 'a' && c <= 'z' || c >= '0' && c <= '9':
+			if dash && slug.Len() > 0 {
+				slug.WriteByte('-')
+			}
+			slug.WriteRune(c)
+			dash = false
+		case c == '\'' || c == '’':
+			// Apostrophes don't separate words.
+		default:
+			dash = true
+		}
+	}
+	return slug.String()
+}
+
+// IsSlug returns true if s can be used as the slug of a room.
+func IsSlug(s string) bool {
+	return slugPattern.MatchString(s)
+}
@@ -0,0 +1,25 @@
+package models
+
+import "testing"
+
+func TestSlugify(t *testing.T) {
+	var tests = []struct {
+		name     string
+		expected string
+	}{
+		{"General's Quarters", "generals-quarters"},
+		{"Major’s Suite", "majors-suite"},
+		{"  Room 12 -- Garden View! ", "room-12-garden-view"},
+		{"!!!", ""},
+	}
+
+	for _, test := range tests {
+		slug := Slugify(test.name)
+		if slug != test.expected {
+			t.Errorf("For %q, expected %q but got %q", test.name, test.expected, slug)
+		}
+		if slug != "" && !IsSlug(slug) {
+			t.Errorf("For %q, %q isn't a valid slug", test.name, slug)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package models
+
+import "errors"
+
+// ReservationStatus is the state of a reservation in its lifecycle.
+type ReservationStatus string
+
+const (
+	StatusPending    ReservationStatus = "pending"
+	StatusConfirmed  ReservationStatus = "confirmed"
+	StatusCheckedIn  ReservationStatus = "checked-in"
+	StatusCheckedOut ReservationStatus = "checked-out"
+	StatusCancelled  ReservationStatus = "cancelled"
+	StatusNoShow     ReservationStatus = "no-show"
+)
+
+// ReservationStatuses lists every status, in lifecycle order.
+var ReservationStatuses = []ReservationStatus{StatusPending, StatusConfirmed, StatusCheckedIn, StatusCheckedOut,
+	StatusCancelled, StatusNoShow}
+
+// ErrInvalidTransition is returned when a reservation can't move from its current status to the requested one.
+var ErrInvalidTransition = errors.New("invalid reservation status transition")
+
+// reservationTransitions holds, for each status, the statuses a reservation can move to. This is the only place
+// where the allowed transitions are defined.
+var reservationTransitions = map[ReservationStatus][]ReservationStatus{
+	StatusPending:    {StatusConfirmed, StatusCancelled},
+	StatusConfirmed:  {StatusCheckedIn, StatusCancelled, StatusNoShow},
+	StatusCheckedIn:  {StatusCheckedOut},
+	StatusCheckedOut: {},
+	StatusCancelled:  {},
+	StatusNoShow:     {},
+}
+
+// Valid returns true if the status is one of the known statuses.
+func (s ReservationStatus) Valid() bool {
+	_, ok := reservationTransitions[s]
+	return ok
+}
+
+// CanTransitionTo returns true if a reservation in this status is allowed to move to the next status.
+func (s ReservationStatus) CanTransitionTo(next ReservationStatus) bool {
+	for _, allowed := range reservationTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// NextStatuses returns the statuses a reservation in this status can move to. Used to show the available actions.
+func (s ReservationStatus) NextStatuses() []ReservationStatus {
+	return reservationTransitions[s]
+}
+
+// Active returns true if the reservation still holds its room, i.e. it has not been cancelled or missed.
+func (s ReservationStatus) Active() bool {
+	return s != StatusCancelled && s != StatusNoShow
+}
+
+// Modifiable returns true if the dates and room of the reservation can still be changed, i.e. the guest has not
+// arrived yet and the reservation was not cancelled or missed.
+func (s ReservationStatus) Modifiable() bool {
+	return s == StatusPending || s == StatusConfirmed
+}
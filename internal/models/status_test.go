@@ -0,0 +1,39 @@
+package models
+
+import "testing"
+
+func TestReservationStatus_CanTransitionTo(t *testing.T) {
+	var tests = []struct {
+		from     ReservationStatus
+		to       ReservationStatus
+		expected bool
+	}{
+		{StatusPending, StatusConfirmed, true},
+		{StatusPending, StatusCancelled, true},
+		{StatusPending, StatusCheckedIn, false},
+		{StatusConfirmed, StatusCheckedIn, true},
+		{StatusConfirmed, StatusNoShow, true},
+		{StatusCheckedIn, StatusCheckedOut, true},
+		{StatusCheckedIn, StatusCancelled, false},
+		{StatusCheckedOut, StatusPending, false},
+		{StatusCancelled, StatusConfirmed, false},
+		{ReservationStatus("unknown"), StatusConfirmed, false},
+	}
+
+	for _, test := range tests {
+		if test.from.CanTransitionTo(test.to) != test.expected {
+			t.Errorf("Transition from %s to %s: expected %t", test.from, test.to, test.expected)
+		}
+	}
+}
+
+func TestReservationStatus_Valid(t *testing.T) {
+	for _, status := range ReservationStatuses {
+		if !status.Valid() {
+			t.Errorf("Status %s should be valid", status)
+		}
+	}
+	if ReservationStatus("processed").Valid() {
+		t.Error("Status processed should not be valid")
+	}
+}
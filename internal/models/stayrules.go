@@ -0,0 +1,106 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Days of the week stay rules commonly apply on, as bitmasks for RoomRestriction.Days. Weekend nights are the ones
+// starting on a Friday or a Saturday, like in pricing.
+const (
+	WeekendDays = 1<<time.Friday | 1<<time.Saturday
+	MidweekDays = 1<<time.Sunday | 1<<time.Monday | 1<<time.Tuesday | 1<<time.Wednesday | 1<<time.Thursday
+)
+
+// IsStayRule returns true for the restrictions that limit which stays can be booked instead of taking the room.
+func IsStayRule(restrictionID int) bool {
+	return restrictionID >= RestrictionMinStay && restrictionID <= RestrictionClosedToDeparture
+}
+
+// AppliesOn returns true if the stay rule applies on the given day: the day is from StartDate to EndDate, the latter
+// excluded like for every room restriction, and on one of the rule's days of the week.
+func (r RoomRestriction) AppliesOn(day time.Time) bool {
+	if DaysBetween(r.StartDate, day) < 0 || DaysBetween(day, r.EndDate) <= 0 {
+		return false
+	}
+	return r.Days == 0 || r.Days&(1<<day.Weekday()) != 0
+}
+
+// LastDay returns the last day the restriction covers, EndDate being excluded.
+func (r RoomRestriction) LastDay() time.Time {
+	return r.EndDate.AddDate(0, 0, -1)
+}
+
+// String describes a stay rule to guests, for example "3-night minimum on weekends" or "No arrivals on Sundays".
+func (r RoomRestriction) String() string {
+	switch r.RestrictionID {
+	case RestrictionMinStay:
+		return fmt.Sprintf("%d-night minimum%s", r.Nights, r.daysDescription(""))
+	case RestrictionMaxStay:
+		return fmt.Sprintf("%d-night maximum%s", r.Nights, r.daysDescription(""))
+	case RestrictionClosedToArrival:
+		return "No arrivals" + r.daysDescription(" on these dates")
+	case RestrictionClosedToDeparture:
+		return "No departures" + r.daysDescription(" on these dates")
+	}
+	return ""
+}
+
+// daysDescription describes the days of the week of a stay rule, or returns all when it applies every day.
+func (r RoomRestriction) daysDescription(all string) string {
+	switch r.Days {
+	case 0:
+		return all
+	case WeekendDays:
+		return " on weekends"
+	case MidweekDays:
+		return " on weekdays"
+	}
+
+	var days []string
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		if r.Days&(1<<day) != 0 {
+			days = append(days, day.String()+"s")
+		}
+	}
+	if len(days) == 1 {
+		return " on " + days[0]
+	}
+	return " on " + strings.Join(days[:len(days)-1], ", ") + " and " + days[len(days)-1]
+}
+
+// StayViolation checks a stay from start to end against the stay rules of the room, and returns the description of
+// the first rule it breaks. Minimum and maximum stays apply when any night of the stay is on a day the rule applies,
+// closed to arrival and departure rules when the arrival or departure is.
+func StayViolation(rules []RoomRestriction, start, end time.Time) (string, bool) {
+	nights := DaysBetween(start, end)
+
+	for _, rule := range rules {
+		broken := false
+		switch rule.RestrictionID {
+		case RestrictionMinStay:
+			broken = nights < rule.Nights && rule.appliesOnAnyNight(start, nights)
+		case RestrictionMaxStay:
+			broken = nights > rule.Nights && rule.appliesOnAnyNight(start, nights)
+		case RestrictionClosedToArrival:
+			broken = rule.AppliesOn(start)
+		case RestrictionClosedToDeparture:
+			broken = rule.AppliesOn(end)
+		}
+		if broken {
+			return rule.String(), true
+		}
+	}
+	return "", false
+}
+
+// appliesOnAnyNight returns true if the stay rule applies on one of the nights of a stay.
+func (r RoomRestriction) appliesOnAnyNight(start time.Time, nights int) bool {
+	for i := 0; i < nights; i++ {
+		if r.AppliesOn(start.AddDate(0, 0, i)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,61 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStayViolation(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2050, 1, d, 0, 0, 0, 0, time.UTC) } // January 1, 2050 is a Saturday.
+	january := func(rule RoomRestriction) RoomRestriction {
+		rule.StartDate, rule.EndDate = day(1), day(31)
+		return rule
+	}
+	rules := []RoomRestriction{
+		january(RoomRestriction{RestrictionID: RestrictionMinStay, Nights: 3, Days: WeekendDays}),
+		january(RoomRestriction{RestrictionID: RestrictionMaxStay, Nights: 7}),
+		january(RoomRestriction{RestrictionID: RestrictionClosedToArrival, Days: 1 << time.Sunday}),
+		january(RoomRestriction{RestrictionID: RestrictionClosedToDeparture, Days: 1<<time.Monday | 1<<time.Tuesday}),
+	}
+
+	var tests = []struct {
+		name      string
+		start     time.Time
+		end       time.Time
+		violation string
+	}{
+		{"midweek-two-nights", day(4), day(6), ""},
+		{"friday-two-nights", day(7), day(9), "3-night minimum on weekends"},
+		{"thursday-three-nights", day(6), day(9), ""},
+		{"eight-nights", day(6), day(14), "7-night maximum"},
+		{"sunday-arrival", day(9), day(12), "No arrivals on Sundays"},
+		{"monday-departure", day(5), day(10), "No departures on Mondays and Tuesdays"},
+		{"after-the-rules", day(31), day(33), ""},
+	}
+
+	for _, test := range tests {
+		violation, broken := StayViolation(rules, test.start, test.end)
+		if violation != test.violation || broken != (test.violation != "") {
+			t.Errorf("For %s, expected violation %q but got %q", test.name, test.violation, violation)
+		}
+	}
+}
+
+func TestRoomRestriction_String(t *testing.T) {
+	var tests = []struct {
+		rule     RoomRestriction
+		expected string
+	}{
+		{RoomRestriction{RestrictionID: RestrictionMinStay, Nights: 2}, "2-night minimum"},
+		{RoomRestriction{RestrictionID: RestrictionMaxStay, Nights: 14, Days: MidweekDays}, "14-night maximum on weekdays"},
+		{RoomRestriction{RestrictionID: RestrictionClosedToArrival}, "No arrivals on these dates"},
+		{RoomRestriction{RestrictionID: RestrictionClosedToDeparture,
+			Days: 1<<time.Monday | 1<<time.Wednesday | 1<<time.Friday}, "No departures on Mondays, Wednesdays and Fridays"},
+	}
+
+	for _, test := range tests {
+		if test.rule.String() != test.expected {
+			t.Errorf("Expected %q but got %q", test.expected, test.rule.String())
+		}
+	}
+}
@@ -13,5 +13,7 @@ type TemplateData struct {
 	Warning         string
 	Error           string
 	Form            *forms.Form
-	IsAuthenticated int // Default value is 0 (int default value) meaning not authed.
+	IsAuthenticated int    // Default value is 0 (int default value) meaning not authed.
+	FirstArrival    string // earliest arrival guests can book, as YYYY-MM-DD for the datepickers.
+	LastArrival     string // latest arrival guests can book, empty when there's no limit.
 }
@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+// RoomUnit is one physical unit of a room, for example room 101 of the Standard Doubles. A room is sold as a type:
+// guests book the room, and each reservation is assigned to one of its units.
+type RoomUnit struct {
+	ID         int
+	RoomID     int
+	Name       string
+	SortOrder  int       // units are assigned in this order, the first free one getting the reservation.
+	ArchivedAt time.Time // when the unit was taken out of use, zero while it's in use.
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// Archived returns true if the unit was taken out of use. Archived units keep their reservations, but new ones
+// aren't assigned to them.
+func (u RoomUnit) Archived() bool {
+	return !u.ArchivedAt.IsZero()
+}
+
+// ActiveUnits returns the units of the room still in use, in the order they are assigned.
+func (r Room) ActiveUnits() []RoomUnit {
+	var units []RoomUnit
+	for _, unit := range r.Units {
+		if !unit.Archived() {
+			units = append(units, unit)
+		}
+	}
+	return units
+}
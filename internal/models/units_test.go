@@ -0,0 +1,22 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRoom_ActiveUnits(t *testing.T) {
+	room := Room{Units: []RoomUnit{
+		{ID: 1, Name: "101"},
+		{ID: 2, Name: "102", ArchivedAt: time.Now()},
+		{ID: 3, Name: "103"},
+	}}
+
+	units := room.ActiveUnits()
+	if len(units) != 2 || units[0].ID != 1 || units[1].ID != 3 {
+		t.Errorf("Expected units 1 and 3, got %v", units)
+	}
+	if len(Room{}.ActiveUnits()) != 0 {
+		t.Error("Expected no units for a room without units")
+	}
+}
@@ -0,0 +1,46 @@
+package models
+
+import "time"
+
+// Statuses of a waitlist entry.
+const (
+	// WaitlistWaiting entries wait for their room to be free for their stay.
+	WaitlistWaiting = "waiting"
+	// WaitlistNotified entries were sent a claim link, and their room is held for them until the claim expires.
+	WaitlistNotified = "notified"
+	// WaitlistClaimed entries used their claim link to book their stay.
+	WaitlistClaimed = "claimed"
+	// WaitlistExpired entries didn't use their claim link in time, and lost their turn.
+	WaitlistExpired = "expired"
+)
+
+// WaitlistEntry is a waitlist_entries model: a guest waiting for a room to be free for their stay. Guests are
+// notified in the order they joined the waitlist.
+type WaitlistEntry struct {
+	ID             int
+	RoomID         int
+	Room           Room
+	StartDate      time.Time
+	EndDate        time.Time
+	FirstName      string
+	LastName       string
+	Email          string
+	Adults         int
+	Children       int
+	Status         string
+	HoldID         int       // hold placed on the room for the guest when they were notified, 0 until then.
+	NotifiedAt     time.Time // zero until the guest is notified.
+	ClaimExpiresAt time.Time // when the claim link sent to the guest stops working, zero until they are notified.
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+// Guests returns how many guests are waiting for the stay, adults and children.
+func (e WaitlistEntry) Guests() int {
+	return e.Adults + e.Children
+}
+
+// Covers returns true if the stay of the entry includes the night of day.
+func (e WaitlistEntry) Covers(day time.Time) bool {
+	return DaysBetween(e.StartDate, day) >= 0 && DaysBetween(day, e.EndDate) > 0
+}
@@ -0,0 +1,28 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWaitlistEntry_Covers(t *testing.T) {
+	start := time.Date(2050, 1, 10, 0, 0, 0, 0, time.UTC)
+	entry := WaitlistEntry{StartDate: start, EndDate: start.AddDate(0, 0, 2)}
+
+	var tests = []struct {
+		name     string
+		day      time.Time
+		expected bool
+	}{
+		{"night-before", start.AddDate(0, 0, -1), false},
+		{"arrival", start, true},
+		{"last-night", start.AddDate(0, 0, 1), true},
+		{"departure", start.AddDate(0, 0, 2), false},
+	}
+
+	for _, test := range tests {
+		if covers := entry.Covers(test.day); covers != test.expected {
+			t.Errorf("For the %s, expected covers to be %t", test.name, test.expected)
+		}
+	}
+}
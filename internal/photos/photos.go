@@ -0,0 +1,140 @@
+// Package photos validates the photos uploaded from the admin and makes their thumbnails, using only the standard
+// library image decoders.
+package photos
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/draw"
+	_ "image/gif" // registers the GIF decoder.
+	"image/jpeg"
+	_ "image/png" // registers the PNG decoder.
+	"net/http"
+)
+
+// MaxFileSize is the largest photo that can be uploaded, in bytes.
+const MaxFileSize = 10 << 20
+
+// maxPixels is the largest photo that is decoded, in pixels, so a small file can't make the app allocate gigabytes.
+const maxPixels = 40_000_000
+
+// thumbnailQuality is the JPEG quality of the thumbnails.
+const thumbnailQuality = 85
+
+var (
+	// ErrTooLarge is returned for files over MaxFileSize or photos over maxPixels.
+	ErrTooLarge = errors.New("the photo is too large")
+	// ErrUnsupportedType is returned for files that aren't JPEG, PNG or GIF images, whatever their name says.
+	ErrUnsupportedType = errors.New("the file isn't a JPEG, PNG or GIF image")
+)
+
+// extensions are the file extensions of the supported image types, by the content type sniffed from the file.
+var extensions = map[string]string{"image/jpeg": ".jpg", "image/png": ".png", "image/gif": ".gif"}
+
+// Photo is an uploaded photo, with its thumbnails encoded as JPEG by width.
+type Photo struct {
+	Extension  string // extension matching the type of the uploaded file, like ".png".
+	Width      int
+	Height     int
+	Thumbnails map[int][]byte
+}
+
+// Process checks that data is a supported image by sniffing its content, and makes its thumbnails for the given
+// widths. Photos narrower than a width get a thumbnail of their own width.
+func Process(data []byte, widths []int) (Photo, error) {
+	var photo Photo
+	if len(data) > MaxFileSize {
+		return photo, ErrTooLarge
+	}
+
+	extension, ok := extensions[http.DetectContentType(data)]
+	if !ok {
+		return photo, ErrUnsupportedType
+	}
+	photo.Extension = extension
+
+	// The size is read from the header, before decoding the whole image.
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return photo, ErrUnsupportedType
+	}
+	if config.Width*config.Height > maxPixels {
+		return photo, ErrTooLarge
+	}
+	photo.Width, photo.Height = config.Width, config.Height
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return photo, ErrUnsupportedType
+	}
+	flat := flatten(img)
+
+	photo.Thumbnails = make(map[int][]byte, len(widths))
+	for _, width := range widths {
+		var thumbnail bytes.Buffer
+		err = jpeg.Encode(&thumbnail, Resize(flat, width), &jpeg.Options{Quality: thumbnailQuality})
+		if err != nil {
+			return photo, err
+		}
+		photo.Thumbnails[width] = thumbnail.Bytes()
+	}
+	return photo, nil
+}
+
+// flatten draws an image over a white background, since JPEG thumbnails can't be transparent.
+func flatten(img image.Image) *image.RGBA {
+	bounds := img.Bounds()
+	flat := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(flat, flat.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(flat, flat.Bounds(), img, bounds.Min, draw.Over)
+	return flat
+}
+
+// Resize scales an image down to the given width, keeping its aspect ratio. Each pixel of the result is the average
+// of the pixels it covers in the source. Images narrower than width keep their size.
+func Resize(src *image.RGBA, width int) *image.RGBA {
+	srcWidth, srcHeight := src.Bounds().Dx(), src.Bounds().Dy()
+	if width > srcWidth {
+		width = srcWidth
+	}
+	height := srcHeight * width / srcWidth
+	if height < 1 {
+		height = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0, y1 := span(y, height, srcHeight)
+		for x := 0; x < width; x++ {
+			x0, x1 := span(x, width, srcWidth)
+
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride+x0*4 : sy*src.Stride+x1*4]
+				for i := 0; i < len(row); i += 4 {
+					sum[0] += int(row[i])
+					sum[1] += int(row[i+1])
+					sum[2] += int(row[i+2])
+					sum[3] += int(row[i+3])
+				}
+			}
+
+			count := (y1 - y0) * (x1 - x0)
+			offset := y*dst.Stride + x*4
+			for c := 0; c < 4; c++ {
+				dst.Pix[offset+c] = uint8(sum[c] / count)
+			}
+		}
+	}
+	return dst
+}
+
+// span returns the range of source pixels covered by pixel i of n, when scaling size source pixels to n.
+func span(i, n, size int) (int, int) {
+	start, end := i*size/n, (i+1)*size/n
+	if end <= start {
+		end = start + 1
+	}
+	return start, end
+}
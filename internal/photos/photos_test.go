@@ -0,0 +1,101 @@
+package photos
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// encodePNG returns a PNG image of the given size, filled with c.
+func encodePNG(t *testing.T, width, height int, c color.Color) []byte {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	err := png.Encode(&buf, img)
+	if err != nil {
+		t.Fatal("Cannot encode PNG:", err)
+	}
+	return buf.Bytes()
+}
+
+func TestProcess(t *testing.T) {
+	data := encodePNG(t, 800, 400, color.NRGBA{R: 200, A: 255})
+
+	photo, err := Process(data, []int{320, 1280})
+	if err != nil {
+		t.Fatal("Cannot process photo:", err)
+	}
+	if photo.Extension != ".png" || photo.Width != 800 || photo.Height != 400 {
+		t.Errorf("Expected an 800x400 PNG, got %+v", photo)
+	}
+
+	var tests = []struct {
+		width          int
+		expectedWidth  int
+		expectedHeight int
+	}{
+		{320, 320, 160},
+		{1280, 800, 400},
+	}
+
+	for _, test := range tests {
+		thumbnail, err := jpeg.DecodeConfig(bytes.NewReader(photo.Thumbnails[test.width]))
+		if err != nil {
+			t.Errorf("The %d thumbnail isn't a JPEG: %v", test.width, err)
+			continue
+		}
+		if thumbnail.Width != test.expectedWidth || thumbnail.Height != test.expectedHeight {
+			t.Errorf("Expected the %d thumbnail to be %dx%d, got %dx%d", test.width, test.expectedWidth,
+				test.expectedHeight, thumbnail.Width, thumbnail.Height)
+		}
+	}
+}
+
+func TestProcess_Invalid(t *testing.T) {
+	var tests = []struct {
+		name     string
+		data     []byte
+		expected error
+	}{
+		{"text", []byte("definitely not a photo"), ErrUnsupportedType},
+		{"truncated", encodePNG(t, 10, 10, color.White)[:40], ErrUnsupportedType},
+		{"too-many-pixels", encodePNG(t, 10000, 5000, color.White), ErrTooLarge},
+		{"too-big", append([]byte("\x89PNG\r\n\x1a\n"), make([]byte, MaxFileSize)...), ErrTooLarge},
+	}
+
+	for _, test := range tests {
+		_, err := Process(test.data, []int{320})
+		if !errors.Is(err, test.expected) {
+			t.Errorf("For %s, expected %v but got %v", test.name, test.expected, err)
+		}
+	}
+}
+
+func TestResize(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	for x := 0; x < 4; x++ {
+		for y := 0; y < 2; y++ {
+			if x%2 == 0 {
+				src.Set(x, y, color.White)
+			} else {
+				src.Set(x, y, color.Black)
+			}
+		}
+	}
+
+	dst := Resize(src, 2)
+	if dst.Bounds().Dx() != 2 || dst.Bounds().Dy() != 1 {
+		t.Fatalf("Expected a 2x1 image, got %v", dst.Bounds())
+	}
+	if r, _, _, _ := dst.At(0, 0).RGBA(); r>>8 != 127 {
+		t.Errorf("Expected the pixels to be averaged to grey, got %d", r>>8)
+	}
+}
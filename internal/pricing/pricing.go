@@ -0,0 +1,328 @@
+package pricing
+
+import (
+	"errors"
+	"github.com/nambroa/lodging-bookings/internal/models"
+	"github.com/nambroa/lodging-bookings/internal/repository"
+	"time"
+)
+
+// The pricing package computes what a stay costs. Rates are read through the repository, the computation itself
+// is kept in plain functions so it can be tested without a database.
+
+// ErrInvalidStay is returned when asked to quote a stay that doesn't last at least one night.
+var ErrInvalidStay = errors.New("the departure must be after the arrival")
+
+// Service quotes stays using the rates stored in the database.
+type Service struct {
+	DB repository.DatabaseRepo
+}
+
+// NewService returns a quote service reading the rates from db.
+func NewService(db repository.DatabaseRepo) *Service {
+	return &Service{DB: db}
+}
+
+// Rates is everything the nights of a room are priced with.
+type Rates struct {
+	Room      models.Room
+	Rules     []models.RateRule
+	Dynamic   []models.PricingRule // active pricing rules of the dynamic pricing engine.
+	Occupancy map[string]int       // percentage of the room's nights booked, by month formatted as "2006-01".
+	Today     time.Time            // day the price is given, the lead time of a night is counted from it.
+	Charges   []models.Charge      // taxes and fees in effect during the stay.
+}
+
+// Quote returns the price of staying in a room from start to end, night by night.
+func (s *Service) Quote(roomID int, start, end time.Time, guests int) (models.Quote, error) {
+	room, err := s.DB.GetRoomByID(roomID)
+	if err != nil {
+		return models.Quote{}, err
+	}
+	return s.QuoteRoom(room, start, end, guests)
+}
+
+// QuoteRoom is Quote for a room that was already loaded, with its rates.
+func (s *Service) QuoteRoom(room models.Room, start, end time.Time, guests int) (models.Quote, error) {
+	rates, err := s.Rates(room, start, end)
+	if err != nil {
+		return models.Quote{}, err
+	}
+	return BuildQuote(rates, start, end, guests)
+}
+
+// Rates loads what the nights of a room from start to end are priced with, as of today.
+func (s *Service) Rates(room models.Room, start, end time.Time) (Rates, error) {
+	rates := Rates{Room: room, Occupancy: map[string]int{}, Today: time.Now()}
+
+	var err error
+	rates.Rules, err = s.DB.GetRateRulesForRoom(room.ID, start, end)
+	if err != nil {
+		return rates, err
+	}
+	rates.Dynamic, err = s.DB.GetActivePricingRules()
+	if err != nil {
+		return rates, err
+	}
+	rates.Charges, err = s.DB.GetChargesForStay(start, end)
+	if err != nil {
+		return rates, err
+	}
+
+	// Occupancy is only needed when a rule depends on it.
+	for _, rule := range rates.Dynamic {
+		if rule.Kind != models.PricingOccupancy {
+			continue
+		}
+		for month := firstOfMonth(start); month.Before(end); month = month.AddDate(0, 1, 0) {
+			next := month.AddDate(0, 1, 0)
+			booked, err := s.DB.GetBookedNights(room.ID, month, next)
+			if err != nil {
+				return rates, err
+			}
+			rates.Occupancy[month.Format("2006-01")] = booked * 100 / models.DaysBetween(month, next)
+		}
+		break
+	}
+	return rates, nil
+}
+
+// Simulate prices the nights of a room for the given number of days from start, showing how dynamic pricing would
+// change them under the current rules and occupancy.
+func (s *Service) Simulate(room models.Room, start time.Time, days int) ([]NightPrice, error) {
+	end := start.AddDate(0, 0, days)
+	rates, err := s.Rates(room, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	prices := make([]NightPrice, 0, days)
+	for night := start; night.Before(end); night = night.AddDate(0, 0, 1) {
+		prices = append(prices, PriceNight(rates, night))
+	}
+	return prices, nil
+}
+
+// BuildQuote prices every night of a stay with the rates of the room.
+func BuildQuote(rates Rates, start, end time.Time, guests int) (models.Quote, error) {
+	quote := models.Quote{RoomID: rates.Room.ID, StartDate: start, EndDate: end, Guests: guests}
+	nights := models.DaysBetween(start, end)
+	if nights < 1 {
+		return quote, ErrInvalidStay
+	}
+
+	for i := 0; i < nights; i++ {
+		night := start.AddDate(0, 0, i)
+		rate := NightlyRate(rates, night)
+		quote.Nights = append(quote.Nights, models.NightlyRate{Date: night, Rate: rate})
+		quote.Subtotal += rate
+	}
+
+	for _, charge := range rates.Charges {
+		amount := ChargeAmount(charge, quote)
+		if amount == 0 {
+			continue
+		}
+		quote.LineItems = append(quote.LineItems, models.LineItem{Name: charge.Name, Kind: charge.Kind,
+			Amount: amount})
+		if charge.Kind == models.ChargeTax {
+			quote.Taxes += amount
+		} else {
+			quote.Fees += amount
+		}
+	}
+	quote.Total = total(quote)
+	return quote, nil
+}
+
+// total adds up the price of a quote: the nights and extras, less the discounts, plus the taxes and fees.
+func total(quote models.Quote) int {
+	return quote.Subtotal + quote.ExtrasTotal - quote.Discount + quote.Fees + quote.Taxes
+}
+
+// ChargeAmount returns how much a tax or fee adds to a quote. Percentages and per night charges only count the
+// nights the charge is in effect, per stay and per guest charges apply if it's in effect on the arrival.
+func ChargeAmount(charge models.Charge, quote models.Quote) int {
+	if charge.Calculation == models.ChargePercent {
+		price := 0
+		for _, night := range quote.Nights {
+			if charge.EffectiveOn(night.Date) {
+				price += night.Rate
+			}
+		}
+		return (price*charge.Amount + 5000) / 10000
+	}
+
+	switch charge.Per {
+	case models.PerNight:
+		nights := 0
+		for _, night := range quote.Nights {
+			if charge.EffectiveOn(night.Date) {
+				nights++
+			}
+		}
+		return charge.Amount * nights
+	case models.PerGuest:
+		if charge.EffectiveOn(quote.StartDate) {
+			return charge.Amount * quote.Guests
+		}
+	case models.PerStay:
+		if charge.EffectiveOn(quote.StartDate) {
+			return charge.Amount
+		}
+	}
+	return 0
+}
+
+// AddExtras returns the quote with the given quantities of extras, by extra id, replacing the extras it had. Extras
+// without a quantity are left out.
+func AddExtras(quote models.Quote, extras []models.Extra, quantities map[int]int) models.Quote {
+	quote.Extras = nil
+	quote.ExtrasTotal = 0
+	for _, extra := range extras {
+		quantity := quantities[extra.ID]
+		if quantity < 1 {
+			continue
+		}
+		amount := ExtraAmount(extra, quantity, quote)
+		quote.Extras = append(quote.Extras, models.ReservationExtra{ExtraID: extra.ID, Name: extra.Name,
+			Per: extra.Per, Quantity: quantity, Amount: amount})
+		quote.ExtrasTotal += amount
+	}
+	quote.Total = total(quote)
+	return quote
+}
+
+// ExtraAmount returns the price of a quantity of an extra for the stay of a quote. Per guest extras are booked for
+// every guest, so their quantity is already the number of guests.
+func ExtraAmount(extra models.Extra, quantity int, quote models.Quote) int {
+	if extra.Per == models.ExtraPerStay {
+		return extra.Price * quantity
+	}
+	return extra.Price * quantity * len(quote.Nights)
+}
+
+// NightlyRate returns the price of a night in a room.
+func NightlyRate(rates Rates, night time.Time) int {
+	return PriceNight(rates, night).Rate
+}
+
+// NightPrice is how the price of a night was arrived at.
+type NightPrice struct {
+	Date     time.Time
+	BaseRate int                  // price from the base rate and the rate rules, before dynamic pricing.
+	Rate     int                  // price of the night.
+	Applied  []models.PricingRule // dynamic pricing rules that changed the price.
+}
+
+// PriceNight prices a night in a room. Friday and Saturday nights get the weekend differential, the other nights the
+// weekday one. The rate rule applying to the night, if any, then sets the price or adjusts it. Finally the dynamic
+// pricing rules adjust it within the floor and ceiling of the room, unless the rate rule fixed the price.
+func PriceNight(rates Rates, night time.Time) NightPrice {
+	price := NightPrice{Date: night}
+
+	rule, ok := ApplicableRule(rates.Rules, night)
+	if ok && rule.Rate > 0 {
+		price.BaseRate, price.Rate = rule.Rate, rule.Rate
+		return price
+	}
+
+	adjustment := rates.Room.WeekdayAdjustment
+	if IsWeekendNight(night) {
+		adjustment = rates.Room.WeekendAdjustment
+	}
+	price.BaseRate = ApplyPercent(rates.Room.BaseRate, adjustment)
+	if ok {
+		price.BaseRate = ApplyPercent(price.BaseRate, rule.Adjustment)
+	}
+
+	price.Rate = price.BaseRate
+	price.Applied = ApplicablePricingRules(rates, night)
+	if len(price.Applied) == 0 {
+		return price
+	}
+	for _, applied := range price.Applied {
+		price.Rate = ApplyPercent(price.Rate, applied.Adjustment)
+	}
+	price.Rate = Clamp(price.Rate, rates.Room.MinRate, rates.Room.MaxRate)
+	return price
+}
+
+// ApplicableRule returns the rule with the highest priority covering the night. Between rules of the same priority,
+// the one created last wins.
+func ApplicableRule(rules []models.RateRule, night time.Time) (models.RateRule, bool) {
+	var best models.RateRule
+	found := false
+	for _, rule := range rules {
+		if !rule.Covers(night) {
+			continue
+		}
+		if !found || rule.Priority > best.Priority || (rule.Priority == best.Priority && rule.ID > best.ID) {
+			best = rule
+			found = true
+		}
+	}
+	return best, found
+}
+
+// ApplicablePricingRules returns the dynamic pricing rules whose condition the night meets, at most one of each
+// kind: the occupancy rule with the highest threshold reached, and the lead time rule with the shortest lead time
+// reached.
+func ApplicablePricingRules(rates Rates, night time.Time) []models.PricingRule {
+	occupancy := rates.Occupancy[night.Format("2006-01")]
+	leadTime := models.DaysBetween(rates.Today, night)
+
+	best := map[string]models.PricingRule{}
+	for _, rule := range rates.Dynamic {
+		current, found := best[rule.Kind]
+		switch rule.Kind {
+		case models.PricingOccupancy:
+			if occupancy >= rule.Threshold && (!found || rule.Threshold > current.Threshold) {
+				best[rule.Kind] = rule
+			}
+		case models.PricingLeadTime:
+			if leadTime >= 0 && leadTime <= rule.Threshold && (!found || rule.Threshold < current.Threshold) {
+				best[rule.Kind] = rule
+			}
+		}
+	}
+
+	var applied []models.PricingRule
+	for _, kind := range models.PricingKinds {
+		if rule, ok := best[kind]; ok {
+			applied = append(applied, rule)
+		}
+	}
+	return applied
+}
+
+// IsWeekendNight returns true for the nights starting on a Friday or a Saturday.
+func IsWeekendNight(night time.Time) bool {
+	return night.Weekday() == time.Friday || night.Weekday() == time.Saturday
+}
+
+// ApplyPercent adds a percentage to an amount in cents, rounding to the nearest cent. Negative percentages discount
+// the amount, which never goes below 0.
+func ApplyPercent(amount, percent int) int {
+	adjusted := (amount*(100+percent) + 50) / 100
+	if adjusted < 0 {
+		return 0
+	}
+	return adjusted
+}
+
+// Clamp keeps an amount between a floor and a ceiling. A floor or ceiling of 0 isn't enforced.
+func Clamp(amount, floor, ceiling int) int {
+	if floor > 0 && amount < floor {
+		return floor
+	}
+	if ceiling > 0 && amount > ceiling {
+		return ceiling
+	}
+	return amount
+}
+
+// firstOfMonth returns the first day of the month of t.
+func firstOfMonth(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+}
@@ -0,0 +1,238 @@
+package pricing
+
+import (
+	"fmt"
+	"github.com/nambroa/lodging-bookings/internal/models"
+	"testing"
+	"time"
+)
+
+var room = models.Room{ID: 1, RoomName: "General's Quarters", BaseRate: 10000, WeekdayAdjustment: -10,
+	WeekendAdjustment: 25}
+
+func TestBuildQuote(t *testing.T) {
+	// Thursday 2050-01-06 to Sunday 2050-01-09: a weekday night followed by two weekend nights.
+	start := time.Date(2050, 1, 6, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2050, 1, 9, 0, 0, 0, 0, time.UTC)
+
+	quote, err := BuildQuote(Rates{Room: room}, start, end, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedRates := []int{9000, 12500, 12500}
+	if len(quote.Nights) != len(expectedRates) {
+		t.Fatalf("Expected %d nights, got %d", len(expectedRates), len(quote.Nights))
+	}
+	for i, night := range quote.Nights {
+		if night.Rate != expectedRates[i] {
+			t.Errorf("Night of %s: expected rate %d, got %d", night.Date.Format("2006-01-02"), expectedRates[i],
+				night.Rate)
+		}
+	}
+	if quote.Subtotal != 34000 || quote.Total != 34000 {
+		t.Errorf("Expected subtotal and total of 34000, got %d and %d", quote.Subtotal, quote.Total)
+	}
+	if quote.RoomID != room.ID || quote.Guests != 2 {
+		t.Errorf("Quote doesn't keep the room and guests it was computed for: %+v", quote)
+	}
+}
+
+func TestBuildQuote_RateRules(t *testing.T) {
+	date := func(day int) time.Time { return time.Date(2050, 12, day, 0, 0, 0, 0, time.UTC) }
+	rules := []models.RateRule{
+		// High season for the whole month, with a fixed price for Christmas Eve.
+		{ID: 1, Name: "High season", StartDate: date(1), EndDate: date(31), Priority: 10, Adjustment: 20},
+		{ID: 2, Name: "Christmas Eve", StartDate: date(24), EndDate: date(24), Priority: 100, Rate: 25000},
+		// Created after the high season with the same priority, so it wins over it.
+		{ID: 3, Name: "Promotion", StartDate: date(26), EndDate: date(26), Priority: 10, Adjustment: -50},
+		// Lower priority than the high season, so it never applies.
+		{ID: 4, Name: "Low season", StartDate: date(1), EndDate: date(31), Priority: 1, Adjustment: -30},
+	}
+
+	// Thursday 2050-12-22 to Tuesday 2050-12-27.
+	quote, err := BuildQuote(Rates{Room: room, Rules: rules}, date(22), date(27), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedRates := []int{
+		10800, // Thursday: weekday rate, 9000, plus the high season's 20%.
+		15000, // Friday: weekend rate, 12500, plus 20%.
+		25000, // Saturday, Christmas Eve: fixed price.
+		10800, // Sunday: weekday rate plus 20%.
+		4500,  // Monday: weekday rate with the promotion's 50% off.
+	}
+	for i, night := range quote.Nights {
+		if night.Rate != expectedRates[i] {
+			t.Errorf("Night of %s: expected rate %d, got %d", night.Date.Format("2006-01-02"), expectedRates[i],
+				night.Rate)
+		}
+	}
+}
+
+func TestPriceNight_Dynamic(t *testing.T) {
+	today := time.Date(2050, 3, 1, 0, 0, 0, 0, time.UTC)
+	dynamic := []models.PricingRule{
+		{ID: 1, Kind: models.PricingOccupancy, Threshold: 50, Adjustment: 5},
+		{ID: 2, Kind: models.PricingOccupancy, Threshold: 80, Adjustment: 15},
+		{ID: 3, Kind: models.PricingLeadTime, Threshold: 3, Adjustment: -20},
+		{ID: 4, Kind: models.PricingLeadTime, Threshold: 14, Adjustment: -5},
+	}
+	occupancy := map[string]int{"2050-03": 85, "2050-04": 10}
+	christmas := []models.RateRule{{ID: 1, StartDate: today, EndDate: today, Rate: 30000, Priority: 100}}
+
+	var tests = []struct {
+		name            string
+		room            models.Room
+		rules           []models.RateRule
+		night           time.Time
+		expectedRate    int
+		expectedApplied []int
+	}{
+		// Wednesday 2050-03-02, weekday rate 9000: +15% for the occupancy, then -20% for the last minute.
+		{"last-minute-in-busy-month", room, nil, today.AddDate(0, 0, 1), 8280, []int{2, 3}},
+		// Wednesday 2050-03-09: the occupancy +15%, then -5% within two weeks.
+		{"within-two-weeks", room, nil, today.AddDate(0, 0, 8), 9833, []int{2, 4}},
+		// Wednesday 2050-03-30: only the occupancy.
+		{"busy-month", room, nil, today.AddDate(0, 0, 29), 10350, []int{2}},
+		// Wednesday 2050-04-06, a quiet month more than two weeks away: no rule applies.
+		{"quiet-month", room, nil, today.AddDate(0, 0, 36), 9000, nil},
+		// The floor and the ceiling bound the dynamic price.
+		{"floor", models.Room{BaseRate: 10000, MinRate: 9500}, nil, today.AddDate(0, 0, 1), 9500, []int{2, 3}},
+		{"ceiling", models.Room{BaseRate: 10000, MaxRate: 11000}, nil, today.AddDate(0, 0, 29), 11000, []int{2}},
+		// Prices fixed by a rate rule aren't touched.
+		{"fixed-price", room, christmas, today, 30000, nil},
+	}
+
+	for _, test := range tests {
+		rates := Rates{Room: test.room, Rules: test.rules, Dynamic: dynamic, Occupancy: occupancy, Today: today}
+		price := PriceNight(rates, test.night)
+		if price.Rate != test.expectedRate {
+			t.Errorf("For %s, expected rate %d, got %d", test.name, test.expectedRate, price.Rate)
+		}
+		var applied []int
+		for _, rule := range price.Applied {
+			applied = append(applied, rule.ID)
+		}
+		if fmt.Sprint(applied) != fmt.Sprint(test.expectedApplied) {
+			t.Errorf("For %s, expected rules %v to apply, got %v", test.name, test.expectedApplied, applied)
+		}
+	}
+}
+
+func TestClamp(t *testing.T) {
+	var tests = []struct {
+		amount, floor, ceiling, expected int
+	}{
+		{10000, 0, 0, 10000},
+		{10000, 12000, 0, 12000},
+		{10000, 0, 8000, 8000},
+		{10000, 8000, 12000, 10000},
+	}
+
+	for _, test := range tests {
+		if result := Clamp(test.amount, test.floor, test.ceiling); result != test.expected {
+			t.Errorf("Clamp(%d, %d, %d): expected %d, got %d", test.amount, test.floor, test.ceiling,
+				test.expected, result)
+		}
+	}
+}
+
+func TestBuildQuote_Charges(t *testing.T) {
+	date := func(day int) time.Time { return time.Date(2050, 1, day, 0, 0, 0, 0, time.UTC) }
+	charges := []models.Charge{
+		{Name: "VAT", Kind: models.ChargeTax, Calculation: models.ChargePercent, Amount: 2100},
+		// Only in effect from the second night on.
+		{Name: "Tourism levy", Kind: models.ChargeTax, Calculation: models.ChargeFixed, Per: models.PerNight,
+			Amount: 250, StartDate: date(7)},
+		{Name: "Cleaning", Kind: models.ChargeFee, Calculation: models.ChargeFixed, Per: models.PerStay, Amount: 5000},
+		{Name: "Linen", Kind: models.ChargeFee, Calculation: models.ChargeFixed, Per: models.PerGuest, Amount: 1000},
+		// No longer in effect.
+		{Name: "Old fee", Kind: models.ChargeFee, Calculation: models.ChargeFixed, Per: models.PerStay, Amount: 999,
+			EndDate: date(5)},
+	}
+
+	// Thursday 2050-01-06 to Sunday 2050-01-09, for 2 guests: nights of 9000, 12500 and 12500.
+	quote, err := BuildQuote(Rates{Room: room, Charges: charges}, date(6), date(9), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []models.LineItem{
+		{Name: "VAT", Kind: models.ChargeTax, Amount: 7140},
+		{Name: "Tourism levy", Kind: models.ChargeTax, Amount: 500},
+		{Name: "Cleaning", Kind: models.ChargeFee, Amount: 5000},
+		{Name: "Linen", Kind: models.ChargeFee, Amount: 2000},
+	}
+	if fmt.Sprint(quote.LineItems) != fmt.Sprint(expected) {
+		t.Errorf("Expected line items %v, got %v", expected, quote.LineItems)
+	}
+	if quote.Subtotal != 34000 || quote.Taxes != 7640 || quote.Fees != 7000 || quote.Total != 48640 {
+		t.Errorf("Expected subtotal 34000, taxes 7640, fees 7000 and total 48640, got %d, %d, %d and %d",
+			quote.Subtotal, quote.Taxes, quote.Fees, quote.Total)
+	}
+}
+
+func TestAddExtras(t *testing.T) {
+	extras := []models.Extra{
+		{ID: 1, Name: "Breakfast", Per: models.ExtraPerGuestNight, Price: 1500},
+		{ID: 2, Name: "Parking", Per: models.ExtraPerNight, Price: 1000, Inventory: 5},
+		{ID: 3, Name: "Late checkout", Per: models.ExtraPerStay, Price: 2500, Inventory: 2},
+	}
+	quote := models.Quote{Guests: 2, Nights: make([]models.NightlyRate, 3), Subtotal: 30000, Fees: 5000,
+		Taxes: 3000, Total: 38000, Extras: []models.ReservationExtra{{ExtraID: 3, Quantity: 1, Amount: 2500}}}
+
+	// Breakfast for 2 guests and one parking space, for 3 nights. The late checkout is dropped.
+	quote = AddExtras(quote, extras, map[int]int{1: 2, 2: 1, 3: 0})
+
+	expected := []models.ReservationExtra{
+		{ExtraID: 1, Name: "Breakfast", Per: models.ExtraPerGuestNight, Quantity: 2, Amount: 9000},
+		{ExtraID: 2, Name: "Parking", Per: models.ExtraPerNight, Quantity: 1, Amount: 3000},
+	}
+	if fmt.Sprint(quote.Extras) != fmt.Sprint(expected) {
+		t.Errorf("Expected extras %v, got %v", expected, quote.Extras)
+	}
+	if quote.ExtrasTotal != 12000 || quote.Total != 50000 {
+		t.Errorf("Expected extras of 12000 and a total of 50000, got %d and %d", quote.ExtrasTotal, quote.Total)
+	}
+
+	quote = AddExtras(quote, extras, map[int]int{3: 1})
+	if len(quote.Extras) != 1 || quote.ExtrasTotal != 2500 || quote.Total != 40500 {
+		t.Errorf("Expected only a late checkout of 2500 and a total of 40500, got %v and %d", quote.Extras,
+			quote.Total)
+	}
+}
+
+func TestBuildQuote_InvalidStay(t *testing.T) {
+	day := time.Date(2050, 1, 6, 0, 0, 0, 0, time.UTC)
+
+	_, err := BuildQuote(Rates{Room: room}, day, day, 1)
+	if err != ErrInvalidStay {
+		t.Errorf("Expected ErrInvalidStay for a stay without nights, got %v", err)
+	}
+	_, err = BuildQuote(Rates{Room: room}, day, day.AddDate(0, 0, -2), 1)
+	if err != ErrInvalidStay {
+		t.Errorf("Expected ErrInvalidStay for a departure before the arrival, got %v", err)
+	}
+}
+
+func TestApplyPercent(t *testing.T) {
+	var tests = []struct {
+		amount   int
+		percent  int
+		expected int
+	}{
+		{10000, 0, 10000},
+		{10000, 15, 11500},
+		{10000, -20, 8000},
+		{999, 15, 1149}, // 1148.85 rounds up.
+		{10000, -150, 0},
+	}
+
+	for _, test := range tests {
+		if result := ApplyPercent(test.amount, test.percent); result != test.expected {
+			t.Errorf("ApplyPercent(%d, %d): expected %d, got %d", test.amount, test.percent, test.expected, result)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package pricing
+
+import (
+	"errors"
+	"fmt"
+	"github.com/nambroa/lodging-bookings/internal/models"
+	"github.com/nambroa/lodging-bookings/internal/repository"
+	"sort"
+	"time"
+)
+
+// PromoCodeError explains why a promo code can't be redeemed for a stay. Its message can be shown to the guest.
+type PromoCodeError struct {
+	Code   string
+	Reason string
+}
+
+func (e *PromoCodeError) Error() string {
+	return fmt.Sprintf("the promo code %s %s", e.Code, e.Reason)
+}
+
+// PromoUses is how many times a promo code was redeemed by reservations that aren't cancelled: by all the guests,
+// and by the guests booking with the email of the stay.
+type PromoUses struct {
+	Total   int
+	ByEmail int
+}
+
+// ApplyPromoCodes checks that the promo codes can be redeemed together for the stay of the quote, booked today with
+// email, and returns the quote with their discounts. The limit of uses per email is only checked once the email is
+// known. Returns a *PromoCodeError for the first code that can't be redeemed.
+func (s *Service) ApplyPromoCodes(quote models.Quote, codes []string, email string) (models.Quote, error) {
+	var promos []models.PromoCode
+	for _, code := range codes {
+		code = models.NormalizePromoCode(code)
+		promo, err := s.DB.GetPromoCodeByCode(code)
+		if errors.Is(err, repository.ErrPromoCodeNotFound) {
+			return quote, &PromoCodeError{Code: code, Reason: "doesn't exist"}
+		}
+		if err != nil {
+			return quote, err
+		}
+
+		var uses PromoUses
+		uses.Total, uses.ByEmail, err = s.DB.GetPromoCodeUses(promo.ID, email)
+		if err != nil {
+			return quote, err
+		}
+		if email == "" {
+			uses.ByEmail = 0
+		}
+		err = CheckPromoCode(promo, quote, uses, time.Now())
+		if err != nil {
+			return quote, err
+		}
+		promos = append(promos, promo)
+	}
+
+	err := CheckStacking(promos)
+	if err != nil {
+		return quote, err
+	}
+	return ApplyDiscounts(quote, promos), nil
+}
+
+// KeepDiscounts returns the quote with the discounts of the promo codes redeemed with a reservation, computed again
+// for its new price. The codes were already redeemed, so they aren't checked again.
+func (s *Service) KeepDiscounts(quote models.Quote, redeemed []models.PromoRedemption) (models.Quote, error) {
+	var promos []models.PromoCode
+	for _, redemption := range redeemed {
+		promo, err := s.DB.GetPromoCodeByID(redemption.PromoCodeID)
+		if err != nil {
+			return quote, err
+		}
+		promos = append(promos, promo)
+	}
+	return ApplyDiscounts(quote, promos), nil
+}
+
+// CheckPromoCode returns a *PromoCodeError if the promo code can't be redeemed today for the stay of the quote,
+// given how many times it was already redeemed.
+func CheckPromoCode(promo models.PromoCode, quote models.Quote, uses PromoUses, today time.Time) error {
+	var reason string
+	switch {
+	case !promo.Active || !promo.ValidOn(today):
+		reason = "isn't valid anymore"
+		if promo.Active && models.DaysBetween(today, promo.ValidFrom) > 0 {
+			reason = fmt.Sprintf("is only valid from %s", promo.ValidFrom.Format("2006-01-02"))
+		}
+	case promo.MinNights > 0 && len(quote.Nights) < promo.MinNights:
+		reason = fmt.Sprintf("is only valid for stays of at least %d nights", promo.MinNights)
+	case !promo.AppliesTo(quote.RoomID):
+		reason = "isn't valid for this room"
+	case promo.MaxUses > 0 && uses.Total >= promo.MaxUses:
+		reason = "has been used up"
+	case promo.MaxUsesPerEmail > 0 && uses.ByEmail >= promo.MaxUsesPerEmail:
+		reason = "was already used with this email"
+	}
+	if reason == "" {
+		return nil
+	}
+	return &PromoCodeError{Code: promo.Code, Reason: reason}
+}
+
+// CheckStacking returns a *PromoCodeError if the promo codes can't be redeemed together: several codes can only be
+// redeemed with the same stay if they are all stackable, and each of them only once.
+func CheckStacking(promos []models.PromoCode) error {
+	seen := make(map[int]bool)
+	for _, promo := range promos {
+		if seen[promo.ID] {
+			return &PromoCodeError{Code: promo.Code, Reason: "was already applied"}
+		}
+		seen[promo.ID] = true
+		if len(promos) > 1 && !promo.Stackable {
+			return &PromoCodeError{Code: promo.Code, Reason: "can't be combined with other codes"}
+		}
+	}
+	return nil
+}
+
+// ApplyDiscounts returns the quote with the discounts of the promo codes, replacing the ones it had. Discounts only
+// apply to the price of the nights: percentages are taken off first, one after the other, then the fixed amounts,
+// and the nights never go below 0. Taxes and fees are still charged on the price before the discounts.
+func ApplyDiscounts(quote models.Quote, promos []models.PromoCode) models.Quote {
+	ordered := append([]models.PromoCode(nil), promos...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ordered[i].Kind == models.PromoPercent && ordered[j].Kind != models.PromoPercent
+	})
+
+	quote.Discounts = nil
+	quote.Discount = 0
+	for _, promo := range ordered {
+		left := quote.Subtotal - quote.Discount
+		amount := promo.Amount
+		if promo.Kind == models.PromoPercent {
+			amount = left - ApplyPercent(left, -promo.Amount)
+		}
+		if amount > left {
+			amount = left
+		}
+		quote.Discounts = append(quote.Discounts, models.PromoRedemption{PromoCodeID: promo.ID, Code: promo.Code,
+			Amount: amount})
+		quote.Discount += amount
+	}
+	quote.Total = total(quote)
+	return quote
+}
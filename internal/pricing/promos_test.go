@@ -0,0 +1,114 @@
+package pricing
+
+import (
+	"errors"
+	"github.com/nambroa/lodging-bookings/internal/models"
+	"testing"
+	"time"
+)
+
+func TestApplyDiscounts(t *testing.T) {
+	quote := models.Quote{Nights: make([]models.NightlyRate, 3), Subtotal: 30000, Fees: 5000, Taxes: 3000,
+		Total: 38000}
+	promos := []models.PromoCode{
+		{ID: 2, Code: "WELCOME20", Kind: models.PromoFixed, Amount: 2000},
+		{ID: 1, Code: "SUMMER10", Kind: models.PromoPercent, Amount: 10},
+	}
+
+	// 10% of the 30000 of the nights first, then 2000 off what's left.
+	quote = ApplyDiscounts(quote, promos)
+	if len(quote.Discounts) != 2 || quote.Discounts[0].Code != "SUMMER10" || quote.Discounts[0].Amount != 3000 ||
+		quote.Discounts[1].Amount != 2000 {
+		t.Errorf("Expected 3000 off with SUMMER10 then 2000 off with WELCOME20, got %v", quote.Discounts)
+	}
+	if quote.Discount != 5000 || quote.Total != 33000 {
+		t.Errorf("Expected a discount of 5000 and a total of 33000, got %d and %d", quote.Discount, quote.Total)
+	}
+
+	// Extras aren't discounted, and keep the discount in the total.
+	quote = AddExtras(quote, []models.Extra{{ID: 3, Per: models.ExtraPerStay, Price: 2500}}, map[int]int{3: 1})
+	if quote.Total != 35500 {
+		t.Errorf("Expected a total of 35500 with the extra, got %d", quote.Total)
+	}
+
+	// The nights never go below 0.
+	quote = ApplyDiscounts(quote, []models.PromoCode{{ID: 4, Code: "FREE", Kind: models.PromoFixed, Amount: 50000}})
+	if quote.Discount != 30000 || quote.Total != 10500 {
+		t.Errorf("Expected the discount to stop at the 30000 of the nights, got %d and a total of %d",
+			quote.Discount, quote.Total)
+	}
+
+	quote = ApplyDiscounts(quote, nil)
+	if quote.Discounts != nil || quote.Discount != 0 || quote.Total != 40500 {
+		t.Errorf("Expected no discount without promo codes, got %d and a total of %d", quote.Discount, quote.Total)
+	}
+}
+
+func TestCheckPromoCode(t *testing.T) {
+	today := time.Date(2050, 6, 15, 0, 0, 0, 0, time.UTC)
+	valid := models.PromoCode{Code: "SUMMER10", Kind: models.PromoPercent, Amount: 10, Active: true,
+		ValidFrom: time.Date(2050, 6, 1, 0, 0, 0, 0, time.UTC), ValidUntil: time.Date(2050, 6, 30, 0, 0, 0, 0, time.UTC)}
+	quote := models.Quote{RoomID: 1, Nights: make([]models.NightlyRate, 3)}
+	with := func(change func(promo *models.PromoCode)) models.PromoCode {
+		promo := valid
+		change(&promo)
+		return promo
+	}
+
+	var tests = []struct {
+		name     string
+		promo    models.PromoCode
+		uses     PromoUses
+		expected string
+	}{
+		{"valid", valid, PromoUses{}, ""},
+		{"inactive", with(func(p *models.PromoCode) { p.Active = false }), PromoUses{}, "isn't valid anymore"},
+		{"expired", with(func(p *models.PromoCode) { p.ValidUntil = today.AddDate(0, 0, -1) }), PromoUses{},
+			"isn't valid anymore"},
+		{"not-yet-valid", with(func(p *models.PromoCode) { p.ValidFrom = today.AddDate(0, 0, 1) }), PromoUses{},
+			"is only valid from 2050-06-16"},
+		{"too-short", with(func(p *models.PromoCode) { p.MinNights = 4 }), PromoUses{},
+			"is only valid for stays of at least 4 nights"},
+		{"other-room", with(func(p *models.PromoCode) { p.RoomIDs = []int{2} }), PromoUses{},
+			"isn't valid for this room"},
+		{"uses-left", with(func(p *models.PromoCode) { p.MaxUses = 2 }), PromoUses{Total: 1}, ""},
+		{"used-up", with(func(p *models.PromoCode) { p.MaxUses = 2 }), PromoUses{Total: 2}, "has been used up"},
+		{"used-by-email", with(func(p *models.PromoCode) { p.MaxUsesPerEmail = 1 }), PromoUses{Total: 5, ByEmail: 1},
+			"was already used with this email"},
+	}
+
+	for _, test := range tests {
+		err := CheckPromoCode(test.promo, quote, test.uses, today)
+		var promoErr *PromoCodeError
+		switch {
+		case test.expected == "" && err != nil:
+			t.Errorf("For %s, expected the code to be valid, got %v", test.name, err)
+		case test.expected != "" && (!errors.As(err, &promoErr) || promoErr.Reason != test.expected):
+			t.Errorf("For %s, expected the code to be refused because it %s, got %v", test.name, test.expected, err)
+		}
+	}
+}
+
+func TestCheckStacking(t *testing.T) {
+	stackable := models.PromoCode{ID: 1, Code: "SUMMER10", Stackable: true}
+	other := models.PromoCode{ID: 3, Code: "LONGSTAY", Stackable: true}
+	single := models.PromoCode{ID: 2, Code: "WELCOME20"}
+
+	var tests = []struct {
+		name   string
+		promos []models.PromoCode
+		valid  bool
+	}{
+		{"no-codes", nil, true},
+		{"single-code", []models.PromoCode{single}, true},
+		{"stackable-codes", []models.PromoCode{stackable, other}, true},
+		{"with-a-single-code", []models.PromoCode{stackable, single}, false},
+		{"same-code-twice", []models.PromoCode{stackable, stackable}, false},
+	}
+
+	for _, test := range tests {
+		if err := CheckStacking(test.promos); (err == nil) != test.valid {
+			t.Errorf("For %s, expected valid to be %t, got %v", test.name, test.valid, err)
+		}
+	}
+}
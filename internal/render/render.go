@@ -12,11 +12,13 @@ import (
 	"log"
 	"net/http"
 	"path/filepath"
+	"strings"
 	"time"
 )
 
 // Specify functions that are available to the golang templates.
-var functions = template.FuncMap{"humanDate": HumanDate, "formatDate": FormatDate, "iterate": Iterate, "add": Add}
+var functions = template.FuncMap{"humanDate": HumanDate, "formatDate": FormatDate, "iterate": Iterate, "add": Add,
+	"formatMoney": FormatMoney, "uploadURL": UploadURL, "srcset": Srcset}
 
 var app *config.AppConfig
 
@@ -51,6 +53,34 @@ func Add(a, b int) int {
 	return a + b
 }
 
+// FormatMoney formats an amount in cents for display, for example 12050 as "$120.50".
+func FormatMoney(cents int) string {
+	sign := ""
+	if cents < 0 {
+		sign = "-"
+		cents = -cents
+	}
+	return fmt.Sprintf("%s$%d.%02d", sign, cents/100, cents%100)
+}
+
+// UploadURL returns the URL of a file uploaded from the admin, from its name in the storage.
+func UploadURL(name string) string {
+	return app.Storage.URL(name)
+}
+
+// Srcset returns the srcset attribute of a room photo, listing its thumbnails up to the width of the photo.
+func Srcset(photo models.RoomPhoto) string {
+	var sources []string
+	for _, width := range models.PhotoWidths {
+		if width >= photo.Width {
+			sources = append(sources, fmt.Sprintf("%s %dw", UploadURL(photo.Thumbnail(width)), photo.Width))
+			break
+		}
+		sources = append(sources, fmt.Sprintf("%s %dw", UploadURL(photo.Thumbnail(width)), width))
+	}
+	return strings.Join(sources, ", ")
+}
+
 func AddDefaultData(templateData *models.TemplateData, r *http.Request) *models.TemplateData {
 	templateData.CSRFToken = nosurf.Token(r)
 	// PopString since you want to show these messages only once to the user.
@@ -62,6 +92,12 @@ func AddDefaultData(templateData *models.TemplateData, r *http.Request) *models.
 	if app.Session.Exists(r.Context(), "user_id") {
 		templateData.IsAuthenticated = 1
 	}
+	// The datepickers only offer the arrivals within the booking window of the property.
+	now := time.Now()
+	templateData.FirstArrival = HumanDate(app.BookingWindow.FirstArrival(now))
+	if last := app.BookingWindow.LastArrival(now); !last.IsZero() {
+		templateData.LastArrival = HumanDate(last)
+	}
 	return templateData
 }
 
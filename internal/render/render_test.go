@@ -61,3 +61,21 @@ func getSession() *http.Request {
 	return r
 
 }
+
+func TestSrcset(t *testing.T) {
+	var tests = []struct {
+		name     string
+		width    int
+		expected string
+	}{
+		{"large", 2000, "/uploads/a-320.jpg 320w, /uploads/a-640.jpg 640w, /uploads/a-1280.jpg 1280w"},
+		{"small", 500, "/uploads/a-320.jpg 320w, /uploads/a-640.jpg 500w"},
+	}
+
+	for _, test := range tests {
+		srcset := Srcset(models.RoomPhoto{Path: "a.png", Width: test.width})
+		if srcset != test.expected {
+			t.Errorf("For %s, expected %q but got %q", test.name, test.expected, srcset)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"github.com/alexedwards/scs/v2"
 	"github.com/nambroa/lodging-bookings/internal/config"
 	"github.com/nambroa/lodging-bookings/internal/models"
+	"github.com/nambroa/lodging-bookings/internal/storage"
 	"log"
 	"net/http"
 	"os"
@@ -19,6 +20,8 @@ var testApp config.AppConfig
 func TestMain(m *testing.M) {
 	// Types that will be stored in the session object (encoded in the session object).
 	gob.Register(models.Reservation{})
+	gob.Register(models.Cart{})
+	gob.Register(models.ReservationGroup{})
 
 	// Change this to true when in production.
 	testApp.InProduction = false
@@ -33,6 +36,7 @@ func TestMain(m *testing.M) {
 	session.Cookie.SameSite = http.SameSiteLaxMode
 	session.Cookie.Secure = false
 	testApp.Session = session
+	testApp.Storage = storage.NewLocalStorage(os.TempDir(), "/uploads")
 	app = &testApp // The app used in render.go is now pointing to our test copy.
 
 	os.Exit(m.Run())
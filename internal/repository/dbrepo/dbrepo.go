@@ -3,7 +3,9 @@ package dbrepo
 import (
 	"database/sql"
 	"github.com/nambroa/lodging-bookings/internal/config"
+	"github.com/nambroa/lodging-bookings/internal/models"
 	"github.com/nambroa/lodging-bookings/internal/repository"
+	"sync"
 )
 
 // Repository pattern to abstract interactions with the DB.
@@ -16,6 +18,14 @@ type postgresDBRepo struct {
 type testDBRepo struct {
 	App *config.AppConfig
 	DB  *sql.DB
+
+	// The idempotency keys are kept in memory, so the tests can check that duplicate submissions are caught.
+	mu                   sync.Mutex
+	idempotencyKeys      map[string]models.IdempotencyKey
+	reservationsInserted int
+	waitlistNotified     []int
+	auditEvents          []models.AuditEvent
+	unitHolds            []unitHold
 }
 
 func NewPostgresRepo(conn *sql.DB, a *config.AppConfig) repository.DatabaseRepo {
@@ -23,5 +33,5 @@ func NewPostgresRepo(conn *sql.DB, a *config.AppConfig) repository.DatabaseRepo
 }
 
 func NewTestingRepo(a *config.AppConfig) repository.DatabaseRepo {
-	return &testDBRepo{App: a}
+	return &testDBRepo{App: a, idempotencyKeys: make(map[string]models.IdempotencyKey)}
 }
@@ -2,24 +2,246 @@ package dbrepo
 
 import (
 	"context"
+	"database/sql"
 	"errors"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/nambroa/lodging-bookings/internal/helpers"
 	"github.com/nambroa/lodging-bookings/internal/models"
+	"github.com/nambroa/lodging-bookings/internal/repository"
 	"golang.org/x/crypto/bcrypt"
 	"log"
 	"time"
 )
 
-func (m *postgresDBRepo) InsertReservation(res models.Reservation) (int, error) {
+// maxConfirmationCodeAttempts is how many confirmation codes are tried before giving up on inserting a reservation.
+const maxConfirmationCodeAttempts = 5
+
+// InsertReservation inserts a reservation into the database with the nightly rates of its quote, giving it a unique
+// confirmation code, and turns the hold of the guest into its room restriction in the same transaction. Returns the
+// id and the confirmation code of the new reservation, and repository.ErrRoomNotAvailable if the hold expired and
+// every unit of the room was taken since. A non empty idempotencyKey is completed with the new reservation.
+func (m *postgresDBRepo) InsertReservation(res models.Reservation, holdID int,
+	idempotencyKey string) (int, string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	// Codes are random, so a collision with an existing code is possible (but unlikely). Just try another one.
+	for attempt := 1; ; attempt++ {
+		code, err := helpers.GenerateConfirmationCode()
+		if err != nil {
+			return 0, "", err
+		}
+
+		newID, err := m.insertReservation(ctx, res, code, holdID, idempotencyKey)
+		if isUniqueViolation(err) && attempt < maxConfirmationCodeAttempts {
+			continue
+		}
+		if err != nil {
+			return 0, "", err
+		}
+		return newID, code, nil
+	}
+}
+
+// InsertReservationGroup books the reservations of a group together, turning the hold of each one, in the same
+// order, into its room restriction. Either all of them are booked or none is. Returns the group as it was inserted,
+// with the ids and confirmation codes of the group and of each of its reservations. A non empty idempotencyKey is
+// completed with the new group.
+func (m *postgresDBRepo) InsertReservationGroup(group models.ReservationGroup, holdIDs []int,
+	idempotencyKey string) (models.ReservationGroup, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	if len(holdIDs) != len(group.Reservations) {
+		return group, errors.New("every reservation of a group needs a hold")
+	}
+
+	// Codes are random, so a collision with an existing code is possible (but unlikely). Just try another one.
+	for attempt := 1; ; attempt++ {
+		inserted, err := m.insertReservationGroup(ctx, group, holdIDs, idempotencyKey)
+		if isUniqueViolation(err) && attempt < maxConfirmationCodeAttempts {
+			continue
+		}
+		if err != nil {
+			return group, err
+		}
+		return inserted, nil
+	}
+}
+
+// insertReservationGroup inserts a group, its reservations and the outcome of its idempotency key in one
+// transaction, with new confirmation codes.
+func (m *postgresDBRepo) insertReservationGroup(ctx context.Context, group models.ReservationGroup, holdIDs []int,
+	idempotencyKey string) (models.ReservationGroup, error) {
+	code, err := helpers.GenerateConfirmationCode()
+	if err != nil {
+		return group, err
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return group, err
+	}
+	defer tx.Rollback()
+
+	var groupID int
+	stmt := `insert into reservation_groups (confirmation_code, first_name, last_name, email, phone, created_at,
+                                updated_at)
+                                values($1, $2, $3, $4, $5, $6, $7)
+                                returning id`
+	err = tx.QueryRowContext(ctx, stmt, code, group.FirstName, group.LastName, group.Email, group.Phone, time.Now(),
+		time.Now()).Scan(&groupID)
+	if err != nil {
+		return group, err
+	}
+
+	inserted := group
+	inserted.ID = groupID
+	inserted.ConfirmationCode = code
+	inserted.Reservations = make([]models.Reservation, len(group.Reservations))
+	for i, res := range group.Reservations {
+		res.ConfirmationCode, err = helpers.GenerateConfirmationCode()
+		if err != nil {
+			return group, err
+		}
+		res.GroupID = groupID
+		res.GroupCode = code
+		res.ID, err = insertReservationTx(ctx, tx, res, res.ConfirmationCode)
+		if err != nil {
+			return group, err
+		}
+		err = convertHold(ctx, tx, holdIDs[i], res.ID)
+		if err != nil {
+			return group, err
+		}
+		inserted.Reservations[i] = res
+	}
+	err = completeIdempotencyKeyTx(ctx, tx, idempotencyKey, 0, groupID)
+	if err != nil {
+		return group, err
+	}
+
+	return inserted, tx.Commit()
+}
+
+// GetReservationGroupByID returns a group with its reservations, in the order they were booked.
+func (m *postgresDBRepo) GetReservationGroupByID(id int) (models.ReservationGroup, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
 	defer cancel()
 
+	var group models.ReservationGroup
+	query := `select id, confirmation_code, first_name, last_name, email, phone, created_at, updated_at
+		from reservation_groups where id = $1`
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&group.ID, &group.ConfirmationCode, &group.FirstName,
+		&group.LastName, &group.Email, &group.Phone, &group.CreatedAt, &group.UpdatedAt)
+	if err != nil {
+		return group, err
+	}
+
+	query = `select r.id, r.confirmation_code, r.start_date, r.end_date, r.room_id, r.status, r.adults, r.children,
+		r.total, rm.room_name
+		from reservations r
+		left join rooms rm on (r.room_id = rm.id)
+		where r.group_id = $1
+		order by r.id`
+	rows, err := m.DB.QueryContext(ctx, query, id)
+	if err != nil {
+		return group, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		reserv := models.Reservation{GroupID: group.ID, GroupCode: group.ConfirmationCode}
+		err = rows.Scan(&reserv.ID,
+			&reserv.ConfirmationCode,
+			&reserv.StartDate,
+			&reserv.EndDate,
+			&reserv.RoomID,
+			&reserv.Status,
+			&reserv.Adults,
+			&reserv.Children,
+			&reserv.Quote.Total,
+			&reserv.Room.RoomName)
+		if err != nil {
+			return group, err
+		}
+		reserv.Room.ID = reserv.RoomID
+		group.Reservations = append(group.Reservations, reserv)
+	}
+	return group, rows.Err()
+}
+
+// insertReservation inserts a reservation, its nightly rates, its room restriction and the outcome of its
+// idempotency key in one transaction. A failed statement aborts a postgres transaction, so every confirmation code
+// attempt gets its own.
+func (m *postgresDBRepo) insertReservation(ctx context.Context, res models.Reservation, code string,
+	holdID int, idempotencyKey string) (int, error) {
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	newID, err := insertReservationTx(ctx, tx, res, code)
+	if err != nil {
+		return 0, err
+	}
+	err = restrictRoomTx(ctx, tx, res, newID, holdID)
+	if err != nil {
+		return 0, err
+	}
+	err = completeIdempotencyKeyTx(ctx, tx, idempotencyKey, newID, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, tx.Commit()
+}
+
+// restrictRoomTx turns the hold of a reservation into its room restriction inside tx. If the hold expired or was
+// removed in the meantime, the reservation gets the first free unit of its room instead, so it's never saved without
+// a restriction. Returns repository.ErrRoomNotAvailable if every unit of the room was taken since.
+func restrictRoomTx(ctx context.Context, tx *sql.Tx, res models.Reservation, reservationID, holdID int) error {
+	err := convertHold(ctx, tx, holdID, reservationID)
+	if !errors.Is(err, repository.ErrHoldNotFound) {
+		return err
+	}
+
+	// Lock the room, so nobody can take the unit between the availability check and the insert.
+	_, err = tx.ExecContext(ctx, `select id from rooms where id = $1 for update`, res.RoomID)
+	if err != nil {
+		return err
+	}
+	units, err := freeUnits(ctx, tx, res.RoomID, res.StartDate, res.EndDate, 0)
+	if err != nil {
+		return err
+	}
+	if len(units) == 0 {
+		return repository.ErrRoomNotAvailable
+	}
+
+	stmt := `insert into room_restrictions (start_date, end_date, room_id, reservation_id, created_at, updated_at,
+			 restriction_id, unit_id) values ($1, $2, $3, $4, $5, $6, $7, $8)`
+	_, err = tx.ExecContext(ctx, stmt, res.StartDate, res.EndDate, res.RoomID, reservationID, time.Now(), time.Now(),
+		models.RestrictionReservation, units[0])
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `update reservations set unit_id = $1, updated_at = $2 where id = $3`, units[0],
+		time.Now(), reservationID)
+	return err
+}
+
+// insertReservationTx inserts a reservation with its nightly rates, taxes, fees and extras inside tx.
+func insertReservationTx(ctx context.Context, tx *sql.Tx, res models.Reservation, code string) (int, error) {
 	var newID int
 
 	stmt := `insert into reservations (first_name, last_name, email, phone, start_date, end_date, room_id, 
-                          created_at, updated_at)
-                          values($1, $2, $3, $4, $5, $6, $7, $8, $9) returning id`
+                          created_at, updated_at, confirmation_code, subtotal, fees, taxes, total, adults, children, extras,
+                          group_id, discount)
+                          values($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19)
+                          returning id`
 
-	err := m.DB.QueryRowContext(ctx, stmt,
+	err := tx.QueryRowContext(ctx, stmt,
 		res.FirstName,
 		res.LastName,
 		res.Email,
@@ -29,22 +251,273 @@ func (m *postgresDBRepo) InsertReservation(res models.Reservation) (int, error)
 		res.RoomID,
 		time.Now(),
 		time.Now(),
+		code,
+		res.Quote.Subtotal,
+		res.Quote.Fees,
+		res.Quote.Taxes,
+		res.Quote.Total,
+		res.Adults,
+		res.Children,
+		res.Quote.ExtrasTotal,
+		nullableID(res.GroupID),
+		res.Quote.Discount,
 	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
 
+	err = insertReservationNights(ctx, tx, newID, res.Quote.Nights)
+	if err != nil {
+		return 0, err
+	}
+	err = insertReservationLineItems(ctx, tx, newID, res.Quote.LineItems)
+	if err != nil {
+		return 0, err
+	}
+	err = insertReservationExtras(ctx, tx, newID, res)
+	if err != nil {
+		return 0, err
+	}
+	err = insertPromoRedemptions(ctx, tx, newID, res)
 	if err != nil {
 		return 0, err
 	}
 	return newID, nil
 }
 
+// insertReservationNights stores the nightly rates a reservation was booked at.
+func insertReservationNights(ctx context.Context, tx *sql.Tx, reservationID int, nights []models.NightlyRate) error {
+	stmt := `insert into reservation_nights (reservation_id, date, rate, created_at, updated_at)
+			 values ($1, $2, $3, $4, $5)`
+	for _, night := range nights {
+		_, err := tx.ExecContext(ctx, stmt, reservationID, night.Date, night.Rate, time.Now(), time.Now())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// insertReservationLineItems stores the taxes and fees a reservation was charged.
+func insertReservationLineItems(ctx context.Context, tx *sql.Tx, reservationID int, items []models.LineItem) error {
+	stmt := `insert into reservation_line_items (reservation_id, name, kind, amount, created_at, updated_at)
+			 values ($1, $2, $3, $4, $5, $6)`
+	for _, item := range items {
+		_, err := tx.ExecContext(ctx, stmt, reservationID, item.Name, item.Kind, item.Amount, time.Now(), time.Now())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getReservationLineItems returns the taxes and fees a reservation was charged, in the order they were quoted.
+func (m *postgresDBRepo) getReservationLineItems(ctx context.Context, reservationID int) ([]models.LineItem, error) {
+	var items []models.LineItem
+
+	query := `select id, reservation_id, name, kind, amount from reservation_line_items where reservation_id = $1
+			  order by id`
+	rows, err := m.DB.QueryContext(ctx, query, reservationID)
+	if err != nil {
+		return items, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var item models.LineItem
+		err := rows.Scan(&item.ID, &item.ReservationID, &item.Name, &item.Kind, &item.Amount)
+		if err != nil {
+			return items, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// insertReservationExtras stores the extras booked with a reservation. Limited extras are locked while their
+// inventory is checked, so two stays can't both book their last unit. Returns repository.ErrExtraSoldOut if one of
+// them doesn't have enough units left on some day of the stay.
+func insertReservationExtras(ctx context.Context, tx *sql.Tx, reservationID int, res models.Reservation) error {
+	stmt := `insert into reservation_extras (reservation_id, extra_id, name, per, quantity, amount, created_at,
+			 updated_at) values ($1, $2, $3, $4, $5, $6, $7, $8)`
+	for _, extra := range res.Quote.Extras {
+		var inventory int
+		err := tx.QueryRowContext(ctx, `select inventory from extras where id = $1 for update`,
+			extra.ExtraID).Scan(&inventory)
+		if err != nil {
+			return err
+		}
+		if inventory > 0 {
+			first, last := models.ExtraDays(extra.Per, res.StartDate, res.EndDate)
+			used, err := usedExtraUnits(ctx, tx, extra.ExtraID, first, last, reservationID)
+			if err != nil {
+				return err
+			}
+			if used+extra.Quantity > inventory {
+				return repository.ErrExtraSoldOut
+			}
+		}
+
+		_, err = tx.ExecContext(ctx, stmt, reservationID, extra.ExtraID, extra.Name, extra.Per, extra.Quantity,
+			extra.Amount, time.Now(), time.Now())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// usedExtraUnits returns the most units of an extra booked on a single day from first to last, both included, by
+// the stays that aren't cancelled. The extras of the given reservation are ignored, 0 for none.
+func usedExtraUnits(ctx context.Context, db queryer, extraID int, first, last time.Time, reservationID int) (int,
+	error) {
+	var used int
+
+	// Per stay extras use their inventory on the departure day, the others on every night of the stay.
+	query := `select coalesce(max(used), 0) from (
+				select d.day, sum(re.quantity) as used
+				from generate_series($2::date, $3::date, interval '1 day') as d(day)
+				join reservation_extras re on (re.extra_id = $1)
+				join reservations r on (r.id = re.reservation_id)
+				where r.status <> $4 and r.id <> $5 and
+				      case when re.per = $6 then r.end_date = d.day::date
+				      else r.start_date <= d.day::date and r.end_date > d.day::date end
+				group by d.day) as days`
+	err := db.QueryRowContext(ctx, query, extraID, first, last, models.StatusCancelled, reservationID,
+		models.ExtraPerStay).Scan(&used)
+	return used, err
+}
+
+// getReservationExtras returns the extras booked with a reservation, in the order they were quoted.
+func (m *postgresDBRepo) getReservationExtras(ctx context.Context, reservationID int) ([]models.ReservationExtra,
+	error) {
+	var extras []models.ReservationExtra
+
+	query := `select id, reservation_id, extra_id, name, per, quantity, amount from reservation_extras
+			  where reservation_id = $1 order by id`
+	rows, err := m.DB.QueryContext(ctx, query, reservationID)
+	if err != nil {
+		return extras, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var extra models.ReservationExtra
+		err := rows.Scan(&extra.ID, &extra.ReservationID, &extra.ExtraID, &extra.Name, &extra.Per, &extra.Quantity,
+			&extra.Amount)
+		if err != nil {
+			return extras, err
+		}
+		extras = append(extras, extra)
+	}
+	return extras, rows.Err()
+}
+
+// insertPromoRedemptions records the promo codes redeemed with a reservation. The promo codes are locked while their
+// uses are counted, so two stays can't both redeem their last use. Returns repository.ErrPromoCodeUsedUp if one of
+// them reached its limit of uses, in total or with the email of the reservation.
+func insertPromoRedemptions(ctx context.Context, tx *sql.Tx, reservationID int, res models.Reservation) error {
+	stmt := `insert into promo_redemptions (reservation_id, promo_code_id, code, amount, created_at, updated_at)
+			 values ($1, $2, $3, $4, $5, $6)`
+	for _, discount := range res.Quote.Discounts {
+		var maxUses, maxUsesPerEmail int
+		err := tx.QueryRowContext(ctx, `select max_uses, max_uses_per_email from promo_codes where id = $1
+			for update`, discount.PromoCodeID).Scan(&maxUses, &maxUsesPerEmail)
+		if err != nil {
+			return err
+		}
+		uses, usesByEmail, err := promoCodeUses(ctx, tx, discount.PromoCodeID, res.Email, reservationID)
+		if err != nil {
+			return err
+		}
+		if (maxUses > 0 && uses >= maxUses) || (maxUsesPerEmail > 0 && usesByEmail >= maxUsesPerEmail) {
+			return repository.ErrPromoCodeUsedUp
+		}
+
+		_, err = tx.ExecContext(ctx, stmt, reservationID, discount.PromoCodeID, discount.Code, discount.Amount,
+			time.Now(), time.Now())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// promoCodeUses returns how many reservations that aren't cancelled redeemed a promo code, in total and with the
+// given email. The given reservation is ignored, 0 for none.
+func promoCodeUses(ctx context.Context, db queryer, promoCodeID int, email string, reservationID int) (int, int,
+	error) {
+	var uses, usesByEmail int
+
+	query := `select count(*), count(*) filter (where lower(trim(r.email)) = lower(trim($2)))
+			  from promo_redemptions pr
+			  join reservations r on (r.id = pr.reservation_id)
+			  where pr.promo_code_id = $1 and r.status <> $3 and r.id <> $4`
+	err := db.QueryRowContext(ctx, query, promoCodeID, email, models.StatusCancelled,
+		reservationID).Scan(&uses, &usesByEmail)
+	return uses, usesByEmail, err
+}
+
+// getReservationDiscounts returns the promo codes redeemed with a reservation, in the order they were applied.
+func (m *postgresDBRepo) getReservationDiscounts(ctx context.Context, reservationID int) ([]models.PromoRedemption,
+	error) {
+	var discounts []models.PromoRedemption
+
+	query := `select id, reservation_id, promo_code_id, code, amount, created_at from promo_redemptions
+			  where reservation_id = $1 order by id`
+	rows, err := m.DB.QueryContext(ctx, query, reservationID)
+	if err != nil {
+		return discounts, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var d models.PromoRedemption
+		err := rows.Scan(&d.ID, &d.ReservationID, &d.PromoCodeID, &d.Code, &d.Amount, &d.CreatedAt)
+		if err != nil {
+			return discounts, err
+		}
+		discounts = append(discounts, d)
+	}
+	return discounts, rows.Err()
+}
+
+// getReservationNights returns the nightly rates a reservation was booked at, in date order.
+func (m *postgresDBRepo) getReservationNights(ctx context.Context, reservationID int) ([]models.NightlyRate, error) {
+	var nights []models.NightlyRate
+
+	query := `select date, rate from reservation_nights where reservation_id = $1 order by date`
+	rows, err := m.DB.QueryContext(ctx, query, reservationID)
+	if err != nil {
+		return nights, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var night models.NightlyRate
+		err := rows.Scan(&night.Date, &night.Rate)
+		if err != nil {
+			return nights, err
+		}
+		nights = append(nights, night)
+	}
+	return nights, rows.Err()
+}
+
+// isUniqueViolation returns true if the error was raised by postgres because of a unique index.
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
+
 // InsertRoomRestriction inserts a room restriction into the database.
 func (m *postgresDBRepo) InsertRoomRestriction(r models.RoomRestriction) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
 	defer cancel()
 
 	stmt := `insert into room_restrictions (start_date, end_date, room_id, reservation_id, created_at, updated_at,
-                               restriction_id)
-                               values ($1, $2, $3, $4, $5, $6, $7)`
+                               restriction_id, unit_id)
+                               values ($1, $2, $3, $4, $5, $6, $7, $8)`
 
 	_, err := m.DB.ExecContext(ctx, stmt,
 		r.StartDate,
@@ -53,7 +526,8 @@ func (m *postgresDBRepo) InsertRoomRestriction(r models.RoomRestriction) error {
 		r.ReservationID,
 		time.Now(),
 		time.Now(),
-		r.RestrictionID)
+		r.RestrictionID,
+		nullableID(r.UnitID))
 
 	if err != nil {
 		return err
@@ -61,63 +535,87 @@ func (m *postgresDBRepo) InsertRoomRestriction(r models.RoomRestriction) error {
 	return nil
 }
 
-// SearchAvailabilityByDatesByRoomID returns true if availability exists for a specific roomID, and false otherwise.
+// SearchAvailabilityByDatesByRoomID returns true if at least one unit of a specific roomID is free for the dates,
+// and false otherwise. A room isn't available for a stay breaking one of its stay rules either.
 func (m *postgresDBRepo) SearchAvailabilityByDatesByRoomID(start, end time.Time, roomID int) (bool, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
 	defer cancel()
 
-	var numRows int
-
-	query := `
-		select
-			count(id)
-		from
-		    room_restrictions
-		where
-		    room_id = $1 and
-		    $2 > start_date and $3 < end_date;`
-
-	row := m.DB.QueryRowContext(ctx, query, roomID, end, start)
-	err := row.Scan(&numRows)
+	units, err := freeUnits(ctx, m.DB, roomID, start, end, 0)
 	if err != nil {
 		return false, err
 	}
 
-	if numRows == 0 { // No matches in select query means date range is available for reservation.
-		return true, nil
+	if len(units) == 0 { // Every unit of the room is taken for some of the dates.
+		return false, nil
+	}
+
+	var archived bool
+	err = m.DB.QueryRowContext(ctx, `select archived_at is not null from rooms where id = $1`, roomID).Scan(&archived)
+	if err != nil || archived {
+		return false, err
 	}
 
-	return false, nil
+	rules, err := m.getStayRules(ctx, stayRulesQuery+` and room_id = $4`, models.RestrictionMinStay, end, start,
+		roomID)
+	if err != nil {
+		return false, err
+	}
+	_, broken := models.StayViolation(rules, start, end)
+	return !broken, nil
 }
 
-// SearchAvailabilityForAllRooms returns a slice of available rooms for a given date range.
-func (m *postgresDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error) {
+// SearchAvailabilityForAllRooms returns a slice of the rooms with at least one unit free for a given date range, with
+// how many are free in their Available field. Rooms sleeping fewer than the given number of guests, rooms missing
+// some of the given amenities, and rooms whose stay rules the stay breaks, are left out.
+func (m *postgresDBRepo) SearchAvailabilityForAllRooms(start, end time.Time, guests int,
+	amenityIDs []int) ([]models.Room, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
 	defer cancel()
 
 	var rooms []models.Room
 	query := `select
-				r.id, r.room_name
+				` + roomColumns + `
 			  from
-			      rooms r
+			      rooms
 			  where
-			      r.id not in (select rr.room_id from room_restrictions rr where rr.start_date < $1 and rr.end_date > $2)
-	
+			      archived_at is null and capacity >= $1 and
+			      (select count(distinct ra.amenity_id) from room_amenities ra
+			       where ra.room_id = rooms.id and ra.amenity_id = any($2)) = $3
+			  order by sort_order, room_name
 `
-	rows, err := m.DB.QueryContext(ctx, query, end, start)
+	rules, err := m.getStayRules(ctx, stayRulesQuery, models.RestrictionMinStay, end, start)
+	if err != nil {
+		return rooms, err
+	}
+	rulesByRoom := make(map[int][]models.RoomRestriction)
+	for _, rule := range rules {
+		rulesByRoom[rule.RoomID] = append(rulesByRoom[rule.RoomID], rule)
+	}
+
+	available, err := m.countFreeUnits(ctx, start, end)
+	if err != nil {
+		return rooms, err
+	}
+
+	rows, err := m.DB.QueryContext(ctx, query, guests, amenityIDs, len(amenityIDs))
 	if err != nil {
 		return rooms, err
 	}
+	defer rows.Close()
 
 	for rows.Next() {
-		var room models.Room
-		err := rows.Scan(
-			&room.ID,
-			&room.RoomName,
-		)
+		room, err := scanRoom(rows)
 		if err != nil {
 			return rooms, err
 		}
+		room.Available = available[room.ID]
+		if room.Available == 0 {
+			continue
+		}
+		if _, broken := models.StayViolation(rulesByRoom[room.ID], start, end); broken {
+			continue
+		}
 		rooms = append(rooms, room)
 	}
 	if err = rows.Err(); err != nil {
@@ -126,36 +624,146 @@ func (m *postgresDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]
 	return rooms, nil
 }
 
-// GetRoomByID gets a room matching the id given as parameter.
-func (m *postgresDBRepo) GetRoomByID(id int) (models.Room, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
-	defer cancel()
+// unitTakenCondition matches the units u taken by a restriction between the end $1 and the start $2 of a stay.
+// Restrictions without a unit, like owner blocks, take every unit of their room. The restrictions of reservation $3
+// are ignored, so it doesn't conflict with itself when it's moved. $4 is models.RestrictionMinStay, and $5 is the
+// current time: expires_at has no time zone and is written from Go, so it's compared to the time in Go too.
+//
+// Every hold and reservation takes the single unit freeUnits gave it, so a room with N units in use accepts exactly
+// N overlapping stays, and the N+1th finds no free unit. Stays are half-open, so a stay arriving the day another one
+// leaves doesn't overlap it. This only holds while the room row is locked (select ... for update) from freeUnits to
+// the insert of the restriction, otherwise two guests could be given the same last unit.
+const unitTakenCondition = `
+	exists (select 1 from room_restrictions rr
+			where rr.room_id = u.room_id and (rr.unit_id is null or rr.unit_id = u.id)
+			and $1 > rr.start_date and $2 < rr.end_date and rr.restriction_id < $4
+			and coalesce(rr.reservation_id, 0) <> $3
+			and (rr.expires_at is null or rr.expires_at > $5))`
+
+// queryer is implemented by both *sql.DB and *sql.Tx.
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
 
-	var room models.Room
+// execer is implemented by both *sql.DB and *sql.Tx.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
 
-	query := `select id, room_name, created_at, updated_at from rooms where id = $1`
+// freeUnits returns the ids of the units of a room in use and free from start to end, in the order they are
+// assigned. The restrictions of the given reservation are ignored, 0 for none.
+func freeUnits(ctx context.Context, db queryer, roomID int, start, end time.Time, reservationID int) ([]int, error) {
+	var ids []int
 
-	row := m.DB.QueryRowContext(ctx, query, id)
+	query := `select u.id from room_units u
+			  where u.room_id = $6 and u.archived_at is null and not ` + unitTakenCondition + `
+			  order by u.sort_order, u.id`
+	rows, err := db.QueryContext(ctx, query, end, start, reservationID, models.RestrictionMinStay, time.Now(),
+		roomID)
+	if err != nil {
+		return ids, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		err := rows.Scan(&id)
+		if err != nil {
+			return ids, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// countFreeUnits returns how many units of each room are in use and free from start to end, by room id. Rooms
+// without any free unit are left out.
+func (m *postgresDBRepo) countFreeUnits(ctx context.Context, start, end time.Time) (map[int]int, error) {
+	counts := make(map[int]int)
+
+	query := `select u.room_id, count(u.id) from room_units u
+			  where u.archived_at is null and not ` + unitTakenCondition + `
+			  group by u.room_id`
+	rows, err := m.DB.QueryContext(ctx, query, end, start, 0, models.RestrictionMinStay, time.Now())
+	if err != nil {
+		return counts, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var roomID, count int
+		err := rows.Scan(&roomID, &count)
+		if err != nil {
+			return counts, err
+		}
+		counts[roomID] = count
+	}
+	return counts, rows.Err()
+}
+
+// roomColumns are the columns of the rooms table, in the order scanRoom reads them.
+const roomColumns = `id, room_name, cancellation_policy_id, base_rate, weekday_adjustment, weekend_adjustment,
+	min_rate, max_rate, slug, description, capacity, beds, size, sort_order, archived_at, created_at, updated_at`
+
+// scanner is implemented by both *sql.Row and *sql.Rows.
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanRoom reads a room selected with roomColumns.
+func scanRoom(row scanner) (models.Room, error) {
+	var room models.Room
+	var archivedAt sql.NullTime
 	err := row.Scan(
 		&room.ID,
 		&room.RoomName,
+		&room.CancellationPolicyID,
+		&room.BaseRate,
+		&room.WeekdayAdjustment,
+		&room.WeekendAdjustment,
+		&room.MinRate,
+		&room.MaxRate,
+		&room.Slug,
+		&room.Description,
+		&room.Capacity,
+		&room.Beds,
+		&room.Size,
+		&room.SortOrder,
+		&archivedAt,
 		&room.CreatedAt,
 		&room.UpdatedAt)
+	room.ArchivedAt = archivedAt.Time
+	return room, err
+}
 
-	if err != nil {
-		return room, err
-	}
+// GetRoomByID gets a room matching the id given as parameter, even if it's archived.
+func (m *postgresDBRepo) GetRoomByID(id int) (models.Room, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	query := `select ` + roomColumns + ` from rooms where id = $1`
 
-	return room, nil
+	return scanRoom(m.DB.QueryRowContext(ctx, query, id))
 }
 
-// GetUserByID returns a user with the given id as a parameter.
-func (m *postgresDBRepo) GetUserByID(id int) (models.User, error) {
+// GetRoomBySlug gets the room with the given slug. Archived rooms aren't returned.
+func (m *postgresDBRepo) GetRoomBySlug(slug string) (models.Room, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
 	defer cancel()
 
-	query := `select id, first_name, last_name, email, password, access_level, created_at, updated_at
-		      from users where id = $1 `
+	query := `select ` + roomColumns + ` from rooms where slug = $1 and archived_at is null`
+
+	return scanRoom(m.DB.QueryRowContext(ctx, query, slug))
+}
+
+// GetUserByID returns a user with the given id as a parameter.
+func (m *postgresDBRepo) GetUserByID(id int) (models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	query := `select id, first_name, last_name, email, password, access_level, created_at, updated_at
+		      from users where id = $1 `
 
 	row := m.DB.QueryRowContext(ctx, query, id)
 
@@ -178,323 +786,2521 @@ func (m *postgresDBRepo) GetUserByID(id int) (models.User, error) {
 
 }
 
-// UpdateUser updates a user in the database.
-func (m *postgresDBRepo) UpdateUser(u models.User) error {
+// UpdateUser updates a user in the database.
+func (m *postgresDBRepo) UpdateUser(u models.User) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	query := `update users set first_name=$1, last_name=$2, email=$3, access_level=$4, updated_at=$5`
+
+	_, err := m.DB.ExecContext(ctx, query, u.FirstName, u.LastName, u.Email, u.AccessLevel, time.Now())
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Authenticate authenticates a user.
+func (m *postgresDBRepo) Authenticate(email, userTypedPassword string) (int, string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	var id int // Holds the id of the authenticated user
+	var hashedPassword string
+
+	row := m.DB.QueryRowContext(ctx, "select id, password from users where email=$1", email)
+	err := row.Scan(&id, &hashedPassword)
+	if err != nil {
+		return id, "", err
+	}
+
+	// At this point, the user is fetched from the DB so the email is correct, but the password is not yet verified.
+
+	// Compare the hash of the password entered by the user to the hash of the password stored in the DB.
+	err = bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(userTypedPassword))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return 0, "", errors.New("incorrect password")
+	}
+	if err != nil {
+		return 0, "", err
+	}
+	return id, hashedPassword, nil
+}
+
+// GetAllReservations returns a slice of all reservations.
+func (m *postgresDBRepo) GetAllReservations() ([]models.Reservation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	var reservations []models.Reservation
+
+	query := `
+		select r.id, r.confirmation_code, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date, r.room_id,
+		r.created_at, r.updated_at, r.status, r.adults, r.children, rm.id, rm.room_name,
+		coalesce(g.confirmation_code, '')
+		from reservations r
+		left join rooms rm on (r.room_id = rm.id)
+		left join reservation_groups g on (r.group_id = g.id)
+		order by r.start_date asc
+`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return reservations, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var reserv models.Reservation
+		err = rows.Scan(&reserv.ID,
+			&reserv.ConfirmationCode,
+			&reserv.FirstName,
+			&reserv.LastName,
+			&reserv.Email,
+			&reserv.Phone,
+			&reserv.StartDate,
+			&reserv.EndDate,
+			&reserv.RoomID,
+			&reserv.CreatedAt,
+			&reserv.UpdatedAt,
+			&reserv.Status,
+			&reserv.Adults,
+			&reserv.Children,
+			&reserv.Room.ID,
+			&reserv.Room.RoomName,
+			&reserv.GroupCode)
+		if err != nil {
+			return reservations, err
+		}
+
+		reservations = append(reservations, reserv)
+	}
+	if err = rows.Err(); err != nil {
+		return reservations, err
+	}
+	return reservations, nil
+
+}
+
+// GetReservationsByStatus returns a slice of all the reservations in a given status.
+func (m *postgresDBRepo) GetReservationsByStatus(status models.ReservationStatus) ([]models.Reservation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	var reservations []models.Reservation
+
+	query := `
+		select r.id, r.confirmation_code, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date, r.room_id,
+		r.created_at, r.updated_at, r.status, r.adults, r.children, rm.id, rm.room_name,
+		coalesce(g.confirmation_code, '')
+		from reservations r
+		left join rooms rm on (r.room_id = rm.id)
+		left join reservation_groups g on (r.group_id = g.id)
+		where r.status = $1
+		order by r.start_date asc
+`
+
+	rows, err := m.DB.QueryContext(ctx, query, status)
+	if err != nil {
+		return reservations, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var reserv models.Reservation
+		err = rows.Scan(&reserv.ID,
+			&reserv.ConfirmationCode,
+			&reserv.FirstName,
+			&reserv.LastName,
+			&reserv.Email,
+			&reserv.Phone,
+			&reserv.StartDate,
+			&reserv.EndDate,
+			&reserv.RoomID,
+			&reserv.CreatedAt,
+			&reserv.UpdatedAt,
+			&reserv.Status,
+			&reserv.Adults,
+			&reserv.Children,
+			&reserv.Room.ID,
+			&reserv.Room.RoomName,
+			&reserv.GroupCode)
+		if err != nil {
+			return reservations, err
+		}
+
+		reservations = append(reservations, reserv)
+	}
+	if err = rows.Err(); err != nil {
+		return reservations, err
+	}
+	return reservations, nil
+
+}
+
+// GetReservationByID returns one reservation with the given ID.
+func (m *postgresDBRepo) GetReservationByID(id int) (models.Reservation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	var reservation models.Reservation
+	var cancelledAt sql.NullTime
+
+	query := `
+		select r.id, r.confirmation_code, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date, r.room_id,
+		r.created_at, r.updated_at, r.status, r.cancelled_at, r.refund_percent, r.refund_amount, r.subtotal, r.fees,
+		r.taxes, r.total, r.adults, r.children, rm.id, rm.room_name, coalesce(r.unit_id, 0), coalesce(u.name, ''), r.extras,
+		coalesce(r.group_id, 0), coalesce(g.confirmation_code, ''), r.discount
+		from reservations r
+		left join rooms rm on (r.room_id = rm.id)
+		left join room_units u on (r.unit_id = u.id)
+		left join reservation_groups g on (r.group_id = g.id)
+		where r.id=$1
+`
+
+	row := m.DB.QueryRowContext(ctx, query, id)
+	err := row.Scan(&reservation.ID,
+		&reservation.ConfirmationCode,
+		&reservation.FirstName,
+		&reservation.LastName,
+		&reservation.Email,
+		&reservation.Phone,
+		&reservation.StartDate,
+		&reservation.EndDate,
+		&reservation.RoomID,
+		&reservation.CreatedAt,
+		&reservation.UpdatedAt,
+		&reservation.Status,
+		&cancelledAt,
+		&reservation.RefundPercent,
+		&reservation.RefundAmount,
+		&reservation.Quote.Subtotal,
+		&reservation.Quote.Fees,
+		&reservation.Quote.Taxes,
+		&reservation.Quote.Total,
+		&reservation.Adults,
+		&reservation.Children,
+		&reservation.Room.ID,
+		&reservation.Room.RoomName,
+		&reservation.UnitID,
+		&reservation.Unit.Name,
+		&reservation.Quote.ExtrasTotal,
+		&reservation.GroupID,
+		&reservation.GroupCode,
+		&reservation.Quote.Discount)
+	if err != nil {
+		return reservation, err
+	}
+	reservation.Unit.ID = reservation.UnitID
+	reservation.Unit.RoomID = reservation.RoomID
+	reservation.CancelledAt = cancelledAt.Time
+	reservation.Quote.Guests = reservation.Guests()
+	reservation.Quote.Nights, err = m.getReservationNights(ctx, reservation.ID)
+	if err != nil {
+		return reservation, err
+	}
+	reservation.Quote.LineItems, err = m.getReservationLineItems(ctx, reservation.ID)
+	if err != nil {
+		return reservation, err
+	}
+	reservation.Quote.Extras, err = m.getReservationExtras(ctx, reservation.ID)
+	if err != nil {
+		return reservation, err
+	}
+	reservation.Quote.Discounts, err = m.getReservationDiscounts(ctx, reservation.ID)
+	return reservation, err
+}
+
+// GetReservationByCode returns one reservation with the given confirmation code.
+func (m *postgresDBRepo) GetReservationByCode(code string) (models.Reservation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	var reservation models.Reservation
+	var cancelledAt sql.NullTime
+
+	query := `
+		select r.id, r.confirmation_code, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date, r.room_id,
+		r.created_at, r.updated_at, r.status, r.cancelled_at, r.refund_percent, r.refund_amount, r.subtotal, r.fees,
+		r.taxes, r.total, r.adults, r.children, rm.id, rm.room_name, coalesce(r.unit_id, 0), coalesce(u.name, ''), r.extras,
+		coalesce(r.group_id, 0), coalesce(g.confirmation_code, ''), r.discount
+		from reservations r
+		left join rooms rm on (r.room_id = rm.id)
+		left join room_units u on (r.unit_id = u.id)
+		left join reservation_groups g on (r.group_id = g.id)
+		where r.confirmation_code=$1
+`
+
+	row := m.DB.QueryRowContext(ctx, query, code)
+	err := row.Scan(&reservation.ID,
+		&reservation.ConfirmationCode,
+		&reservation.FirstName,
+		&reservation.LastName,
+		&reservation.Email,
+		&reservation.Phone,
+		&reservation.StartDate,
+		&reservation.EndDate,
+		&reservation.RoomID,
+		&reservation.CreatedAt,
+		&reservation.UpdatedAt,
+		&reservation.Status,
+		&cancelledAt,
+		&reservation.RefundPercent,
+		&reservation.RefundAmount,
+		&reservation.Quote.Subtotal,
+		&reservation.Quote.Fees,
+		&reservation.Quote.Taxes,
+		&reservation.Quote.Total,
+		&reservation.Adults,
+		&reservation.Children,
+		&reservation.Room.ID,
+		&reservation.Room.RoomName,
+		&reservation.UnitID,
+		&reservation.Unit.Name,
+		&reservation.Quote.ExtrasTotal,
+		&reservation.GroupID,
+		&reservation.GroupCode,
+		&reservation.Quote.Discount)
+	if err != nil {
+		return reservation, err
+	}
+	reservation.Unit.ID = reservation.UnitID
+	reservation.Unit.RoomID = reservation.RoomID
+	reservation.CancelledAt = cancelledAt.Time
+	reservation.Quote.Guests = reservation.Guests()
+	reservation.Quote.Nights, err = m.getReservationNights(ctx, reservation.ID)
+	if err != nil {
+		return reservation, err
+	}
+	reservation.Quote.LineItems, err = m.getReservationLineItems(ctx, reservation.ID)
+	if err != nil {
+		return reservation, err
+	}
+	reservation.Quote.Extras, err = m.getReservationExtras(ctx, reservation.ID)
+	if err != nil {
+		return reservation, err
+	}
+	reservation.Quote.Discounts, err = m.getReservationDiscounts(ctx, reservation.ID)
+	return reservation, err
+}
+
+// UpdateReservation updates a reservation in the database.
+func (m *postgresDBRepo) UpdateReservation(res models.Reservation) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	query := `update reservations set first_name=$1, last_name=$2, email=$3, phone=$4, updated_at=$5 where id=$6`
+
+	_, err := m.DB.ExecContext(ctx, query, res.FirstName, res.LastName, res.Email, res.Phone, time.Now(), res.ID)
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ModifyReservation moves a reservation to other dates and/or another room, together with its room restriction, and
+// replaces its price and extras with the new quote. The reservation keeps its unit if it's free for the new dates,
+// otherwise it's assigned the first free one. The promo codes redeemed with it keep being redeemed, with the discounts
+// of the new quote. Returns repository.ErrRoomNotAvailable if every unit of the room is taken
+// for the new dates by anything but the reservation itself, and repository.ErrExtraSoldOut if one of its extras
+// doesn't have enough units left for them.
+func (m *postgresDBRepo) ModifyReservation(res models.Reservation) error {
+	id, roomID, start, end := res.ID, res.RoomID, res.StartDate, res.EndDate
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Lock the target room, so two modifications into the same room can't both pass the availability check.
+	_, err = tx.ExecContext(ctx, `select id from rooms where id = $1 for update`, roomID)
+	if err != nil {
+		return err
+	}
+
+	units, err := freeUnits(ctx, tx, roomID, start, end, id)
+	if err != nil {
+		return err
+	}
+	if len(units) == 0 {
+		return repository.ErrRoomNotAvailable
+	}
+	// The reservation stays in its unit if it's still free, so guests aren't moved around needlessly.
+	unitID := units[0]
+	for _, free := range units {
+		if free == res.UnitID {
+			unitID = free
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `update reservations set room_id = $1, start_date = $2, end_date = $3,
+		subtotal = $4, fees = $5, taxes = $6, total = $7, updated_at = $8, unit_id = $9, extras = $10, discount = $11
+		where id = $12`, roomID, start, end, res.Quote.Subtotal, res.Quote.Fees, res.Quote.Taxes, res.Quote.Total,
+		time.Now(), unitID, res.Quote.ExtrasTotal, res.Quote.Discount, id)
+	if err != nil {
+		return err
+	}
+	// The promo codes stay redeemed, only the discounts they give change with the price.
+	for _, discount := range res.Quote.Discounts {
+		_, err = tx.ExecContext(ctx, `update promo_redemptions set amount = $1, updated_at = $2
+			where reservation_id = $3 and promo_code_id = $4`, discount.Amount, time.Now(), id, discount.PromoCodeID)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from reservation_nights where reservation_id = $1`, id)
+	if err != nil {
+		return err
+	}
+	err = insertReservationNights(ctx, tx, id, res.Quote.Nights)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `delete from reservation_line_items where reservation_id = $1`, id)
+	if err != nil {
+		return err
+	}
+	err = insertReservationLineItems(ctx, tx, id, res.Quote.LineItems)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `delete from reservation_extras where reservation_id = $1`, id)
+	if err != nil {
+		return err
+	}
+	err = insertReservationExtras(ctx, tx, id, res)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `update room_restrictions set room_id = $1, start_date = $2, end_date = $3,
+		updated_at = $4, unit_id = $5 where reservation_id = $6`, roomID, start, end, time.Now(), unitID, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// TransitionReservationStatus moves a reservation to another status and records the change in its history.
+// Returns models.ErrInvalidTransition if the reservation can't move from its current status to the new one.
+func (m *postgresDBRepo) TransitionReservationStatus(id int, to models.ReservationStatus, userID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = transitionReservationStatus(ctx, tx, id, to, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// CancelReservation cancels a reservation, keeping it with the cancelled status and the refund owed to the guest,
+// and frees its dates by removing its room restriction. userID is 0 when the guest cancels. Returns the refunded
+// amount in cents, refundPercent of the total of the reservation, which is stored so later changes to the total don't
+// change it.
+func (m *postgresDBRepo) CancelReservation(id, refundPercent, userID int) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	err = transitionReservationStatus(ctx, tx, id, models.StatusCancelled, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	var refundAmount int
+	err = tx.QueryRowContext(ctx, `update reservations set cancelled_at = $1, refund_percent = $2,
+		refund_amount = total * $2 / 100 where id = $3 returning refund_amount`, time.Now(), refundPercent, id).
+		Scan(&refundAmount)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from room_restrictions where reservation_id = $1`, id)
+	if err != nil {
+		return 0, err
+	}
+
+	return refundAmount, tx.Commit()
+}
+
+// transitionReservationStatus changes the status of a reservation inside tx and records the change in its history.
+func transitionReservationStatus(ctx context.Context, tx *sql.Tx, id int, to models.ReservationStatus, userID int) error {
+	// Lock the reservation row, so two concurrent transitions can't both be validated against the same status.
+	var from models.ReservationStatus
+	err := tx.QueryRowContext(ctx, `select status from reservations where id = $1 for update`, id).Scan(&from)
+	if err != nil {
+		return err
+	}
+	if !from.CanTransitionTo(to) {
+		return models.ErrInvalidTransition
+	}
+
+	_, err = tx.ExecContext(ctx, `update reservations set status = $1, updated_at = $2 where id = $3`,
+		to, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	stmt := `insert into reservation_status_history (reservation_id, from_status, to_status, user_id, created_at,
+			 updated_at) values ($1, $2, $3, nullif($4, 0), $5, $6)`
+	_, err = tx.ExecContext(ctx, stmt, id, from, to, userID, time.Now(), time.Now())
+	return err
+}
+
+// GetStatusHistoryForReservation returns the status changes of a reservation, oldest first.
+func (m *postgresDBRepo) GetStatusHistoryForReservation(id int) ([]models.StatusChange, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	var changes []models.StatusChange
+
+	query := `
+		select h.id, h.reservation_id, h.from_status, h.to_status, coalesce(h.user_id, 0), h.created_at, h.updated_at,
+		coalesce(u.first_name, ''), coalesce(u.last_name, '')
+		from reservation_status_history h
+		left join users u on (h.user_id = u.id)
+		where h.reservation_id = $1
+		order by h.created_at asc
+`
+	rows, err := m.DB.QueryContext(ctx, query, id)
+	if err != nil {
+		return changes, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var c models.StatusChange
+		err := rows.Scan(&c.ID, &c.ReservationID, &c.FromStatus, &c.ToStatus, &c.UserID, &c.CreatedAt, &c.UpdatedAt,
+			&c.User.FirstName, &c.User.LastName)
+		if err != nil {
+			return changes, err
+		}
+		c.User.ID = c.UserID
+		changes = append(changes, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return changes, err
+	}
+	return changes, nil
+}
+
+// GetAllRooms gets all the rooms in use, in the order they are shown to guests. Archived rooms are left out.
+func (m *postgresDBRepo) GetAllRooms() ([]models.Room, error) {
+	return m.getRooms(`select ` + roomColumns + ` from rooms where archived_at is null order by sort_order, room_name`)
+}
+
+// GetArchivedRooms gets the rooms taken out of use, the most recently archived first.
+func (m *postgresDBRepo) GetArchivedRooms() ([]models.Room, error) {
+	return m.getRooms(`select ` + roomColumns + ` from rooms where archived_at is not null
+		order by archived_at desc`)
+}
+
+// getRooms returns the rooms selected by query.
+func (m *postgresDBRepo) getRooms(query string) ([]models.Room, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	var rooms []models.Room
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return rooms, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		rm, err := scanRoom(rows)
+		if err != nil {
+			return rooms, err
+		}
+		rooms = append(rooms, rm)
+	}
+
+	if err = rows.Err(); err != nil {
+		return rooms, err
+	}
+
+	return rooms, nil
+}
+
+// InsertRoom inserts a room, last in the order shown to guests and with the first cancellation policy, and returns
+// its id. The room gets one unit named after it, so it can be booked right away. Returns repository.ErrSlugTaken if
+// another room already has its slug.
+func (m *postgresDBRepo) InsertRoom(room models.Room) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var newID int
+
+	stmt := `insert into rooms (room_name, slug, description, capacity, beds, size, base_rate, sort_order,
+			 cancellation_policy_id, created_at, updated_at)
+			 values ($1, $2, $3, $4, $5, $6, $7, (select coalesce(max(sort_order), 0) + 1 from rooms),
+			 (select min(id) from cancellation_policies), $8, $9) returning id`
+
+	err = tx.QueryRowContext(ctx, stmt, room.RoomName, room.Slug, room.Description, room.Capacity, room.Beds,
+		room.Size, room.BaseRate, time.Now(), time.Now()).Scan(&newID)
+	if isUniqueViolation(err) {
+		return 0, repository.ErrSlugTaken
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, `insert into room_units (room_id, name, sort_order, created_at, updated_at)
+		values ($1, $2, 1, $3, $4)`, newID, room.RoomName, time.Now(), time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, tx.Commit()
+}
+
+// UpdateRoom updates the details of a room shown to guests. Returns repository.ErrSlugTaken if another room
+// already has its slug.
+func (m *postgresDBRepo) UpdateRoom(room models.Room) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	stmt := `update rooms set room_name = $1, slug = $2, description = $3, capacity = $4, beds = $5, size = $6,
+			 updated_at = $7 where id = $8`
+
+	_, err := m.DB.ExecContext(ctx, stmt, room.RoomName, room.Slug, room.Description, room.Capacity, room.Beds,
+		room.Size, time.Now(), room.ID)
+	if isUniqueViolation(err) {
+		return repository.ErrSlugTaken
+	}
+	return err
+}
+
+// SetRoomArchived takes a room out of use, or puts an archived room back in use.
+func (m *postgresDBRepo) SetRoomArchived(id int, archived bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	archivedAt := sql.NullTime{Time: time.Now(), Valid: archived}
+	_, err := m.DB.ExecContext(ctx, `update rooms set archived_at = $1, updated_at = $2 where id = $3`, archivedAt,
+		time.Now(), id)
+	return err
+}
+
+// UpdateRoomOrder sets the order the rooms are shown to guests in, from the ids of the rooms in that order.
+func (m *postgresDBRepo) UpdateRoomOrder(ids []int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for i, id := range ids {
+		_, err = tx.ExecContext(ctx, `update rooms set sort_order = $1, updated_at = $2 where id = $3`, i+1,
+			time.Now(), id)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetRestrictionsForRoomByDate returns restrictions for a room by date range
+func (m *postgresDBRepo) GetRestrictionsForRoomByDate(roomID int, start, end time.Time) ([]models.RoomRestriction, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	var restrictions []models.RoomRestriction
+
+	// Coalesce is used here since a restriction could have no reservation. For example if an owner decides to disable
+	// reservations for a given date range. Holds and stay rules are left out, since they are not managed from the
+	// calendar.
+	query := ` select id, coalesce(reservation_id, 0), restriction_id, room_id, start_date, end_date
+			   from room_restrictions where $1 < end_date and $2 >= start_date
+			   and room_id = $3 and restriction_id in ($4, $5)
+`
+	rows, err := m.DB.QueryContext(ctx, query, start, end, roomID, models.RestrictionReservation,
+		models.RestrictionOwnerBlock)
+	if err != nil {
+		return restrictions, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r models.RoomRestriction
+		err := rows.Scan(&r.ID, &r.ReservationID, &r.RestrictionID, &r.RoomID, &r.StartDate, &r.EndDate)
+		if err != nil {
+			return restrictions, err
+		}
+
+		restrictions = append(restrictions, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return restrictions, nil
+
+}
+
+// DeleteBlockByID deletes a restriction .
+func (m *postgresDBRepo) DeleteBlockByID(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	query := ` delete from room_restrictions where id=$1`
+	_, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	return nil
+
+}
+
+// InsertBlockForRoom inserts a restriction for a specific room given a specific date, taking every unit of the room.
+// Returns the id of the new block.
+func (m *postgresDBRepo) InsertBlockForRoom(id int, startDate time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	var newID int
+
+	query := ` insert into room_restrictions (start_date, end_date, room_id, restriction_id, created_at, updated_at)
+values ($1, $2, $3, $4, $5, $6) returning id
+`
+	err := m.DB.QueryRowContext(ctx, query, startDate, startDate.AddDate(0, 0, 1), id, 2, time.Now(), time.Now()).
+		Scan(&newID)
+	if err != nil {
+		log.Println(err)
+		return 0, err
+	}
+	return newID, nil
+
+}
+
+// InsertHold holds a unit of a room for the given dates until expiresAt, while a guest fills in the reservation form,
+// and returns the id of the hold. Returns repository.ErrRoomNotAvailable if every unit of the room is already taken
+// for those dates.
+func (m *postgresDBRepo) InsertHold(roomID int, start, end, expiresAt time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Lock the room, so two guests can't both pass the availability check and hold the same unit.
+	_, err = tx.ExecContext(ctx, `select id from rooms where id = $1 for update`, roomID)
+	if err != nil {
+		return 0, err
+	}
+
+	units, err := freeUnits(ctx, tx, roomID, start, end, 0)
+	if err != nil {
+		return 0, err
+	}
+	if len(units) == 0 {
+		return 0, repository.ErrRoomNotAvailable
+	}
+
+	// The hold takes the first free unit, which the reservation made with it is assigned to.
+	var newID int
+	stmt := `insert into room_restrictions (start_date, end_date, room_id, restriction_id, expires_at, created_at,
+			 updated_at, unit_id) values ($1, $2, $3, $4, $5, $6, $7, $8) returning id`
+	err = tx.QueryRowContext(ctx, stmt, start, end, roomID, models.RestrictionHold, expiresAt, time.Now(),
+		time.Now(), units[0]).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, tx.Commit()
+}
+
+// ExtendHold pushes back the expiry of a hold that is still active. Returns repository.ErrHoldNotFound otherwise.
+func (m *postgresDBRepo) ExtendHold(id int, expiresAt time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	query := `update room_restrictions set expires_at = $1, updated_at = $2
+			  where id = $3 and restriction_id = $4 and expires_at > $2`
+	result, err := m.DB.ExecContext(ctx, query, expiresAt, time.Now(), id, models.RestrictionHold)
+	if err != nil {
+		return err
+	}
+	return holdAffected(result)
+}
+
+// ReleaseHold deletes a hold, freeing the room. Releasing a hold that is already gone is not an error.
+func (m *postgresDBRepo) ReleaseHold(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	query := `delete from room_restrictions where id = $1 and restriction_id = $2`
+	_, err := m.DB.ExecContext(ctx, query, id, models.RestrictionHold)
+	return err
+}
+
+// convertHold turns an active hold into the room restriction of the reservation made with it, and assigns the
+// reservation to the unit of the hold. Returns repository.ErrHoldNotFound if the hold expired in the meantime.
+func convertHold(ctx context.Context, db execer, holdID, reservationID int) error {
+	query := `with hold as (
+				update room_restrictions set restriction_id = $1, reservation_id = $2, expires_at = null,
+				updated_at = $3
+				where id = $4 and restriction_id = $5 and expires_at > $3
+				returning unit_id)
+			  update reservations set unit_id = hold.unit_id, updated_at = $3 from hold where reservations.id = $2`
+	result, err := db.ExecContext(ctx, query, models.RestrictionReservation, reservationID, time.Now(), holdID,
+		models.RestrictionHold)
+	if err != nil {
+		return err
+	}
+	return holdAffected(result)
+}
+
+// DeleteExpiredHolds removes the holds of abandoned reservation forms and returns how many were removed.
+func (m *postgresDBRepo) DeleteExpiredHolds() (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	query := `delete from room_restrictions where restriction_id = $1 and expires_at <= $2`
+	result, err := m.DB.ExecContext(ctx, query, models.RestrictionHold, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// holdAffected returns repository.ErrHoldNotFound if an update of a hold didn't match any active hold.
+func holdAffected(result sql.Result) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return repository.ErrHoldNotFound
+	}
+	return nil
+}
+
+// TouchUserSession records the user and device owning a session token, and refreshes its last seen time.
+// The row is created if the session store has not committed the token yet (for example right after logging in).
+func (m *postgresDBRepo) TouchUserSession(token string, userID int, userAgent, ipAddress string, expiry time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	// Last seen is only refreshed once per minute, to avoid writing to the DB on every single request.
+	query := `insert into sessions (token, data, expiry, user_id, user_agent, ip_address, last_seen_at)
+			  values ($1, '', $2, $3, $4, $5, $6)
+			  on conflict (token) do update set user_id = excluded.user_id, user_agent = excluded.user_agent,
+			  ip_address = excluded.ip_address, last_seen_at = excluded.last_seen_at
+			  where sessions.user_id is distinct from excluded.user_id
+			  or sessions.last_seen_at is null or sessions.last_seen_at < excluded.last_seen_at - interval '1 minute'
+`
+	_, err := m.DB.ExecContext(ctx, query, token, expiry, userID, userAgent, ipAddress, time.Now())
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// GetSessionsForUser returns the active (not expired) sessions of a user, most recently used first.
+func (m *postgresDBRepo) GetSessionsForUser(userID int) ([]models.UserSession, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	var sessions []models.UserSession
+
+	query := `
+		select md5(token), user_id, user_agent, ip_address, coalesce(last_seen_at, expiry), expiry
+		from sessions
+		where user_id = $1 and current_timestamp < expiry
+		order by last_seen_at desc nulls last
+`
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return sessions, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var s models.UserSession
+		err := rows.Scan(&s.TokenHash, &s.UserID, &s.UserAgent, &s.IPAddress, &s.LastSeenAt, &s.Expiry)
+		if err != nil {
+			return sessions, err
+		}
+		sessions = append(sessions, s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return sessions, err
+	}
+	return sessions, nil
+}
+
+// DeleteUserSession revokes a session of a user. The session is identified by the md5 of its token.
+func (m *postgresDBRepo) DeleteUserSession(tokenHash string, userID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	query := `delete from sessions where md5(token) = $1 and user_id = $2`
+	_, err := m.DB.ExecContext(ctx, query, tokenHash, userID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// GetReservationsByEmail returns all the reservations made with a given email, case-insensitive.
+func (m *postgresDBRepo) GetReservationsByEmail(email string) ([]models.Reservation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	var reservations []models.Reservation
+
+	query := `
+		select r.id, r.confirmation_code, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date, r.room_id,
+		r.created_at, r.updated_at, r.status, r.adults, r.children, rm.id, rm.room_name
+		from reservations r
+		left join rooms rm on (r.room_id = rm.id)
+		where lower(r.email) = lower($1)
+		order by r.start_date desc
+`
+
+	rows, err := m.DB.QueryContext(ctx, query, email)
+	if err != nil {
+		return reservations, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var reserv models.Reservation
+		err = rows.Scan(&reserv.ID,
+			&reserv.ConfirmationCode,
+			&reserv.FirstName,
+			&reserv.LastName,
+			&reserv.Email,
+			&reserv.Phone,
+			&reserv.StartDate,
+			&reserv.EndDate,
+			&reserv.RoomID,
+			&reserv.CreatedAt,
+			&reserv.UpdatedAt,
+			&reserv.Status,
+			&reserv.Adults,
+			&reserv.Children,
+			&reserv.Room.ID,
+			&reserv.Room.RoomName)
+		if err != nil {
+			return reservations, err
+		}
+
+		reservations = append(reservations, reserv)
+	}
+	if err = rows.Err(); err != nil {
+		return reservations, err
+	}
+	return reservations, nil
+}
+
+// InsertGuestLoginToken stores the hash of a one-time login token sent to a guest by email.
+func (m *postgresDBRepo) InsertGuestLoginToken(email, tokenHash string, expiresAt time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	stmt := `insert into guest_login_tokens (token_hash, email, expires_at, created_at, updated_at)
+			 values ($1, $2, $3, $4, $5)`
+
+	_, err := m.DB.ExecContext(ctx, stmt, tokenHash, email, expiresAt, time.Now(), time.Now())
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// ConsumeGuestLoginToken marks a guest login token as used and returns the email it was issued for.
+// Tokens that are unknown, expired or already used return an error.
+func (m *postgresDBRepo) ConsumeGuestLoginToken(tokenHash string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	var email string
+
+	// Marking the token as used in the same statement that reads it guarantees it can only be used once.
+	query := `update guest_login_tokens set used_at = $1, updated_at = $1
+			  where token_hash = $2 and used_at is null and expires_at > $1
+			  returning email`
+
+	err := m.DB.QueryRowContext(ctx, query, time.Now(), tokenHash).Scan(&email)
+	if err == sql.ErrNoRows {
+		return "", errors.New("invalid or expired login link")
+	}
+	if err != nil {
+		return "", err
+	}
+	return email, nil
+}
+
+// GetAllUsers returns all the users, ordered by name.
+func (m *postgresDBRepo) GetAllUsers() ([]models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	var users []models.User
+
+	query := `select id, first_name, last_name, email, access_level, created_at, updated_at
+			  from users order by last_name, first_name`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return users, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var u models.User
+		err := rows.Scan(&u.ID, &u.FirstName, &u.LastName, &u.Email, &u.AccessLevel, &u.CreatedAt, &u.UpdatedAt)
+		if err != nil {
+			return users, err
+		}
+		users = append(users, u)
+	}
+
+	if err = rows.Err(); err != nil {
+		return users, err
+	}
+	return users, nil
+}
+
+// InsertAuditEvent records a change made from the admin in the audit log.
+func (m *postgresDBRepo) InsertAuditEvent(e models.AuditEvent) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	// A user id of 0 means the change was not made by a logged-in user, and is stored as null.
+	stmt := `insert into audit_events (user_id, action, entity_type, entity_id, changes, created_at, updated_at)
+			 values (nullif($1, 0), $2, $3, $4, $5, $6, $7)`
+
+	_, err := m.DB.ExecContext(ctx, stmt, e.UserID, e.Action, e.EntityType, e.EntityID, e.Changes, time.Now(),
+		time.Now())
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// GetAuditEvents returns the audit log matching the filter, most recent first.
+func (m *postgresDBRepo) GetAuditEvents(filter models.AuditFilter) ([]models.AuditEvent, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	var events []models.AuditEvent
+
+	// Each filter is skipped when its parameter holds the zero value. The end date is inclusive.
+	query := `
+		select a.id, coalesce(a.user_id, 0), a.action, a.entity_type, a.entity_id, a.changes, a.created_at,
+		a.updated_at, coalesce(u.first_name, ''), coalesce(u.last_name, '')
+		from audit_events a
+		left join users u on (a.user_id = u.id)
+		where ($1 = 0 or a.user_id = $1)
+		and ($2 = '' or a.entity_type = $2)
+		and ($3 = 0 or a.entity_id = $3)
+		and ($4::date is null or a.created_at >= $4::date)
+		and ($5::date is null or a.created_at < $5::date + 1)
+		order by a.created_at desc
+		limit 500
+`
+	rows, err := m.DB.QueryContext(ctx, query, filter.UserID, filter.EntityType, filter.EntityID,
+		nullableDate(filter.Start), nullableDate(filter.End))
+	if err != nil {
+		return events, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e models.AuditEvent
+		err := rows.Scan(&e.ID, &e.UserID, &e.Action, &e.EntityType, &e.EntityID, &e.Changes, &e.CreatedAt,
+			&e.UpdatedAt, &e.User.FirstName, &e.User.LastName)
+		if err != nil {
+			return events, err
+		}
+		e.User.ID = e.UserID
+		events = append(events, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return events, err
+	}
+	return events, nil
+}
+
+// ClaimIdempotencyKey stores a new idempotency key and returns claimed as true, meaning the caller processes the
+// submission. An expired key is claimed again as if it were new. If the key already exists, it is returned with
+// claimed as false.
+func (m *postgresDBRepo) ClaimIdempotencyKey(key, requestHash string,
+	expiresAt time.Time) (models.IdempotencyKey, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	record := models.IdempotencyKey{Key: key, RequestHash: requestHash, ExpiresAt: expiresAt}
+
+	// The primary key makes the insert the lock: only one of several concurrent submissions gets to insert the row.
+	stmt := `insert into idempotency_keys (key, request_hash, expires_at, created_at, updated_at)
+			 values ($1, $2, $3, $4, $5)
+			 on conflict (key) do update set request_hash = excluded.request_hash, reservation_id = null,
+			 group_id = null, expires_at = excluded.expires_at, created_at = excluded.created_at,
+			 updated_at = excluded.updated_at
+			 where idempotency_keys.expires_at <= $4`
+	result, err := m.DB.ExecContext(ctx, stmt, key, requestHash, expiresAt, time.Now(), time.Now())
+	if err != nil {
+		return record, false, err
+	}
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return record, false, err
+	}
+	if inserted == 1 {
+		return record, true, nil
+	}
+
+	query := `select key, request_hash, coalesce(reservation_id, 0), coalesce(group_id, 0), expires_at, created_at,
+			  updated_at from idempotency_keys where key = $1`
+	err = m.DB.QueryRowContext(ctx, query, key).Scan(&record.Key, &record.RequestHash, &record.ReservationID,
+		&record.GroupID, &record.ExpiresAt, &record.CreatedAt, &record.UpdatedAt)
+	if err != nil {
+		return record, false, err
+	}
+	return record, false, nil
+}
+
+// completeIdempotencyKeyTx stores the reservation or the group made by the submission that claimed the key inside
+// tx, so the key is never left without what it made. An empty key is skipped.
+func completeIdempotencyKeyTx(ctx context.Context, tx *sql.Tx, key string, reservationID, groupID int) error {
+	if key == "" {
+		return nil
+	}
+	query := `update idempotency_keys set reservation_id = $1, group_id = $2, updated_at = $3 where key = $4`
+	_, err := tx.ExecContext(ctx, query, nullableID(reservationID), nullableID(groupID), time.Now(), key)
+	return err
+}
+
+// DeleteIdempotencyKey removes a key whose submission failed, so it can be submitted again.
+func (m *postgresDBRepo) DeleteIdempotencyKey(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	query := `delete from idempotency_keys where key = $1 and reservation_id is null and group_id is null`
+	_, err := m.DB.ExecContext(ctx, query, key)
+	return err
+}
+
+// DeleteExpiredIdempotencyKeys removes the keys that can't be replayed anymore and returns how many were removed.
+func (m *postgresDBRepo) DeleteExpiredIdempotencyKeys() (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `delete from idempotency_keys where expires_at <= $1`, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// GetCancellationPolicies returns all the cancellation policies with their tiers, most generous tier first.
+func (m *postgresDBRepo) GetCancellationPolicies() ([]models.CancellationPolicy, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	var policies []models.CancellationPolicy
+
+	query := `
+		select p.id, p.name, p.created_at, p.updated_at, coalesce(t.id, 0), coalesce(t.days_before_arrival, 0),
+		coalesce(t.refund_percent, 0)
+		from cancellation_policies p
+		left join cancellation_policy_tiers t on (t.cancellation_policy_id = p.id)
+		order by p.id, t.days_before_arrival desc
+`
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return policies, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p models.CancellationPolicy
+		var tier models.CancellationTier
+		err := rows.Scan(&p.ID, &p.Name, &p.CreatedAt, &p.UpdatedAt, &tier.ID, &tier.DaysBeforeArrival,
+			&tier.RefundPercent)
+		if err != nil {
+			return policies, err
+		}
+		// Rows come ordered by policy, so a new policy starts whenever the id changes.
+		if len(policies) == 0 || policies[len(policies)-1].ID != p.ID {
+			policies = append(policies, p)
+		}
+		if tier.ID != 0 {
+			tier.CancellationPolicyID = p.ID
+			last := &policies[len(policies)-1]
+			last.Tiers = append(last.Tiers, tier)
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return policies, err
+	}
+	return policies, nil
+}
+
+// GetCancellationPolicyForRoom returns the cancellation policy of a room, with its tiers.
+func (m *postgresDBRepo) GetCancellationPolicyForRoom(roomID int) (models.CancellationPolicy, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	var policy models.CancellationPolicy
+
+	query := `
+		select p.id, p.name, p.created_at, p.updated_at
+		from cancellation_policies p
+		join rooms r on (r.cancellation_policy_id = p.id)
+		where r.id = $1
+`
+	err := m.DB.QueryRowContext(ctx, query, roomID).Scan(&policy.ID, &policy.Name, &policy.CreatedAt, &policy.UpdatedAt)
+	if err != nil {
+		return policy, err
+	}
+
+	query = `
+		select id, cancellation_policy_id, days_before_arrival, refund_percent, created_at, updated_at
+		from cancellation_policy_tiers
+		where cancellation_policy_id = $1
+		order by days_before_arrival desc
+`
+	rows, err := m.DB.QueryContext(ctx, query, policy.ID)
+	if err != nil {
+		return policy, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var t models.CancellationTier
+		err := rows.Scan(&t.ID, &t.CancellationPolicyID, &t.DaysBeforeArrival, &t.RefundPercent, &t.CreatedAt,
+			&t.UpdatedAt)
+		if err != nil {
+			return policy, err
+		}
+		policy.Tiers = append(policy.Tiers, t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return policy, err
+	}
+	return policy, nil
+}
+
+// InsertCancellationPolicy inserts a custom cancellation policy with its tiers and returns its id.
+func (m *postgresDBRepo) InsertCancellationPolicy(p models.CancellationPolicy) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var newID int
+	stmt := `insert into cancellation_policies (name, created_at, updated_at) values ($1, $2, $3) returning id`
+	err = tx.QueryRowContext(ctx, stmt, p.Name, time.Now(), time.Now()).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	stmt = `insert into cancellation_policy_tiers (cancellation_policy_id, days_before_arrival, refund_percent,
+			created_at, updated_at) values ($1, $2, $3, $4, $5)`
+	for _, t := range p.Tiers {
+		_, err = tx.ExecContext(ctx, stmt, newID, t.DaysBeforeArrival, t.RefundPercent, time.Now(), time.Now())
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return newID, tx.Commit()
+}
+
+// UpdateCancellationPolicyForRoom assigns a cancellation policy to a room.
+func (m *postgresDBRepo) UpdateCancellationPolicyForRoom(roomID, policyID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	query := `update rooms set cancellation_policy_id = $1, updated_at = $2 where id = $3`
+
+	_, err := m.DB.ExecContext(ctx, query, policyID, time.Now(), roomID)
+	return err
+}
+
+// UpdateRoomRates saves the base rate, the weekday/weekend differentials and the floor and ceiling of a room.
+func (m *postgresDBRepo) UpdateRoomRates(room models.Room) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	query := `update rooms set base_rate = $1, weekday_adjustment = $2, weekend_adjustment = $3, min_rate = $4,
+			  max_rate = $5, updated_at = $6 where id = $7`
+
+	_, err := m.DB.ExecContext(ctx, query, room.BaseRate, room.WeekdayAdjustment, room.WeekendAdjustment,
+		room.MinRate, room.MaxRate, time.Now(), room.ID)
+	return err
+}
+
+// GetRateRules returns every rate rule with the name of its room, ordered by room and date.
+func (m *postgresDBRepo) GetRateRules() ([]models.RateRule, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	var rules []models.RateRule
+
+	query := `select rr.id, rr.room_id, rr.name, rr.start_date, rr.end_date, rr.priority, rr.rate, rr.adjustment,
+			  rr.created_at, rr.updated_at, rm.room_name
+			  from rate_rules rr
+			  left join rooms rm on (rr.room_id = rm.id)
+			  order by rm.room_name, rr.start_date, rr.priority desc`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return rules, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rule models.RateRule
+		err := rows.Scan(&rule.ID, &rule.RoomID, &rule.Name, &rule.StartDate, &rule.EndDate, &rule.Priority,
+			&rule.Rate, &rule.Adjustment, &rule.CreatedAt, &rule.UpdatedAt, &rule.Room.RoomName)
+		if err != nil {
+			return rules, err
+		}
+		rule.Room.ID = rule.RoomID
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+// GetRateRuleByID returns a rate rule with the name of its room.
+func (m *postgresDBRepo) GetRateRuleByID(id int) (models.RateRule, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	var rule models.RateRule
+	query := `select rr.id, rr.room_id, rr.name, rr.start_date, rr.end_date, rr.priority, rr.rate, rr.adjustment,
+			  rr.created_at, rr.updated_at, rm.room_name
+			  from rate_rules rr
+			  left join rooms rm on (rr.room_id = rm.id)
+			  where rr.id = $1`
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&rule.ID, &rule.RoomID, &rule.Name, &rule.StartDate,
+		&rule.EndDate, &rule.Priority, &rule.Rate, &rule.Adjustment, &rule.CreatedAt, &rule.UpdatedAt,
+		&rule.Room.RoomName)
+	if err != nil {
+		return rule, err
+	}
+	rule.Room.ID = rule.RoomID
+	return rule, nil
+}
+
+// GetRateRulesForRoom returns the rate rules of a room covering at least one of the nights from start to end.
+func (m *postgresDBRepo) GetRateRulesForRoom(roomID int, start, end time.Time) ([]models.RateRule, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	var rules []models.RateRule
+
+	query := `select id, room_id, name, start_date, end_date, priority, rate, adjustment, created_at, updated_at
+			  from rate_rules
+			  where room_id = $1 and start_date < $2 and end_date >= $3`
+
+	rows, err := m.DB.QueryContext(ctx, query, roomID, end, start)
+	if err != nil {
+		return rules, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rule models.RateRule
+		err := rows.Scan(&rule.ID, &rule.RoomID, &rule.Name, &rule.StartDate, &rule.EndDate, &rule.Priority,
+			&rule.Rate, &rule.Adjustment, &rule.CreatedAt, &rule.UpdatedAt)
+		if err != nil {
+			return rules, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+// InsertRateRule inserts a rate rule and returns its id.
+func (m *postgresDBRepo) InsertRateRule(rule models.RateRule) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	var newID int
+
+	stmt := `insert into rate_rules (room_id, name, start_date, end_date, priority, rate, adjustment, created_at,
+			 updated_at) values ($1, $2, $3, $4, $5, $6, $7, $8, $9) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt, rule.RoomID, rule.Name, rule.StartDate, rule.EndDate, rule.Priority,
+		rule.Rate, rule.Adjustment, time.Now(), time.Now()).Scan(&newID)
+	return newID, err
+}
+
+// DeleteRateRule deletes a rate rule by id.
+func (m *postgresDBRepo) DeleteRateRule(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from rate_rules where id = $1`, id)
+	return err
+}
+
+// SetRateOverride fixes the price of one night in a room, replacing the override previously set for that night.
+// Returns the id of the override rule.
+func (m *postgresDBRepo) SetRateOverride(roomID int, night time.Time, rate int) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `delete from rate_rules where room_id = $1 and start_date = $2 and end_date = $2
+		and priority = $3`, roomID, night, models.OverridePriority)
+	if err != nil {
+		return 0, err
+	}
+
+	var newID int
+	stmt := `insert into rate_rules (room_id, name, start_date, end_date, priority, rate, adjustment, created_at,
+			 updated_at) values ($1, $2, $3, $3, $4, $5, 0, $6, $7) returning id`
+	err = tx.QueryRowContext(ctx, stmt, roomID, "Override", night, models.OverridePriority, rate, time.Now(),
+		time.Now()).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, tx.Commit()
+}
+
+// GetPricingRules returns every pricing rule of the dynamic pricing engine.
+func (m *postgresDBRepo) GetPricingRules() ([]models.PricingRule, error) {
+	return m.getPricingRules(`select id, name, kind, threshold, adjustment, active, created_at, updated_at
+		from pricing_rules order by kind, threshold`)
+}
+
+// GetActivePricingRules returns the pricing rules the dynamic pricing engine evaluates.
+func (m *postgresDBRepo) GetActivePricingRules() ([]models.PricingRule, error) {
+	return m.getPricingRules(`select id, name, kind, threshold, adjustment, active, created_at, updated_at
+		from pricing_rules where active = true order by kind, threshold`)
+}
+
+// GetPricingRuleByID returns a pricing rule, or sql.ErrNoRows if there is none with that id.
+func (m *postgresDBRepo) GetPricingRuleByID(id int) (models.PricingRule, error) {
+	rules, err := m.getPricingRules(`select id, name, kind, threshold, adjustment, active, created_at, updated_at
+		from pricing_rules where id = $1`, id)
+	if err != nil {
+		return models.PricingRule{}, err
+	}
+	if len(rules) == 0 {
+		return models.PricingRule{}, sql.ErrNoRows
+	}
+	return rules[0], nil
+}
+
+// getPricingRules returns the pricing rules selected by query.
+func (m *postgresDBRepo) getPricingRules(query string, args ...interface{}) ([]models.PricingRule, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	var rules []models.PricingRule
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return rules, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rule models.PricingRule
+		err := rows.Scan(&rule.ID, &rule.Name, &rule.Kind, &rule.Threshold, &rule.Adjustment, &rule.Active,
+			&rule.CreatedAt, &rule.UpdatedAt)
+		if err != nil {
+			return rules, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+// InsertPricingRule inserts an active pricing rule and returns its id.
+func (m *postgresDBRepo) InsertPricingRule(rule models.PricingRule) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	var newID int
+
+	stmt := `insert into pricing_rules (name, kind, threshold, adjustment, active, created_at, updated_at)
+			 values ($1, $2, $3, $4, true, $5, $6) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt, rule.Name, rule.Kind, rule.Threshold, rule.Adjustment, time.Now(),
+		time.Now()).Scan(&newID)
+	return newID, err
+}
+
+// UpdatePricingRuleActive turns a pricing rule on or off.
+func (m *postgresDBRepo) UpdatePricingRuleActive(id int, active bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `update pricing_rules set active = $1, updated_at = $2 where id = $3`, active,
+		time.Now(), id)
+	return err
+}
+
+// DeletePricingRule deletes a pricing rule by id.
+func (m *postgresDBRepo) DeletePricingRule(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from pricing_rules where id = $1`, id)
+	return err
+}
+
+// GetBookedNights returns how many nights of a room from start to end are taken by reservations or owner blocks, on
+// average across the units of the room in use. Holds and stay rules aren't counted, they don't mean the room was
+// booked.
+func (m *postgresDBRepo) GetBookedNights(roomID int, start, end time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	var nights int
+
+	// Owner blocks don't have a unit, they take all of them.
+	query := `select coalesce(sum((least(rr.end_date, $3::date) - greatest(rr.start_date, $2::date)) *
+			  case when rr.unit_id is null then u.units else 1 end) / max(u.units), 0)
+			  from room_restrictions rr,
+			  (select greatest(count(id), 1) as units from room_units where room_id = $1 and archived_at is null) u
+			  where rr.room_id = $1 and rr.start_date < $3 and rr.end_date > $2 and rr.restriction_id in ($4, $5)`
+
+	err := m.DB.QueryRowContext(ctx, query, roomID, start, end, models.RestrictionReservation,
+		models.RestrictionOwnerBlock).Scan(&nights)
+	return nights, err
+}
+
+// GetCharges returns every tax and fee, in the order they are added to quotes.
+func (m *postgresDBRepo) GetCharges() ([]models.Charge, error) {
+	return m.getCharges(`select id, name, kind, calculation, per, amount, start_date, end_date, created_at,
+		updated_at from charges order by kind desc, id`)
+}
+
+// GetChargesForStay returns the taxes and fees in effect on at least one of the nights from start to end.
+func (m *postgresDBRepo) GetChargesForStay(start, end time.Time) ([]models.Charge, error) {
+	return m.getCharges(`select id, name, kind, calculation, per, amount, start_date, end_date, created_at,
+		updated_at from charges where (start_date is null or start_date < $1) and (end_date is null or end_date >= $2)
+		order by kind desc, id`, end, start)
+}
+
+// GetChargeByID returns a tax or fee, or sql.ErrNoRows if there is none with that id.
+func (m *postgresDBRepo) GetChargeByID(id int) (models.Charge, error) {
+	charges, err := m.getCharges(`select id, name, kind, calculation, per, amount, start_date, end_date, created_at,
+		updated_at from charges where id = $1`, id)
+	if err != nil {
+		return models.Charge{}, err
+	}
+	if len(charges) == 0 {
+		return models.Charge{}, sql.ErrNoRows
+	}
+	return charges[0], nil
+}
+
+// getCharges returns the charges selected by query.
+func (m *postgresDBRepo) getCharges(query string, args ...interface{}) ([]models.Charge, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	var charges []models.Charge
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return charges, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var charge models.Charge
+		var startDate, endDate sql.NullTime
+		err := rows.Scan(&charge.ID, &charge.Name, &charge.Kind, &charge.Calculation, &charge.Per, &charge.Amount,
+			&startDate, &endDate, &charge.CreatedAt, &charge.UpdatedAt)
+		if err != nil {
+			return charges, err
+		}
+		charge.StartDate, charge.EndDate = startDate.Time, endDate.Time
+		charges = append(charges, charge)
+	}
+	return charges, rows.Err()
+}
+
+// InsertCharge inserts a tax or fee and returns its id.
+func (m *postgresDBRepo) InsertCharge(charge models.Charge) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	var newID int
+
+	stmt := `insert into charges (name, kind, calculation, per, amount, start_date, end_date, created_at, updated_at)
+			 values ($1, $2, $3, $4, $5, $6, $7, $8, $9) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt, charge.Name, charge.Kind, charge.Calculation, charge.Per, charge.Amount,
+		nullableDate(charge.StartDate), nullableDate(charge.EndDate), time.Now(), time.Now()).Scan(&newID)
+	return newID, err
+}
+
+// DeleteCharge deletes a tax or fee. Reservations keep the line items they were charged.
+func (m *postgresDBRepo) DeleteCharge(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from charges where id = $1`, id)
+	return err
+}
+
+// nullableID converts a zero id to a SQL null, for optional foreign keys.
+func nullableID(id int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(id), Valid: id != 0}
+}
+
+// nullableDate converts a zero time to a SQL null, for optional date parameters.
+func nullableDate(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+// stayRulesQuery selects the stay rules that can apply to a stay, from its arrival to its departure day included.
+// Its parameters are models.RestrictionMinStay and the departure and arrival dates.
+const stayRulesQuery = `select id, room_id, restriction_id, start_date, end_date, nights, days, created_at, updated_at
+	from room_restrictions where restriction_id >= $1 and start_date <= $2 and end_date > $3`
+
+// GetStayRules returns the stay rules of every room, with the name of the room.
+func (m *postgresDBRepo) GetStayRules() ([]models.RoomRestriction, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	var rules []models.RoomRestriction
+
+	query := `select rr.id, rr.room_id, rr.restriction_id, rr.start_date, rr.end_date, rr.nights, rr.days,
+			  rr.created_at, rr.updated_at, r.room_name
+			  from room_restrictions rr left join rooms r on (r.id = rr.room_id)
+			  where rr.restriction_id >= $1 order by r.room_name, rr.start_date, rr.restriction_id`
+
+	rows, err := m.DB.QueryContext(ctx, query, models.RestrictionMinStay)
+	if err != nil {
+		return rules, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rule models.RoomRestriction
+		err := rows.Scan(&rule.ID, &rule.RoomID, &rule.RestrictionID, &rule.StartDate, &rule.EndDate, &rule.Nights,
+			&rule.Days, &rule.CreatedAt, &rule.UpdatedAt, &rule.Room.RoomName)
+		if err != nil {
+			return rules, err
+		}
+		rule.Room.ID = rule.RoomID
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+// GetStayRuleByID returns a stay rule, or sql.ErrNoRows if there is none with that id.
+func (m *postgresDBRepo) GetStayRuleByID(id int) (models.RoomRestriction, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	rules, err := m.getStayRules(ctx, `select id, room_id, restriction_id, start_date, end_date, nights, days,
+		created_at, updated_at from room_restrictions where restriction_id >= $1 and id = $2`,
+		models.RestrictionMinStay, id)
+	if err != nil {
+		return models.RoomRestriction{}, err
+	}
+	if len(rules) == 0 {
+		return models.RoomRestriction{}, sql.ErrNoRows
+	}
+	return rules[0], nil
+}
+
+// GetStayRulesForRoom returns the stay rules of a room that can apply to a stay from start to end.
+func (m *postgresDBRepo) GetStayRulesForRoom(roomID int, start, end time.Time) ([]models.RoomRestriction, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	return m.getStayRules(ctx, stayRulesQuery+` and room_id = $4`, models.RestrictionMinStay, end, start, roomID)
+}
+
+// getStayRules returns the stay rules selected by query.
+func (m *postgresDBRepo) getStayRules(ctx context.Context, query string, args ...interface{}) ([]models.RoomRestriction,
+	error) {
+	var rules []models.RoomRestriction
+
+	rows, err := m.DB.QueryContext(ctx, query+` order by restriction_id, start_date`, args...)
+	if err != nil {
+		return rules, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rule models.RoomRestriction
+		err := rows.Scan(&rule.ID, &rule.RoomID, &rule.RestrictionID, &rule.StartDate, &rule.EndDate, &rule.Nights,
+			&rule.Days, &rule.CreatedAt, &rule.UpdatedAt)
+		if err != nil {
+			return rules, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+// InsertStayRule inserts a stay rule for a room and returns its id.
+func (m *postgresDBRepo) InsertStayRule(rule models.RoomRestriction) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	var newID int
+
+	stmt := `insert into room_restrictions (start_date, end_date, room_id, restriction_id, nights, days, created_at,
+			 updated_at) values ($1, $2, $3, $4, $5, $6, $7, $8) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt, rule.StartDate, rule.EndDate, rule.RoomID, rule.RestrictionID,
+		rule.Nights, rule.Days, time.Now(), time.Now()).Scan(&newID)
+	return newID, err
+}
+
+// DeleteStayRule deletes a stay rule. Other restrictions are left alone even if their id is given.
+func (m *postgresDBRepo) DeleteStayRule(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from room_restrictions where id = $1 and restriction_id >= $2`, id,
+		models.RestrictionMinStay)
+	return err
+}
+
+// roomPhotoColumns are the columns of room_photos, in the order scanRoomPhoto reads them.
+const roomPhotoColumns = `id, room_id, path, caption, sort_order, width, height, created_at, updated_at`
+
+// scanRoomPhoto reads a room photo selected with roomPhotoColumns.
+func scanRoomPhoto(row scanner) (models.RoomPhoto, error) {
+	var p models.RoomPhoto
+	err := row.Scan(&p.ID, &p.RoomID, &p.Path, &p.Caption, &p.SortOrder, &p.Width, &p.Height, &p.CreatedAt,
+		&p.UpdatedAt)
+	return p, err
+}
+
+// GetPhotosForRoom returns the photos of a room, in the order they are shown on its page.
+func (m *postgresDBRepo) GetPhotosForRoom(roomID int) ([]models.RoomPhoto, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	var photos []models.RoomPhoto
+
+	query := `select ` + roomPhotoColumns + ` from room_photos where room_id = $1 order by sort_order, id`
+	rows, err := m.DB.QueryContext(ctx, query, roomID)
+	if err != nil {
+		return photos, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		p, err := scanRoomPhoto(rows)
+		if err != nil {
+			return photos, err
+		}
+		photos = append(photos, p)
+	}
+	return photos, rows.Err()
+}
+
+// GetCoverPhotos returns the first photo of every room that has photos, by room id.
+func (m *postgresDBRepo) GetCoverPhotos() (map[int]models.RoomPhoto, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	covers := make(map[int]models.RoomPhoto)
+
+	query := `select distinct on (room_id) ` + roomPhotoColumns + ` from room_photos order by room_id, sort_order, id`
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return covers, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		p, err := scanRoomPhoto(rows)
+		if err != nil {
+			return covers, err
+		}
+		covers[p.RoomID] = p
+	}
+	return covers, rows.Err()
+}
+
+// GetRoomPhotoByID returns a room photo by id.
+func (m *postgresDBRepo) GetRoomPhotoByID(id int) (models.RoomPhoto, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	row := m.DB.QueryRowContext(ctx, `select `+roomPhotoColumns+` from room_photos where id = $1`, id)
+	return scanRoomPhoto(row)
+}
+
+// InsertRoomPhoto inserts a photo of a room, last in the order of its photos, and returns its id.
+func (m *postgresDBRepo) InsertRoomPhoto(photo models.RoomPhoto) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	var newID int
+
+	stmt := `insert into room_photos (room_id, path, caption, width, height, sort_order, created_at, updated_at)
+			 values ($1, $2, $3, $4, $5, (select coalesce(max(sort_order), 0) + 1 from room_photos where room_id = $1),
+			 $6, $7) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt, photo.RoomID, photo.Path, photo.Caption, photo.Width, photo.Height,
+		time.Now(), time.Now()).Scan(&newID)
+	return newID, err
+}
+
+// UpdateRoomPhotoCaption updates the caption of a room photo.
+func (m *postgresDBRepo) UpdateRoomPhotoCaption(id int, caption string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `update room_photos set caption = $1, updated_at = $2 where id = $3`, caption,
+		time.Now(), id)
+	return err
+}
+
+// UpdateRoomPhotoOrder sets the order the photos of a room are shown in, from the ids of the photos in that order.
+// Ids of photos of other rooms are ignored.
+func (m *postgresDBRepo) UpdateRoomPhotoOrder(roomID int, ids []int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for i, id := range ids {
+		_, err = tx.ExecContext(ctx, `update room_photos set sort_order = $1, updated_at = $2
+			where id = $3 and room_id = $4`, i+1, time.Now(), id, roomID)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// DeleteRoomPhoto deletes a room photo. Its files are left in the storage, for the caller to delete.
+func (m *postgresDBRepo) DeleteRoomPhoto(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from room_photos where id = $1`, id)
+	return err
+}
+
+// GetUnitsForRoom returns the units of a room, in the order they are assigned. Archived units come last.
+func (m *postgresDBRepo) GetUnitsForRoom(roomID int) ([]models.RoomUnit, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	var units []models.RoomUnit
+
+	query := `select id, room_id, name, sort_order, archived_at, created_at, updated_at from room_units
+			  where room_id = $1 order by archived_at is not null, sort_order, id`
+	rows, err := m.DB.QueryContext(ctx, query, roomID)
+	if err != nil {
+		return units, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var u models.RoomUnit
+		var archivedAt sql.NullTime
+		err := rows.Scan(&u.ID, &u.RoomID, &u.Name, &u.SortOrder, &archivedAt, &u.CreatedAt, &u.UpdatedAt)
+		if err != nil {
+			return units, err
+		}
+		u.ArchivedAt = archivedAt.Time
+		units = append(units, u)
+	}
+	return units, rows.Err()
+}
+
+// InsertRoomUnit inserts a unit of a room, last in the order units are assigned in, and returns its id.
+func (m *postgresDBRepo) InsertRoomUnit(unit models.RoomUnit) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	var newID int
+
+	stmt := `insert into room_units (room_id, name, sort_order, created_at, updated_at)
+			 values ($1, $2, (select coalesce(max(sort_order), 0) + 1 from room_units where room_id = $1), $3, $4)
+			 returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt, unit.RoomID, unit.Name, time.Now(), time.Now()).Scan(&newID)
+	return newID, err
+}
+
+// UpdateRoomUnit updates the name of a unit.
+func (m *postgresDBRepo) UpdateRoomUnit(unit models.RoomUnit) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `update room_units set name = $1, updated_at = $2 where id = $3`, unit.Name,
+		time.Now(), unit.ID)
+	return err
+}
+
+// SetRoomUnitArchived takes a unit out of use, or puts an archived unit back in use.
+func (m *postgresDBRepo) SetRoomUnitArchived(id int, archived bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	archivedAt := sql.NullTime{Time: time.Now(), Valid: archived}
+	_, err := m.DB.ExecContext(ctx, `update room_units set archived_at = $1, updated_at = $2 where id = $3`,
+		archivedAt, time.Now(), id)
+	return err
+}
+
+// AssignReservationUnit moves a reservation to another unit of its room, overriding the automatic assignment.
+// Returns repository.ErrRoomNotAvailable if the unit isn't a unit in use of the room, or is taken for the dates of
+// the reservation.
+func (m *postgresDBRepo) AssignReservationUnit(reservationID, unitID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var roomID int
+	var start, end time.Time
+	err = tx.QueryRowContext(ctx, `select room_id, start_date, end_date from reservations where id = $1`,
+		reservationID).Scan(&roomID, &start, &end)
+	if err != nil {
+		return err
+	}
+
+	// Lock the room, so a guest can't hold the unit while the reservation is moved to it.
+	_, err = tx.ExecContext(ctx, `select id from rooms where id = $1 for update`, roomID)
+	if err != nil {
+		return err
+	}
+
+	units, err := freeUnits(ctx, tx, roomID, start, end, reservationID)
+	if err != nil {
+		return err
+	}
+	free := false
+	for _, id := range units {
+		free = free || id == unitID
+	}
+	if !free {
+		return repository.ErrRoomNotAvailable
+	}
+
+	_, err = tx.ExecContext(ctx, `update reservations set unit_id = $1, updated_at = $2 where id = $3`, unitID,
+		time.Now(), reservationID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `update room_restrictions set unit_id = $1, updated_at = $2
+		where reservation_id = $3`, unitID, time.Now(), reservationID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetAmenities returns every amenity, by name.
+func (m *postgresDBRepo) GetAmenities() ([]models.Amenity, error) {
+	return m.getAmenities(`select id, name, icon, created_at, updated_at from amenities order by name`)
+}
+
+// GetAmenitiesForRoom returns the amenities of a room, by name.
+func (m *postgresDBRepo) GetAmenitiesForRoom(roomID int) ([]models.Amenity, error) {
+	return m.getAmenities(`select a.id, a.name, a.icon, a.created_at, a.updated_at from amenities a
+		join room_amenities ra on (ra.amenity_id = a.id) where ra.room_id = $1 order by a.name`, roomID)
+}
+
+// getAmenities returns the amenities selected by query.
+func (m *postgresDBRepo) getAmenities(query string, args ...interface{}) ([]models.Amenity, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	var amenities []models.Amenity
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return amenities, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var a models.Amenity
+		err := rows.Scan(&a.ID, &a.Name, &a.Icon, &a.CreatedAt, &a.UpdatedAt)
+		if err != nil {
+			return amenities, err
+		}
+		amenities = append(amenities, a)
+	}
+	return amenities, rows.Err()
+}
+
+// InsertAmenity inserts an amenity and returns its id. Returns repository.ErrAmenityTaken if another amenity already
+// has its name.
+func (m *postgresDBRepo) InsertAmenity(amenity models.Amenity) (int, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
 	defer cancel()
 
-	query := `update users set first_name=$1, last_name=$2, email=$3, access_level=$4, updated_at=$5`
+	var newID int
 
-	_, err := m.DB.ExecContext(ctx, query, u.FirstName, u.LastName, u.Email, u.AccessLevel, time.Now())
+	stmt := `insert into amenities (name, icon, created_at, updated_at) values ($1, $2, $3, $4) returning id`
 
-	if err != nil {
-		return err
+	err := m.DB.QueryRowContext(ctx, stmt, amenity.Name, amenity.Icon, time.Now(), time.Now()).Scan(&newID)
+	if isUniqueViolation(err) {
+		return 0, repository.ErrAmenityTaken
 	}
+	return newID, err
+}
 
-	return nil
+// UpdateAmenity updates the name and icon of an amenity. Returns repository.ErrAmenityTaken if another amenity
+// already has its name.
+func (m *postgresDBRepo) UpdateAmenity(amenity models.Amenity) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `update amenities set name = $1, icon = $2, updated_at = $3 where id = $4`,
+		amenity.Name, amenity.Icon, time.Now(), amenity.ID)
+	if isUniqueViolation(err) {
+		return repository.ErrAmenityTaken
+	}
+	return err
 }
 
-// Authenticate authenticates a user.
-func (m *postgresDBRepo) Authenticate(email, userTypedPassword string) (int, string, error) {
+// DeleteAmenity deletes an amenity, removing it from the rooms that had it.
+func (m *postgresDBRepo) DeleteAmenity(id int) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
 	defer cancel()
 
-	var id int // Holds the id of the authenticated user
-	var hashedPassword string
+	_, err := m.DB.ExecContext(ctx, `delete from amenities where id = $1`, id)
+	return err
+}
 
-	row := m.DB.QueryRowContext(ctx, "select id, password from users where email=$1", email)
-	err := row.Scan(&id, &hashedPassword)
+// SetRoomAmenities replaces the amenities of a room with the ones with the given ids.
+func (m *postgresDBRepo) SetRoomAmenities(roomID int, amenityIDs []int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
 	if err != nil {
-		return id, "", err
+		return err
 	}
+	defer tx.Rollback()
 
-	// At this point, the user is fetched from the DB so the email is correct, but the password is not yet verified.
-
-	// Compare the hash of the password entered by the user to the hash of the password stored in the DB.
-	err = bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(userTypedPassword))
-	if err == bcrypt.ErrMismatchedHashAndPassword {
-		return 0, "", errors.New("incorrect password")
-	}
+	_, err = tx.ExecContext(ctx, `delete from room_amenities where room_id = $1`, roomID)
 	if err != nil {
-		return 0, "", err
+		return err
 	}
-	return id, hashedPassword, nil
+	for _, id := range amenityIDs {
+		_, err = tx.ExecContext(ctx, `insert into room_amenities (room_id, amenity_id, created_at, updated_at)
+			values ($1, $2, $3, $4)`, roomID, id, time.Now(), time.Now())
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
 }
 
-// GetAllReservations returns a slice of all reservations.
-func (m *postgresDBRepo) GetAllReservations() ([]models.Reservation, error) {
+// GetExtras returns every extra, by name.
+func (m *postgresDBRepo) GetExtras() ([]models.Extra, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
 	defer cancel()
 
-	var reservations []models.Reservation
+	return m.getExtras(ctx, `select `+extraColumns+` from extras order by name`)
+}
 
-	query := `
-		select r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date, r.room_id,
-		r.created_at, r.updated_at, r.processed, rm.id, rm.room_name
-		from reservations r
-		left join rooms rm on (r.room_id = rm.id)
-		order by r.start_date asc
-`
+// GetExtrasForStay returns the active extras, by name, with the units limited extras have left on every day of a
+// stay from start to end in their Remaining field. The extras of the given reservation are ignored, 0 for none.
+func (m *postgresDBRepo) GetExtrasForStay(start, end time.Time, reservationID int) ([]models.Extra, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
 
-	rows, err := m.DB.QueryContext(ctx, query)
+	extras, err := m.getExtras(ctx, `select `+extraColumns+` from extras where active order by name`)
 	if err != nil {
-		return reservations, err
+		return extras, err
 	}
-	defer rows.Close()
-	for rows.Next() {
-		var reserv models.Reservation
-		err = rows.Scan(&reserv.ID,
-			&reserv.FirstName,
-			&reserv.LastName,
-			&reserv.Email,
-			&reserv.Phone,
-			&reserv.StartDate,
-			&reserv.EndDate,
-			&reserv.RoomID,
-			&reserv.CreatedAt,
-			&reserv.UpdatedAt,
-			&reserv.Processed,
-			&reserv.Room.ID,
-			&reserv.Room.RoomName)
+	for i, extra := range extras {
+		if !extra.Limited() {
+			continue
+		}
+		first, last := models.ExtraDays(extra.Per, start, end)
+		used, err := usedExtraUnits(ctx, m.DB, extra.ID, first, last, reservationID)
 		if err != nil {
-			return reservations, err
+			return extras, err
+		}
+		if used < extra.Inventory {
+			extras[i].Remaining = extra.Inventory - used
 		}
-
-		reservations = append(reservations, reserv)
-	}
-	if err = rows.Err(); err != nil {
-		return reservations, err
 	}
-	return reservations, nil
-
+	return extras, nil
 }
 
-// GetNewReservations returns a slice of all new (not processed) reservations.
-func (m *postgresDBRepo) GetNewReservations() ([]models.Reservation, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
-	defer cancel()
-
-	var reservations []models.Reservation
+// extraColumns are the columns of the extras table, in the order getExtras reads them.
+const extraColumns = `id, name, per, price, inventory, active, created_at, updated_at`
 
-	query := `
-		select r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date, r.room_id,
-		r.created_at, r.updated_at, rm.id, rm.room_name
-		from reservations r
-		left join rooms rm on (r.room_id = rm.id)
-		where r.processed = 0
-		order by r.start_date asc
-`
+// getExtras returns the extras selected by query.
+func (m *postgresDBRepo) getExtras(ctx context.Context, query string, args ...interface{}) ([]models.Extra, error) {
+	var extras []models.Extra
 
-	rows, err := m.DB.QueryContext(ctx, query)
+	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
-		return reservations, err
+		return extras, err
 	}
 	defer rows.Close()
+
 	for rows.Next() {
-		var reserv models.Reservation
-		err = rows.Scan(&reserv.ID,
-			&reserv.FirstName,
-			&reserv.LastName,
-			&reserv.Email,
-			&reserv.Phone,
-			&reserv.StartDate,
-			&reserv.EndDate,
-			&reserv.RoomID,
-			&reserv.CreatedAt,
-			&reserv.UpdatedAt,
-			&reserv.Room.ID,
-			&reserv.Room.RoomName)
+		var e models.Extra
+		err := rows.Scan(&e.ID, &e.Name, &e.Per, &e.Price, &e.Inventory, &e.Active, &e.CreatedAt, &e.UpdatedAt)
 		if err != nil {
-			return reservations, err
+			return extras, err
 		}
-
-		reservations = append(reservations, reserv)
-	}
-	if err = rows.Err(); err != nil {
-		return reservations, err
+		extras = append(extras, e)
 	}
-	return reservations, nil
-
+	return extras, rows.Err()
 }
 
-// GetReservationByID returns one reservation with the given ID.
-func (m *postgresDBRepo) GetReservationByID(id int) (models.Reservation, error) {
+// InsertExtra inserts an extra and returns its id. Returns repository.ErrExtraTaken if another extra already has its
+// name.
+func (m *postgresDBRepo) InsertExtra(extra models.Extra) (int, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
 	defer cancel()
 
-	var reservation models.Reservation
+	var newID int
 
-	query := `
-		select r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date, r.room_id,
-		r.created_at, r.updated_at, r.processed, rm.id, rm.room_name
-		from reservations r
-		left join rooms rm on (r.room_id = rm.id)
-		where r.id=$1
-`
+	stmt := `insert into extras (name, per, price, inventory, active, created_at, updated_at)
+			 values ($1, $2, $3, $4, $5, $6, $7) returning id`
 
-	row := m.DB.QueryRowContext(ctx, query, id)
-	err := row.Scan(&reservation.ID,
-		&reservation.FirstName,
-		&reservation.LastName,
-		&reservation.Email,
-		&reservation.Phone,
-		&reservation.StartDate,
-		&reservation.EndDate,
-		&reservation.RoomID,
-		&reservation.CreatedAt,
-		&reservation.UpdatedAt,
-		&reservation.Processed,
-		&reservation.Room.ID,
-		&reservation.Room.RoomName)
-	if err != nil {
-		return reservation, err
+	err := m.DB.QueryRowContext(ctx, stmt, extra.Name, extra.Per, extra.Price, extra.Inventory, extra.Active,
+		time.Now(), time.Now()).Scan(&newID)
+	if isUniqueViolation(err) {
+		return 0, repository.ErrExtraTaken
 	}
-	return reservation, nil
+	return newID, err
 }
 
-// UpdateReservation updates a reservation in the database.
-func (m *postgresDBRepo) UpdateReservation(res models.Reservation) error {
+// UpdateExtra updates an extra. Reservations keep the price they booked it at. Returns repository.ErrExtraTaken if
+// another extra already has its name.
+func (m *postgresDBRepo) UpdateExtra(extra models.Extra) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
 	defer cancel()
 
-	query := `update reservations set first_name=$1, last_name=$2, email=$3, phone=$4, updated_at=$5 where id=$6`
+	_, err := m.DB.ExecContext(ctx, `update extras set name = $1, per = $2, price = $3, inventory = $4, active = $5,
+		updated_at = $6 where id = $7`, extra.Name, extra.Per, extra.Price, extra.Inventory, extra.Active, time.Now(),
+		extra.ID)
+	if isUniqueViolation(err) {
+		return repository.ErrExtraTaken
+	}
+	return err
+}
 
-	_, err := m.DB.ExecContext(ctx, query, res.FirstName, res.LastName, res.Email, res.Phone, time.Now(), res.ID)
+// waitlistColumns are the columns of a waitlist entry, with the name of its room, in the order scanWaitlistEntry reads
+// them. Queries using it select from waitlist_entries w joined with rooms rm.
+const waitlistColumns = `w.id, w.room_id, rm.room_name, w.start_date, w.end_date, w.first_name, w.last_name, w.email,
+	w.adults, w.children, w.status, coalesce(w.hold_id, 0), w.notified_at, w.claim_expires_at, w.created_at,
+	w.updated_at`
+
+// scanWaitlistEntry reads a waitlist entry selected with waitlistColumns.
+func scanWaitlistEntry(row scanner) (models.WaitlistEntry, error) {
+	var entry models.WaitlistEntry
+	var notifiedAt, claimExpiresAt sql.NullTime
+	err := row.Scan(
+		&entry.ID,
+		&entry.RoomID,
+		&entry.Room.RoomName,
+		&entry.StartDate,
+		&entry.EndDate,
+		&entry.FirstName,
+		&entry.LastName,
+		&entry.Email,
+		&entry.Adults,
+		&entry.Children,
+		&entry.Status,
+		&entry.HoldID,
+		&notifiedAt,
+		&claimExpiresAt,
+		&entry.CreatedAt,
+		&entry.UpdatedAt,
+	)
+	entry.Room.ID = entry.RoomID
+	entry.NotifiedAt = notifiedAt.Time
+	entry.ClaimExpiresAt = claimExpiresAt.Time
+	return entry, err
+}
 
+// getWaitlistEntries returns the waitlist entries selected by query, which selects waitlistColumns.
+func (m *postgresDBRepo) getWaitlistEntries(ctx context.Context, query string,
+	args ...interface{}) ([]models.WaitlistEntry, error) {
+	var entries []models.WaitlistEntry
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
-		return err
+		return entries, err
 	}
+	defer rows.Close()
 
-	return nil
+	for rows.Next() {
+		entry, err := scanWaitlistEntry(rows)
+		if err != nil {
+			return entries, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
 }
 
-// DeleteReservation deletes a reservation with a given ID.
-func (m *postgresDBRepo) DeleteReservation(id int) error {
+// InsertWaitlistEntry puts a guest on the waitlist of a room for their stay and returns the id of the new entry.
+func (m *postgresDBRepo) InsertWaitlistEntry(entry models.WaitlistEntry) (int, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
 	defer cancel()
 
-	query := `delete from reservations where id=$1`
-
-	_, err := m.DB.ExecContext(ctx, query, id)
-
+	var newID int
+	stmt := `insert into waitlist_entries (room_id, start_date, end_date, first_name, last_name, email, adults,
+			 children, status, created_at, updated_at)
+			 values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) returning id`
+	err := m.DB.QueryRowContext(ctx, stmt, entry.RoomID, entry.StartDate, entry.EndDate, entry.FirstName,
+		entry.LastName, entry.Email, entry.Adults, entry.Children, models.WaitlistWaiting, time.Now(),
+		time.Now()).Scan(&newID)
 	if err != nil {
-		return err
+		return 0, err
 	}
-	return nil
+	return newID, nil
+}
+
+// GetWaitingEntries returns the entries still waiting for a room whose stay overlaps the dates from start to end, in
+// the order the guests joined the waitlist.
+func (m *postgresDBRepo) GetWaitingEntries(roomID int, start, end time.Time) ([]models.WaitlistEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
 
+	query := `select ` + waitlistColumns + `
+		from waitlist_entries w
+		left join rooms rm on (w.room_id = rm.id)
+		where w.room_id = $1 and w.status = $2 and w.start_date < $3 and w.end_date > $4
+		order by w.id`
+	return m.getWaitlistEntries(ctx, query, roomID, models.WaitlistWaiting, end, start)
 }
 
-// UpdateProcessedForReservation updates the processed attribute for a reservation with a given ID.
-func (m *postgresDBRepo) UpdateProcessedForReservation(id, processed int) error {
+// NotifyWaitlistEntry records that a waiting guest was sent a claim link, with the hash of its token, and the hold
+// placed on the room for them until the claim expires.
+func (m *postgresDBRepo) NotifyWaitlistEntry(id, holdID int, tokenHash string, expiresAt time.Time) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
 	defer cancel()
 
-	query := `update reservations set processed = $1 where id = $2`
+	stmt := `update waitlist_entries set status = $1, hold_id = $2, token_hash = $3, notified_at = $4,
+			 claim_expires_at = $5, updated_at = $4
+			 where id = $6 and status = $7`
+	_, err := m.DB.ExecContext(ctx, stmt, models.WaitlistNotified, holdID, tokenHash, time.Now(), expiresAt, id,
+		models.WaitlistWaiting)
+	return err
+}
 
-	_, err := m.DB.ExecContext(ctx, query, processed, id)
+// ClaimWaitlistEntry marks the entry a claim link was sent for as claimed, and returns it. Returns
+// repository.ErrWaitlistClaimNotFound if the link is unknown, expired or was already used.
+func (m *postgresDBRepo) ClaimWaitlistEntry(tokenHash string) (models.WaitlistEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
 
-	if err != nil {
-		return err
+	// Marking the entry as claimed in the same statement that reads it guarantees the link can only be used once.
+	query := `with claimed as (
+				update waitlist_entries set status = $1, updated_at = $2
+				where token_hash = $3 and status = $4 and claim_expires_at > $2
+				returning *)
+			  select ` + waitlistColumns + `
+			  from claimed w
+			  left join rooms rm on (w.room_id = rm.id)`
+	entry, err := scanWaitlistEntry(m.DB.QueryRowContext(ctx, query, models.WaitlistClaimed, time.Now(), tokenHash,
+		models.WaitlistNotified))
+	if errors.Is(err, sql.ErrNoRows) {
+		return entry, repository.ErrWaitlistClaimNotFound
 	}
-	return nil
+	return entry, err
+}
+
+// ExpireWaitlistClaims marks the entries whose claim link expired unused as expired, and returns them.
+func (m *postgresDBRepo) ExpireWaitlistClaims() ([]models.WaitlistEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
 
+	query := `with expired as (
+				update waitlist_entries set status = $1, updated_at = $2
+				where status = $3 and claim_expires_at <= $2
+				returning *)
+			  select ` + waitlistColumns + `
+			  from expired w
+			  left join rooms rm on (w.room_id = rm.id)
+			  order by w.id`
+	return m.getWaitlistEntries(ctx, query, models.WaitlistExpired, time.Now(), models.WaitlistNotified)
 }
 
-// GetAllRooms gets all the rooms in the DB.
-func (m *postgresDBRepo) GetAllRooms() ([]models.Room, error) {
+// GetWaitlistEntriesForDate returns the waitlist entries whose stay includes the night of date, whatever their
+// status, by room and in the order the guests joined the waitlist.
+func (m *postgresDBRepo) GetWaitlistEntriesForDate(date time.Time) ([]models.WaitlistEntry, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
 	defer cancel()
 
-	var rooms []models.Room
+	query := `select ` + waitlistColumns + `
+		from waitlist_entries w
+		left join rooms rm on (w.room_id = rm.id)
+		where w.start_date <= $1 and w.end_date > $1
+		order by rm.sort_order, rm.room_name, w.id`
+	return m.getWaitlistEntries(ctx, query, date)
+}
+
+// promoCodeColumns are the columns of a promo code, in the order scanPromoCode reads them. Queries using it select
+// from promo_codes p.
+const promoCodeColumns = `p.id, p.code, p.description, p.kind, p.amount, p.valid_from, p.valid_until, p.min_nights,
+	p.max_uses, p.max_uses_per_email, p.stackable, p.active, p.created_at, p.updated_at`
+
+// scanPromoCode reads a promo code selected with promoCodeColumns, followed by the given destinations.
+func scanPromoCode(row scanner, dest ...interface{}) (models.PromoCode, error) {
+	var p models.PromoCode
+	err := row.Scan(append([]interface{}{&p.ID, &p.Code, &p.Description, &p.Kind, &p.Amount, &p.ValidFrom,
+		&p.ValidUntil, &p.MinNights, &p.MaxUses, &p.MaxUsesPerEmail, &p.Stackable, &p.Active, &p.CreatedAt,
+		&p.UpdatedAt}, dest...)...)
+	return p, err
+}
 
-	query := `select id, room_name, created_at, updated_at from rooms order by room_name`
+// getPromoCodeRooms returns the rooms promo codes can be redeemed for, by promo code id, as selected by query.
+func (m *postgresDBRepo) getPromoCodeRooms(ctx context.Context, query string, args ...interface{}) (map[int][]int,
+	error) {
+	rooms := make(map[int][]int)
 
-	rows, err := m.DB.QueryContext(ctx, query)
+	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
 		return rooms, err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var rm models.Room
-		err := rows.Scan(&rm.ID, &rm.RoomName, &rm.CreatedAt, &rm.UpdatedAt)
+		var promoCodeID, roomID int
+		err := rows.Scan(&promoCodeID, &roomID)
 		if err != nil {
 			return rooms, err
 		}
-		rooms = append(rooms, rm)
-	}
-
-	if err = rows.Err(); err != nil {
-		return rooms, err
+		rooms[promoCodeID] = append(rooms[promoCodeID], roomID)
 	}
-
-	return rooms, nil
+	return rooms, rows.Err()
 }
 
-// GetRestrictionsForRoomByDate returns restrictions for a room by date range
-func (m *postgresDBRepo) GetRestrictionsForRoomByDate(roomID int, start, end time.Time) ([]models.RoomRestriction, error) {
+// GetPromoCodes returns every promo code, by code, with how many reservations that aren't cancelled redeemed it and
+// the discount they got.
+func (m *postgresDBRepo) GetPromoCodes() ([]models.PromoCode, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
 	defer cancel()
 
-	var restrictions []models.RoomRestriction
-
-	// Coalesce is used here since a restriction could have no reservation. For example if an owner decides to disable
-	// reservations for a given date range.
-	query := ` select id, coalesce(reservation_id, 0), restriction_id, room_id, start_date, end_date
-			   from room_restrictions where $1 < end_date and $2 >= start_date
-			   and room_id = $3
-`
-	rows, err := m.DB.QueryContext(ctx, query, start, end, roomID)
+	var promos []models.PromoCode
+
+	query := `select ` + promoCodeColumns + `, coalesce(u.uses, 0), coalesce(u.discounted, 0)
+		from promo_codes p
+		left join (
+			select pr.promo_code_id, count(*) as uses, sum(pr.amount) as discounted
+			from promo_redemptions pr
+			join reservations r on (r.id = pr.reservation_id)
+			where r.status <> $1
+			group by pr.promo_code_id) u on (u.promo_code_id = p.id)
+		order by p.code`
+	rows, err := m.DB.QueryContext(ctx, query, models.StatusCancelled)
 	if err != nil {
-		return restrictions, err
+		return promos, err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var r models.RoomRestriction
-		err := rows.Scan(&r.ID, &r.ReservationID, &r.RestrictionID, &r.RoomID, &r.StartDate, &r.EndDate)
+		var uses, discounted int
+		promo, err := scanPromoCode(rows, &uses, &discounted)
 		if err != nil {
-			return restrictions, err
+			return promos, err
 		}
+		promo.Uses, promo.Discounted = uses, discounted
+		promos = append(promos, promo)
+	}
+	if err = rows.Err(); err != nil {
+		return promos, err
+	}
 
-		restrictions = append(restrictions, r)
+	rooms, err := m.getPromoCodeRooms(ctx, `select promo_code_id, room_id from promo_code_rooms order by room_id`)
+	if err != nil {
+		return promos, err
 	}
+	for i := range promos {
+		promos[i].RoomIDs = rooms[promos[i].ID]
+	}
+	return promos, nil
+}
 
-	if err = rows.Err(); err != nil {
-		return nil, err
+// GetPromoCodeByID returns the promo code with the given id. Returns repository.ErrPromoCodeNotFound if there is
+// none.
+func (m *postgresDBRepo) GetPromoCodeByID(id int) (models.PromoCode, error) {
+	return m.getPromoCode(`select `+promoCodeColumns+` from promo_codes p where p.id = $1`, id)
+}
+
+// GetPromoCodeByCode returns the promo code with the given code, as stored. Returns repository.ErrPromoCodeNotFound
+// if there is none.
+func (m *postgresDBRepo) GetPromoCodeByCode(code string) (models.PromoCode, error) {
+	return m.getPromoCode(`select `+promoCodeColumns+` from promo_codes p where p.code = $1`, code)
+}
+
+// getPromoCode returns the promo code selected by query, with its rooms.
+func (m *postgresDBRepo) getPromoCode(query string, args ...interface{}) (models.PromoCode, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	promo, err := scanPromoCode(m.DB.QueryRowContext(ctx, query, args...))
+	if errors.Is(err, sql.ErrNoRows) {
+		return promo, repository.ErrPromoCodeNotFound
+	}
+	if err != nil {
+		return promo, err
 	}
-	return restrictions, nil
 
+	rooms, err := m.getPromoCodeRooms(ctx, `select promo_code_id, room_id from promo_code_rooms
+		where promo_code_id = $1 order by room_id`, promo.ID)
+	promo.RoomIDs = rooms[promo.ID]
+	return promo, err
 }
 
-// DeleteBlockByID deletes a restriction .
-func (m *postgresDBRepo) DeleteBlockByID(id int) error {
+// GetPromoCodeUses returns how many reservations that aren't cancelled redeemed a promo code, in total and with the
+// given email.
+func (m *postgresDBRepo) GetPromoCodeUses(id int, email string) (int, int, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
 	defer cancel()
 
-	query := ` delete from room_restrictions where id=$1`
-	_, err := m.DB.ExecContext(ctx, query, id)
+	return promoCodeUses(ctx, m.DB, id, email, 0)
+}
+
+// InsertPromoCode inserts a promo code with its rooms and returns its id. Returns repository.ErrPromoCodeTaken if
+// another promo code already has its code.
+func (m *postgresDBRepo) InsertPromoCode(promo models.PromoCode) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
 	if err != nil {
-		log.Println(err)
-		return err
+		return 0, err
 	}
-	return nil
+	defer tx.Rollback()
+
+	var newID int
+
+	stmt := `insert into promo_codes (code, description, kind, amount, valid_from, valid_until, min_nights, max_uses,
+			 max_uses_per_email, stackable, active, created_at, updated_at)
+			 values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13) returning id`
+
+	err = tx.QueryRowContext(ctx, stmt, promo.Code, promo.Description, promo.Kind, promo.Amount, promo.ValidFrom,
+		promo.ValidUntil, promo.MinNights, promo.MaxUses, promo.MaxUsesPerEmail, promo.Stackable, promo.Active,
+		time.Now(), time.Now()).Scan(&newID)
+	if isUniqueViolation(err) {
+		return 0, repository.ErrPromoCodeTaken
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	for _, roomID := range promo.RoomIDs {
+		_, err = tx.ExecContext(ctx, `insert into promo_code_rooms (promo_code_id, room_id, created_at, updated_at)
+			values ($1, $2, $3, $4)`, newID, roomID, time.Now(), time.Now())
+		if err != nil {
+			return 0, err
+		}
+	}
+	return newID, tx.Commit()
+}
+
+// UpdatePromoCodeActive turns a promo code on or off. Reservations keep the discounts they got with it.
+func (m *postgresDBRepo) UpdatePromoCodeActive(id int, active bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
+	defer cancel()
 
+	_, err := m.DB.ExecContext(ctx, `update promo_codes set active = $1, updated_at = $2 where id = $3`, active,
+		time.Now(), id)
+	return err
 }
 
-// InsertBlockForRoom inserts a restriction for a specific room given a specific date.
-func (m *postgresDBRepo) InsertBlockForRoom(id int, startDate time.Time) error {
+// GetPromoRedemptions returns the redemptions of a promo code by reservations that aren't cancelled, the latest
+// first, with the confirmation code and email of their reservation.
+func (m *postgresDBRepo) GetPromoRedemptions(promoCodeID int) ([]models.PromoRedemption, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // gives the transaction a 3-second timeout.
 	defer cancel()
 
-	query := ` insert into room_restrictions (start_date, end_date, room_id, restriction_id, created_at, updated_at)
-values ($1, $2, $3, $4, $5, $6)
-`
-	_, err := m.DB.ExecContext(ctx, query, startDate, startDate.AddDate(0, 0, 1), id, 2, time.Now(), time.Now())
+	var redemptions []models.PromoRedemption
+
+	query := `select pr.id, pr.reservation_id, pr.promo_code_id, pr.code, pr.amount, pr.created_at,
+			  r.confirmation_code, r.email
+			  from promo_redemptions pr
+			  join reservations r on (r.id = pr.reservation_id)
+			  where pr.promo_code_id = $1 and r.status <> $2
+			  order by pr.created_at desc, pr.id desc`
+	rows, err := m.DB.QueryContext(ctx, query, promoCodeID, models.StatusCancelled)
 	if err != nil {
-		log.Println(err)
-		return err
+		return redemptions, err
 	}
-	return nil
+	defer rows.Close()
 
+	for rows.Next() {
+		var r models.PromoRedemption
+		err := rows.Scan(&r.ID, &r.ReservationID, &r.PromoCodeID, &r.Code, &r.Amount, &r.CreatedAt,
+			&r.ConfirmationCode, &r.Email)
+		if err != nil {
+			return redemptions, err
+		}
+		redemptions = append(redemptions, r)
+	}
+	return redemptions, rows.Err()
 }
@@ -1,13 +1,104 @@
 package dbrepo
 
 import (
+	"database/sql"
 	"errors"
+	"github.com/nambroa/lodging-bookings/internal/helpers"
 	"github.com/nambroa/lodging-bookings/internal/models"
+	"github.com/nambroa/lodging-bookings/internal/repository"
+	"strings"
 	"time"
 )
 
-func (m *testDBRepo) InsertReservation(res models.Reservation) (int, error) {
-	return 1, nil
+func (m *testDBRepo) InsertReservation(res models.Reservation, holdID int,
+	idempotencyKey string) (int, string, error) {
+	if soldOutExtras(res) {
+		return 0, "", repository.ErrExtraSoldOut
+	}
+	// Without an active hold, room 2 was fully booked by someone else in the meantime.
+	if !activeHold(holdID) && res.RoomID == 2 {
+		return 0, "", repository.ErrRoomNotAvailable
+	}
+	// The last use of LASTONE is always redeemed by someone else while the guest fills in the form.
+	for _, discount := range res.Quote.Discounts {
+		if discount.Code == "LASTONE" {
+			return 0, "", repository.ErrPromoCodeUsedUp
+		}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.reservationsInserted++
+	if record, ok := m.idempotencyKeys[idempotencyKey]; ok {
+		record.ReservationID = 1
+		m.idempotencyKeys[idempotencyKey] = record
+	}
+	return 1, "LB-7K3Q9X", nil
+}
+
+func (m *testDBRepo) InsertReservationGroup(group models.ReservationGroup, holdIDs []int,
+	idempotencyKey string) (models.ReservationGroup, error) {
+	// Only hold 1 is still active, so a group with any other hold is never booked.
+	for _, holdID := range holdIDs {
+		if holdID != 1 {
+			return group, repository.ErrHoldNotFound
+		}
+	}
+	m.mu.Lock()
+	m.reservationsInserted += len(group.Reservations)
+	if record, ok := m.idempotencyKeys[idempotencyKey]; ok {
+		record.GroupID = 1
+		m.idempotencyKeys[idempotencyKey] = record
+	}
+	m.mu.Unlock()
+
+	// The reservations get the ids they have in group 1: the one in room 1 is reservation 2, the one in room 2 is
+	// reservation 3, whatever order they were booked in.
+	inserted := group
+	inserted.ID = 1
+	inserted.ConfirmationCode = "LB-GRP234"
+	inserted.Reservations = nil
+	for _, res := range group.Reservations {
+		res.ID = res.RoomID + 1
+		res.GroupID = 1
+		res.GroupCode = "LB-GRP234"
+		inserted.Reservations = append(inserted.Reservations, res)
+	}
+	return inserted, nil
+}
+
+func (m *testDBRepo) GetReservationGroupByID(id int) (models.ReservationGroup, error) {
+	if id != 1 {
+		return models.ReservationGroup{}, errors.New("non-existent reservation group test case")
+	}
+
+	// The group of reservation 2, booked with reservation 3.
+	start := time.Now().AddDate(0, 1, 0)
+	group := models.ReservationGroup{ID: 1, ConfirmationCode: "LB-GRP234", FirstName: "John", LastName: "Smith",
+		Email: "john@smith.com"}
+	codes := []string{"LB-GRP235", "LB-GRP236"}
+	for i, roomName := range []string{"General's Quarters", "Major's Suite"} {
+		group.Reservations = append(group.Reservations, models.Reservation{
+			ID:               i + 2,
+			ConfirmationCode: codes[i],
+			StartDate:        start,
+			EndDate:          start.AddDate(0, 0, 2),
+			RoomID:           i + 1,
+			Room:             models.Room{ID: i + 1, RoomName: roomName},
+			Status:           models.StatusPending,
+			Adults:           2,
+			GroupID:          1,
+			GroupCode:        "LB-GRP234",
+			Quote:            models.Quote{Total: 25000},
+		})
+	}
+	return group, nil
+}
+
+// ReservationsInserted returns how many reservations were inserted since the test repo was created.
+func (m *testDBRepo) ReservationsInserted() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.reservationsInserted
 }
 
 // InsertRoomRestriction inserts a room restriction into the database.
@@ -22,13 +113,24 @@ func (m *testDBRepo) InsertRoomRestriction(r models.RoomRestriction) error {
 
 // SearchAvailabilityByDatesByRoomID returns true if availability exists for a specific roomID, and false otherwise.
 func (m *testDBRepo) SearchAvailabilityByDatesByRoomID(start, end time.Time, roomID int) (bool, error) {
-	return false, nil
+	// Only the General's Quarters is free, and only for stays arriving in 2051.
+	return roomID == 1 && start.Year() == 2051, nil
 }
 
 // SearchAvailabilityForAllRooms returns a slice of available rooms for a given date range.
-func (m *testDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error) {
+func (m *testDBRepo) SearchAvailabilityForAllRooms(start, end time.Time, guests int,
+	amenityIDs []int) ([]models.Room, error) {
 
 	var rooms []models.Room
+	if start.Year() != 2051 || guests > 2 {
+		return rooms, nil
+	}
+	room, err := m.GetRoomByID(1)
+	if err != nil {
+		return rooms, err
+	}
+	room.Available = 1
+	rooms = append(rooms, room)
 	return rooms, nil
 }
 
@@ -39,6 +141,16 @@ func (m *testDBRepo) GetRoomByID(id int) (models.Room, error) {
 	if id > 2 {
 		return room, errors.New("non-existent room test case")
 	}
+	// $100 a night, $120 on Friday and Saturday nights.
+	room.ID = id
+	room.BaseRate = 10000
+	room.WeekendAdjustment = 20
+	room.Capacity = 2
+	room.SortOrder = id
+	room.RoomName, room.Slug = "General's Quarters", "generals-quarters"
+	if id == 2 {
+		room.RoomName, room.Slug = "Major's Suite", "majors-suite"
+	}
 
 	return room, nil
 }
@@ -51,7 +163,7 @@ func (m *testDBRepo) GetAllReservations() ([]models.Reservation, error) {
 
 	return reservations, nil
 }
-func (m *testDBRepo) GetNewReservations() ([]models.Reservation, error) {
+func (m *testDBRepo) GetReservationsByStatus(status models.ReservationStatus) ([]models.Reservation, error) {
 	var reservations []models.Reservation
 
 	return reservations, nil
@@ -60,25 +172,124 @@ func (m *testDBRepo) GetNewReservations() ([]models.Reservation, error) {
 func (m *testDBRepo) GetReservationByID(id int) (models.Reservation, error) {
 	var reservations models.Reservation
 
+	if id > 2 {
+		return reservations, errors.New("non-existent reservation test case")
+	}
+
+	// Reservation made by the guest used in the guest portal tests, starting in a month.
+	reservations.ID = id
+	reservations.ConfirmationCode = "LB-7K3Q9X"
+	reservations.Status = models.StatusPending
+	reservations.Email = "john@smith.com"
+	reservations.StartDate = time.Now().AddDate(0, 1, 0)
+	reservations.EndDate = time.Now().AddDate(0, 1, 2)
+	reservations.RoomID = 1
+	reservations.UnitID = 1
+	reservations.Unit = models.RoomUnit{ID: 1, RoomID: 1, Name: "101"}
+	reservations.Adults = 2
+	reservations.Quote = models.Quote{Guests: 2, Subtotal: 20000, Fees: 5000, Total: 25000,
+		LineItems: []models.LineItem{{Name: "Cleaning", Kind: models.ChargeFee, Amount: 5000}}}
+	// Reservation 2 was booked in a group, with another room.
+	if id == 2 {
+		reservations.GroupID = 1
+		reservations.GroupCode = "LB-GRP234"
+	}
+
 	return reservations, nil
 
 }
 
+func (m *testDBRepo) GetReservationByCode(code string) (models.Reservation, error) {
+	if code != "LB-7K3Q9X" {
+		return models.Reservation{}, errors.New("non-existent reservation test case")
+	}
+	return m.GetReservationByID(1)
+}
+
 func (m *testDBRepo) UpdateReservation(res models.Reservation) error { return nil }
 
-func (m *testDBRepo) DeleteReservation(id int) error {
+func (m *testDBRepo) ModifyReservation(res models.Reservation) error {
+	// Room 2 is fully booked, so moving a reservation into it always fails.
+	if res.RoomID == 2 {
+		return repository.ErrRoomNotAvailable
+	}
+	if soldOutExtras(res) {
+		return repository.ErrExtraSoldOut
+	}
 	return nil
 }
-func (m *testDBRepo) UpdateProcessedForReservation(id, processed int) error {
+
+// soldOutExtras returns true if the reservation books 2 parking spaces. GetExtrasForStay says 2 are left, but another
+// guest takes one of them while the reservation is being saved.
+func soldOutExtras(res models.Reservation) bool {
+	return res.Quote.ExtraQuantities()[2] == 2
+}
+
+func (m *testDBRepo) TransitionReservationStatus(id int, to models.ReservationStatus, userID int) error {
+	// The test reservations are pending, so they can only be confirmed or cancelled.
+	if !models.StatusPending.CanTransitionTo(to) {
+		return models.ErrInvalidTransition
+	}
 	return nil
 }
 
+func (m *testDBRepo) CancelReservation(id, refundPercent, userID int) (int, error) {
+	// Every test reservation has a total of 25000.
+	return 25000 * refundPercent / 100, m.TransitionReservationStatus(id, models.StatusCancelled, userID)
+}
+
+func (m *testDBRepo) GetStatusHistoryForReservation(id int) ([]models.StatusChange, error) {
+	var changes []models.StatusChange
+
+	return changes, nil
+}
+
 func (m *testDBRepo) GetAllRooms() ([]models.Room, error) {
 	var rooms []models.Room
+	for id := 1; id <= 2; id++ {
+		room, _ := m.GetRoomByID(id)
+		rooms = append(rooms, room)
+	}
 
 	return rooms, nil
 }
 
+func (m *testDBRepo) GetArchivedRooms() ([]models.Room, error) {
+	return nil, nil
+}
+
+func (m *testDBRepo) GetRoomBySlug(slug string) (models.Room, error) {
+	rooms, _ := m.GetAllRooms()
+	for _, room := range rooms {
+		if room.Slug == slug {
+			return room, nil
+		}
+	}
+	return models.Room{}, sql.ErrNoRows
+}
+
+func (m *testDBRepo) InsertRoom(room models.Room) (int, error) {
+	if _, err := m.GetRoomBySlug(room.Slug); err == nil {
+		return 0, repository.ErrSlugTaken
+	}
+	return 3, nil
+}
+
+func (m *testDBRepo) UpdateRoom(room models.Room) error {
+	if other, err := m.GetRoomBySlug(room.Slug); err == nil && other.ID != room.ID {
+		return repository.ErrSlugTaken
+	}
+	return nil
+}
+
+func (m *testDBRepo) SetRoomArchived(id int, archived bool) error {
+	return nil
+}
+
+func (m *testDBRepo) UpdateRoomOrder(ids []int) error {
+	return nil
+}
+
 func (m *testDBRepo) GetRestrictionsForRoomByDate(roomID int, start, end time.Time) ([]models.RoomRestriction, error) {
 	var rooms []models.RoomRestriction
 
@@ -90,7 +301,644 @@ func (m *testDBRepo) DeleteBlockByID(id int) error {
 
 }
 
-func (m *testDBRepo) InsertBlockForRoom(id int, startDate time.Time) error {
+func (m *testDBRepo) InsertBlockForRoom(id int, startDate time.Time) (int, error) {
+	return 1, nil
+
+}
+
+func (m *testDBRepo) InsertHold(roomID int, start, end, expiresAt time.Time) (int, error) {
+	// Room 2 is fully booked, so it can't be held.
+	if roomID == 2 {
+		return 0, repository.ErrRoomNotAvailable
+	}
+	if roomID > 2 {
+		return 0, errors.New("non-existent room test case")
+	}
+	// Stays in room 1 arriving in 2052 are given its units the way the database does, so its last unit can be booked.
+	if start.Year() == 2052 {
+		return m.holdUnit(roomID, start, end)
+	}
+	return 1, nil
+}
+
+// unitHold is a hold on a unit of a room, for the tests booking its last unit.
+type unitHold struct {
+	id, unitID int
+	start, end time.Time
+}
+
+// holdUnit holds the first unit in use of the room that no other hold overlapping start to end took. Returns
+// repository.ErrRoomNotAvailable if every unit is taken.
+func (m *testDBRepo) holdUnit(roomID int, start, end time.Time) (int, error) {
+	units, _ := m.GetUnitsForRoom(roomID)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, unit := range units {
+		if !unit.ArchivedAt.IsZero() {
+			continue
+		}
+		taken := false
+		for _, hold := range m.unitHolds {
+			if hold.unitID == unit.ID && end.After(hold.start) && start.Before(hold.end) {
+				taken = true
+			}
+		}
+		if !taken {
+			id := 100 + len(m.unitHolds)
+			m.unitHolds = append(m.unitHolds, unitHold{id: id, unitID: unit.ID, start: start, end: end})
+			return id, nil
+		}
+	}
+	return 0, repository.ErrRoomNotAvailable
+}
+
+func (m *testDBRepo) ExtendHold(id int, expiresAt time.Time) error {
+	// Only hold 1 is still active, any other hold has expired. Hold 3 can still be extended, but it's swept right
+	// after, before the reservation made with it is saved.
+	if id != 1 && id != 3 {
+		return repository.ErrHoldNotFound
+	}
 	return nil
+}
 
+// activeHold returns true if the hold is still active when a reservation is saved with it.
+func activeHold(id int) bool {
+	return id == 1
+}
+
+func (m *testDBRepo) ReleaseHold(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, hold := range m.unitHolds {
+		if hold.id == id {
+			// The hold frees its unit, but keeps its place so the ids of the holds stay unique.
+			m.unitHolds[i].unitID = 0
+		}
+	}
+	return nil
+}
+
+func (m *testDBRepo) DeleteExpiredHolds() (int64, error) {
+	return 0, nil
+}
+
+func (m *testDBRepo) TouchUserSession(token string, userID int, userAgent, ipAddress string, expiry time.Time) error {
+	return nil
+}
+
+func (m *testDBRepo) GetSessionsForUser(userID int) ([]models.UserSession, error) {
+	var sessions []models.UserSession
+
+	return sessions, nil
+}
+
+func (m *testDBRepo) DeleteUserSession(tokenHash string, userID int) error {
+	return nil
+}
+
+func (m *testDBRepo) GetReservationsByEmail(email string) ([]models.Reservation, error) {
+	var reservations []models.Reservation
+
+	return reservations, nil
+}
+
+func (m *testDBRepo) InsertGuestLoginToken(email, tokenHash string, expiresAt time.Time) error {
+	return nil
+}
+
+func (m *testDBRepo) ConsumeGuestLoginToken(tokenHash string) (string, error) {
+	if tokenHash == helpers.HashToken("invalid") {
+		return "", errors.New("invalid or expired login link")
+	}
+	return "john@smith.com", nil
+}
+
+func (m *testDBRepo) GetAllUsers() ([]models.User, error) {
+	var users []models.User
+
+	return users, nil
+}
+
+func (m *testDBRepo) InsertAuditEvent(e models.AuditEvent) error {
+	m.mu.Lock()
+	m.auditEvents = append(m.auditEvents, e)
+	m.mu.Unlock()
+	return nil
+}
+
+// AuditEvents returns the audit events recorded since the test repo was created, in order.
+func (m *testDBRepo) AuditEvents() []models.AuditEvent {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]models.AuditEvent(nil), m.auditEvents...)
+}
+
+func (m *testDBRepo) GetAuditEvents(filter models.AuditFilter) ([]models.AuditEvent, error) {
+	var events []models.AuditEvent
+
+	return events, nil
+}
+
+func (m *testDBRepo) ClaimIdempotencyKey(key, requestHash string,
+	expiresAt time.Time) (models.IdempotencyKey, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if record, ok := m.idempotencyKeys[key]; ok && record.ExpiresAt.After(time.Now()) {
+		return record, false, nil
+	}
+	record := models.IdempotencyKey{Key: key, RequestHash: requestHash, ExpiresAt: expiresAt}
+	m.idempotencyKeys[key] = record
+	return record, true, nil
+}
+
+func (m *testDBRepo) DeleteIdempotencyKey(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if record := m.idempotencyKeys[key]; record.ReservationID == 0 && record.GroupID == 0 {
+		delete(m.idempotencyKeys, key)
+	}
+	return nil
+}
+
+func (m *testDBRepo) DeleteExpiredIdempotencyKeys() (int64, error) {
+	return 0, nil
+}
+
+func (m *testDBRepo) GetCancellationPolicies() ([]models.CancellationPolicy, error) {
+	var policies []models.CancellationPolicy
+
+	return policies, nil
+}
+
+func (m *testDBRepo) GetCancellationPolicyForRoom(roomID int) (models.CancellationPolicy, error) {
+	if roomID > 2 {
+		return models.CancellationPolicy{}, errors.New("non-existent room test case")
+	}
+	// Full refund until a week before arrival, half until the day before.
+	return models.CancellationPolicy{ID: 1, Name: "Moderate", Tiers: []models.CancellationTier{
+		{DaysBeforeArrival: 7, RefundPercent: 100},
+		{DaysBeforeArrival: 1, RefundPercent: 50},
+	}}, nil
+}
+
+func (m *testDBRepo) InsertCancellationPolicy(p models.CancellationPolicy) (int, error) {
+	return 1, nil
+}
+
+func (m *testDBRepo) UpdateCancellationPolicyForRoom(roomID, policyID int) error {
+	if roomID > 2 {
+		return errors.New("non-existent room test case")
+	}
+	return nil
+}
+
+func (m *testDBRepo) UpdateRoomRates(room models.Room) error {
+	if room.ID > 2 {
+		return errors.New("non-existent room test case")
+	}
+	return nil
+}
+
+func (m *testDBRepo) GetRateRules() ([]models.RateRule, error) {
+	var rules []models.RateRule
+
+	return rules, nil
+}
+
+func (m *testDBRepo) GetRateRuleByID(id int) (models.RateRule, error) {
+	if id != 1 {
+		return models.RateRule{}, sql.ErrNoRows
+	}
+	return models.RateRule{ID: 1, RoomID: 1, Name: "High season", Adjustment: 20}, nil
+}
+
+func (m *testDBRepo) GetRateRulesForRoom(roomID int, start, end time.Time) ([]models.RateRule, error) {
+	var rules []models.RateRule
+
+	return rules, nil
+}
+
+func (m *testDBRepo) InsertRateRule(rule models.RateRule) (int, error) {
+	if rule.RoomID > 2 {
+		return 0, errors.New("non-existent room test case")
+	}
+	return 1, nil
+}
+
+func (m *testDBRepo) DeleteRateRule(id int) error {
+	return nil
+}
+
+func (m *testDBRepo) SetRateOverride(roomID int, night time.Time, rate int) (int, error) {
+	if roomID > 2 {
+		return 0, errors.New("non-existent room test case")
+	}
+	return 1, nil
+}
+
+func (m *testDBRepo) GetPricingRules() ([]models.PricingRule, error) {
+	var rules []models.PricingRule
+
+	return rules, nil
+}
+
+func (m *testDBRepo) GetActivePricingRules() ([]models.PricingRule, error) {
+	var rules []models.PricingRule
+
+	return rules, nil
+}
+
+func (m *testDBRepo) GetPricingRuleByID(id int) (models.PricingRule, error) {
+	if id != 1 {
+		return models.PricingRule{}, sql.ErrNoRows
+	}
+	return models.PricingRule{ID: 1, Name: "Last minute", Kind: models.PricingLeadTime, Threshold: 3,
+		Adjustment: -15, Active: true}, nil
+}
+
+func (m *testDBRepo) InsertPricingRule(rule models.PricingRule) (int, error) {
+	return 1, nil
+}
+
+func (m *testDBRepo) UpdatePricingRuleActive(id int, active bool) error {
+	return nil
+}
+
+func (m *testDBRepo) DeletePricingRule(id int) error {
+	return nil
+}
+
+func (m *testDBRepo) GetBookedNights(roomID int, start, end time.Time) (int, error) {
+	return 0, nil
+}
+
+func (m *testDBRepo) GetCharges() ([]models.Charge, error) {
+	return m.GetChargesForStay(time.Time{}, time.Time{})
+}
+
+func (m *testDBRepo) GetChargeByID(id int) (models.Charge, error) {
+	charges, _ := m.GetCharges()
+	for _, charge := range charges {
+		if charge.ID == id {
+			return charge, nil
+		}
+	}
+	return models.Charge{}, sql.ErrNoRows
+}
+
+func (m *testDBRepo) GetChargesForStay(start, end time.Time) ([]models.Charge, error) {
+	// 10% of tax and a $50 cleaning fee on every stay.
+	return []models.Charge{
+		{ID: 1, Name: "VAT", Kind: models.ChargeTax, Calculation: models.ChargePercent, Per: models.PerStay,
+			Amount: 1000},
+		{ID: 2, Name: "Cleaning", Kind: models.ChargeFee, Calculation: models.ChargeFixed, Per: models.PerStay,
+			Amount: 5000},
+	}, nil
+}
+
+func (m *testDBRepo) InsertCharge(charge models.Charge) (int, error) {
+	return 1, nil
+}
+
+func (m *testDBRepo) DeleteCharge(id int) error {
+	return nil
+}
+
+func (m *testDBRepo) GetStayRules() ([]models.RoomRestriction, error) {
+	// Room 1 has a 3-night minimum on weekends in the second week of 2050.
+	return []models.RoomRestriction{
+		{ID: 1, RoomID: 1, RestrictionID: models.RestrictionMinStay, Nights: 3, Days: models.WeekendDays,
+			StartDate: time.Date(2050, 1, 7, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2050, 1, 14, 0, 0, 0, 0, time.UTC),
+			Room: models.Room{ID: 1, RoomName: "General's Quarters"}},
+	}, nil
+}
+
+func (m *testDBRepo) GetStayRuleByID(id int) (models.RoomRestriction, error) {
+	rules, _ := m.GetStayRules()
+	for _, rule := range rules {
+		if rule.ID == id {
+			return rule, nil
+		}
+	}
+	return models.RoomRestriction{}, sql.ErrNoRows
+}
+
+func (m *testDBRepo) GetStayRulesForRoom(roomID int, start, end time.Time) ([]models.RoomRestriction, error) {
+	var applying []models.RoomRestriction
+	rules, _ := m.GetStayRules()
+	for _, rule := range rules {
+		if rule.RoomID == roomID && !rule.StartDate.After(end) && rule.EndDate.After(start) {
+			applying = append(applying, rule)
+		}
+	}
+	return applying, nil
+}
+
+func (m *testDBRepo) InsertStayRule(rule models.RoomRestriction) (int, error) {
+	return 1, nil
+}
+
+func (m *testDBRepo) DeleteStayRule(id int) error {
+	return nil
+}
+
+func (m *testDBRepo) GetPhotosForRoom(roomID int) ([]models.RoomPhoto, error) {
+	// Room 1 has a single photo, room 2 has two.
+	switch roomID {
+	case 1:
+		photo, err := m.GetRoomPhotoByID(1)
+		return []models.RoomPhoto{photo}, err
+	case 2:
+		return []models.RoomPhoto{
+			{ID: 3, RoomID: 2, Path: "rooms/2/majors-suite.png", SortOrder: 1, Width: 1600, Height: 1200},
+			{ID: 4, RoomID: 2, Path: "rooms/2/majors-suite-bathroom.png", SortOrder: 2, Width: 1600, Height: 1200},
+		}, nil
+	}
+	return nil, nil
+}
+
+func (m *testDBRepo) GetCoverPhotos() (map[int]models.RoomPhoto, error) {
+	photo, err := m.GetRoomPhotoByID(1)
+	return map[int]models.RoomPhoto{1: photo}, err
+}
+
+func (m *testDBRepo) GetRoomPhotoByID(id int) (models.RoomPhoto, error) {
+	if id != 1 {
+		return models.RoomPhoto{}, sql.ErrNoRows
+	}
+	return models.RoomPhoto{ID: 1, RoomID: 1, Path: "rooms/1/generals-quarters.png", Caption: "The bedroom",
+		SortOrder: 1, Width: 1600, Height: 1200}, nil
+}
+
+func (m *testDBRepo) InsertRoomPhoto(photo models.RoomPhoto) (int, error) {
+	if photo.RoomID > 2 {
+		return 0, errors.New("non-existent room photo test case")
+	}
+	return 2, nil
+}
+
+func (m *testDBRepo) UpdateRoomPhotoCaption(id int, caption string) error {
+	return nil
+}
+
+func (m *testDBRepo) UpdateRoomPhotoOrder(roomID int, ids []int) error {
+	return nil
+}
+
+func (m *testDBRepo) DeleteRoomPhoto(id int) error {
+	return nil
+}
+
+func (m *testDBRepo) GetUnitsForRoom(roomID int) ([]models.RoomUnit, error) {
+	// Room 1 has three units, the last one archived. Room 2 has a single unit.
+	switch roomID {
+	case 1:
+		return []models.RoomUnit{
+			{ID: 1, RoomID: 1, Name: "101", SortOrder: 1},
+			{ID: 2, RoomID: 1, Name: "102", SortOrder: 2},
+			{ID: 3, RoomID: 1, Name: "103", SortOrder: 3, ArchivedAt: time.Now()},
+		}, nil
+	case 2:
+		return []models.RoomUnit{{ID: 4, RoomID: 2, Name: "201", SortOrder: 1}}, nil
+	}
+	return nil, nil
+}
+
+func (m *testDBRepo) InsertRoomUnit(unit models.RoomUnit) (int, error) {
+	if unit.RoomID > 2 {
+		return 0, errors.New("non-existent room unit test case")
+	}
+	return 5, nil
+}
+
+func (m *testDBRepo) UpdateRoomUnit(unit models.RoomUnit) error {
+	return nil
+}
+
+func (m *testDBRepo) SetRoomUnitArchived(id int, archived bool) error {
+	return nil
+}
+
+func (m *testDBRepo) AssignReservationUnit(reservationID, unitID int) error {
+	// Units 1 and 2 are free for the test reservations, the others are taken or archived.
+	if unitID != 1 && unitID != 2 {
+		return repository.ErrRoomNotAvailable
+	}
+	return nil
+}
+
+func (m *testDBRepo) GetAmenities() ([]models.Amenity, error) {
+	return []models.Amenity{
+		{ID: 4, Name: "Accessible", Icon: "ti-wheelchair"},
+		{ID: 2, Name: "Balcony", Icon: "ti-shine"},
+		{ID: 1, Name: "Ensuite", Icon: "ti-home"},
+		{ID: 3, Name: "Pet-friendly", Icon: "ti-heart"},
+	}, nil
+}
+
+func (m *testDBRepo) GetAmenitiesForRoom(roomID int) ([]models.Amenity, error) {
+	// Room 1 has a balcony and an ensuite, room 2 has no amenities.
+	if roomID != 1 {
+		return nil, nil
+	}
+	return []models.Amenity{{ID: 2, Name: "Balcony", Icon: "ti-shine"}, {ID: 1, Name: "Ensuite", Icon: "ti-home"}},
+		nil
+}
+
+func (m *testDBRepo) InsertAmenity(amenity models.Amenity) (int, error) {
+	if amenity.Name == "Ensuite" {
+		return 0, repository.ErrAmenityTaken
+	}
+	return 5, nil
+}
+
+func (m *testDBRepo) UpdateAmenity(amenity models.Amenity) error {
+	if amenity.Name == "Ensuite" && amenity.ID != 1 {
+		return repository.ErrAmenityTaken
+	}
+	return nil
+}
+
+func (m *testDBRepo) DeleteAmenity(id int) error {
+	return nil
+}
+
+func (m *testDBRepo) SetRoomAmenities(roomID int, amenityIDs []int) error {
+	return nil
+}
+
+func (m *testDBRepo) GetExtras() ([]models.Extra, error) {
+	extras, _ := m.GetExtrasForStay(time.Time{}, time.Time{}, 0)
+	return append([]models.Extra{{ID: 4, Name: "Airport pickup", Per: models.ExtraPerStay, Price: 4000}}, extras...),
+		nil
+}
+
+func (m *testDBRepo) GetExtrasForStay(start, end time.Time, reservationID int) ([]models.Extra, error) {
+	// Unlimited breakfast, a sold out late checkout and 2 parking spaces left.
+	return []models.Extra{
+		{ID: 1, Name: "Breakfast", Per: models.ExtraPerGuestNight, Price: 1500, Active: true},
+		{ID: 3, Name: "Late checkout", Per: models.ExtraPerStay, Price: 2500, Inventory: 2, Active: true},
+		{ID: 2, Name: "Parking", Per: models.ExtraPerNight, Price: 1000, Inventory: 5, Active: true, Remaining: 2},
+	}, nil
+}
+
+func (m *testDBRepo) InsertExtra(extra models.Extra) (int, error) {
+	if extra.Name == "Breakfast" {
+		return 0, repository.ErrExtraTaken
+	}
+	return 5, nil
+}
+
+func (m *testDBRepo) UpdateExtra(extra models.Extra) error {
+	if extra.Name == "Breakfast" && extra.ID != 1 {
+		return repository.ErrExtraTaken
+	}
+	return nil
+}
+
+// testWaitlist is the waitlist of the test repo: two guests waiting for overlapping stays in the General's Quarters,
+// and one for the Major's Suite, which is fully booked.
+var testWaitlist = []models.WaitlistEntry{
+	{ID: 1, RoomID: 1, Room: models.Room{ID: 1, RoomName: "General's Quarters"},
+		StartDate: time.Date(2050, 1, 10, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2050, 1, 12, 0, 0, 0, 0, time.UTC), FirstName: "John", Email: "john@smith.com",
+		Adults: 2, Status: models.WaitlistWaiting},
+	{ID: 2, RoomID: 1, Room: models.Room{ID: 1, RoomName: "General's Quarters"},
+		StartDate: time.Date(2050, 1, 11, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2050, 1, 13, 0, 0, 0, 0, time.UTC), FirstName: "Jane", Email: "jane@smith.com",
+		Adults: 1, Status: models.WaitlistWaiting},
+	{ID: 3, RoomID: 2, Room: models.Room{ID: 2, RoomName: "Major's Suite"},
+		StartDate: time.Date(2050, 1, 10, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2050, 1, 12, 0, 0, 0, 0, time.UTC), FirstName: "Jack", Email: "jack@smith.com",
+		Adults: 1, Status: models.WaitlistWaiting},
+}
+
+func (m *testDBRepo) InsertWaitlistEntry(entry models.WaitlistEntry) (int, error) {
+	if entry.RoomID > 2 {
+		return 0, errors.New("non-existent room test case")
+	}
+	return 4, nil
+}
+
+func (m *testDBRepo) GetWaitingEntries(roomID int, start, end time.Time) ([]models.WaitlistEntry, error) {
+	var entries []models.WaitlistEntry
+	for _, entry := range testWaitlist {
+		if entry.RoomID == roomID && entry.StartDate.Before(end) && entry.EndDate.After(start) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+func (m *testDBRepo) NotifyWaitlistEntry(id, holdID int, tokenHash string, expiresAt time.Time) error {
+	m.mu.Lock()
+	m.waitlistNotified = append(m.waitlistNotified, id)
+	m.mu.Unlock()
+	return nil
+}
+
+// WaitlistNotified returns the ids of the waitlist entries notified since the test repo was created, in order.
+func (m *testDBRepo) WaitlistNotified() []int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]int(nil), m.waitlistNotified...)
+}
+
+func (m *testDBRepo) ClaimWaitlistEntry(tokenHash string) (models.WaitlistEntry, error) {
+	if tokenHash != helpers.HashToken("valid-claim") {
+		return models.WaitlistEntry{}, repository.ErrWaitlistClaimNotFound
+	}
+	entry := testWaitlist[0]
+	entry.Status = models.WaitlistClaimed
+	entry.HoldID = 1
+	return entry, nil
+}
+
+func (m *testDBRepo) ExpireWaitlistClaims() ([]models.WaitlistEntry, error) {
+	// A guest waiting for the General's Quarters didn't claim it in time.
+	expired := models.WaitlistEntry{ID: 5, RoomID: 1, StartDate: time.Date(2050, 1, 10, 0, 0, 0, 0, time.UTC),
+		EndDate: time.Date(2050, 1, 12, 0, 0, 0, 0, time.UTC), Status: models.WaitlistExpired}
+	return []models.WaitlistEntry{expired}, nil
+}
+
+func (m *testDBRepo) GetWaitlistEntriesForDate(date time.Time) ([]models.WaitlistEntry, error) {
+	var entries []models.WaitlistEntry
+	for _, entry := range testWaitlist {
+		if entry.Covers(date) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// testPromoCodes are the promo codes of the test repo, all valid from 2000 to 2099.
+var testPromoCodes = []models.PromoCode{
+	{ID: 1, Code: "SUMMER10", Kind: models.PromoPercent, Amount: 10, Stackable: true},
+	{ID: 2, Code: "WELCOME20", Kind: models.PromoFixed, Amount: 2000},
+	{ID: 3, Code: "LONGSTAY", Kind: models.PromoPercent, Amount: 15, MinNights: 7, Stackable: true},
+	{ID: 4, Code: "MAJOR5", Kind: models.PromoPercent, Amount: 5, RoomIDs: []int{2}},
+	// Already redeemed once by john@smith.com.
+	{ID: 5, Code: "ONCE", Kind: models.PromoFixed, Amount: 1000, MaxUsesPerEmail: 1, Uses: 1, Discounted: 1000},
+	{ID: 6, Code: "LASTONE", Kind: models.PromoFixed, Amount: 500, MaxUses: 1},
+}
+
+func (m *testDBRepo) GetPromoCodes() ([]models.PromoCode, error) {
+	var promos []models.PromoCode
+	for _, promo := range testPromoCodes {
+		promo.ValidFrom = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+		promo.ValidUntil = time.Date(2099, 12, 31, 0, 0, 0, 0, time.UTC)
+		promo.Active = true
+		promos = append(promos, promo)
+	}
+	return promos, nil
+}
+
+func (m *testDBRepo) GetPromoCodeByID(id int) (models.PromoCode, error) {
+	promos, _ := m.GetPromoCodes()
+	for _, promo := range promos {
+		if promo.ID == id {
+			return promo, nil
+		}
+	}
+	return models.PromoCode{}, repository.ErrPromoCodeNotFound
+}
+
+func (m *testDBRepo) GetPromoCodeByCode(code string) (models.PromoCode, error) {
+	promos, _ := m.GetPromoCodes()
+	for _, promo := range promos {
+		if promo.Code == code {
+			return promo, nil
+		}
+	}
+	return models.PromoCode{}, repository.ErrPromoCodeNotFound
+}
+
+func (m *testDBRepo) GetPromoCodeUses(id int, email string) (int, int, error) {
+	if id != 5 {
+		return 0, 0, nil
+	}
+	if strings.EqualFold(strings.TrimSpace(email), "john@smith.com") {
+		return 1, 1, nil
+	}
+	return 1, 0, nil
+}
+
+func (m *testDBRepo) InsertPromoCode(promo models.PromoCode) (int, error) {
+	if promo.Code == "SUMMER10" {
+		return 0, repository.ErrPromoCodeTaken
+	}
+	return 7, nil
+}
+
+func (m *testDBRepo) UpdatePromoCodeActive(id int, active bool) error {
+	return nil
+}
+
+func (m *testDBRepo) GetPromoRedemptions(promoCodeID int) ([]models.PromoRedemption, error) {
+	if promoCodeID != 5 {
+		return nil, nil
+	}
+	return []models.PromoRedemption{{ID: 1, ReservationID: 1, PromoCodeID: 5, Code: "ONCE", Amount: 1000,
+		ConfirmationCode: "LB-7K3Q9X", Email: "john@smith.com", CreatedAt: time.Now()}}, nil
 }
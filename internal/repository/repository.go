@@ -1,27 +1,149 @@
 package repository
 
 import (
+	"errors"
 	"github.com/nambroa/lodging-bookings/internal/models"
 	"time"
 )
 
+// ErrRoomNotAvailable is returned when a room is already booked or blocked for some of the requested dates.
+var ErrRoomNotAvailable = errors.New("room is not available for the requested dates")
+
+// ErrHoldNotFound is returned when a hold on a room has expired or was already released.
+var ErrHoldNotFound = errors.New("room hold not found or expired")
+
+// ErrWaitlistClaimNotFound is returned when a waitlist claim link is unknown, expired or was already used.
+var ErrWaitlistClaimNotFound = errors.New("waitlist claim not found or expired")
+
+// ErrSlugTaken is returned when saving a room with the slug of another room.
+var ErrSlugTaken = errors.New("another room already has this slug")
+
+// ErrAmenityTaken is returned when saving an amenity with the name of another amenity.
+var ErrAmenityTaken = errors.New("another amenity already has this name")
+
+// ErrExtraTaken is returned when saving an extra with the name of another extra.
+var ErrExtraTaken = errors.New("another extra already has this name")
+
+// ErrExtraSoldOut is returned when booking more units of an extra than it has left on some day of a stay.
+var ErrExtraSoldOut = errors.New("extra is sold out for some of the requested dates")
+
+// ErrPromoCodeNotFound is returned when looking up a promo code that doesn't exist.
+var ErrPromoCodeNotFound = errors.New("promo code not found")
+
+// ErrPromoCodeTaken is returned when saving a promo code with the code of another promo code.
+var ErrPromoCodeTaken = errors.New("another promo code already has this code")
+
+// ErrPromoCodeUsedUp is returned when booking a stay with a promo code that reached one of its usage limits.
+var ErrPromoCodeUsedUp = errors.New("promo code has reached its usage limit")
+
 type DatabaseRepo interface {
-	InsertReservation(res models.Reservation) (int, error)
+	InsertReservation(res models.Reservation, holdID int, idempotencyKey string) (int, string, error)
+	InsertReservationGroup(group models.ReservationGroup, holdIDs []int,
+		idempotencyKey string) (models.ReservationGroup, error)
+	GetReservationGroupByID(id int) (models.ReservationGroup, error)
 	InsertRoomRestriction(r models.RoomRestriction) error
 	SearchAvailabilityByDatesByRoomID(start, end time.Time, roomID int) (bool, error)
-	SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error)
+	SearchAvailabilityForAllRooms(start, end time.Time, guests int, amenityIDs []int) ([]models.Room, error)
 	GetRoomByID(id int) (models.Room, error)
 	GetUserByID(id int) (models.User, error)
 	UpdateUser(u models.User) error
 	Authenticate(email, userTypedPassword string) (int, string, error)
 	GetAllReservations() ([]models.Reservation, error)
-	GetNewReservations() ([]models.Reservation, error)
+	GetReservationsByStatus(status models.ReservationStatus) ([]models.Reservation, error)
 	GetReservationByID(id int) (models.Reservation, error)
+	GetReservationByCode(code string) (models.Reservation, error)
 	UpdateReservation(res models.Reservation) error
-	DeleteReservation(id int) error
-	UpdateProcessedForReservation(id, processed int) error
+	ModifyReservation(res models.Reservation) error
+	TransitionReservationStatus(id int, to models.ReservationStatus, userID int) error
+	CancelReservation(id, refundPercent, userID int) (int, error)
+	GetStatusHistoryForReservation(id int) ([]models.StatusChange, error)
 	GetAllRooms() ([]models.Room, error)
+	GetArchivedRooms() ([]models.Room, error)
+	GetRoomBySlug(slug string) (models.Room, error)
+	InsertRoom(room models.Room) (int, error)
+	UpdateRoom(room models.Room) error
+	SetRoomArchived(id int, archived bool) error
+	UpdateRoomOrder(ids []int) error
 	GetRestrictionsForRoomByDate(roomID int, start, end time.Time) ([]models.RoomRestriction, error)
-	InsertBlockForRoom(id int, startDate time.Time) error
+	InsertBlockForRoom(id int, startDate time.Time) (int, error)
 	DeleteBlockByID(id int) error
+	InsertHold(roomID int, start, end, expiresAt time.Time) (int, error)
+	ExtendHold(id int, expiresAt time.Time) error
+	ReleaseHold(id int) error
+	DeleteExpiredHolds() (int64, error)
+	TouchUserSession(token string, userID int, userAgent, ipAddress string, expiry time.Time) error
+	GetSessionsForUser(userID int) ([]models.UserSession, error)
+	DeleteUserSession(tokenHash string, userID int) error
+	GetReservationsByEmail(email string) ([]models.Reservation, error)
+	InsertGuestLoginToken(email, tokenHash string, expiresAt time.Time) error
+	ConsumeGuestLoginToken(tokenHash string) (string, error)
+	GetAllUsers() ([]models.User, error)
+	InsertAuditEvent(e models.AuditEvent) error
+	GetAuditEvents(filter models.AuditFilter) ([]models.AuditEvent, error)
+	ClaimIdempotencyKey(key, requestHash string, expiresAt time.Time) (models.IdempotencyKey, bool, error)
+	DeleteIdempotencyKey(key string) error
+	DeleteExpiredIdempotencyKeys() (int64, error)
+	GetCancellationPolicies() ([]models.CancellationPolicy, error)
+	GetCancellationPolicyForRoom(roomID int) (models.CancellationPolicy, error)
+	InsertCancellationPolicy(p models.CancellationPolicy) (int, error)
+	UpdateCancellationPolicyForRoom(roomID, policyID int) error
+	UpdateRoomRates(room models.Room) error
+	GetRateRules() ([]models.RateRule, error)
+	GetRateRuleByID(id int) (models.RateRule, error)
+	GetRateRulesForRoom(roomID int, start, end time.Time) ([]models.RateRule, error)
+	InsertRateRule(rule models.RateRule) (int, error)
+	DeleteRateRule(id int) error
+	SetRateOverride(roomID int, night time.Time, rate int) (int, error)
+	GetPricingRules() ([]models.PricingRule, error)
+	GetActivePricingRules() ([]models.PricingRule, error)
+	GetPricingRuleByID(id int) (models.PricingRule, error)
+	InsertPricingRule(rule models.PricingRule) (int, error)
+	UpdatePricingRuleActive(id int, active bool) error
+	DeletePricingRule(id int) error
+	GetBookedNights(roomID int, start, end time.Time) (int, error)
+	GetCharges() ([]models.Charge, error)
+	GetChargesForStay(start, end time.Time) ([]models.Charge, error)
+	GetChargeByID(id int) (models.Charge, error)
+	InsertCharge(charge models.Charge) (int, error)
+	DeleteCharge(id int) error
+	GetStayRules() ([]models.RoomRestriction, error)
+	GetStayRuleByID(id int) (models.RoomRestriction, error)
+	GetStayRulesForRoom(roomID int, start, end time.Time) ([]models.RoomRestriction, error)
+	InsertStayRule(rule models.RoomRestriction) (int, error)
+	DeleteStayRule(id int) error
+	GetPhotosForRoom(roomID int) ([]models.RoomPhoto, error)
+	GetCoverPhotos() (map[int]models.RoomPhoto, error)
+	GetRoomPhotoByID(id int) (models.RoomPhoto, error)
+	InsertRoomPhoto(photo models.RoomPhoto) (int, error)
+	UpdateRoomPhotoCaption(id int, caption string) error
+	UpdateRoomPhotoOrder(roomID int, ids []int) error
+	DeleteRoomPhoto(id int) error
+	GetUnitsForRoom(roomID int) ([]models.RoomUnit, error)
+	InsertRoomUnit(unit models.RoomUnit) (int, error)
+	UpdateRoomUnit(unit models.RoomUnit) error
+	SetRoomUnitArchived(id int, archived bool) error
+	AssignReservationUnit(reservationID, unitID int) error
+	GetAmenities() ([]models.Amenity, error)
+	GetAmenitiesForRoom(roomID int) ([]models.Amenity, error)
+	InsertAmenity(amenity models.Amenity) (int, error)
+	UpdateAmenity(amenity models.Amenity) error
+	DeleteAmenity(id int) error
+	SetRoomAmenities(roomID int, amenityIDs []int) error
+	GetExtras() ([]models.Extra, error)
+	GetExtrasForStay(start, end time.Time, reservationID int) ([]models.Extra, error)
+	InsertExtra(extra models.Extra) (int, error)
+	UpdateExtra(extra models.Extra) error
+	InsertWaitlistEntry(entry models.WaitlistEntry) (int, error)
+	GetWaitingEntries(roomID int, start, end time.Time) ([]models.WaitlistEntry, error)
+	NotifyWaitlistEntry(id, holdID int, tokenHash string, expiresAt time.Time) error
+	ClaimWaitlistEntry(tokenHash string) (models.WaitlistEntry, error)
+	ExpireWaitlistClaims() ([]models.WaitlistEntry, error)
+	GetWaitlistEntriesForDate(date time.Time) ([]models.WaitlistEntry, error)
+	GetPromoCodes() ([]models.PromoCode, error)
+	GetPromoCodeByID(id int) (models.PromoCode, error)
+	GetPromoCodeByCode(code string) (models.PromoCode, error)
+	GetPromoCodeUses(id int, email string) (int, int, error)
+	InsertPromoCode(promo models.PromoCode) (int, error)
+	UpdatePromoCodeActive(id int, active bool) error
+	GetPromoRedemptions(promoCodeID int) ([]models.PromoRedemption, error)
 }
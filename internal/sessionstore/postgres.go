@@ -0,0 +1,83 @@
+package sessionstore
+
+import (
+	"database/sql"
+	"time"
+)
+
+// PostgresStore is a scs.Store that keeps the sessions in the postgres "sessions" table.
+type PostgresStore struct {
+	cleaner
+	db *sql.DB
+}
+
+// NewPostgresStore returns a postgres session store that deletes expired sessions every 5 minutes.
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	return NewPostgresStoreWithCleanupInterval(db, defaultCleanupInterval)
+}
+
+// NewPostgresStoreWithCleanupInterval returns a postgres session store with a custom cleanup interval. An interval of 0
+// disables the cleanup goroutine.
+func NewPostgresStoreWithCleanupInterval(db *sql.DB, cleanupInterval time.Duration) *PostgresStore {
+	p := &PostgresStore{db: db}
+	p.startCleanup(cleanupInterval, p.deleteExpired)
+	return p
+}
+
+// Find returns the data for a session token. Expired or unknown tokens return found as false.
+func (p *PostgresStore) Find(token string) ([]byte, bool, error) {
+	var b []byte
+	row := p.db.QueryRow("select data from sessions where token = $1 and current_timestamp < expiry", token)
+	err := row.Scan(&b)
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return b, true, nil
+}
+
+// Commit adds the session token and data to the store, overwriting the data and expiry if the token already exists.
+// The user and device columns are left untouched, since they are maintained by the repository.
+func (p *PostgresStore) Commit(token string, b []byte, expiry time.Time) error {
+	stmt := `insert into sessions (token, data, expiry) values ($1, $2, $3)
+			 on conflict (token) do update set data = excluded.data, expiry = excluded.expiry`
+	_, err := p.db.Exec(stmt, token, b, expiry)
+	return err
+}
+
+// Delete removes a session token and its data from the store.
+func (p *PostgresStore) Delete(token string) error {
+	_, err := p.db.Exec("delete from sessions where token = $1", token)
+	return err
+}
+
+// All returns the data of every session that has not expired, keyed by token.
+func (p *PostgresStore) All() (map[string][]byte, error) {
+	rows, err := p.db.Query("select token, data from sessions where current_timestamp < expiry")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := make(map[string][]byte)
+	for rows.Next() {
+		var token string
+		var data []byte
+		err = rows.Scan(&token, &data)
+		if err != nil {
+			return nil, err
+		}
+		sessions[token] = data
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+func (p *PostgresStore) deleteExpired() error {
+	_, err := p.db.Exec("delete from sessions where expiry < current_timestamp")
+	return err
+}
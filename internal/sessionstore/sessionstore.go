@@ -0,0 +1,48 @@
+package sessionstore
+
+import (
+	"log"
+	"time"
+)
+
+// Database backed session stores for scs. Both stores keep the session data in a "sessions" table, which also holds
+// the user, device and last seen information used to list and revoke the active sessions of a user.
+
+// defaultCleanupInterval is how often expired sessions are removed from the database.
+const defaultCleanupInterval = 5 * time.Minute
+
+// cleaner runs a function that removes expired sessions on a fixed interval, until stopped.
+type cleaner struct {
+	stopCleanup chan bool
+}
+
+// startCleanup launches the goroutine that periodically deletes expired sessions. An interval of 0 disables it.
+func (c *cleaner) startCleanup(interval time.Duration, deleteExpired func() error) {
+	if interval <= 0 {
+		return
+	}
+	c.stopCleanup = make(chan bool)
+	ticker := time.NewTicker(interval)
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				err := deleteExpired()
+				if err != nil {
+					log.Println("Error deleting expired sessions:", err)
+				}
+			case <-c.stopCleanup:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+}
+
+// StopCleanup terminates the background cleanup goroutine. Only needed when the store is discarded before the app
+// exits, for example in tests.
+func (c *cleaner) StopCleanup() {
+	if c.stopCleanup != nil {
+		c.stopCleanup <- true
+	}
+}
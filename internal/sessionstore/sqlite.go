@@ -0,0 +1,95 @@
+package sessionstore
+
+import (
+	"database/sql"
+	"time"
+)
+
+// SQLiteStore is a scs.Store that keeps the sessions in a SQLite "sessions" table. The caller is responsible for
+// importing a SQLite driver and opening the connection. The table must be created with:
+//
+//	CREATE TABLE sessions (
+//		token TEXT PRIMARY KEY,
+//		data BLOB NOT NULL,
+//		expiry REAL NOT NULL,
+//		user_id INTEGER,
+//		user_agent TEXT NOT NULL DEFAULT '',
+//		ip_address TEXT NOT NULL DEFAULT '',
+//		last_seen_at REAL
+//	);
+//	CREATE INDEX sessions_expiry_idx ON sessions(expiry);
+//	CREATE INDEX sessions_user_id_idx ON sessions(user_id);
+type SQLiteStore struct {
+	cleaner
+	db *sql.DB
+}
+
+// NewSQLiteStore returns a SQLite session store that deletes expired sessions every 5 minutes.
+func NewSQLiteStore(db *sql.DB) *SQLiteStore {
+	return NewSQLiteStoreWithCleanupInterval(db, defaultCleanupInterval)
+}
+
+// NewSQLiteStoreWithCleanupInterval returns a SQLite session store with a custom cleanup interval. An interval of 0
+// disables the cleanup goroutine.
+func NewSQLiteStoreWithCleanupInterval(db *sql.DB, cleanupInterval time.Duration) *SQLiteStore {
+	s := &SQLiteStore{db: db}
+	s.startCleanup(cleanupInterval, s.deleteExpired)
+	return s
+}
+
+// Find returns the data for a session token. Expired or unknown tokens return found as false.
+func (s *SQLiteStore) Find(token string) ([]byte, bool, error) {
+	var b []byte
+	row := s.db.QueryRow("select data from sessions where token = ? and julianday('now') < expiry", token)
+	err := row.Scan(&b)
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return b, true, nil
+}
+
+// Commit adds the session token and data to the store, overwriting the data and expiry if the token already exists.
+func (s *SQLiteStore) Commit(token string, b []byte, expiry time.Time) error {
+	stmt := `insert into sessions (token, data, expiry) values (?, ?, julianday(?))
+			 on conflict (token) do update set data = excluded.data, expiry = excluded.expiry`
+	_, err := s.db.Exec(stmt, token, b, expiry.UTC().Format("2006-01-02T15:04:05.999"))
+	return err
+}
+
+// Delete removes a session token and its data from the store.
+func (s *SQLiteStore) Delete(token string) error {
+	_, err := s.db.Exec("delete from sessions where token = ?", token)
+	return err
+}
+
+// All returns the data of every session that has not expired, keyed by token.
+func (s *SQLiteStore) All() (map[string][]byte, error) {
+	rows, err := s.db.Query("select token, data from sessions where julianday('now') < expiry")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := make(map[string][]byte)
+	for rows.Next() {
+		var token string
+		var data []byte
+		err = rows.Scan(&token, &data)
+		if err != nil {
+			return nil, err
+		}
+		sessions[token] = data
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+func (s *SQLiteStore) deleteExpired() error {
+	_, err := s.db.Exec("delete from sessions where expiry < julianday('now')")
+	return err
+}
@@ -0,0 +1,166 @@
+package sessionstore
+
+import (
+	"bytes"
+	"database/sql"
+	_ "github.com/mattn/go-sqlite3"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestSQLiteStore returns a SQLite store on a new in memory database, with the sessions table created.
+func newTestSQLiteStore(t *testing.T, cleanupInterval time.Duration) (*SQLiteStore, *sql.DB) {
+	t.Helper()
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every connection to :memory: opens its own database.
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	_, err = db.Exec(`CREATE TABLE sessions (
+		token TEXT PRIMARY KEY,
+		data BLOB NOT NULL,
+		expiry REAL NOT NULL,
+		user_id INTEGER,
+		user_agent TEXT NOT NULL DEFAULT '',
+		ip_address TEXT NOT NULL DEFAULT '',
+		last_seen_at REAL
+	)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := NewSQLiteStoreWithCleanupInterval(db, cleanupInterval)
+	t.Cleanup(store.StopCleanup)
+	return store, db
+}
+
+func TestSQLiteStore_Find(t *testing.T) {
+	store, _ := newTestSQLiteStore(t, 0)
+	if err := store.Commit("valid", []byte("data"), time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Commit("expired", []byte("data"), time.Now().Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name          string
+		token         string
+		expectedFound bool
+	}{
+		{"valid", "valid", true},
+		{"expired", "expired", false},
+		{"unknown", "unknown", false},
+	}
+
+	for _, test := range tests {
+		b, found, err := store.Find(test.token)
+		if err != nil {
+			t.Errorf("For %s, expected no error but got %v", test.name, err)
+		}
+		if found != test.expectedFound {
+			t.Errorf("For %s, expected found to be %t", test.name, test.expectedFound)
+		}
+		if found && !bytes.Equal(b, []byte("data")) {
+			t.Errorf("For %s, expected the committed data but got %q", test.name, b)
+		}
+	}
+}
+
+func TestSQLiteStore_Commit(t *testing.T) {
+	var tests = []struct {
+		name          string
+		expiry        time.Duration
+		expectedData  string
+		expectedFound bool
+	}{
+		{"new-data", time.Hour, "new", true},
+		{"new-expiry", -time.Second, "", false},
+	}
+
+	for _, test := range tests {
+		store, db := newTestSQLiteStore(t, 0)
+		if err := store.Commit("token", []byte("old"), time.Now().Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Commit("token", []byte(test.expectedData), time.Now().Add(test.expiry)); err != nil {
+			t.Errorf("For %s, expected no error overwriting the session but got %v", test.name, err)
+		}
+
+		b, found, _ := store.Find("token")
+		if found != test.expectedFound || string(b) != test.expectedData {
+			t.Errorf("For %s, expected found to be %t with %q, got %t with %q", test.name, test.expectedFound,
+				test.expectedData, found, b)
+		}
+		var count int
+		_ = db.QueryRow("select count(*) from sessions").Scan(&count)
+		if count != 1 {
+			t.Errorf("For %s, expected the session to be overwritten, got %d rows", test.name, count)
+		}
+	}
+}
+
+func TestSQLiteStore_Delete(t *testing.T) {
+	store, _ := newTestSQLiteStore(t, 0)
+	_ = store.Commit("deleted", []byte("data"), time.Now().Add(time.Hour))
+	_ = store.Commit("kept", []byte("data"), time.Now().Add(time.Hour))
+
+	if err := store.Delete("deleted"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete("unknown"); err != nil {
+		t.Errorf("Expected no error deleting an unknown token but got %v", err)
+	}
+
+	sessions, err := store.All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := sessions["deleted"]; ok || len(sessions) != 1 {
+		t.Errorf("Expected only the kept session to remain, got %d sessions", len(sessions))
+	}
+}
+
+func TestSQLiteStore_Cleanup(t *testing.T) {
+	store, db := newTestSQLiteStore(t, 10*time.Millisecond)
+	_ = store.Commit("expired", []byte("data"), time.Now().Add(-time.Second))
+	_ = store.Commit("valid", []byte("data"), time.Now().Add(time.Hour))
+
+	var count int
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		_ = db.QueryRow("select count(*) from sessions").Scan(&count)
+		if count == 1 {
+			break
+		}
+	}
+	if count != 1 {
+		t.Errorf("Expected the cleanup to delete the expired session, got %d rows", count)
+	}
+}
+
+func TestCleaner_StopCleanup(t *testing.T) {
+	var calls int32
+	var c cleaner
+	c.startCleanup(5*time.Millisecond, func() error {
+		atomic.AddInt32(&calls, 1)
+		return nil
+	})
+	time.Sleep(20 * time.Millisecond)
+
+	// StopCleanup blocks until the goroutine receives the signal, so no cleanup runs after it returns.
+	c.StopCleanup()
+	stopped := atomic.LoadInt32(&calls)
+	time.Sleep(20 * time.Millisecond)
+	if stopped == 0 || atomic.LoadInt32(&calls) != stopped {
+		t.Errorf("Expected the cleanup to run until stopped, got %d calls then %d", stopped, atomic.LoadInt32(&calls))
+	}
+
+	// Without a goroutine there's nothing to stop, and it must not block.
+	var disabled cleaner
+	disabled.startCleanup(0, func() error { return nil })
+	disabled.StopCleanup()
+}
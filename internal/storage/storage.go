@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Storage keeps the files uploaded from the admin, like the photos of the rooms, and tells where they are served
+// from. Names are slash separated paths, for example "rooms/1/a1b2c3.jpg".
+type Storage interface {
+	Save(name string, data []byte) error
+	Delete(name string) error
+	URL(name string) string
+}
+
+// ErrInvalidName is returned for names that could escape the storage, like "../secret".
+var ErrInvalidName = errors.New("invalid file name")
+
+// LocalStorage stores the files in a directory of the local disk, served by the app under BaseURL.
+type LocalStorage struct {
+	Dir     string
+	BaseURL string
+}
+
+// NewLocalStorage returns a storage keeping the files in dir, served under baseURL.
+func NewLocalStorage(dir, baseURL string) *LocalStorage {
+	return &LocalStorage{Dir: dir, BaseURL: strings.TrimSuffix(baseURL, "/")}
+}
+
+// Save writes a file, creating the directories of its path and replacing the file if it exists.
+func (s *LocalStorage) Save(name string, data []byte) error {
+	file, err := s.path(name)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(file), 0755)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, data, 0644)
+}
+
+// Delete removes a file. Deleting a file that doesn't exist isn't an error.
+func (s *LocalStorage) Delete(name string) error {
+	file, err := s.path(name)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// URL returns the URL a file is served at.
+func (s *LocalStorage) URL(name string) string {
+	return s.BaseURL + "/" + name
+}
+
+// path returns where a file is stored on disk.
+func (s *LocalStorage) path(name string) (string, error) {
+	if name == "" || path.Clean(name) != name || strings.HasPrefix(name, "/") || strings.HasPrefix(name, "..") {
+		return "", ErrInvalidName
+	}
+	return filepath.Join(s.Dir, filepath.FromSlash(name)), nil
+}
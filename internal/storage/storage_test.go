@@ -0,0 +1,46 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLocalStorage(t *testing.T) {
+	dir := t.TempDir()
+	store := NewLocalStorage(dir, "/uploads/")
+
+	err := store.Save("rooms/1/photo.jpg", []byte("jpeg"))
+	if err != nil {
+		t.Fatal("Cannot save file:", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "rooms", "1", "photo.jpg"))
+	if err != nil || string(data) != "jpeg" {
+		t.Error("The file wasn't written in the storage directory")
+	}
+	if url := store.URL("rooms/1/photo.jpg"); url != "/uploads/rooms/1/photo.jpg" {
+		t.Errorf("Expected the file to be served at /uploads/rooms/1/photo.jpg, got %s", url)
+	}
+
+	err = store.Delete("rooms/1/photo.jpg")
+	if err != nil {
+		t.Error("Cannot delete file:", err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "rooms", "1", "photo.jpg")); !os.IsNotExist(err) {
+		t.Error("The file wasn't deleted")
+	}
+	if err = store.Delete("rooms/1/photo.jpg"); err != nil {
+		t.Error("Deleting a missing file returned an error:", err)
+	}
+}
+
+func TestLocalStorage_InvalidNames(t *testing.T) {
+	store := NewLocalStorage(t.TempDir(), "/uploads")
+
+	for _, name := range []string{"", "../outside.jpg", "/etc/passwd", "rooms/../../outside.jpg", "rooms//1.jpg"} {
+		if err := store.Save(name, []byte("data")); !errors.Is(err, ErrInvalidName) {
+			t.Errorf("Expected saving %q to fail with ErrInvalidName, got %v", name, err)
+		}
+	}
+}